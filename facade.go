@@ -0,0 +1,310 @@
+package hamt
+
+import (
+	"log"
+	"time"
+
+	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+)
+
+// Width selects the implementation of a Hamt created by New().
+type Width uint
+
+const (
+	// Hamt32 selects hamt32: 32 wide tables and 30 bit hash paths.
+	Hamt32 Width = 32
+
+	// Hamt64 selects hamt64: 64 wide tables and 60 bit hash paths.
+	Hamt64 Width = 64
+)
+
+// Hamt is the interface of the Hamts created by New(), whatever their Width.
+// So the Width can be chosen by configuration, without changing the types
+// of the code that uses the Hamt.
+type Hamt interface {
+	// Width returns the Width of the implementation.
+	Width() Width
+
+	// Unwrap returns the hamt32.Hamt or hamt64.Hamt of the implementation.
+	Unwrap() interface{}
+
+	IsEmpty() bool
+	Nentries() uint
+	Get(k key.Key) (interface{}, bool)
+	Put(k key.Key, v interface{}) (Hamt, bool)
+	Del(k key.Key) (Hamt, interface{}, bool)
+	Range(fn func(k key.Key, v interface{}) bool)
+	String() string
+
+	// LongString returns the whole trie of the Hamt, one node per line,
+	// each line prefixed by indent.
+	LongString(indent string) string
+}
+
+// Option sets one part of the configuration of a Hamt created by New(),
+// for either Width.
+type Option struct {
+	opt32 hamt32.Option
+	opt64 hamt64.Option
+}
+
+// Hasher is the interface of hamt32.Hasher and hamt64.Hasher.
+type Hasher interface {
+	Hash(data []byte) uint64
+}
+
+// Compressor is the interface of hamt32.Compressor and hamt64.Compressor.
+type Compressor interface {
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// Instruments is the interface of hamt32.Instruments and
+// hamt64.Instruments.
+type Instruments interface {
+	TableGraded(upgrade bool)
+	ObserveOp(op string, d time.Duration)
+}
+
+// Codec is the interface of hamt32.Codec and hamt64.Codec.
+type Codec interface {
+	AppendKey(buf []byte, k key.Key) ([]byte, error)
+	DecodeKey(data []byte) (key.Key, error)
+	AppendVal(buf []byte, v interface{}) ([]byte, error)
+	DecodeVal(data []byte) (interface{}, error)
+}
+
+// AssertLevel is hamt32.AssertLevel or hamt64.AssertLevel.
+type AssertLevel uint8
+
+// The AssertLevels of hamt32 and hamt64.
+const (
+	AssertOff       = AssertLevel(hamt32.AssertOff)
+	AssertCheap     = AssertLevel(hamt32.AssertCheap)
+	AssertExpensive = AssertLevel(hamt32.AssertExpensive)
+)
+
+// Interner is a hamt32.Interner and a hamt64.Interner made with the same
+// arguments; the Hamts of each Width share the values interned by theirs.
+type Interner struct {
+	in32 *hamt32.Interner
+	in64 *hamt64.Interner
+}
+
+// NewInterner is hamt32.NewInterner and hamt64.NewInterner.
+func NewInterner(hash func(interface{}) uint64, equal func(a, b interface{}) bool, max int) *Interner {
+	return &Interner{
+		in32: hamt32.NewInterner(hash, equal, max),
+		in64: hamt64.NewInterner(hash, equal, max),
+	}
+}
+
+// WithGradeTables is hamt32.WithGradeTables or hamt64.WithGradeTables.
+func WithGradeTables(grade bool) Option {
+	return Option{hamt32.WithGradeTables(grade), hamt64.WithGradeTables(grade)}
+}
+
+// WithFullTableInit is hamt32.WithFullTableInit or hamt64.WithFullTableInit.
+func WithFullTableInit(full bool) Option {
+	return Option{hamt32.WithFullTableInit(full), hamt64.WithFullTableInit(full)}
+}
+
+// WithUpgradeThreshold is hamt32.WithUpgradeThreshold or
+// hamt64.WithUpgradeThreshold.
+func WithUpgradeThreshold(n uint) Option {
+	return Option{hamt32.WithUpgradeThreshold(n), hamt64.WithUpgradeThreshold(n)}
+}
+
+// WithDowngradeThreshold is hamt32.WithDowngradeThreshold or
+// hamt64.WithDowngradeThreshold.
+func WithDowngradeThreshold(n uint) Option {
+	return Option{hamt32.WithDowngradeThreshold(n), hamt64.WithDowngradeThreshold(n)}
+}
+
+// WithMerkle is hamt32.WithMerkle or hamt64.WithMerkle.
+func WithMerkle(merkle bool) Option {
+	return Option{hamt32.WithMerkle(merkle), hamt64.WithMerkle(merkle)}
+}
+
+// WithHasher is hamt32.WithHasher or hamt64.WithHasher.
+func WithHasher(hasher Hasher) Option {
+	return Option{hamt32.WithHasher(hasher), hamt64.WithHasher(hasher)}
+}
+
+// WithValueCompressor is hamt32.WithValueCompressor or
+// hamt64.WithValueCompressor.
+func WithValueCompressor(c Compressor, threshold int) Option {
+	return Option{hamt32.WithValueCompressor(c, threshold), hamt64.WithValueCompressor(c, threshold)}
+}
+
+// WithAdaptiveTables is hamt32.WithAdaptiveTables or
+// hamt64.WithAdaptiveTables.
+func WithAdaptiveTables(threshold, depth uint) Option {
+	return Option{hamt32.WithAdaptiveTables(threshold, depth), hamt64.WithAdaptiveTables(threshold, depth)}
+}
+
+// WithKeyNormalizer is hamt32.WithKeyNormalizer or hamt64.WithKeyNormalizer.
+func WithKeyNormalizer(fn func(key.Key) key.Key) Option {
+	return Option{hamt32.WithKeyNormalizer(fn), hamt64.WithKeyNormalizer(fn)}
+}
+
+// WithAssertLevel is hamt32.WithAssertLevel or hamt64.WithAssertLevel.
+func WithAssertLevel(lvl AssertLevel) Option {
+	return Option{hamt32.WithAssertLevel(hamt32.AssertLevel(lvl)), hamt64.WithAssertLevel(hamt64.AssertLevel(lvl))}
+}
+
+// WithValueInterner is hamt32.WithValueInterner or
+// hamt64.WithValueInterner. By default, or if in is nil, values are not
+// interned.
+func WithValueInterner(in *Interner) Option {
+	if in == nil {
+		return Option{hamt32.WithValueInterner(nil), hamt64.WithValueInterner(nil)}
+	}
+	return Option{hamt32.WithValueInterner(in.in32), hamt64.WithValueInterner(in.in64)}
+}
+
+// WithSizeFunc is hamt32.WithSizeFunc or hamt64.WithSizeFunc.
+func WithSizeFunc(fn func(k key.Key, v interface{}) int) Option {
+	return Option{hamt32.WithSizeFunc(fn), hamt64.WithSizeFunc(fn)}
+}
+
+// WithCopyHook is hamt32.WithCopyHook or hamt64.WithCopyHook.
+func WithCopyHook(fn func(op string, k key.Key, copied, pathLen int)) Option {
+	return Option{hamt32.WithCopyHook(fn), hamt64.WithCopyHook(fn)}
+}
+
+// WithInstruments is hamt32.WithInstruments or hamt64.WithInstruments.
+func WithInstruments(in Instruments) Option {
+	return Option{hamt32.WithInstruments(in), hamt64.WithInstruments(in)}
+}
+
+// WithCodec is hamt32.WithCodec or hamt64.WithCodec.
+func WithCodec(c Codec) Option {
+	return Option{hamt32.WithCodec(c), hamt64.WithCodec(c)}
+}
+
+// New returns an empty Hamt of the given Width, configured by opts as by
+// hamt32.New() or hamt64.New(). New panics if w is neither Hamt32 nor
+// Hamt64.
+func New(w Width, opts ...Option) Hamt {
+	switch w {
+	case Hamt32:
+		var opts32 = make([]hamt32.Option, len(opts))
+		for i, opt := range opts {
+			opts32[i] = opt.opt32
+		}
+		return facade32{hamt32.New(opts32...)}
+	case Hamt64:
+		var opts64 = make([]hamt64.Option, len(opts))
+		for i, opt := range opts {
+			opts64[i] = opt.opt64
+		}
+		return facade64{hamt64.New(opts64...)}
+	}
+	log.Panicf("hamt.New: Width %d is neither Hamt32 nor Hamt64", w)
+	return nil
+}
+
+// Wrap32 returns the Hamt of an existing hamt32.Hamt.
+func Wrap32(h hamt32.Hamt) Hamt {
+	return facade32{h}
+}
+
+// Wrap64 returns the Hamt of an existing hamt64.Hamt.
+func Wrap64(h hamt64.Hamt) Hamt {
+	return facade64{h}
+}
+
+type facade32 struct {
+	h hamt32.Hamt
+}
+
+func (f facade32) Width() Width {
+	return Hamt32
+}
+
+func (f facade32) Unwrap() interface{} {
+	return f.h
+}
+
+func (f facade32) IsEmpty() bool {
+	return f.h.IsEmpty()
+}
+
+func (f facade32) Nentries() uint {
+	return f.h.Nentries()
+}
+
+func (f facade32) Get(k key.Key) (interface{}, bool) {
+	return f.h.Get(k)
+}
+
+func (f facade32) Put(k key.Key, v interface{}) (Hamt, bool) {
+	var nh, added = f.h.Put(k, v)
+	return facade32{nh}, added
+}
+
+func (f facade32) Del(k key.Key) (Hamt, interface{}, bool) {
+	var nh, val, deleted = f.h.Del(k)
+	return facade32{nh}, val, deleted
+}
+
+func (f facade32) Range(fn func(k key.Key, v interface{}) bool) {
+	f.h.Range(fn)
+}
+
+func (f facade32) String() string {
+	return f.h.String()
+}
+
+func (f facade32) LongString(indent string) string {
+	return f.h.LongString(indent)
+}
+
+type facade64 struct {
+	h hamt64.Hamt
+}
+
+func (f facade64) Width() Width {
+	return Hamt64
+}
+
+func (f facade64) Unwrap() interface{} {
+	return f.h
+}
+
+func (f facade64) IsEmpty() bool {
+	return f.h.IsEmpty()
+}
+
+func (f facade64) Nentries() uint {
+	return f.h.Nentries()
+}
+
+func (f facade64) Get(k key.Key) (interface{}, bool) {
+	return f.h.Get(k)
+}
+
+func (f facade64) Put(k key.Key, v interface{}) (Hamt, bool) {
+	var nh, added = f.h.Put(k, v)
+	return facade64{nh}, added
+}
+
+func (f facade64) Del(k key.Key) (Hamt, interface{}, bool) {
+	var nh, val, deleted = f.h.Del(k)
+	return facade64{nh}, val, deleted
+}
+
+func (f facade64) Range(fn func(k key.Key, v interface{}) bool) {
+	f.h.Range(fn)
+}
+
+func (f facade64) String() string {
+	return f.h.String()
+}
+
+func (f facade64) LongString(indent string) string {
+	return f.h.LongString(indent)
+}
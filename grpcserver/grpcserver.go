@@ -0,0 +1,495 @@
+/*
+Package grpcserver serves a hamt64.AtomicHamt as a gRPC service, so a
+versioned in-memory key/value service can be stood up directly from this
+package. The service, hamt.KV, has the unary methods
+
+	Get(GetRequest) GetResponse
+	Put(PutRequest) WriteResponse
+	Del(DelRequest) WriteResponse
+	Snapshot(SnapshotRequest) SnapshotResponse
+	Diff(DiffRequest) DiffResponse
+	Scan(ScanRequest) ScanResponse
+
+Keys are strings, stored as *stringkey.StringKey, and values are []byte.
+Every response carries the version stamp (hamt64.Version.Stamp) of the Hamt
+it was served from. A Put or Del with a nonzero IfVersion is only applied
+to the version of that stamp, as by AtomicHamt.UpdateIfVersion; otherwise
+it fails with codes.FailedPrecondition.
+
+Snapshot returns the MarshalBinary snapshot of the current version, and
+names that version by its Label, if given; Diff returns the Changes between
+two named versions, where "current" always names the current version. Scan
+returns a page of the entries of the current version, and the cursor of the
+next page.
+
+The messages are the Go structs of this package, marshaled as JSON by the
+codec registered under CodecName; so no protobuf toolchain is needed on
+either side. A Client sends its calls with that codec.
+*/
+package grpcserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// ServiceName is the full name of the gRPC service.
+const ServiceName = "hamt.KV"
+
+// CodecName is the gRPC content-subtype of the messages of the service. It
+// is specific to this package; so registering the codec does not replace a
+// "json" codec of the program importing it.
+const CodecName = "hamt-json"
+
+// Current is the label of the current version of the AtomicHamt.
+const Current = "current"
+
+// The limits of a page of Scan.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 10000
+)
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec marshals the messages of the service as JSON.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return CodecName
+}
+
+// GetRequest asks for the value of Key in the current version.
+type GetRequest struct {
+	Key string `json:"key"`
+}
+
+// GetResponse is the value of a key; Val is nil if the key was not Found.
+type GetResponse struct {
+	Val     []byte `json:"val,omitempty"`
+	Found   bool   `json:"found"`
+	Version uint64 `json:"version"`
+}
+
+// PutRequest asks to set the value of Key to Val. If IfVersion is not zero,
+// only the version of that stamp is changed.
+type PutRequest struct {
+	Key       string `json:"key"`
+	Val       []byte `json:"val"`
+	IfVersion uint64 `json:"if_version,omitempty"`
+}
+
+// DelRequest asks to delete Key. If IfVersion is not zero, only the version
+// of that stamp is changed.
+type DelRequest struct {
+	Key       string `json:"key"`
+	IfVersion uint64 `json:"if_version,omitempty"`
+}
+
+// WriteResponse is the result of a Put or Del: whether a key was added or
+// deleted, and the stamp of the version made current.
+type WriteResponse struct {
+	Changed bool   `json:"changed"`
+	Version uint64 `json:"version"`
+}
+
+// SnapshotRequest asks for the snapshot of the current version, and to name
+// it Label if Label is not empty.
+type SnapshotRequest struct {
+	Label string `json:"label,omitempty"`
+}
+
+// SnapshotResponse is the MarshalBinary snapshot of a version.
+type SnapshotResponse struct {
+	Data    []byte `json:"data"`
+	Version uint64 `json:"version"`
+}
+
+// DiffRequest asks for the Changes from the version named From to the
+// version named To.
+type DiffRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Change is a hamt64.Change of a DiffResponse.
+type Change struct {
+	Kind string `json:"kind"`
+	Key  string `json:"key"`
+	Old  []byte `json:"old,omitempty"`
+	New  []byte `json:"new,omitempty"`
+}
+
+// DiffResponse is the Changes between two versions.
+type DiffResponse struct {
+	Changes []Change `json:"changes"`
+}
+
+// ScanRequest asks for a page of at most Limit entries of the current
+// version, DefaultLimit if zero, starting at Cursor, a Next of a previous
+// ScanResponse, or at the first entry if Cursor is empty.
+type ScanRequest struct {
+	Limit  int    `json:"limit,omitempty"`
+	Cursor []byte `json:"cursor,omitempty"`
+}
+
+// Entry is an entry of a ScanResponse.
+type Entry struct {
+	Key string `json:"key"`
+	Val []byte `json:"val"`
+}
+
+// ScanResponse is a page of entries. Next is the cursor of the next page,
+// or empty after the last page.
+type ScanResponse struct {
+	Nentries uint64  `json:"nentries"`
+	Entries  []Entry `json:"entries"`
+	Next     []byte  `json:"next,omitempty"`
+	Version  uint64  `json:"version"`
+}
+
+// labeled is a version named by a label.
+type labeled struct {
+	h     hamt64.Hamt
+	stamp uint64
+}
+
+// Server implements the service for one AtomicHamt. It is safe for
+// concurrent use.
+type Server struct {
+	a *hamt64.AtomicHamt
+
+	mu     sync.RWMutex
+	labels map[string]labeled
+}
+
+// NewServer returns a new Server of the versions of a.
+func NewServer(a *hamt64.AtomicHamt) *Server {
+	return &Server{a: a, labels: make(map[string]labeled)}
+}
+
+// Register registers the service of s with gs, eg. a *grpc.Server.
+func (s *Server) Register(gs grpc.ServiceRegistrar) {
+	gs.RegisterService(&serviceDesc, s)
+}
+
+// Unlabel forgets the version named label.
+func (s *Server) Unlabel(label string) {
+	s.mu.Lock()
+	delete(s.labels, label)
+	s.mu.Unlock()
+}
+
+// version returns the version named label.
+func (s *Server) version(label string) (labeled, bool) {
+	if label == Current {
+		var h, ver = s.a.LoadVersion()
+		return labeled{h, ver.Stamp()}, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var l, found = s.labels[label]
+	return l, found
+}
+
+// Get implements the Get method of the service.
+func (s *Server) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	var h, ver = s.a.LoadVersion()
+	var v, found = h.Get(stringkey.New(req.Key))
+	if !found {
+		return &GetResponse{Version: ver.Stamp()}, nil
+	}
+	var val, err = bytesVal(req.Key, v)
+	if err != nil {
+		return nil, err
+	}
+	return &GetResponse{Val: val, Found: true, Version: ver.Stamp()}, nil
+}
+
+// Put implements the Put method of the service.
+func (s *Server) Put(ctx context.Context, req *PutRequest) (*WriteResponse, error) {
+	var k = stringkey.New(req.Key)
+	var added bool
+	var stamp, err = s.write(req.IfVersion, func(h hamt64.Hamt) hamt64.Hamt {
+		h, added = h.Put(k, req.Val)
+		return h
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WriteResponse{Changed: added, Version: stamp}, nil
+}
+
+// Del implements the Del method of the service.
+func (s *Server) Del(ctx context.Context, req *DelRequest) (*WriteResponse, error) {
+	var k = stringkey.New(req.Key)
+	var deleted bool
+	var stamp, err = s.write(req.IfVersion, func(h hamt64.Hamt) hamt64.Hamt {
+		h, _, deleted = h.Del(k)
+		return h
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &WriteResponse{Changed: deleted, Version: stamp}, nil
+}
+
+// write makes fn(h) of the current Hamt h current, if ifVersion is zero or
+// the stamp of h, and returns the stamp of the current version.
+func (s *Server) write(ifVersion uint64, fn func(h hamt64.Hamt) hamt64.Hamt) (uint64, error) {
+	if ifVersion == 0 {
+		for {
+			var h, ver = s.a.LoadVersion()
+			if s.a.CompareAndSwap(ver, fn(h)) {
+				return ver.Stamp() + 1, nil
+			}
+		}
+	}
+
+	var _, stamp, err = s.a.UpdateIfVersion(ifVersion, fn)
+	if errors.Is(err, hamt64.ErrStaleVersion) {
+		return 0, status.Errorf(codes.FailedPrecondition, "version %d is not current; version %d is", ifVersion, stamp)
+	}
+	return stamp, err
+}
+
+// Snapshot implements the Snapshot method of the service.
+func (s *Server) Snapshot(ctx context.Context, req *SnapshotRequest) (*SnapshotResponse, error) {
+	if req.Label == Current {
+		return nil, status.Errorf(codes.InvalidArgument, "the label %q is reserved", Current)
+	}
+
+	var h, ver = s.a.LoadVersion()
+	var data, err = h.MarshalBinary()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if req.Label != "" {
+		s.mu.Lock()
+		s.labels[req.Label] = labeled{h, ver.Stamp()}
+		s.mu.Unlock()
+	}
+	return &SnapshotResponse{Data: data, Version: ver.Stamp()}, nil
+}
+
+// Diff implements the Diff method of the service.
+func (s *Server) Diff(ctx context.Context, req *DiffRequest) (*DiffResponse, error) {
+	var from, found = s.version(req.From)
+	if !found {
+		return nil, status.Errorf(codes.NotFound, "no version is labeled from=%q", req.From)
+	}
+	var to labeled
+	if to, found = s.version(req.To); !found {
+		return nil, status.Errorf(codes.NotFound, "no version is labeled to=%q", req.To)
+	}
+
+	var resp = &DiffResponse{Changes: []Change{}}
+	for _, c := range hamt64.Diff(from.h, to.h) {
+		var ks = keyString(c.Key)
+		var old, err = bytesVal(ks, c.Old)
+		if err != nil {
+			return nil, err
+		}
+		var nu []byte
+		if nu, err = bytesVal(ks, c.New); err != nil {
+			return nil, err
+		}
+		resp.Changes = append(resp.Changes, Change{c.Kind.String(), ks, old, nu})
+	}
+	return resp, nil
+}
+
+// Scan implements the Scan method of the service.
+func (s *Server) Scan(ctx context.Context, req *ScanRequest) (*ScanResponse, error) {
+	var limit = req.Limit
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+	if limit < 1 || limit > MaxLimit {
+		return nil, status.Errorf(codes.InvalidArgument, "limit %d is not in 1..%d", req.Limit, MaxLimit)
+	}
+
+	var h, ver = s.a.LoadVersion()
+	var it = h.Iter()
+	if len(req.Cursor) != 0 {
+		var err error
+		if it, err = h.IterAt(req.Cursor); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	var resp = &ScanResponse{Nentries: uint64(h.Nentries()), Entries: []Entry{}, Version: ver.Stamp()}
+	for len(resp.Entries) < limit {
+		var k, v, ok = it.Next()
+		if !ok {
+			break
+		}
+		var ks = keyString(k)
+		var val, err = bytesVal(ks, v)
+		if err != nil {
+			return nil, err
+		}
+		resp.Entries = append(resp.Entries, Entry{ks, val})
+	}
+	if len(resp.Entries) == limit {
+		var cursor = it.Cursor()
+		if _, _, ok := it.Next(); ok {
+			resp.Next = cursor
+		}
+	}
+	return resp, nil
+}
+
+// keyString returns the string of a key of the service.
+func keyString(k key.Key) string {
+	if sk, isString := k.(*stringkey.StringKey); isString {
+		return sk.Str()
+	}
+	return k.String()
+}
+
+// bytesVal returns v, the value of the key ks, as a []byte. Values not Put
+// by the service are an error, unless nil.
+func bytesVal(ks string, v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return x, nil
+	}
+	return nil, status.Errorf(codes.FailedPrecondition, "the value of %q is a %T, not []byte", ks, v)
+}
+
+// unary returns the MethodDesc of the method name of the service, served by
+// fn.
+func unary[Req, Resp any](name string, fn func(s *Server, ctx context.Context, req *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			var req = new(Req)
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			var handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return fn(srv.(*Server), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			var info = &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		unary("Get", (*Server).Get),
+		unary("Put", (*Server).Put),
+		unary("Del", (*Server).Del),
+		unary("Snapshot", (*Server).Snapshot),
+		unary("Diff", (*Server).Diff),
+		unary("Scan", (*Server).Scan),
+	},
+	Metadata: "grpcserver",
+}
+
+// Client calls the service over a gRPC connection.
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a new Client of the service served over cc, eg. a
+// *grpc.ClientConn.
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{cc}
+}
+
+// invoke calls the method name of the service with the codec of the
+// package.
+func (c *Client) invoke(ctx context.Context, name string, req, resp interface{}, opts []grpc.CallOption) error {
+	opts = append(opts, grpc.CallContentSubtype(CodecName))
+	var err = c.cc.Invoke(ctx, "/"+ServiceName+"/"+name, req, resp, opts...)
+	if err != nil {
+		return fmt.Errorf("grpcserver: %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get calls the Get method of the service.
+func (c *Client) Get(ctx context.Context, req *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	var resp = new(GetResponse)
+	if err := c.invoke(ctx, "Get", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Put calls the Put method of the service.
+func (c *Client) Put(ctx context.Context, req *PutRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	var resp = new(WriteResponse)
+	if err := c.invoke(ctx, "Put", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Del calls the Del method of the service.
+func (c *Client) Del(ctx context.Context, req *DelRequest, opts ...grpc.CallOption) (*WriteResponse, error) {
+	var resp = new(WriteResponse)
+	if err := c.invoke(ctx, "Del", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Snapshot calls the Snapshot method of the service.
+func (c *Client) Snapshot(ctx context.Context, req *SnapshotRequest, opts ...grpc.CallOption) (*SnapshotResponse, error) {
+	var resp = new(SnapshotResponse)
+	if err := c.invoke(ctx, "Snapshot", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Diff calls the Diff method of the service.
+func (c *Client) Diff(ctx context.Context, req *DiffRequest, opts ...grpc.CallOption) (*DiffResponse, error) {
+	var resp = new(DiffResponse)
+	if err := c.invoke(ctx, "Diff", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Scan calls the Scan method of the service.
+func (c *Client) Scan(ctx context.Context, req *ScanRequest, opts ...grpc.CallOption) (*ScanResponse, error) {
+	var resp = new(ScanResponse)
+	if err := c.invoke(ctx, "Scan", req, resp, opts); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
@@ -0,0 +1,27 @@
+package hamt32
+
+import "github.com/lleo/go-hamt-key"
+
+// PutMany returns a new Hamt with every key/val pair of kvs put into h. When
+// a key occurs more than once, the last value wins. Unlike len(kvs) calls
+// to Put, every table that receives new entries is copied exactly once; see
+// MergeSorted. kvs is not modified.
+func (h Hamt) PutMany(kvs []key.KeyVal) Hamt {
+	var sorted = make([]key.KeyVal, len(kvs))
+	copy(sorted, kvs)
+	SortEntries(sorted)
+	return h.MergeSorted(SliceEntries(sorted))
+}
+
+// DelMany returns a new Hamt with every key of keys removed from h, and the
+// removed values; vals[i] is the value of keys[i], or nil if keys[i] was not
+// found. Deletions that land in the same subtree share the copies of their
+// parent tables, rather than each copying the whole path; see Builder.
+func (h Hamt) DelMany(keys []key.Key) (Hamt, []interface{}) {
+	var vals = make([]interface{}, len(keys))
+	var b = h.Builder()
+	for i, k := range keys {
+		vals[i], _ = b.Del(k)
+	}
+	return b.Freeze(), vals
+}
@@ -0,0 +1,158 @@
+package hamt32
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Codec is the interface MarshalBinary and UnmarshalBinary use to serialize
+// the keys and values of a Hamt. The Append methods append the encoding of
+// their argument to buf and return the extended buffer; the Decode methods
+// are given exactly the bytes one Append call produced, which are only valid
+// for the duration of the call.
+type Codec interface {
+	AppendKey(buf []byte, k key.Key) ([]byte, error)
+	DecodeKey(data []byte) (key.Key, error)
+	AppendVal(buf []byte, v interface{}) ([]byte, error)
+	DecodeVal(data []byte) (interface{}, error)
+}
+
+// WithCodec sets the Codec the keys and values of the Hamt are encoded with
+// by MarshalBinary, Encode, Digest and WriteReplica, and decoded with by
+// UnmarshalBinary, Decode and ReadReplica. A Hamt must be unmarshaled with
+// the same Codec it was marshaled with. By default, or if c is nil,
+// StringCodec{}.
+func WithCodec(c Codec) Option {
+	return func(cfg *config) {
+		cfg.codec = c
+	}
+}
+
+// Codec returns the Codec of h; see WithCodec.
+func (h Hamt) Codec() Codec {
+	return h.conf().snapshotCodec()
+}
+
+// snapshotCodec returns the Codec of cfg, or StringCodec{}.
+func (cfg config) snapshotCodec() Codec {
+	if cfg.codec == nil {
+		return StringCodec{}
+	}
+	return cfg.codec
+}
+
+// StringCodec is a Codec for Hamts with *stringkey.StringKey keys and
+// values of type nil, bool, int, int64, uint64, float64, string or []byte.
+// An int value is decoded as an int, even on a platform where it would
+// overflow; so snapshots move between 64bit platforms only.
+type StringCodec struct{}
+
+// The tag byte that starts the encoding of each type of value StringCodec
+// supports.
+const (
+	valNil byte = iota
+	valFalse
+	valTrue
+	valInt
+	valInt64
+	valUint64
+	valFloat64
+	valString
+	valBytes
+)
+
+// AppendKey is required for Codec.
+func (StringCodec) AppendKey(buf []byte, k key.Key) ([]byte, error) {
+	var sk, ok = k.(*stringkey.StringKey)
+	if !ok {
+		return buf, fmt.Errorf("hamt32: StringCodec can not encode a key of type %T", k)
+	}
+	return append(buf, sk.Str()...), nil
+}
+
+// DecodeKey is required for Codec.
+func (StringCodec) DecodeKey(data []byte) (key.Key, error) {
+	return stringkey.New(string(data)), nil
+}
+
+// AppendVal is required for Codec.
+func (StringCodec) AppendVal(buf []byte, v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case nil:
+		return append(buf, valNil), nil
+	case bool:
+		if x {
+			return append(buf, valTrue), nil
+		}
+		return append(buf, valFalse), nil
+	case int:
+		return binary.AppendVarint(append(buf, valInt), int64(x)), nil
+	case int64:
+		return binary.AppendVarint(append(buf, valInt64), x), nil
+	case uint64:
+		return binary.AppendUvarint(append(buf, valUint64), x), nil
+	case float64:
+		return binary.LittleEndian.AppendUint64(append(buf, valFloat64), math.Float64bits(x)), nil
+	case string:
+		return append(append(buf, valString), x...), nil
+	case []byte:
+		return append(append(buf, valBytes), x...), nil
+	}
+	return buf, fmt.Errorf("hamt32: StringCodec can not encode a value of type %T", v)
+}
+
+// DecodeVal is required for Codec.
+func (StringCodec) DecodeVal(data []byte) (interface{}, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("hamt32: StringCodec value has no type tag")
+	}
+
+	var tag, rest = data[0], data[1:]
+	switch tag {
+	case valNil, valFalse, valTrue:
+		if len(rest) != 0 {
+			break
+		}
+		switch tag {
+		case valFalse:
+			return false, nil
+		case valTrue:
+			return true, nil
+		}
+		return nil, nil
+	case valInt, valInt64:
+		var i, n = binary.Varint(rest)
+		if n <= 0 || n != len(rest) {
+			break
+		}
+		if tag == valInt {
+			return int(i), nil
+		}
+		return i, nil
+	case valUint64:
+		var u, n = binary.Uvarint(rest)
+		if n <= 0 || n != len(rest) {
+			break
+		}
+		return u, nil
+	case valFloat64:
+		if len(rest) != 8 {
+			break
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(rest)), nil
+	case valString:
+		return string(rest), nil
+	case valBytes:
+		var bs = make([]byte, len(rest))
+		copy(bs, rest)
+		return bs, nil
+	default:
+		return nil, fmt.Errorf("hamt32: StringCodec value has unknown type tag %d", tag)
+	}
+
+	return nil, fmt.Errorf("hamt32: StringCodec value with type tag %d has a bad length %d", tag, len(rest))
+}
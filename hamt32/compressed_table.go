@@ -34,10 +34,11 @@ import (
 // there is a function to calculate the index called index(hash, depth);
 //
 type compressedTable struct {
-	hashPath key.HashVal30 // depth*Nbits of hash to get to this location in the Trie
-	depth    uint
-	nodeMap  uint32
-	nodes    []nodeI
+	hashPath   key.HashVal30 // depth*Nbits of hash to get to this location in the Trie
+	nodeMap    uint32
+	nodes      []nodeI
+	numKeyVals uint        // key/val pairs in the subtree; see nkeyvals()
+	digest     *nodeDigest // set once complete, for WithMerkle Hamts; see seal()
 }
 
 func createRootCompressedTable(lf leafI) tableI {
@@ -45,10 +46,10 @@ func createRootCompressedTable(lf leafI) tableI {
 
 	var ct = new(compressedTable)
 	//ct.hashPath = 0
-	//ct.depth = 0
 	ct.nodeMap = 1 << idx
 	ct.nodes = make([]nodeI, 1)
 	ct.nodes[0] = lf
+	ct.numKeyVals = nodeKeyVals(lf)
 
 	return ct
 }
@@ -56,7 +57,7 @@ func createRootCompressedTable(lf leafI) tableI {
 func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 	var retTable = new(compressedTable)
 	retTable.hashPath = leaf1.Hash30() & key.HashPathMask30(depth-1)
-	retTable.depth = depth
+	retTable.numKeyVals = nodeKeyVals(leaf1) + 1
 
 	var curTable = retTable
 	var hashPath = retTable.hashPath
@@ -89,7 +90,7 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 
 		var newTable = new(compressedTable)
 		newTable.hashPath = hashPath
-		newTable.depth = d + 1
+		newTable.numKeyVals = retTable.numKeyVals
 
 		curTable.nodeMap = 1 << idx1 //Set the idx1'th bit
 		curTable.nodes[0] = newTable
@@ -123,13 +124,11 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		// leaf1.Hash30() == leaf2.Hash30() all the way to MaxDepth;
 		// because Hamt.createTable() is called only once, and after a
 		// leaf1.Hash30() == leaf2.Hash30() check. It is here for completeness.
-		log.Printf("compressed_table.go:newCompressedTable: SHOULD NOT BE CALLED")
 
 		// Check if the path of leaf1 is not equal to the one leaf2 just traversed.
 		if leaf1.Hash30() != leaf2.Hash30() {
-			log.Printf("madDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
-			log.Panicf("newCompressedTable: %s,0x%#06x != %s,0x%#06x",
-				leaf1.Hash30(), leaf1.Hash30(), leaf2.Hash30(), leaf2.Hash30())
+			log.Panicf("newCompressedTable: %s,0x%#06x != %s,0x%#06x; MaxDepth=%d; d=%d; idx1=%d; idx2=%d",
+				leaf1.Hash30(), leaf1.Hash30(), leaf2.Hash30(), leaf2.Hash30(), MaxDepth, d, idx1, idx2)
 		}
 
 		// Just for completeness; leaf1.Hash30() == leaf2.hash30()
@@ -148,10 +147,9 @@ func createCompressedTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 //
 // The ents []tableEntry slice is guaranteed to be in order from lowest idx to
 // highest. tableI.entries() also adhears to this contract.
-func downgradeToCompressedTable(hashPath key.HashVal30, depth uint, ents []tableEntry) *compressedTable {
+func downgradeToCompressedTable(hashPath key.HashVal30, ents []tableEntry) *compressedTable {
 	var nt = new(compressedTable)
 	nt.hashPath = hashPath
-	nt.depth = depth
 	//nt.nodeMap = 0
 	nt.nodes = make([]nodeI, len(ents))
 
@@ -160,6 +158,7 @@ func downgradeToCompressedTable(hashPath key.HashVal30, depth uint, ents []table
 		var nodeBit = uint32(1 << ent.idx)
 		nt.nodeMap |= nodeBit
 		nt.nodes[i] = ent.node
+		nt.numKeyVals += nodeKeyVals(ent.node)
 	}
 
 	return nt
@@ -172,8 +171,8 @@ func (t compressedTable) Hash30() key.HashVal30 {
 func (t compressedTable) copyExceptNodes() *compressedTable {
 	var nt = new(compressedTable)
 	nt.hashPath = t.hashPath
-	nt.depth = t.depth
 	nt.nodeMap = t.nodeMap
+	nt.numKeyVals = t.numKeyVals
 	return nt
 }
 
@@ -193,6 +192,11 @@ func (t compressedTable) nentries() uint {
 	return uint(len(t.nodes))
 }
 
+// nkeyvals() is required for tableI
+func (t compressedTable) nkeyvals() uint {
+	return t.numKeyVals
+}
+
 // This function MUST return the slice of tableEntry structs from lowest
 // tableEntry.idx to highest tableEntry.idx .
 func (t compressedTable) entries() []tableEntry {
@@ -229,7 +233,7 @@ func (t compressedTable) get(idx uint) nodeI {
 	return node
 }
 
-func (t compressedTable) insert(idx uint, entry nodeI) tableI {
+func (t compressedTable) insert(cfg config, idx uint, entry nodeI) tableI {
 	var nodeBit = uint32(1 << idx)
 	var bitMask = nodeBit - 1
 	var i = bitCount32(t.nodeMap & bitMask)
@@ -248,10 +252,12 @@ func (t compressedTable) insert(idx uint, entry nodeI) tableI {
 	copy(nt.nodes, t.nodes[:i])
 	nt.nodes[i] = entry
 	copy(nt.nodes[i+1:], t.nodes[i:])
+	nt.numKeyVals += nodeKeyVals(entry)
 
-	if GradeTables && uint(len(nt.nodes)) >= UpgradeThreshold {
+	if cfg.gradeTables && uint(len(nt.nodes)) >= cfg.upgradeThreshold {
 		// promote compressedTable to fullTable
-		return upgradeToFullTable(nt.hashPath, nt.depth, nt.entries())
+		cfg.noteGraded(true)
+		return upgradeToFullTable(nt.hashPath, nt.entries())
 	}
 
 	return nt
@@ -273,11 +279,12 @@ func (t compressedTable) replace(idx uint, entry nodeI) tableI {
 	copy(nt.nodes, t.nodes)
 
 	nt.nodes[i] = entry
+	nt.numKeyVals += nodeKeyVals(entry) - nodeKeyVals(t.nodes[i])
 
 	return nt
 }
 
-func (t compressedTable) remove(idx uint) tableI {
+func (t compressedTable) remove(cfg config, idx uint) tableI {
 	var nodeBit = uint32(1 << idx)
 	var bitMask = nodeBit - 1
 	var i = bitCount32(t.nodeMap & bitMask)
@@ -294,6 +301,7 @@ func (t compressedTable) remove(idx uint) tableI {
 	nt.nodes = make([]nodeI, len(t.nodes)-1)
 	copy(nt.nodes, t.nodes[:i])
 	copy(nt.nodes[i:], t.nodes[i+1:])
+	nt.numKeyVals -= nodeKeyVals(t.nodes[i])
 
 	if nt.nodeMap == 0 {
 		return nil
@@ -317,23 +325,24 @@ func nodeMapString(nodeMap uint32) string {
 	return strings.Join(strs, " ")
 }
 
-//String() is required for nodeI depth
+// String() is required for nodeI. A table does not record its depth, so
+// the whole hashPath is printed, zero past the table's own levels.
 func (t compressedTable) String() string {
 	// compressedTale{hashPath:/%d/%d/%d/%d/%d/%d, nentries:%d,}
-	return fmt.Sprintf("compressedTable{hashPath:%s, nentries()=%d, depth=%d}",
-		t.hashPath.HashPathString(t.depth), t.nentries(), t.depth)
+	return fmt.Sprintf("compressedTable{hashPath:%s, nentries()=%d}",
+		t.hashPath, t.nentries())
 }
 
 // LongString() is required for tableI
-func (t compressedTable) LongString(indent string, recurse bool) string {
+func (t compressedTable) LongString(indent string, depth uint, recurse bool) string {
 	var strs = make([]string, 2+len(t.nodes))
 
-	strs[0] = indent + fmt.Sprintf("compressedTable{hashPath=%s, nentries()=%d, t.depth=%d, nodeMap=%s,", t.hashPath.HashPathString(t.depth), t.nentries(), t.depth, nodeMapString(t.nodeMap))
+	strs[0] = indent + fmt.Sprintf("compressedTable{hashPath=%s, nentries()=%d, depth=%d, nodeMap=%s,", t.hashPath.HashPathString(depth), t.nentries(), depth, nodeMapString(t.nodeMap))
 
 	for i, n := range t.nodes {
 		if tt, ok := n.(tableI); ok {
 			if recurse {
-				strs[1+i] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]:\n%s", i, tt.LongString(indent+fullIndent, recurse))
+				strs[1+i] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]:\n%s", i, tt.LongString(indent+fullIndent, depth+1, recurse))
 			} else {
 				strs[1+i] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]: %s", i, tt.String())
 			}
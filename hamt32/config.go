@@ -0,0 +1,103 @@
+package hamt32
+
+import "github.com/lleo/go-hamt-key"
+
+// config is the table policy, and WithMerkle, WithHasher,
+// WithValueCompressor, WithValueInterner, WithAdaptiveTables,
+// WithKeyNormalizer, WithSizeFunc, WithCopyHook, WithAssertLevel,
+// WithInstruments, and WithCodec settings, of a Hamt. Hamts created by New()
+// point to their own config; the zero Hamt uses the package variables.
+type config struct {
+	gradeTables        bool
+	fullTableInit      bool
+	upgradeThreshold   uint
+	downgradeThreshold uint
+	merkle             bool
+	hasher             Hasher
+	compressor         Compressor
+	compressThreshold  int
+	interner           *Interner
+	adaptive           *adaptivePolicy
+	normalizer         func(key.Key) key.Key
+	sizeFunc           func(k key.Key, v interface{}) int
+	copyHook           func(op string, k key.Key, copied, pathLen int)
+	assert             AssertLevel
+	instruments        Instruments
+	codec              Codec
+}
+
+// globalConfig returns the table policy of the package variables
+// GradeTables, FullTableInit, UpgradeThreshold, and DowngradeThreshold.
+func globalConfig() config {
+	return config{
+		gradeTables:        GradeTables,
+		fullTableInit:      FullTableInit,
+		upgradeThreshold:   UpgradeThreshold,
+		downgradeThreshold: DowngradeThreshold,
+	}
+}
+
+// conf returns the table policy of the Hamt.
+func (h Hamt) conf() config {
+	if h.cfg == nil {
+		return globalConfig()
+	}
+	return *h.cfg
+}
+
+// Option sets one part of the table policy of a Hamt created by New().
+type Option func(*config)
+
+// New returns an empty Hamt with its own table policy. The policy starts as
+// the current values of the package variables GradeTables, FullTableInit,
+// UpgradeThreshold, and DowngradeThreshold, then opts are applied. The policy
+// is carried by every Hamt derived from the new Hamt; later changes to the
+// package variables do not affect it. So Hamts with different table
+// strategies can be used side by side.
+func New(opts ...Option) Hamt {
+	var cfg = globalConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return Hamt{cfg: &cfg, assert: cfg.assert}
+}
+
+// WithGradeTables sets whether tables are upgraded and downgraded between
+// compressedTables and fullTables; see GradeTables.
+func WithGradeTables(grade bool) Option {
+	return func(cfg *config) {
+		cfg.gradeTables = grade
+	}
+}
+
+// WithFullTableInit sets whether new tables are fullTables; see
+// FullTableInit.
+func WithFullTableInit(full bool) Option {
+	return func(cfg *config) {
+		cfg.fullTableInit = full
+	}
+}
+
+// WithUpgradeThreshold sets the number of entries at which a compressedTable
+// is upgraded to a fullTable; see UpgradeThreshold.
+func WithUpgradeThreshold(n uint) Option {
+	return func(cfg *config) {
+		cfg.upgradeThreshold = n
+	}
+}
+
+// WithDowngradeThreshold sets the number of entries below which a fullTable
+// is downgraded to a compressedTable; see DowngradeThreshold.
+func WithDowngradeThreshold(n uint) Option {
+	return func(cfg *config) {
+		cfg.downgradeThreshold = n
+	}
+}
+
+// WithAssertLevel sets the AssertLevel at which the Hamt checks its
+// invariants; see AssertLevel. Default: AssertOff
+func WithAssertLevel(lvl AssertLevel) Option {
+	return func(cfg *config) {
+		cfg.assert = lvl
+	}
+}
@@ -0,0 +1,62 @@
+package hamt32
+
+import (
+	"github.com/lleo/go-hamt-key"
+)
+
+// WithCopyHook sets a function called after every Put or Del that modifies
+// a Hamt. It is given the key, as normalized, the number of tables in the
+// new Hamt that are not shared with the old Hamt, and the number of tables
+// along the hash path of the key in the new Hamt. The persistence property
+// is that copied <= pathLen; ie. a modification copies at most the depth+1
+// tables on one hash path. By default, or if fn is nil, nothing is called.
+//
+// A copy hook is for tests; computing copied is O(n) in the number of
+// tables.
+func WithCopyHook(fn func(op string, k key.Key, copied, pathLen int)) Option {
+	return func(cfg *config) {
+		cfg.copyHook = fn
+	}
+}
+
+// reportCopies() calls the copy hook of nh, if set, for the modification of
+// h into nh.
+func (nh *Hamt) reportCopies(op string, h Hamt, k key.Key) {
+	if nh.cfg == nil || nh.cfg.copyHook == nil {
+		return
+	}
+
+	var old = make(map[tableI]bool)
+	collectTables(h.root, old)
+
+	var copied int
+	var cur = make(map[tableI]bool)
+	collectTables(nh.root, cur)
+	for t := range cur {
+		if !old[t] {
+			copied++
+		}
+	}
+
+	var pathLen int
+	var h30 = k.Hash30()
+	for t, depth := nh.root, uint(0); t != nil; depth++ {
+		pathLen++
+		t, _ = t.get(h30.Index(depth)).(tableI)
+	}
+
+	nh.cfg.copyHook(op, userKey(k), copied, pathLen)
+}
+
+// collectTables adds t and every table below it to the set m.
+func collectTables(t tableI, m map[tableI]bool) {
+	if t == nil {
+		return
+	}
+	m[t] = true
+	for _, ent := range t.entries() {
+		if child, isTable := ent.node.(tableI); isTable {
+			collectTables(child, m)
+		}
+	}
+}
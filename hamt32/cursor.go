@@ -0,0 +1,83 @@
+package hamt32
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// The first byte of a Cursor is the direction of its Iter.
+const (
+	cursorForward = 1 + iota
+	cursorReverse
+)
+
+// Cursor returns the position of the iterator, just after the last pair
+// returned by Next, as bytes to be saved; eg. as the checkpoint of a long
+// export. IterAt resumes from it, in the same direction, on the same Hamt or
+// any later version.
+//
+// A position is a hash path and the number of pairs of the leaf at that hash
+// path already returned. So on a later version, every pair before the hash
+// path is skipped and every pair after it is returned, whatever was Put or
+// Deleted in between. Only when pairs of a collisionLeaf at that very hash
+// path were Put or Deleted in between may a pair of it be skipped or
+// returned twice.
+func (it *Iter) Cursor() []byte {
+	var buf = make([]byte, 1, 1+2*binary.MaxVarintLen64)
+	buf[0] = cursorForward
+	if it.reverse {
+		buf[0] = cursorReverse
+	}
+	buf = binary.AppendUvarint(buf, uint64(it.pos))
+	buf = binary.AppendUvarint(buf, uint64(it.npos))
+	return buf
+}
+
+// IterAt returns a new Iter of the Hamt positioned at cursor, a value
+// returned by Iter.Cursor. An error wrapping ErrBadCursor is returned if
+// cursor can not be decoded.
+func (h Hamt) IterAt(cursor []byte) (*Iter, error) {
+	if len(cursor) == 0 || (cursor[0] != cursorForward && cursor[0] != cursorReverse) {
+		return nil, fmt.Errorf("%w: unknown direction", ErrBadCursor)
+	}
+
+	var hv, n1 = binary.Uvarint(cursor[1:])
+	if n1 <= 0 || hv > maxHashPath {
+		return nil, fmt.Errorf("%w: bad hash path", ErrBadCursor)
+	}
+	var npos, n2 = binary.Uvarint(cursor[1+n1:])
+	if n2 <= 0 || 1+n1+n2 != len(cursor) {
+		return nil, fmt.Errorf("%w: bad leaf position", ErrBadCursor)
+	}
+
+	var it = h.Iter()
+	if cursor[0] == cursorReverse {
+		it = h.ReverseIter()
+	}
+	it.Seek(key.HashVal30(hv))
+	if len(it.stack) == 0 {
+		return it, nil
+	}
+
+	// Skip the pairs of the leaf at the hash path already returned.
+	var top = &it.stack[len(it.stack)-1]
+	if top.i >= 0 && top.i < len(top.ents) {
+		if l, isLeaf := top.ents[top.i].node.(leafI); isLeaf && l.Hash30() == it.pos {
+			top.i += it.step()
+			it.kvs = l.keyVals()
+			if npos > uint64(len(it.kvs)) {
+				npos = uint64(len(it.kvs))
+			}
+			if it.reverse {
+				it.kvs = it.kvs[:uint64(len(it.kvs))-npos]
+			} else {
+				it.kvs = it.kvs[npos:]
+			}
+			it.npos = int(npos)
+		}
+	}
+
+	return it, nil
+}
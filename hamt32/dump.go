@@ -0,0 +1,84 @@
+package hamt32
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// dumpNode is the JSON object DumpTree writes for each table and leaf.
+type dumpNode struct {
+	Type     string     `json:"type"`
+	Index    *uint      `json:"index,omitempty"`
+	Depth    uint       `json:"depth"`
+	HashPath string     `json:"hashPath"`
+	NodeMap  string     `json:"nodeMap,omitempty"`
+	Keys     []string   `json:"keys,omitempty"`
+	Entries  []dumpNode `json:"entries,omitempty"`
+}
+
+// dumpTree is the JSON object DumpTree writes for a Hamt.
+type dumpTree struct {
+	Width    uint      `json:"width"`
+	Nentries uint      `json:"nentries"`
+	Root     *dumpNode `json:"root"`
+}
+
+// DumpTree writes the shape of the trie of the Hamt to w, as one JSON
+// object. Every table and leaf is an object with its "type", its "index" in
+// its parent table, the "depth" of the table that holds it, and its
+// "hashPath". A table also has its "nodeMap", as a hex string, and its
+// "entries"; a leaf has the String() of its "keys". Values are not
+// written. The "root" of an empty Hamt is null.
+func (h Hamt) DumpTree(w io.Writer) error {
+	var tree = dumpTree{Width: TableCapacity, Nentries: h.nentries}
+	if !h.IsEmpty() {
+		var root = dumpTable(h.root, 0)
+		tree.Root = &root
+	}
+	return json.NewEncoder(w).Encode(tree)
+}
+
+func dumpTable(t tableI, depth uint) dumpNode {
+	var d = dumpNode{Depth: depth, HashPath: t.Hash30().HashPathString(depth)}
+	switch t.(type) {
+	case *compressedTable:
+		d.Type = "compressedTable"
+	case *fullTable:
+		d.Type = "fullTable"
+	}
+
+	var nodeMap uint32
+	var ents = t.entries()
+	d.Entries = make([]dumpNode, len(ents))
+	for i, ent := range ents {
+		nodeMap |= 1 << ent.idx
+
+		switch x := ent.node.(type) {
+		case tableI:
+			d.Entries[i] = dumpTable(x, depth+1)
+		case leafI:
+			d.Entries[i] = dumpLeaf(x, depth)
+		}
+		var idx = ent.idx
+		d.Entries[i].Index = &idx
+	}
+	d.NodeMap = fmt.Sprintf("%#x", nodeMap)
+
+	return d
+}
+
+func dumpLeaf(l leafI, depth uint) dumpNode {
+	var d = dumpNode{
+		Type:     "flatLeaf",
+		Depth:    depth,
+		HashPath: l.Hash30().HashPathString(MaxDepth + 1),
+	}
+	if _, isCollision := l.(*collisionLeaf); isCollision {
+		d.Type = "collisionLeaf"
+	}
+	for _, kv := range l.keyVals() {
+		d.Keys = append(d.Keys, kv.Key.String())
+	}
+	return d
+}
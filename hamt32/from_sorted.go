@@ -0,0 +1,84 @@
+package hamt32
+
+import (
+	"github.com/lleo/go-hamt-key"
+)
+
+// FromSortedEntries builds a new Hamt from a stream of key/val pairs sorted
+// by SortEntries. The trie is built bottom-up: every table is allocated
+// once, at its final size, after the tables below it; there is no path
+// copying at all. This is the fastest way to create a large Hamt; eg. when
+// loading a snapshot.
+//
+// When a key occurs more than once, the last value wins. If the stream is
+// not in hash path order a *KeyError wrapping ErrUnsortedEntries is
+// returned, and for a nil key one wrapping ErrNilKey. The new Hamt is
+// configured by opts, as by New().
+func FromSortedEntries(it SortedEntries, opts ...Option) (Hamt, error) {
+	var h Hamt
+	if len(opts) > 0 {
+		h = New(opts...)
+	}
+
+	var kvs []key.KeyVal
+	for kv, ok := it.Next(); ok; kv, ok = it.Next() {
+		if kv.Key == nil {
+			return Hamt{}, &KeyError{"FromSortedEntries", nil, ErrNilKey}
+		}
+		kv.Key = h.hashKey(kv.Key)
+		kv.Val = h.conf().storeVal(kv.Val)
+		if len(kvs) > 0 && hashPathLess(kv.Key.Hash30(), kvs[len(kvs)-1].Key.Hash30()) {
+			return Hamt{}, &KeyError{"FromSortedEntries", userKey(kv.Key), ErrUnsortedEntries}
+		}
+		kvs = append(kvs, kv)
+	}
+
+	if len(kvs) == 0 {
+		return h, nil
+	}
+
+	var m = merger{cfg: h.conf()}
+	h.root = m.buildTable(0, kvs)
+	h.nentries = uint(m.added)
+	h.nbytes = m.nbytes
+	h.adaptAll(len(kvs))
+	h.seal()
+
+	return h, nil
+}
+
+// buildTable returns a new table at depth holding the sorted pairs of kvs.
+func (m *merger) buildTable(depth uint, kvs []key.KeyVal) tableI {
+	var ngroups = 1
+	for i := 1; i < len(kvs); i++ {
+		if kvs[i].Key.Hash30().Index(depth) != kvs[i-1].Key.Hash30().Index(depth) {
+			ngroups++
+		}
+	}
+
+	var ents = make([]tableEntry, 0, ngroups)
+	for len(kvs) > 0 {
+		var idx = kvs[0].Key.Hash30().Index(depth)
+		var n = 1
+		for n < len(kvs) && kvs[n].Key.Hash30().Index(depth) == idx {
+			n++
+		}
+
+		var node nodeI
+		if kvs[0].Key.Hash30() == kvs[n-1].Key.Hash30() {
+			node = m.buildLeaf(kvs[:n])
+		} else {
+			node = m.buildTable(depth+1, kvs[:n])
+		}
+
+		ents = append(ents, tableEntry{idx, node})
+		kvs = kvs[n:]
+	}
+
+	var hashPath key.HashVal30
+	if depth > 0 {
+		hashPath = ents[0].node.Hash30() & key.HashPathMask30(depth-1)
+	}
+
+	return newTableFromEntries(m.cfg, hashPath, ents)
+}
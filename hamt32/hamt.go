@@ -45,6 +45,10 @@ const TableCapacity uint = 1 << key.BitsPerLevel30
 // GradeTables variable controls whether Hamt structures will upgrade/
 // downgrade compressed/full tables. This variable and FullTableInit
 // should not be changed during the lifetime of any Hamt structure.
+//
+// These four table policy variables are the defaults of New(), and the
+// policy of every Hamt not created by New() (eg. Hamt{}). A Hamt created by
+// New() keeps its own copy of the policy; see Option.
 // Default: true
 var GradeTables = true
 
@@ -68,13 +72,24 @@ var DowngradeThreshold = TableCapacity / 4
 type Hamt struct {
 	root     tableI
 	nentries uint
+	nbytes   int // see Stats.StoredBytes
+	assert   AssertLevel
+	cfg      *config // nil means use the package variables
 }
 
 func (h Hamt) IsEmpty() bool {
 	//return h.root == nil
 	//return h.nentries == 0
 	//return h.root == nil && h.nentries == 0
-	return h == Hamt{}
+	//return h == Hamt{}
+	return h.root == nil
+}
+
+// Same returns true if a and b share the same root table; ie. they are the
+// same version of a Hamt. It is an O(1) check, unlike comparing the entries
+// of a and b.
+func Same(a, b Hamt) bool {
+	return a.root == b.root && a.nentries == b.nentries
 }
 
 //func (h Hamt) Root() tableI {
@@ -85,16 +100,16 @@ func (h Hamt) Nentries() uint {
 	return h.nentries
 }
 
-func createRootTable(leaf leafI) tableI {
-	if FullTableInit {
+func createRootTable(cfg config, leaf leafI) tableI {
+	if cfg.fullTableInit {
 		return createRootFullTable(leaf)
 	}
 	return createRootCompressedTable(leaf)
 }
 
 //func createTable(depth uint, leaf1 leafI, k key.Key, v interface{}) tableI {
-func createTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
-	if FullTableInit {
+func createTable(cfg config, depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
+	if cfg.fullTableInit {
 		return createFullTable(depth, leaf1, leaf2)
 	}
 	return createCompressedTable(depth, leaf1, leaf2)
@@ -117,7 +132,7 @@ func (nh *Hamt) persist(oldTable, newTable tableI, path tableStack) {
 	var newParent tableI
 
 	if newTable == nil {
-		newParent = oldParent.remove(parentIdx)
+		newParent = oldParent.remove(nh.conf(), parentIdx)
 	} else {
 		newParent = oldParent.replace(parentIdx, newTable)
 	}
@@ -180,11 +195,62 @@ DepthIter:
 //	return
 //}
 
+// walk calls fn for every leaf of the Hamt, in hash path order, until fn
+// returns false. It returns false if fn stopped the walk.
+func (h Hamt) walk(fn func(leafI) bool) bool {
+	if h.IsEmpty() {
+		return true
+	}
+	return walkTable(h.root, fn)
+}
+
+// walkNode is walk for the subtree of node; node may be a table or a leaf.
+func walkNode(node nodeI, fn func(leafI) bool) bool {
+	switch n := node.(type) {
+	case tableI:
+		return walkTable(n, fn)
+	case leafI:
+		return fn(n)
+	}
+	return true
+}
+
+func walkTable(t tableI, fn func(leafI) bool) bool {
+	for _, ent := range t.entries() {
+		switch n := ent.node.(type) {
+		case tableI:
+			if !walkTable(n, fn) {
+				return false
+			}
+		case leafI:
+			if !fn(n) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Get(k) retrieves the value for a given key from the Hamt. The bool
+// represents whether the key was found.
 func (h Hamt) Get(k key.Key) (val interface{}, found bool) {
+	mustKey("Get", k)
+	defer h.observeOp("Get", h.opStart())
+	h.noteRead()
+	val, found = h.get(k)
+	val = decompressVal(val)
+	return
+}
+
+// get is Get without decompressing the value; it returns the value exactly
+// as it was stored in the leaf.
+func (h Hamt) get(k key.Key) (val interface{}, found bool) {
 	if h.IsEmpty() {
 		return //nil, false
 	}
 
+	k = h.hashKey(k)
+
 	var h30 = k.Hash30()
 
 	var curTable = h.root
@@ -214,32 +280,75 @@ func (h Hamt) Get(k key.Key) (val interface{}, found bool) {
 // Put inserts a key/val pair into Hamt, returning a new persistent Hamt and a
 // bool indicating if the key/val pair was added(true) or mearly updated(false).
 func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
+	mustKey("Put", k)
+	defer h.observeOp("Put", h.opStart())
+	return h.put(k, h.conf().storeVal(v))
+}
+
+// storeVal applies the Interner, then the Compressor, of cfg to v; it
+// returns the value as it is to be stored in a leaf.
+func (cfg config) storeVal(v interface{}) interface{} {
+	if cfg.interner != nil {
+		v = cfg.interner.Intern(v)
+	}
+	return cfg.compressVal(v)
+}
+
+// put is Put without the Interner and Compressor. It is used directly for
+// the Hamts internal to OrderedHamt, IndexedMap, etc, whose values are not
+// the user's values.
+func (h Hamt) put(k key.Key, v interface{}) (nh Hamt, added bool) {
+	k = h.hashKey(k)
+	var path, leaf, idx = h.find(k)
+	return h.putAt(k, v, path, leaf, idx)
+}
+
+// putAt is put of the hashed key k, where path, leaf, and idx are h.find(k).
+func (h Hamt) putAt(k key.Key, v interface{}, path tableStack, leaf leafI, idx uint) (nh Hamt, added bool) {
+	nh, added = h.linkAt(k, v, path, leaf, idx)
+
+	if !h.IsEmpty() {
+		nh.adapt(k.Hash30())
+	}
+	nh.seal()
+	nh.check("Put", k)
+	nh.reportCopies("Put", h, k)
+
+	//return nh, added
+	return
+}
+
+// linkAt is putAt without adapting, sealing, checking, or reporting the
+// copies of, the new Hamt.
+func (h Hamt) linkAt(k key.Key, v interface{}, path tableStack, leaf leafI, idx uint) (nh Hamt, added bool) {
 	nh = h //copy by value
 
 	if nh.IsEmpty() {
-		nh.root = createRootTable(newFlatLeaf(k, v))
+		nh.root = createRootTable(nh.conf(), newFlatLeaf(k, v))
 		nh.nentries++
+		nh.nbytes += nh.conf().entrySize(k, v)
 		added = true
 		return
 	}
 
-	var path, leaf, idx = h.find(k)
-
 	var curTable = path.pop()
 	var depth = uint(path.len())
 
 	var newTable tableI
 
 	if leaf == nil {
-		newTable = curTable.insert(idx, newFlatLeaf(k, v))
+		newTable = curTable.insert(nh.conf(), idx, newFlatLeaf(k, v))
 		added = true
 	} else {
 		if leaf.Hash30() == k.Hash30() {
+			if old, found := leaf.get(k); found {
+				nh.nbytes -= nh.conf().entrySize(k, old)
+			}
 			var newLeaf leafI
 			newLeaf, added = leaf.put(k, v)
 			newTable = curTable.replace(idx, newLeaf)
 		} else {
-			var tmpTable = createTable(depth+1, leaf, *newFlatLeaf(k, v))
+			var tmpTable = createTable(nh.conf(), depth+1, leaf, *newFlatLeaf(k, v))
 			newTable = curTable.replace(idx, tmpTable)
 			added = true
 		}
@@ -248,6 +357,7 @@ func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
 	if added {
 		nh.nentries++
 	}
+	nh.nbytes += nh.conf().entrySize(k, v)
 
 	nh.persist(curTable, newTable, path)
 
@@ -261,9 +371,43 @@ func (h Hamt) Put(k key.Key, v interface{}) (nh Hamt, added bool) {
 // persistent Hamt structure, otherwise it returns a nil value and the original
 // (immutable) Hamt structure
 func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
-	nh = h // copy by value
+	mustKey("Del", k)
+	defer h.observeOp("Del", h.opStart())
+	nh, val, deleted = h.del(k)
+	if deleted {
+		val = decompressVal(val)
+	}
+	return
+}
 
+// del is Del without decompressing the value; it returns the value exactly
+// as it was stored in the leaf.
+func (h Hamt) del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
+	k = h.hashKey(k)
 	var path, leaf, idx = h.find(k)
+	return h.delAt(k, path, leaf, idx)
+}
+
+// delAt is del of the hashed key k, where path, leaf, and idx are h.find(k).
+func (h Hamt) delAt(k key.Key, path tableStack, leaf leafI, idx uint) (nh Hamt, val interface{}, deleted bool) {
+	nh, val, deleted = h.unlinkAt(k, path, leaf, idx)
+	if !deleted {
+		return
+	}
+
+	nh.adapt(k.Hash30())
+	nh.seal()
+	nh.check("Del", k)
+	nh.reportCopies("Del", h, k)
+
+	//return nh, val, deleted
+	return
+}
+
+// unlinkAt is delAt without adapting, sealing, checking, or reporting the
+// copies of, the new Hamt.
+func (h Hamt) unlinkAt(k key.Key, path tableStack, leaf leafI, idx uint) (nh Hamt, val interface{}, deleted bool) {
+	nh = h // copy by value
 
 	if path == nil { // h.IsEmpty()
 		//return nh, nil, false
@@ -290,7 +434,7 @@ func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
 		}
 
 		if newLeaf == nil {
-			newTable = curTable.remove(idx)
+			newTable = curTable.remove(nh.conf(), idx)
 		} else {
 			newTable = curTable.replace(idx, newLeaf)
 		}
@@ -298,6 +442,7 @@ func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
 
 	if deleted {
 		nh.nentries--
+		nh.nbytes -= nh.conf().entrySize(k, val)
 	}
 
 	nh.persist(curTable, newTable, path)
@@ -307,6 +452,9 @@ func (h Hamt) Del(k key.Key) (nh Hamt, val interface{}, deleted bool) {
 }
 
 func (h Hamt) String() string {
+	if h.root == nil {
+		return fmt.Sprintf("Hamt{ nentries: %d, root: nil }", h.nentries)
+	}
 	return fmt.Sprintf("Hamt{ nentries: %d, root: %s }", h.nentries, h.root)
 }
 
@@ -317,7 +465,7 @@ func (h Hamt) LongString(indent string) string {
 	var str string
 	if h.root != nil {
 		str = indent + fmt.Sprintf("Hamt{ nentries: %d, root:\n", h.nentries)
-		str += indent + h.root.LongString(indent+fullIndent, true)
+		str += indent + h.root.LongString(indent+fullIndent, 0, true)
 		str += indent + "}end\n"
 		return str
 	} else {
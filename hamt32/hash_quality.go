@@ -0,0 +1,90 @@
+package hamt32
+
+import "fmt"
+
+// DepthQuality describes how evenly the entries of the tables at one depth
+// of a Hamt are spread over the TableCapacity slots of those tables.
+type DepthQuality struct {
+	// Depth is the depth of the tables; the root table is at depth 0.
+	Depth uint
+
+	// Tables is the number of tables at Depth.
+	Tables int
+
+	// Entries is the number of leaves and tables held by the tables at Depth.
+	Entries int
+
+	// ChiSquared is Pearson's chi-squared statistic of the per-slot entry
+	// counts, summed over the tables at Depth, against a uniform
+	// distribution.
+	ChiSquared float64
+
+	// Skew is ChiSquared divided by its degrees of freedom,
+	// TableCapacity-1. It is near 1.0 for uniformly distributed hashes;
+	// values well above 1.0 mean the keys, or their hash function, favor
+	// some slots over others.
+	Skew float64
+}
+
+func (dq DepthQuality) String() string {
+	return fmt.Sprintf("DepthQuality{Depth:%d, Tables:%d, Entries:%d, ChiSquared:%.2f, Skew:%.3f}", dq.Depth, dq.Tables, dq.Entries, dq.ChiSquared, dq.Skew)
+}
+
+// HashQuality measures the slot occupancy of the Hamt's tables, depth by
+// depth, against the ideal uniform distribution. It returns one DepthQuality
+// for each depth that has tables.
+//
+// Only the root table is expected to be evenly filled, given enough
+// entries; deeper tables only receive keys whose hash paths collided above
+// them, so their Skew is a meaningful measure only when they hold many
+// entries.
+func (h Hamt) HashQuality() []DepthQuality {
+	if h.IsEmpty() {
+		return nil
+	}
+
+	var counts [][TableCapacity]int
+	var ntables []int
+
+	var tabs = []tableI{h.root}
+	for depth := 0; len(tabs) > 0; depth++ {
+		counts = append(counts, [TableCapacity]int{})
+		ntables = append(ntables, len(tabs))
+
+		var next []tableI
+		for _, t := range tabs {
+			for _, ent := range t.entries() {
+				counts[depth][ent.idx]++
+				if child, isTable := ent.node.(tableI); isTable {
+					next = append(next, child)
+				}
+			}
+		}
+		tabs = next
+	}
+
+	var dqs = make([]DepthQuality, len(counts))
+	for depth := range counts {
+		var n int
+		for _, c := range counts[depth] {
+			n += c
+		}
+
+		var expected = float64(n) / float64(TableCapacity)
+		var chi2 float64
+		for _, c := range counts[depth] {
+			var diff = float64(c) - expected
+			chi2 += diff * diff / expected
+		}
+
+		dqs[depth] = DepthQuality{
+			Depth:      uint(depth),
+			Tables:     ntables[depth],
+			Entries:    n,
+			ChiSquared: chi2,
+			Skew:       chi2 / float64(TableCapacity-1),
+		}
+	}
+
+	return dqs
+}
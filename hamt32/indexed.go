@@ -0,0 +1,145 @@
+package hamt32
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// IndexFunc extracts a secondary index key from a key/val pair. The bool is
+// false if the pair should not appear in that index.
+type IndexFunc func(k key.Key, v interface{}) (key.Key, bool)
+
+// IndexedMap is a functional Hamt with secondary indexes. Every index maps
+// the key returned by its IndexFunc to the set of primary keys with that
+// index key. The indexes are Hamts themselves and are updated with every Put
+// and Del; so each version of an IndexedMap is a consistent, persistent,
+// multi-index record store.
+type IndexedMap struct {
+	h       Hamt
+	fns     []IndexFunc // shared between all versions; never modified
+	indexes []Hamt      // index key -> Hamt{primary key -> nil}
+}
+
+// NewIndexedMap returns an empty IndexedMap with one secondary index per
+// IndexFunc. Indexes are identified by the position of their IndexFunc.
+func NewIndexedMap(fns ...IndexFunc) IndexedMap {
+	var m IndexedMap
+	m.fns = append(m.fns, fns...)
+	m.indexes = make([]Hamt, len(fns))
+	return m
+}
+
+// IsEmpty returns true if the IndexedMap has no entries.
+func (m IndexedMap) IsEmpty() bool {
+	return m.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the IndexedMap.
+func (m IndexedMap) Nentries() uint {
+	return m.h.Nentries()
+}
+
+// Get retrieves the value for a given primary key. The bool represents
+// whether the key was found.
+func (m IndexedMap) Get(k key.Key) (interface{}, bool) {
+	return m.h.Get(k)
+}
+
+// Lookup returns the primary keys whose entries have the index key ik in the
+// i'th index.
+func (m IndexedMap) Lookup(i int, ik key.Key) []key.Key {
+	var set, found = m.indexes[i].Get(ik)
+	if !found {
+		return nil
+	}
+
+	var ks = make([]key.Key, 0, set.(Hamt).Nentries())
+	set.(Hamt).walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			ks = append(ks, userKey(kv.Key))
+		}
+		return true
+	})
+
+	return ks
+}
+
+// Put inserts a key/val pair, and updates every index, returning a new
+// IndexedMap and a bool indicating if the key/val pair was added(true) or
+// merely updated(false).
+func (m IndexedMap) Put(k key.Key, v interface{}) (IndexedMap, bool) {
+	var old, found = m.h.Get(k)
+
+	var nm = m.copyIndexes()
+	nm.h, _ = m.h.Put(k, v)
+
+	for i, fn := range nm.fns {
+		if found {
+			if ik, ok := fn(k, old); ok {
+				nm.indexes[i] = removeFromIndex(nm.indexes[i], ik, k)
+			}
+		}
+		if ik, ok := fn(k, v); ok {
+			nm.indexes[i] = addToIndex(nm.indexes[i], ik, k)
+		}
+	}
+
+	return nm, !found
+}
+
+// Del removes a key, and its index entries, returning a new IndexedMap, the
+// key's value and a bool indicating whether the key was found (and therefor
+// deleted).
+func (m IndexedMap) Del(k key.Key) (IndexedMap, interface{}, bool) {
+	var nh, old, deleted = m.h.Del(k)
+	if !deleted {
+		return m, nil, false
+	}
+
+	var nm = m.copyIndexes()
+	nm.h = nh
+
+	for i, fn := range nm.fns {
+		if ik, ok := fn(k, old); ok {
+			nm.indexes[i] = removeFromIndex(nm.indexes[i], ik, k)
+		}
+	}
+
+	return nm, old, true
+}
+
+func (m IndexedMap) copyIndexes() IndexedMap {
+	var nm = m
+	nm.indexes = make([]Hamt, len(m.indexes))
+	copy(nm.indexes, m.indexes)
+	return nm
+}
+
+func addToIndex(idx Hamt, ik key.Key, k key.Key) Hamt {
+	var set Hamt
+	if s, found := idx.Get(ik); found {
+		set = s.(Hamt)
+	}
+	set, _ = set.put(k, nil)
+	idx, _ = idx.put(ik, set)
+	return idx
+}
+
+func removeFromIndex(idx Hamt, ik key.Key, k key.Key) Hamt {
+	var s, found = idx.Get(ik)
+	if !found {
+		return idx
+	}
+	var set, _, _ = s.(Hamt).Del(k)
+	if set.IsEmpty() {
+		idx, _, _ = idx.Del(ik)
+	} else {
+		idx, _ = idx.put(ik, set)
+	}
+	return idx
+}
+
+func (m IndexedMap) String() string {
+	return fmt.Sprintf("IndexedMap{ nindexes: %d, h: %s }", len(m.indexes), m.h)
+}
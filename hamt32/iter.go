@@ -0,0 +1,165 @@
+package hamt32
+
+import (
+	"sort"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Iter is an iterator over the key/val pairs of a Hamt, in hash path order:
+// ordered by the index of the hash path at depth 0, then at depth 1, and so
+// on. Keys with the same hash path are in the order of their collisionLeaf.
+// Hash path order depends only on the keys, not on the table policy or the
+// order of Puts and Dels. It is also the order of Range and every other walk
+// of a Hamt. An Iter from ReverseIter goes in the reverse order.
+//
+// Iter holds only the path from the root to its current leaf; so it does not
+// materialize the entries of the Hamt. The Hamt is immutable, so the
+// iterator is unaffected by new versions made while it is used.
+type Iter struct {
+	root    tableI
+	reverse bool
+	stack   []iterFrame
+	kvs     []key.KeyVal // remaining pairs of the current leaf
+
+	// Every pair before the hash path pos, and the first npos pairs of the
+	// leaf at pos, have been returned; see Cursor. For a reverse Iter, every
+	// pair after pos and the last npos pairs of the leaf at pos.
+	pos  key.HashVal30
+	npos int
+}
+
+type iterFrame struct {
+	ents []tableEntry
+	i    int
+}
+
+// maxHashPath is the last hash path in hash path order.
+const maxHashPath = 1<<(Nbits*(MaxDepth+1)) - 1
+
+// Iter returns a new Iter positioned before the first key/val pair of the
+// Hamt.
+func (h Hamt) Iter() *Iter {
+	var it = new(Iter)
+	if !h.IsEmpty() {
+		it.root = h.root
+		it.push(h.root.entries())
+	}
+	return it
+}
+
+// ReverseIter returns a new Iter, in reverse hash path order, positioned
+// before the last key/val pair of the Hamt.
+func (h Hamt) ReverseIter() *Iter {
+	var it = &Iter{reverse: true, pos: maxHashPath}
+	if !h.IsEmpty() {
+		it.root = h.root
+		it.push(h.root.entries())
+	}
+	return it
+}
+
+// push starts iterating over ents, from the first entry in the direction of
+// the iterator.
+func (it *Iter) push(ents []tableEntry) {
+	var frame = iterFrame{ents: ents}
+	if it.reverse {
+		frame.i = len(ents) - 1
+	}
+	it.stack = append(it.stack, frame)
+}
+
+// step is the increment of iterFrame.i in the direction of the iterator.
+func (it *Iter) step() int {
+	if it.reverse {
+		return -1
+	}
+	return 1
+}
+
+// Seek positions the iterator before the first key/val pair whose hash path
+// is at or after the hash path of hv, in hash path order; for a reverse
+// Iter, at or before. It costs one descent of the trie. Since hash path order
+// is the same for every version of a Hamt, the Hash30() of the last key seen
+// is a position in any later version; eg. to paginate a Hamt that changes
+// between pages. For a Hamt with a Hasher, use the Hash30() of
+// h.HashKey(k).
+func (it *Iter) Seek(hv key.HashVal30) {
+	it.stack, it.kvs = it.stack[:0], nil
+	it.pos, it.npos = hv, 0
+	if it.root == nil {
+		return
+	}
+
+	var t = it.root
+	for depth := uint(0); ; depth++ {
+		var ents = t.entries()
+		var idx = hv.Index(depth)
+		var i = sort.Search(len(ents), func(i int) bool {
+			return ents[i].idx >= idx
+		})
+		if it.reverse && (i == len(ents) || ents[i].idx != idx) {
+			i-- // the last entry before idx
+		}
+		it.stack = append(it.stack, iterFrame{ents: ents, i: i})
+		if i < 0 || i == len(ents) || ents[i].idx != idx {
+			return
+		}
+
+		switch n := ents[i].node.(type) {
+		case tableI:
+			it.stack[len(it.stack)-1].i += it.step()
+			t = n
+		case leafI:
+			var passed bool
+			if it.reverse {
+				passed = hashPathLess(hv, n.Hash30())
+			} else {
+				passed = hashPathLess(n.Hash30(), hv)
+			}
+			if passed {
+				it.stack[len(it.stack)-1].i += it.step()
+			}
+			return
+		}
+	}
+}
+
+// Next returns the next key/val pair of the Hamt. The bool is false once
+// every pair has been returned.
+func (it *Iter) Next() (key.Key, interface{}, bool) {
+	for len(it.kvs) == 0 {
+		if len(it.stack) == 0 {
+			return nil, nil, false
+		}
+
+		var top = &it.stack[len(it.stack)-1]
+		if top.i < 0 || top.i == len(top.ents) {
+			it.stack = it.stack[:len(it.stack)-1]
+			continue
+		}
+
+		var node = top.ents[top.i].node
+		top.i += it.step()
+
+		switch n := node.(type) {
+		case tableI:
+			it.push(n.entries())
+		case leafI:
+			it.kvs = n.keyVals()
+			it.pos, it.npos = n.Hash30(), 0
+		}
+	}
+
+	var kv key.KeyVal
+	if it.reverse {
+		kv = it.kvs[len(it.kvs)-1]
+		it.kvs = it.kvs[:len(it.kvs)-1]
+	} else {
+		kv = it.kvs[0]
+		it.kvs = it.kvs[1:]
+	}
+	it.npos++
+
+	return userKey(kv.Key), decompressVal(kv.Val), true
+}
@@ -0,0 +1,185 @@
+package hamt32
+
+import (
+	"github.com/lleo/go-hamt-key"
+)
+
+// ResolveFunc returns the value for a key present in both Hamts of a Merge;
+// v1 is the key's value in h, and v2 its value in other. It must return v1
+// when v1 and v2 are the same value; Merge does not call it for keys in
+// tables shared by both Hamts.
+type ResolveFunc func(k key.Key, v1, v2 interface{}) interface{}
+
+// Merge returns a new Hamt with every key/val pair of h and other. The value
+// of a key in both is resolve(k, v1, v2); if resolve is nil, other's value
+// wins. The new Hamt keeps the table policy and AssertLevel of h.
+//
+// The two tries are merged table by table. Tables shared by h and other,
+// eg. because they are versions of the same Hamt, are reused without being
+// visited, as are subtrees present in only one of them. Subtrees taken from
+// other are walked once to count their entries. If h and other normalize or
+// hash their keys differently, every pair of other is put into h instead.
+func (h Hamt) Merge(other Hamt, resolve ResolveFunc) Hamt {
+	if other.IsEmpty() || Same(h, other) {
+		return h
+	}
+	if !h.sameLayout(other) {
+		return h.mergePairs(other, resolve)
+	}
+
+	var nh = h
+	if h.IsEmpty() {
+		nh.root = other.root
+		nh.nentries = other.nentries
+		nh.nbytes = other.nbytes
+		return nh
+	}
+
+	if resolve == nil {
+		resolve = func(_ key.Key, _, v2 interface{}) interface{} { return v2 }
+	}
+
+	var m = merger{cfg: h.conf()}
+	nh.root = m.mergeTables(h.root, other.root, 0, resolve)
+	nh.nentries = uint(int(nh.nentries) + m.added)
+	nh.nbytes += m.nbytes
+	nh.adaptAll(int(other.nentries))
+	nh.seal()
+	nh.checkAll("Merge")
+
+	return nh
+}
+
+// mergePairs is Merge of an other Hamt whose tries are laid out differently
+// than those of h; each pair of other is looked up in h and put through a
+// Builder.
+func (h Hamt) mergePairs(other Hamt, resolve ResolveFunc) Hamt {
+	var b = h.Builder()
+	other.Range(func(k key.Key, v2 interface{}) bool {
+		if v1, found := h.get(k); found && resolve != nil {
+			v2 = resolve(k, decompressVal(v1), v2)
+		}
+		b.Put(k, v2)
+		return true
+	})
+	return b.Freeze()
+}
+
+// mergeTables returns the merge of the tables a and b at depth. The changes
+// in nentries and nbytes are relative to a.
+func (m *merger) mergeTables(a, b tableI, depth uint, resolve ResolveFunc) tableI {
+	if a == b {
+		return a
+	}
+
+	var aents, bents = a.entries(), b.entries()
+	var ents = make([]tableEntry, 0, len(aents)+len(bents))
+
+	var i, j int
+	for i < len(aents) || j < len(bents) {
+		switch {
+		case j == len(bents) || (i < len(aents) && aents[i].idx < bents[j].idx):
+			ents = append(ents, aents[i])
+			i++
+		case i == len(aents) || bents[j].idx < aents[i].idx:
+			var n, nbytes = countTree(m.cfg, bents[j].node)
+			m.added += n
+			m.nbytes += nbytes
+			ents = append(ents, bents[j])
+			j++
+		default:
+			var node = m.mergeNodes(aents[i].node, bents[j].node, depth, resolve)
+			ents = append(ents, tableEntry{aents[i].idx, node})
+			i++
+			j++
+		}
+	}
+
+	return newTableFromEntries(m.cfg, a.Hash30(), ents)
+}
+
+// mergeNodes returns the merge of the nodes a and b, the entries of tables
+// at depth with the same index.
+func (m *merger) mergeNodes(a, b nodeI, depth uint, resolve ResolveFunc) nodeI {
+	if sameTable(a, b) {
+		return a
+	}
+
+	if at, isTable := a.(tableI); isTable {
+		if bt, isTable := b.(tableI); isTable {
+			return m.mergeTables(at, bt, depth+1, resolve)
+		}
+	}
+
+	if bl, isLeaf := b.(leafI); isLeaf {
+		// merge b's pairs into a
+		var kvs = bl.keyVals()
+		for i, kv := range kvs {
+			if v1, found := lookupNode(a, depth, kv.Key); found {
+				kvs[i].Val = m.cfg.storeVal(resolve(userKey(kv.Key), decompressVal(v1), decompressVal(kv.Val)))
+			}
+		}
+		return m.mergeNode(a, depth, kvs)
+	}
+
+	// a is a leaf and b is a table; merge a's pairs into b
+	var n, nbytes = countTree(m.cfg, b)
+	m.added += n
+	m.nbytes += nbytes
+
+	var kvs = a.(leafI).keyVals()
+	for i, kv := range kvs {
+		m.added--
+		m.nbytes -= m.cfg.entrySize(kv.Key, kv.Val)
+		if v2, found := lookupNode(b, depth, kv.Key); found {
+			kvs[i].Val = m.cfg.storeVal(resolve(userKey(kv.Key), decompressVal(kv.Val), decompressVal(v2)))
+		}
+	}
+
+	return m.mergeNode(b, depth, kvs)
+}
+
+// sameTable returns true if a and b are the same table. Leaves are not
+// compared: a flatLeaf is stored by value, and comparing two of them panics
+// when their keys or values are of an uncomparable type, eg. []byte.
+func sameTable(a, b nodeI) bool {
+	var at, isTable = a.(tableI)
+	return isTable && at == b
+}
+
+// lookupNode returns the stored value of k in the subtree of node, the
+// entry of a table at depth.
+func lookupNode(node nodeI, depth uint, k key.Key) (interface{}, bool) {
+	for {
+		switch n := node.(type) {
+		case tableI:
+			depth++
+			node = n.get(k.Hash30().Index(depth))
+		case leafI:
+			return n.get(k)
+		default:
+			return nil, false
+		}
+	}
+}
+
+// countTree returns the number of key/val pairs and their total size in the
+// subtree of node.
+func countTree(cfg config, node nodeI) (n int, nbytes int) {
+	var count = func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			n++
+			nbytes += cfg.entrySize(kv.Key, kv.Val)
+		}
+		return true
+	}
+
+	switch x := node.(type) {
+	case tableI:
+		walkTable(x, count)
+	case leafI:
+		count(x)
+	}
+
+	return n, nbytes
+}
@@ -0,0 +1,195 @@
+package hamt32
+
+import (
+	"sort"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// SortedEntries is a stream of key/val pairs ordered by the hash paths of
+// their keys, as sorted by SortEntries. Next returns false once the stream
+// is exhausted.
+type SortedEntries interface {
+	Next() (key.KeyVal, bool)
+}
+
+type entrySlice struct {
+	kvs []key.KeyVal
+}
+
+func (s *entrySlice) Next() (key.KeyVal, bool) {
+	if len(s.kvs) == 0 {
+		return key.KeyVal{}, false
+	}
+	var kv = s.kvs[0]
+	s.kvs = s.kvs[1:]
+	return kv, true
+}
+
+// SliceEntries returns a SortedEntries stream of kvs; kvs should already be
+// sorted by SortEntries.
+func SliceEntries(kvs []key.KeyVal) SortedEntries {
+	return &entrySlice{kvs}
+}
+
+// SortEntries sorts kvs by the hash paths of their keys; ie. by Index(0),
+// then Index(1), et al. Pairs with equal hashes keep their relative order.
+func SortEntries(kvs []key.KeyVal) {
+	sort.SliceStable(kvs, func(i, j int) bool {
+		return hashPathLess(kvs[i].Key.Hash30(), kvs[j].Key.Hash30())
+	})
+}
+
+func hashPathLess(a, b key.HashVal30) bool {
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		var ai, bi = a.Index(depth), b.Index(depth)
+		if ai != bi {
+			return ai < bi
+		}
+	}
+	return false
+}
+
+// MergeSorted returns a new Hamt with every key/val pair of the stream
+// put into h. Rather than path copying once per key, as Put does, every
+// table that receives new entries is rebuilt exactly once. When a key
+// occurs more than once, the last value wins.
+//
+// Entries that turn out not to be in hash path order (for example because
+// key normalizer changed their keys) are sorted first.
+func (h Hamt) MergeSorted(it SortedEntries) Hamt {
+	var kvs []key.KeyVal
+	var sorted = true
+	for kv, ok := it.Next(); ok; kv, ok = it.Next() {
+		mustKey("MergeSorted", kv.Key)
+		kv.Key = h.hashKey(kv.Key)
+		kv.Val = h.conf().storeVal(kv.Val)
+		if sorted && len(kvs) > 0 && hashPathLess(kv.Key.Hash30(), kvs[len(kvs)-1].Key.Hash30()) {
+			sorted = false
+		}
+		kvs = append(kvs, kv)
+	}
+
+	if len(kvs) == 0 {
+		return h
+	}
+	if !sorted {
+		SortEntries(kvs)
+	}
+
+	var m = merger{cfg: h.conf()}
+	var nh = h
+	nh.root = m.mergeTable(h.root, 0, kvs)
+	nh.nentries = uint(int(nh.nentries) + m.added)
+	nh.nbytes += m.nbytes
+	nh.adaptAll(len(kvs))
+	nh.seal()
+	nh.checkAll("MergeSorted")
+
+	return nh
+}
+
+// merger accumulates the changes in nentries and nbytes of a merge.
+type merger struct {
+	cfg    config
+	added  int
+	nbytes int
+}
+
+// mergeTable returns a new table at depth with the sorted pairs of kvs
+// merged into t; t may be nil. Every kvs[i].Key must be on t's hash path.
+func (m *merger) mergeTable(t tableI, depth uint, kvs []key.KeyVal) tableI {
+	var old []tableEntry
+	if t != nil {
+		old = t.entries()
+	}
+
+	var ents = make([]tableEntry, 0, len(old)+len(kvs))
+	var i int
+	for len(kvs) > 0 {
+		var idx = kvs[0].Key.Hash30().Index(depth)
+		var n = 1
+		for n < len(kvs) && kvs[n].Key.Hash30().Index(depth) == idx {
+			n++
+		}
+
+		for i < len(old) && old[i].idx < idx {
+			ents = append(ents, old[i])
+			i++
+		}
+
+		var node nodeI
+		if i < len(old) && old[i].idx == idx {
+			node = old[i].node
+			i++
+		}
+
+		ents = append(ents, tableEntry{idx, m.mergeNode(node, depth, kvs[:n])})
+		kvs = kvs[n:]
+	}
+	ents = append(ents, old[i:]...)
+
+	var hashPath key.HashVal30
+	if depth > 0 {
+		hashPath = ents[0].node.Hash30() & key.HashPathMask30(depth-1)
+	}
+
+	return newTableFromEntries(m.cfg, hashPath, ents)
+}
+
+// mergeNode returns the node replacing node, the entry of a table at depth,
+// once the sorted pairs of kvs are merged into it; node may be nil.
+func (m *merger) mergeNode(node nodeI, depth uint, kvs []key.KeyVal) nodeI {
+	switch x := node.(type) {
+	case tableI:
+		return m.mergeTable(x, depth+1, kvs)
+	case leafI:
+		// The leaf's pairs go before any pairs of kvs with the same hash;
+		// so the new values win.
+		var lkvs = x.keyVals()
+		var h30 = x.Hash30()
+		var pos = sort.Search(len(kvs), func(i int) bool {
+			return !hashPathLess(kvs[i].Key.Hash30(), h30)
+		})
+
+		var all = make([]key.KeyVal, 0, len(kvs)+len(lkvs))
+		all = append(all, kvs[:pos]...)
+		all = append(all, lkvs...)
+		kvs = append(all, kvs[pos:]...)
+
+		for _, kv := range lkvs {
+			m.added--
+			m.nbytes -= m.cfg.entrySize(kv.Key, kv.Val)
+		}
+	}
+
+	if kvs[0].Key.Hash30() == kvs[len(kvs)-1].Key.Hash30() {
+		return m.buildLeaf(kvs)
+	}
+
+	return m.mergeTable(nil, depth+1, kvs)
+}
+
+// buildLeaf returns a leaf of kvs, which all have the same hash.
+func (m *merger) buildLeaf(kvs []key.KeyVal) leafI {
+	var l leafI = newFlatLeaf(kvs[0].Key, kvs[0].Val)
+	for _, kv := range kvs[1:] {
+		l, _ = l.put(kv.Key, kv.Val)
+	}
+
+	for _, kv := range l.keyVals() {
+		m.added++
+		m.nbytes += m.cfg.entrySize(kv.Key, kv.Val)
+	}
+
+	return l
+}
+
+// newTableFromEntries returns a table with the given entries, which are
+// sorted by idx. The type of table follows the table policy cfg.
+func newTableFromEntries(cfg config, hashPath key.HashVal30, ents []tableEntry) tableI {
+	if cfg.fullTableInit || (cfg.gradeTables && uint(len(ents)) >= cfg.upgradeThreshold) {
+		return upgradeToFullTable(hashPath, ents)
+	}
+	return downgradeToCompressedTable(hashPath, ents)
+}
@@ -0,0 +1,201 @@
+package hamt32
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// The first byte hashed into the digest of each kind of node; so a leaf can
+// never have the digest of a table, or vice versa.
+const (
+	tagDigestFlatLeaf byte = iota
+	tagDigestTable
+	tagDigestCollisionLeaf
+)
+
+// nodeDigest is the content hash of a node. The digest of a table that holds
+// a single leaf, directly or through other single entry tables, is that of
+// the leaf; leaf is true for such digests. So the digest does not depend on
+// the tables Del leaves behind, only on the key/val pairs.
+type nodeDigest struct {
+	sum  [sha256.Size]byte
+	leaf bool
+}
+
+// WithMerkle sets whether every table carries the digest of its children,
+// once it is complete; see Digest. Each Put, Del, Merge, MergeSorted, and
+// Builder.Freeze then costs an extra copy, and a hash, of the tables it
+// changed, but Digest does not rehash the unchanged subtrees.
+func WithMerkle(merkle bool) Option {
+	return func(cfg *config) {
+		cfg.merkle = merkle
+	}
+}
+
+// Digest returns the root digest of h: a SHA-256 hash over every key/val
+// pair, as encoded by the Codec of h, arranged by the trie. Hamts with the
+// same key/val pairs have the same Digest, whatever the order they were put
+// in or the table policy; a change to any pair changes the Digest.
+//
+// For a Hamt made by New(WithMerkle(true)) the digest of every unchanged
+// table is remembered from the previous version; otherwise the whole trie
+// is hashed. A key or value the Codec can not encode returns a
+// *KeyError wrapping the Codec's error.
+func (h Hamt) Digest() ([sha256.Size]byte, error) {
+	if h.IsEmpty() {
+		return tableDigestOf(nil, nil).sum, nil
+	}
+	var d, err = digestTable(h.Codec(), h.root)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return d.sum, nil
+}
+
+// seal replaces every table of nh without a digest with a copy that carries
+// one, when nh was made by New(WithMerkle(true)). Only copies, which no
+// other Hamt can be reading, are written to. If some key/val pair can not be
+// encoded, nh is left unsealed and Digest reports the error.
+func (nh *Hamt) seal() {
+	if nh.IsEmpty() || !nh.conf().merkle {
+		return
+	}
+	if root, _, err := sealTable(nh.Codec(), nh.root); err == nil {
+		nh.root = root
+	}
+}
+
+// cachedDigest returns the digest carried by t, or nil.
+func cachedDigest(t tableI) *nodeDigest {
+	switch x := t.(type) {
+	case *compressedTable:
+		return x.digest
+	case *fullTable:
+		return x.digest
+	}
+	return nil
+}
+
+// sealTable returns t if it carries a digest, otherwise a copy of t, with
+// its entries sealed, that carries its digest. Leaves are encoded by c.
+func sealTable(c Codec, t tableI) (tableI, *nodeDigest, error) {
+	if d := cachedDigest(t); d != nil {
+		return t, d, nil
+	}
+
+	var nt tableI
+	switch x := t.(type) {
+	case *compressedTable:
+		nt = x.copy()
+	case *fullTable:
+		nt = x.copy()
+	}
+
+	var ents = nt.entries()
+	var ds = make([]*nodeDigest, len(ents))
+	for i, ent := range ents {
+		var err error
+		switch x := ent.node.(type) {
+		case tableI:
+			var st tableI
+			if st, ds[i], err = sealTable(c, x); err == nil && st != x {
+				setInPlace(nt, ent.idx, st)
+			}
+		case leafI:
+			ds[i], err = digestLeaf(c, x)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var d = tableDigestOf(ents, ds)
+	switch x := nt.(type) {
+	case *compressedTable:
+		x.digest = d
+	case *fullTable:
+		x.digest = d
+	}
+	return nt, d, nil
+}
+
+// digestTable returns the digest of t, hashing the tables under it that do
+// not carry one. Leaves are encoded by c.
+func digestTable(c Codec, t tableI) (*nodeDigest, error) {
+	if d := cachedDigest(t); d != nil {
+		return d, nil
+	}
+
+	var ents = t.entries()
+	var ds = make([]*nodeDigest, len(ents))
+	for i, ent := range ents {
+		var err error
+		switch x := ent.node.(type) {
+		case tableI:
+			ds[i], err = digestTable(c, x)
+		case leafI:
+			ds[i], err = digestLeaf(c, x)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return tableDigestOf(ents, ds), nil
+}
+
+// tableDigestOf returns the digest of a table with ents, whose digests are
+// ds.
+func tableDigestOf(ents []tableEntry, ds []*nodeDigest) *nodeDigest {
+	if len(ds) == 1 && ds[0].leaf {
+		return ds[0]
+	}
+
+	var nodeMap uint32
+	for _, ent := range ents {
+		nodeMap |= 1 << ent.idx
+	}
+
+	var s = sha256.New()
+	s.Write(binary.AppendUvarint([]byte{tagDigestTable}, uint64(nodeMap)))
+	for _, d := range ds {
+		s.Write(d.sum[:])
+	}
+
+	var d = new(nodeDigest)
+	s.Sum(d.sum[:0])
+	return d
+}
+
+// digestLeaf returns the digest of the key/val pairs of l. The pairs of a
+// collisionLeaf are hashed in the order of their encoding; so the order they
+// were put in does not matter. The pairs are encoded by c.
+func digestLeaf(c Codec, l leafI) (*nodeDigest, error) {
+	var recs [][]byte
+	for _, kv := range l.keyVals() {
+		var rec, err = appendKeyVal(nil, c, "Digest", kv.Key, kv.Val)
+		if err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+
+	var tag = tagDigestFlatLeaf
+	if _, isCollision := l.(*collisionLeaf); isCollision {
+		tag = tagDigestCollisionLeaf
+		sort.Slice(recs, func(i, j int) bool {
+			return bytes.Compare(recs[i], recs[j]) < 0
+		})
+	}
+
+	var s = sha256.New()
+	s.Write([]byte{tag})
+	for _, rec := range recs {
+		s.Write(rec)
+	}
+
+	var d = &nodeDigest{leaf: true}
+	s.Sum(d.sum[:0])
+	return d, nil
+}
@@ -0,0 +1,124 @@
+package hamt32
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// valueList is the persistent list of the values of a key of a Multimap,
+// most recent first. Lists are never modified; PutAppend puts a new head in
+// front of the old list, so every version of a Multimap shares the values
+// of the previous one.
+type valueList struct {
+	val  interface{}
+	next *valueList
+	n    int
+}
+
+// Multimap is a functional map from each key to a list of values. The zero
+// Multimap is empty and ready to use.
+type Multimap struct {
+	h       Hamt
+	nvalues uint
+}
+
+// NewMultimap returns an empty Multimap whose Hamt is configured by opts, as
+// by New().
+func NewMultimap(opts ...Option) Multimap {
+	var m Multimap
+	if len(opts) > 0 {
+		m.h = New(opts...)
+	}
+	return m
+}
+
+// IsEmpty returns true if the Multimap has no keys.
+func (m Multimap) IsEmpty() bool {
+	return m.h.IsEmpty()
+}
+
+// Nkeys returns the number of keys in the Multimap.
+func (m Multimap) Nkeys() uint {
+	return m.h.Nentries()
+}
+
+// Nvalues returns the number of values of every key in the Multimap.
+func (m Multimap) Nvalues() uint {
+	return m.nvalues
+}
+
+// PutAppend returns a new Multimap with v appended to the values of k. It
+// costs one Hamt Put, however many values k has.
+func (m Multimap) PutAppend(k key.Key, v interface{}) Multimap {
+	var l = &valueList{val: m.h.conf().storeVal(v), n: 1}
+	if old, found := m.h.get(k); found {
+		l.next = old.(*valueList)
+		l.n += l.next.n
+	}
+
+	var nm = m
+	nm.h, _ = m.h.put(k, l)
+	nm.nvalues++
+	return nm
+}
+
+// GetAll returns the values of k, in the order they were appended; or nil
+// if k has none.
+func (m Multimap) GetAll(k key.Key) []interface{} {
+	var l, found = m.h.get(k)
+	if !found {
+		return nil
+	}
+	return l.(*valueList).values()
+}
+
+// Count returns the number of values of k.
+func (m Multimap) Count(k key.Key) int {
+	var l, found = m.h.get(k)
+	if !found {
+		return 0
+	}
+	return l.(*valueList).n
+}
+
+// DelAll removes k and all its values, returning a new Multimap, the
+// values in the order they were appended, and a bool indicating whether k
+// was found (and therefor deleted).
+func (m Multimap) DelAll(k key.Key) (Multimap, []interface{}, bool) {
+	var nh, l, deleted = m.h.del(k)
+	if !deleted {
+		return m, nil, false
+	}
+
+	var nm = m
+	nm.h = nh
+	nm.nvalues -= uint(l.(*valueList).n)
+	return nm, l.(*valueList).values(), true
+}
+
+// Range calls fn for every key of the Multimap, and its values in the order
+// they were appended, in hash path order, until fn returns false.
+func (m Multimap) Range(fn func(k key.Key, vs []interface{}) bool) {
+	m.h.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			if !fn(userKey(kv.Key), kv.Val.(*valueList).values()) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (m Multimap) String() string {
+	return fmt.Sprintf("Multimap{ nvalues: %d, h: %s }", m.nvalues, m.h)
+}
+
+// values returns the values of the list, oldest first.
+func (l *valueList) values() []interface{} {
+	var vs = make([]interface{}, l.n)
+	for i := l.n - 1; l != nil; i, l = i-1, l.next {
+		vs[i] = decompressVal(l.val)
+	}
+	return vs
+}
@@ -52,22 +52,38 @@ func (kvs KeyVals) contains(k0 key.Key) bool {
 type tableI interface {
 	nodeI
 
-	LongString(indent string, recurse bool) string
+	LongString(indent string, depth uint, recurse bool) string
 
 	nentries() uint // get the number of nodeI entries
 
+	nkeyvals() uint // get the number of key/val pairs in the subtree
+
 	// Get an Ordered list of index and node pairs. This slice MUST BE Ordered
 	// from lowest index to highest.
 	entries() []tableEntry
 
 	get(idx uint) nodeI
 
-	insert(idx uint, entry nodeI) tableI
+	insert(cfg config, idx uint, entry nodeI) tableI
 	replace(idx uint, entry nodeI) tableI
-	remove(idx uint) tableI
+	remove(cfg config, idx uint) tableI
 }
 
 type tableEntry struct {
 	idx  uint
 	node nodeI
 }
+
+// nodeKeyVals returns the number of key/val pairs in the subtree of node; or
+// zero for a nil node.
+func nodeKeyVals(node nodeI) uint {
+	switch n := node.(type) {
+	case tableI:
+		return n.nkeyvals()
+	case *collisionLeaf:
+		return uint(n.nkeyvals())
+	case leafI:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package hamt32
+
+import (
+	"strings"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// WithKeyNormalizer sets a function applied to every key given to Get, Put
+// and Del before the key is hashed. So keys that are logically the same (eg.
+// "Foo" and "foo" when case folding) find the same entry. By default, or if
+// fn is nil, keys are used as given. Only the Hamts derived from the Hamt
+// returned by New() normalize their keys; so a normalizer never changes the
+// keys of another Hamt.
+//
+// A normalizer MUST return keys of any type it does not handle unchanged;
+// OrderedHamt and IndexedMap store keys of their own types.
+func WithKeyNormalizer(fn func(key.Key) key.Key) Option {
+	return func(cfg *config) {
+		cfg.normalizer = fn
+	}
+}
+
+// normalizeKey returns k normalized per the WithKeyNormalizer setting of h.
+func (h Hamt) normalizeKey(k key.Key) key.Key {
+	if h.cfg == nil || h.cfg.normalizer == nil {
+		return k
+	}
+	return h.cfg.normalizer(k)
+}
+
+// FoldCase is a key normalizer that lower cases the ASCII letters of
+// *stringkey.StringKey keys. Only ASCII is folded; other letters, eg. "É",
+// are left as they are, and Unicode case folding is not attempted. Chain
+// FoldCase after normalize.NFC or normalize.NFKC to also match the other
+// encodings of a string.
+func FoldCase(k key.Key) key.Key {
+	var sk, ok = k.(*stringkey.StringKey)
+	if !ok {
+		return k
+	}
+	var s = strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, sk.Str())
+	if s == sk.Str() {
+		return k
+	}
+	return stringkey.New(s)
+}
+
+// TrimSpace is a key normalizer that removes the leading and trailing white
+// space of *stringkey.StringKey keys.
+func TrimSpace(k key.Key) key.Key {
+	var sk, ok = k.(*stringkey.StringKey)
+	if !ok {
+		return k
+	}
+	var s = strings.TrimSpace(sk.Str())
+	if s == sk.Str() {
+		return k
+	}
+	return stringkey.New(s)
+}
+
+// ChainNormalizers returns a key normalizer that applies each of fns in order.
+func ChainNormalizers(fns ...func(key.Key) key.Key) func(key.Key) key.Key {
+	return func(k key.Key) key.Key {
+		for _, fn := range fns {
+			k = fn(k)
+		}
+		return k
+	}
+}
@@ -0,0 +1,156 @@
+package hamt32
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// OrderedHamt is a functional Hamt that also remembers the order in which
+// its keys were first inserted. Every entry is stored with a sequence number
+// and a second, persistent, Hamt indexes the keys by sequence number. That
+// gives IterByInsertion() a deterministic "oldest first" order on top of the
+// hash trie. When the sequence numbers of deleted keys outnumber the
+// entries, Del renumbers the entries, in order, from 0. Like Hamt,
+// OrderedHamt is immutable and used by value; the zero value is an empty
+// OrderedHamt.
+type OrderedHamt struct {
+	h     Hamt   // key -> seqVal
+	bySeq Hamt   // seqKey -> key
+	seq   uint64 // next sequence number
+}
+
+// compactSlack is how many more sequence numbers than twice its entries an
+// OrderedHamt may have issued before Del renumbers them.
+const compactSlack = 32
+
+type seqVal struct {
+	seq uint64
+	val interface{}
+}
+
+// seqKey is the key.Key of OrderedHamt's sequence number index. Its hash
+// values are the FNV-1 hash of the 8 bytes of the uint64, least significant
+// byte first, xor folded down to 30 and 60 bits: the bits above 30 (or 60)
+// are xored into the low bits.
+type seqKey uint64
+
+func (k seqKey) Hash30() key.HashVal30 {
+	var h = uint32(2166136261)
+	for i := uint(0); i < 8; i++ {
+		h *= 16777619
+		h ^= uint32(byte(k >> (8 * i)))
+	}
+	return key.HashVal30((h >> 30) ^ (h & (1<<30 - 1)))
+}
+
+func (k seqKey) Hash60() key.HashVal60 {
+	var h = uint64(14695981039346656037)
+	for i := uint(0); i < 8; i++ {
+		h *= 1099511628211
+		h ^= uint64(byte(k >> (8 * i)))
+	}
+	return key.HashVal60((h >> 60) ^ (h & (1<<60 - 1)))
+}
+
+func (k seqKey) Equals(k1 key.Key) bool {
+	var sk, ok = k1.(seqKey)
+	return ok && sk == k
+}
+
+func (k seqKey) String() string {
+	return fmt.Sprintf("seqKey(%d)", uint64(k))
+}
+
+// Size is required for Sizer; a seqKey is not sized by its String().
+func (k seqKey) Size() int {
+	return 8
+}
+
+// IsEmpty returns true if the OrderedHamt has no entries.
+func (o OrderedHamt) IsEmpty() bool {
+	return o.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the OrderedHamt.
+func (o OrderedHamt) Nentries() uint {
+	return o.h.Nentries()
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found.
+func (o OrderedHamt) Get(k key.Key) (interface{}, bool) {
+	var sv, found = o.h.Get(k)
+	if !found {
+		return nil, false
+	}
+	return sv.(seqVal).val, true
+}
+
+// Put inserts a key/val pair, returning a new OrderedHamt and a bool
+// indicating if the key/val pair was added(true) or merely updated(false).
+// Updating a key does not change its position in the insertion order.
+func (o OrderedHamt) Put(k key.Key, v interface{}) (OrderedHamt, bool) {
+	if sv, found := o.h.Get(k); found {
+		o.h, _ = o.h.put(k, seqVal{sv.(seqVal).seq, v})
+		return o, false
+	}
+
+	o.h, _ = o.h.put(k, seqVal{o.seq, v})
+	o.bySeq, _ = o.bySeq.put(seqKey(o.seq), k)
+	o.seq++
+
+	return o, true
+}
+
+// Del removes a key, returning a new OrderedHamt, the key's value and a bool
+// indicating whether the key was found (and therefor deleted).
+func (o OrderedHamt) Del(k key.Key) (OrderedHamt, interface{}, bool) {
+	var nh, sv, deleted = o.h.Del(k)
+	if !deleted {
+		return o, nil, false
+	}
+
+	o.h = nh
+	o.bySeq, _, _ = o.bySeq.Del(seqKey(sv.(seqVal).seq))
+	if o.seq > 2*uint64(o.h.Nentries())+compactSlack {
+		o = o.compact()
+	}
+
+	return o, sv.(seqVal).val, true
+}
+
+// compact returns o with its entries renumbered from 0, in order; so the
+// sequence numbers of deleted keys no longer cost IterByInsertion.
+func (o OrderedHamt) compact() OrderedHamt {
+	var h = o.h.Builder()
+	var bySeq = Hamt{assert: o.bySeq.assert, cfg: o.bySeq.cfg}.Builder()
+	var n uint64
+	o.IterByInsertion(func(k key.Key, v interface{}) bool {
+		h.put(k, seqVal{n, v})
+		bySeq.put(seqKey(n), k)
+		n++
+		return true
+	})
+	return OrderedHamt{h.Freeze(), bySeq.Freeze(), n}
+}
+
+// IterByInsertion calls fn for every key/val pair, oldest first, until fn
+// returns false. Its cost is proportional to the number of entries, since
+// Del keeps the sequence numbers issued below twice that.
+func (o OrderedHamt) IterByInsertion(fn func(k key.Key, v interface{}) bool) {
+	for s := uint64(0); s < o.seq; s++ {
+		var k, found = o.bySeq.Get(seqKey(s))
+		if !found {
+			continue
+		}
+		var v, _ = o.Get(k.(key.Key))
+		if !fn(k.(key.Key), v) {
+			return
+		}
+	}
+}
+
+func (o OrderedHamt) String() string {
+	return fmt.Sprintf("OrderedHamt{ seq: %d, h: %s }", o.seq, o.h)
+}
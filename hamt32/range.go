@@ -0,0 +1,193 @@
+package hamt32
+
+import (
+	"sort"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// RangeKeys calls fn for every key of the Hamt, in hash path order, until fn
+// returns false. Values are never touched; in particular compressed values
+// are not decompressed. So RangeKeys is the cheap way to audit keys or to
+// build a filter of them.
+func (h Hamt) RangeKeys(fn func(k key.Key) bool) {
+	h.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			if !fn(userKey(kv.Key)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Range calls fn for every key/val pair of the Hamt, in hash path order,
+// until fn returns false. No table or leaf is visited after fn returns
+// false; so Range is the way to scan a large Hamt for the first matching
+// entry.
+func (h Hamt) Range(fn func(k key.Key, v interface{}) bool) {
+	h.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			if !fn(userKey(kv.Key), decompressVal(kv.Val)) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Find returns the first key/val pair of the Hamt, in hash path order, for
+// which pred returns true, and true; or nil, nil, false if there is none.
+// The walk stops at the first match.
+func (h Hamt) Find(pred func(k key.Key, v interface{}) bool) (key.Key, interface{}, bool) {
+	var fk key.Key
+	var fv interface{}
+	h.Range(func(k key.Key, v interface{}) bool {
+		if pred(k, v) {
+			fk, fv = k, v
+			return false
+		}
+		return true
+	})
+	return fk, fv, fk != nil
+}
+
+// CountWhere returns the number of key/val pairs of the Hamt for which pred
+// returns true; it visits every pair. If pred is nil it returns Nentries(),
+// which the Hamt keeps up to date, without visiting any.
+func (h Hamt) CountWhere(pred func(k key.Key, v interface{}) bool) uint {
+	if pred == nil {
+		return h.nentries
+	}
+
+	var n uint
+	h.Range(func(k key.Key, v interface{}) bool {
+		if pred(k, v) {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
+// SortedRange calls fn for every key/val pair of the Hamt, in the order of
+// less, until fn returns false. The pairs are collected and sorted before
+// the first call of fn; so, unlike Range, SortedRange costs a slice of every
+// pair and O(n log n) comparisons. Values are decompressed only as fn is
+// called.
+func (h Hamt) SortedRange(less func(a, b key.Key) bool, fn func(k key.Key, v interface{}) bool) {
+	h.sortedRange(less, nil, fn)
+}
+
+// RangeBetween calls fn for every key/val pair of the Hamt with lo <= key <
+// hi, in the order of less, until fn returns false. A hash trie has no key
+// order of its own; so, like SortedRange, RangeBetween walks every pair, but
+// only the pairs in [lo, hi) are collected and sorted.
+func (h Hamt) RangeBetween(lo, hi key.Key, less func(a, b key.Key) bool, fn func(k key.Key, v interface{}) bool) {
+	h.sortedRange(less, func(k key.Key) bool {
+		return !less(k, lo) && less(k, hi)
+	}, fn)
+}
+
+// sortedRange is SortedRange of the pairs whose key keep returns true for;
+// or of every pair if keep is nil.
+func (h Hamt) sortedRange(less func(a, b key.Key) bool, keep func(k key.Key) bool, fn func(k key.Key, v interface{}) bool) {
+	var kvs []key.KeyVal
+	if keep == nil {
+		kvs = make([]key.KeyVal, 0, h.nentries)
+	}
+	h.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			var k = userKey(kv.Key)
+			if keep == nil || keep(k) {
+				kvs = append(kvs, key.KeyVal{Key: k, Val: kv.Val})
+			}
+		}
+		return true
+	})
+
+	sort.Slice(kvs, func(i, j int) bool {
+		return less(kvs[i].Key, kvs[j].Key)
+	})
+
+	for _, kv := range kvs {
+		if !fn(kv.Key, decompressVal(kv.Val)) {
+			return
+		}
+	}
+}
+
+// Page returns at most limit key/val pairs of the Hamt, after skipping the
+// first offset pairs, in hash path order. Every table counts the pairs under
+// it; so the skipped pairs are passed over a subtree at a time, and Page
+// costs about one descent of the trie plus the pairs it returns. To page
+// through a Hamt that changes between pages, keep the Cursor of an Iter
+// instead; IterAt resumes from it in one descent of the trie.
+func (h Hamt) Page(offset, limit uint) []key.KeyVal {
+	if offset >= h.nentries || limit == 0 {
+		return nil
+	}
+	if limit > h.nentries-offset {
+		limit = h.nentries - offset
+	}
+
+	var p = pager{offset: offset, limit: limit, kvs: make([]key.KeyVal, 0, limit)}
+	p.table(h.root)
+	return p.kvs
+}
+
+// pager collects the pairs of a Page.
+type pager struct {
+	offset uint // pairs still to be skipped
+	limit  uint
+	kvs    []key.KeyVal
+}
+
+// table adds the pairs of t to the page, skipping every subtree with no
+// more pairs than are still to be skipped. It returns false once the page is
+// full.
+func (p *pager) table(t tableI) bool {
+	for _, ent := range t.entries() {
+		var n = nodeKeyVals(ent.node)
+		if p.offset >= n {
+			p.offset -= n
+			continue
+		}
+
+		switch x := ent.node.(type) {
+		case tableI:
+			if !p.table(x) {
+				return false
+			}
+		case leafI:
+			for _, kv := range x.keyVals()[p.offset:] {
+				p.kvs = append(p.kvs, key.KeyVal{Key: userKey(kv.Key), Val: decompressVal(kv.Val)})
+				if uint(len(p.kvs)) == p.limit {
+					return false
+				}
+			}
+			p.offset = 0
+		}
+	}
+	return true
+}
+
+// Keys returns every key of the Hamt, in hash path order.
+func (h Hamt) Keys() []key.Key {
+	var ks = make([]key.Key, 0, h.nentries)
+	h.RangeKeys(func(k key.Key) bool {
+		ks = append(ks, k)
+		return true
+	})
+	return ks
+}
+
+// Values returns every value of the Hamt, in the same order as Keys().
+func (h Hamt) Values() []interface{} {
+	var vs = make([]interface{}, 0, h.nentries)
+	h.Range(func(_ key.Key, v interface{}) bool {
+		vs = append(vs, v)
+		return true
+	})
+	return vs
+}
@@ -0,0 +1,475 @@
+package hamt32
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// snapshotMagic starts every snapshot made by MarshalBinary; the last byte
+// is the format version.
+const snapshotMagic = "HAMT32\x01"
+
+// The tag byte that starts the encoding of each node of a snapshot.
+const (
+	tagCompressedTable byte = iota + 1
+	tagFullTable
+	tagFlatLeaf
+	tagCollisionLeaf
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler. The snapshot records
+// the trie node by node: the nodeMap of every table, in depth first order,
+// and a record for every leaf. Keys and values are encoded by the Codec of
+// h; see WithCodec.
+//
+// A snapshot of a Hamt with a key or value its Codec can not encode
+// returns a *KeyError wrapping the Codec's error.
+func (h Hamt) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := h.Encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It replaces the
+// entries of h with those of a snapshot made by MarshalBinary; h keeps its
+// AssertLevel, table policy and Codec. The trie is rebuilt exactly as it was
+// recorded, so no key is re-inserted; the values are stored through the
+// Interner and Compressor of h like Put would.
+//
+// A snapshot that is truncated, malformed, or does not decode to a valid
+// Hamt returns an error wrapping ErrCorruptSnapshot, and leaves h unchanged;
+// DecodeCompact loads a snapshot of an invalid Hamt.
+func (h *Hamt) UnmarshalBinary(data []byte) error {
+	var r = bytes.NewReader(data)
+	var nh, err = h.decode(r)
+	if err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes", ErrCorruptSnapshot, r.Len())
+	}
+	*h = nh
+	return nil
+}
+
+// Encode writes the snapshot of h, as made by MarshalBinary, to w. The
+// snapshot is written a node at a time as the trie is walked; so, unlike
+// MarshalBinary, the memory used does not grow with the size of h.
+//
+// Besides the errors of MarshalBinary, the first error writing to w is
+// returned.
+func (h Hamt) Encode(w io.Writer) error {
+	var e = snapshotEncoder{w: bufio.NewWriter(w), codec: h.Codec()}
+	e.buf = append(e.buf, snapshotMagic...)
+	e.buf = binary.AppendUvarint(e.buf, uint64(h.nentries))
+	if err := e.flush(); err != nil {
+		return err
+	}
+	if !h.IsEmpty() {
+		if err := e.node(h.root); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// Decode returns a new Hamt, configured by opts as by New(), read from a
+// snapshot made by Encode or MarshalBinary; see UnmarshalBinary. Besides the
+// new Hamt itself, only the record being decoded is held in memory.
+//
+// If r is not an io.ByteReader it is buffered; so Decode may read past the
+// end of the snapshot. Besides the errors of UnmarshalBinary, the first
+// error reading r, other than io.EOF, is returned.
+func Decode(r io.Reader, opts ...Option) (Hamt, error) {
+	var h Hamt
+	if len(opts) > 0 {
+		h = New(opts...)
+	}
+	return h.decode(r)
+}
+
+// decode returns h with its entries replaced by those of the snapshot read
+// from r.
+func (h Hamt) decode(r io.Reader) (Hamt, error) {
+	var br, ok = r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	var d = snapshotDecoder{r: br, cfg: h.cfg}
+
+	var root, nentries, err = d.snapshot()
+	if err != nil {
+		return Hamt{}, err
+	}
+	return d.hamt(h, root, nentries)
+}
+
+// DecodeCompact is Decode for a snapshot made by an older or buggy writer,
+// that records an invalid trie. Instead of rebuilding the trie as recorded,
+// it puts every key/val pair of the snapshot into the new Hamt through a
+// Builder. So each pair is placed by the hash of its key, recomputed by the
+// new Hamt, wherever it was recorded; a key recorded more than once, eg. in
+// a collisionLeaf, keeps the value recorded last; and the tables are those
+// the configuration of the new Hamt makes. The decoded pairs are held in
+// memory until the new Hamt is built.
+//
+// Only a snapshot that is truncated or malformed returns an error wrapping
+// ErrCorruptSnapshot; besides, the errors are those of Decode.
+func DecodeCompact(r io.Reader, opts ...Option) (Hamt, error) {
+	var h Hamt
+	if len(opts) > 0 {
+		h = New(opts...)
+	}
+	var br, ok = r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	var d = snapshotDecoder{r: br, cfg: h.cfg}
+
+	var root, nentries, err = d.snapshot()
+	if err != nil {
+		return Hamt{}, err
+	}
+	if uint64(d.nentries) != nentries {
+		return Hamt{}, fmt.Errorf("%w: found %d entries; expected %d", ErrCorruptSnapshot, d.nentries, nentries)
+	}
+
+	var b = h.Builder()
+	if root != nil {
+		walkNode(root, func(l leafI) bool {
+			for _, kv := range l.keyVals() {
+				b.put(kv.Key, kv.Val)
+			}
+			return true
+		})
+	}
+	return b.Freeze(), nil
+}
+
+// snapshot decodes a snapshot, as recorded, into the trie under root, and
+// returns it with the number of entries of its header. The trie is not
+// checked.
+func (d *snapshotDecoder) snapshot() (root nodeI, nentries uint64, err error) {
+	var magic []byte
+	if magic, err = d.next(uint64(len(snapshotMagic))); err != nil {
+		return nil, 0, err
+	}
+	if string(magic) != snapshotMagic {
+		return nil, 0, fmt.Errorf("%w: bad magic or version", ErrCorruptSnapshot)
+	}
+
+	if nentries, err = d.uvarint(); err != nil {
+		return nil, 0, err
+	}
+	if nentries > 0 {
+		if root, err = d.node(0); err != nil {
+			return nil, 0, err
+		}
+	}
+	return root, nentries, nil
+}
+
+// hamt returns h with its entries replaced by those under the decoded root,
+// after checking there are nentries of them and they make a valid Hamt.
+func (d *snapshotDecoder) hamt(h Hamt, root nodeI, nentries uint64) (Hamt, error) {
+	var nh = h
+	nh.root, nh.nentries, nh.nbytes = nil, 0, 0
+	if root != nil {
+		var isTable bool
+		if nh.root, isTable = root.(tableI); !isTable {
+			return Hamt{}, fmt.Errorf("%w: root is not a table", ErrCorruptSnapshot)
+		}
+		nh.nentries, nh.nbytes = d.nentries, d.nbytes
+	}
+
+	if uint64(nh.nentries) != nentries {
+		return Hamt{}, fmt.Errorf("%w: found %d entries; expected %d", ErrCorruptSnapshot, nh.nentries, nentries)
+	}
+	if err := nh.Validate(); err != nil {
+		return Hamt{}, fmt.Errorf("%w: %v", ErrCorruptSnapshot, err)
+	}
+	nh.seal()
+
+	return nh, nil
+}
+
+// snapshotEncoder writes a snapshot, a node at a time, through buf; keys
+// and values are encoded by codec.
+type snapshotEncoder struct {
+	w     *bufio.Writer
+	codec Codec
+	buf   []byte
+}
+
+// flush writes, and empties, buf.
+func (e *snapshotEncoder) flush() error {
+	var _, err = e.w.Write(e.buf)
+	e.buf = e.buf[:0]
+	return err
+}
+
+// node writes the encoding of node, and everything below it.
+func (e *snapshotEncoder) node(node nodeI) error {
+	var err error
+
+	switch x := node.(type) {
+	case tableI:
+		if err = e.table(x); err != nil {
+			return err
+		}
+		for _, ent := range x.entries() {
+			if err = e.node(ent.node); err != nil {
+				return err
+			}
+		}
+		return nil
+	case flatLeaf:
+		return e.node(&x)
+	case *flatLeaf:
+		e.buf = append(e.buf, tagFlatLeaf)
+		if e.buf, err = appendKeyVal(e.buf, e.codec, "Encode", x.key, x.val); err != nil {
+			return err
+		}
+	case *collisionLeaf:
+		var kvs = x.keyVals()
+		e.buf = binary.AppendUvarint(append(e.buf, tagCollisionLeaf), uint64(len(kvs)))
+		for _, kv := range kvs {
+			if e.buf, err = appendKeyVal(e.buf, e.codec, "Encode", kv.Key, kv.Val); err != nil {
+				return err
+			}
+		}
+	default:
+		panic(fmt.Sprintf("snapshotEncoder.node: unknown node type %T", node))
+	}
+
+	return e.flush()
+}
+
+// table writes the tag and nodeMap of t, that start its encoding.
+func (e *snapshotEncoder) table(t tableI) error {
+	var tag = tagCompressedTable
+	if _, isFull := t.(*fullTable); isFull {
+		tag = tagFullTable
+	}
+	var nodeMap uint32
+	for _, ent := range t.entries() {
+		nodeMap |= 1 << ent.idx
+	}
+	e.buf = binary.AppendUvarint(append(e.buf, tag), uint64(nodeMap))
+	return e.flush()
+}
+
+// appendKeyVal appends the length prefixed records of k and v, as stored in
+// a leaf and encoded by c, to buf. Encoding errors are reported as a
+// *KeyError of op.
+func appendKeyVal(buf []byte, c Codec, op string, k key.Key, v interface{}) ([]byte, error) {
+	k = userKey(k)
+	var rec, err = c.AppendKey(nil, k)
+	if err != nil {
+		return nil, &KeyError{op, k, err}
+	}
+	buf = append(binary.AppendUvarint(buf, uint64(len(rec))), rec...)
+
+	rec, err = c.AppendVal(rec[:0], decompressVal(v))
+	if err != nil {
+		return nil, &KeyError{op, k, err}
+	}
+	return append(binary.AppendUvarint(buf, uint64(len(rec))), rec...), nil
+}
+
+// snapshotReader is what snapshotDecoder reads from.
+type snapshotReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// snapshotDecoder consumes a snapshot made by Encode, counting the key/val
+// pairs it decodes. Records are read into buf; so buf only grows to the
+// size of the largest record.
+type snapshotDecoder struct {
+	r        snapshotReader
+	cfg      *config
+	buf      []byte
+	nentries uint
+	nbytes   int
+}
+
+// readErr returns the error for err, returned while reading the snapshot.
+func readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: truncated", ErrCorruptSnapshot)
+	}
+	return err
+}
+
+func (d *snapshotDecoder) byte() (byte, error) {
+	var b, err = d.r.ReadByte()
+	if err != nil {
+		return 0, readErr(err)
+	}
+	return b, nil
+}
+
+func (d *snapshotDecoder) uvarint() (uint64, error) {
+	var u uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		var b, err = d.byte()
+		if err != nil {
+			return 0, err
+		}
+		u |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return u, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: bad varint", ErrCorruptSnapshot)
+}
+
+// next reads the next n bytes into buf; they are only valid until the next
+// call of next.
+func (d *snapshotDecoder) next(n uint64) ([]byte, error) {
+	if n <= uint64(cap(d.buf)) {
+		d.buf = d.buf[:n]
+		if _, err := io.ReadFull(d.r, d.buf); err != nil {
+			return nil, readErr(err)
+		}
+		return d.buf, nil
+	}
+
+	// Grow buf as the bytes arrive; so a corrupt length can not make the
+	// decoder allocate far more memory than the snapshot has bytes.
+	var buf = bytes.NewBuffer(d.buf[:0])
+	var m, err = io.CopyN(buf, d.r, int64(n))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if uint64(m) != n {
+		return nil, fmt.Errorf("%w: record of %d bytes is truncated", ErrCorruptSnapshot, n)
+	}
+	d.buf = buf.Bytes()
+	return d.buf, nil
+}
+
+func (d *snapshotDecoder) record() ([]byte, error) {
+	var n, err = d.uvarint()
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+func (d *snapshotDecoder) keyVal() (key.KeyVal, error) {
+	var kv key.KeyVal
+	var c = Hamt{cfg: d.cfg}.Codec()
+
+	var rec, err = d.record()
+	if err != nil {
+		return kv, err
+	}
+	if kv.Key, err = c.DecodeKey(rec); err != nil {
+		return kv, fmt.Errorf("%w: key: %v", ErrCorruptSnapshot, err)
+	}
+	if kv.Key == nil {
+		return kv, fmt.Errorf("%w: %w", ErrCorruptSnapshot, ErrNilKey)
+	}
+
+	if rec, err = d.record(); err != nil {
+		return kv, err
+	}
+	if kv.Val, err = c.DecodeVal(rec); err != nil {
+		return kv, fmt.Errorf("%w: value of %s: %v", ErrCorruptSnapshot, kv.Key, err)
+	}
+
+	kv.Key = Hamt{cfg: d.cfg}.hashKey(kv.Key)
+	kv.Val = Hamt{cfg: d.cfg}.conf().storeVal(kv.Val)
+	d.nentries++
+	d.nbytes += Hamt{cfg: d.cfg}.conf().entrySize(kv.Key, kv.Val)
+
+	return kv, nil
+}
+
+// node decodes the next node, found in a table at depth-1; the root table
+// is decoded at depth 0.
+func (d *snapshotDecoder) node(depth uint) (nodeI, error) {
+	var tag, err = d.byte()
+	if err != nil {
+		return nil, err
+	}
+	return d.tagged(tag, depth, func(uint) (nodeI, error) {
+		return d.node(depth + 1)
+	})
+}
+
+// tagged decodes the rest of the node started by tag, found in a table at
+// depth-1; entry decodes the entry of a table at an index.
+func (d *snapshotDecoder) tagged(tag byte, depth uint, entry func(idx uint) (nodeI, error)) (nodeI, error) {
+	var err error
+
+	switch tag {
+	case tagCompressedTable, tagFullTable:
+		if depth > MaxDepth {
+			return nil, fmt.Errorf("%w: %w: table deeper than MaxDepth,%d", ErrCorruptSnapshot, ErrDepthExhausted, MaxDepth)
+		}
+		var nodeMap uint64
+		if nodeMap, err = d.uvarint(); err != nil {
+			return nil, err
+		}
+		if nodeMap == 0 || uint(bits.Len64(nodeMap)) > TableCapacity {
+			return nil, fmt.Errorf("%w: bad nodeMap %#x", ErrCorruptSnapshot, nodeMap)
+		}
+
+		var ents []tableEntry
+		for idx := uint(0); idx < TableCapacity; idx++ {
+			if nodeMap&(1<<idx) == 0 {
+				continue
+			}
+			var node nodeI
+			if node, err = entry(idx); err != nil {
+				return nil, err
+			}
+			ents = append(ents, tableEntry{idx, node})
+		}
+
+		var hashPath key.HashVal30
+		if depth > 0 {
+			hashPath = ents[0].node.Hash30() & key.HashPathMask30(depth-1)
+		}
+		if tag == tagFullTable {
+			return upgradeToFullTable(hashPath, ents), nil
+		}
+		return downgradeToCompressedTable(hashPath, ents), nil
+	case tagFlatLeaf:
+		var kv key.KeyVal
+		if kv, err = d.keyVal(); err != nil {
+			return nil, err
+		}
+		return newFlatLeaf(kv.Key, kv.Val), nil
+	case tagCollisionLeaf:
+		var n uint64
+		if n, err = d.uvarint(); err != nil {
+			return nil, err
+		}
+		if n < 2 {
+			return nil, fmt.Errorf("%w: collisionLeaf of %d keys", ErrCorruptSnapshot, n)
+		}
+		var kvs []key.KeyVal
+		for i := uint64(0); i < n; i++ {
+			var kv key.KeyVal
+			if kv, err = d.keyVal(); err != nil {
+				return nil, err
+			}
+			kvs = append(kvs, kv)
+		}
+		return newCollisionLeaf(kvs), nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown node tag %d", ErrCorruptSnapshot, tag)
+}
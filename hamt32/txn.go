@@ -0,0 +1,110 @@
+package hamt32
+
+import (
+	"errors"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Txn is a transaction on an AtomicHamt. It reads and writes a private
+// version of the Hamt, based on the version current when it began, and
+// records the keys it reads and writes. Commit makes its writes current
+// unless one of those keys was changed by another writer in the meantime;
+// so the Txn sees and keeps the invariants of several keys as if it ran
+// alone. A Txn is for one goroutine, and must not be used after Commit.
+type Txn struct {
+	a      *AtomicHamt
+	base   Hamt    // the version the Txn began on
+	ver    Version // of base
+	h      Hamt    // base with the writes of the Txn
+	keys   Hamt    // every key read or written, normalized, as a set
+	writes []Op
+}
+
+// Begin starts a Txn on the current Hamt of a.
+func (a *AtomicHamt) Begin() *Txn {
+	var h, ver = a.LoadVersion()
+	return &Txn{a: a, base: h, ver: ver, h: h}
+}
+
+// Atomically runs fn in a new Txn and commits it. If the commit conflicts,
+// fn is run again in a new Txn, until a commit succeeds; so fn must not have
+// side effects outside the Txn. If fn returns an error, the Txn is dropped
+// and the error is returned.
+func (a *AtomicHamt) Atomically(fn func(tx *Txn) error) error {
+	for {
+		var tx = a.Begin()
+		if err := fn(tx); err != nil {
+			return err
+		}
+		var err = tx.Commit()
+		if !errors.Is(err, ErrTxnConflict) {
+			return err
+		}
+	}
+}
+
+// record adds k to the keys of the Txn. k is recorded as the Hamt stores it,
+// after the key normalizer; so it matches the keys reported by Diff.
+func (tx *Txn) record(k key.Key) {
+	tx.keys, _ = tx.keys.put(tx.h.normalizeKey(k), nil)
+}
+
+// Get is Hamt.Get of the version of the Txn; k is recorded as read.
+func (tx *Txn) Get(k key.Key) (interface{}, bool) {
+	tx.record(k)
+	return tx.h.Get(k)
+}
+
+// Put is Hamt.Put of the version of the Txn; k is recorded as written.
+func (tx *Txn) Put(k key.Key, v interface{}) bool {
+	var added bool
+	tx.record(k)
+	tx.h, added = tx.h.Put(k, v)
+	tx.writes = append(tx.writes, PutOp(k, v))
+	return added
+}
+
+// Del is Hamt.Del of the version of the Txn; k is recorded as written.
+func (tx *Txn) Del(k key.Key) (interface{}, bool) {
+	var val interface{}
+	var deleted bool
+	tx.record(k)
+	tx.h, val, deleted = tx.h.Del(k)
+	tx.writes = append(tx.writes, DelOp(k))
+	return val, deleted
+}
+
+// Hamt returns the version of the Txn. Reads of it are not recorded; so
+// they are not checked for conflicts by Commit.
+func (tx *Txn) Hamt() Hamt {
+	return tx.h
+}
+
+// Commit makes the writes of the Txn current in its AtomicHamt. If the
+// AtomicHamt was changed since the Txn began, the Diff of the changes is
+// checked against the keys the Txn read or wrote: if any of them changed,
+// nothing is written and ErrTxnConflict is returned; otherwise the writes
+// are applied again to the current Hamt. A Txn without writes commits
+// trivially.
+func (tx *Txn) Commit() error {
+	if len(tx.writes) == 0 {
+		return nil
+	}
+
+	for {
+		var cur, ver = tx.a.LoadVersion()
+		var nh = tx.h
+		if ver != tx.ver {
+			for _, c := range Diff(tx.base, cur) {
+				if _, found := tx.keys.get(c.Key); found {
+					return ErrTxnConflict
+				}
+			}
+			nh = cur.Apply(tx.writes)
+		}
+		if tx.a.CompareAndSwap(ver, nh) {
+			return nil
+		}
+	}
+}
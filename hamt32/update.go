@@ -0,0 +1,57 @@
+package hamt32
+
+import (
+	"reflect"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Update returns a new Hamt with the value of k replaced by the result of
+// fn, which is given the current value of k and whether k was found. If fn
+// returns keep == false, k is deleted. The key is hashed, and the trie
+// descended, once; where Get followed by Put does both twice.
+//
+// If fn keeps a missing key missing, h is returned.
+func (h Hamt) Update(k key.Key, fn UpdateFunc) Hamt {
+	mustKey("Update", k)
+	k = h.hashKey(k)
+	var path, leaf, idx = h.find(k)
+
+	var old interface{}
+	var found bool
+	if leaf != nil {
+		old, found = leaf.get(k)
+	}
+
+	var val, keep = fn(decompressVal(old), found)
+	switch {
+	case keep:
+		h, _ = h.putAt(k, h.conf().storeVal(val), path, leaf, idx)
+	case found:
+		h, _, _ = h.delAt(k, path, leaf, idx)
+	}
+	return h
+}
+
+// ReplaceIf returns a new Hamt with the value of k replaced by newVal, and
+// true, if k is found with a value eq to expected; otherwise it returns h
+// and false. Values are compared with eq; if eq is nil, reflect.DeepEqual is
+// used. Like Update, the trie is descended once.
+func (h Hamt) ReplaceIf(k key.Key, expected, newVal interface{}, eq func(a, b interface{}) bool) (Hamt, bool) {
+	mustKey("ReplaceIf", k)
+	if eq == nil {
+		eq = reflect.DeepEqual
+	}
+
+	k = h.hashKey(k)
+	var path, leaf, idx = h.find(k)
+	if leaf == nil {
+		return h, false
+	}
+	if old, found := leaf.get(k); !found || !eq(decompressVal(old), expected) {
+		return h, false
+	}
+
+	h, _ = h.putAt(k, h.conf().storeVal(newVal), path, leaf, idx)
+	return h, true
+}
@@ -0,0 +1,67 @@
+package hamt32
+
+import "sync"
+
+// Watcher delivers the Changes between successive versions of a Hamt on its
+// channel C. Each version is given to Observe; eg. the Hamt returned by
+// AtomicHamt.Update, or a.Load() polled by a ticker. The Changes are
+// computed by Diff, which skips the subtrees the two versions share; so a
+// Watcher costs about as much as the changes it reports, not the size of
+// the Hamt.
+type Watcher struct {
+	// C is the channel of the Changes, in the order of the versions given
+	// to Observe and, for each version, in hash path order. It is closed by
+	// Close.
+	C <-chan Change
+
+	mu     sync.Mutex // serializes Observe, and Close with Observe
+	c      chan Change
+	last   Hamt
+	done   chan struct{}
+	once   sync.Once
+	closed bool
+}
+
+// NewWatcher returns a new Watcher of the versions following h. C buffers up
+// to size Changes; with a size of 0 every Observe blocks until its Changes
+// are received.
+func NewWatcher(h Hamt, size int) *Watcher {
+	var c = make(chan Change, size)
+	return &Watcher{C: c, c: c, last: h, done: make(chan struct{})}
+}
+
+// Observe sends the Changes from the last version observed, or the Hamt
+// given to NewWatcher, to h on C, and makes h the last version. It blocks
+// until every Change is sent, or until Close is called, in which case the
+// remaining Changes are dropped; concurrent calls of Observe are
+// serialized. Observe after Close does nothing.
+func (w *Watcher) Observe(h Hamt) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return
+	}
+	for _, c := range Diff(w.last, h) {
+		select {
+		case w.c <- c:
+		case <-w.done:
+			return
+		}
+	}
+	w.last = h
+}
+
+// Close closes C, after the Changes already sent. An Observe blocked on C
+// is stopped first.
+func (w *Watcher) Close() {
+	w.once.Do(func() { close(w.done) })
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.closed {
+		w.closed = true
+		close(w.c)
+	}
+}
@@ -1,12 +1,31 @@
 package hamt_test
 
 import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
 	"github.com/lleo/go-hamt-functional/hamt32"
+	"github.com/lleo/go-hamt-functional/hamttest"
+	"github.com/lleo/go-hamt-functional/hashers"
+	"github.com/lleo/go-hamt-functional/keys"
+	"github.com/lleo/go-hamt-functional/normalize"
+	"github.com/lleo/go-hamt-key"
 	"github.com/lleo/go-hamt-key/stringkey"
 )
 
@@ -150,6 +169,3159 @@ func TestDeleteAll32(t *testing.T) {
 	RunTime[name] = time.Since(StartTime[name])
 }
 
+func TestValueCompression32(t *testing.T) {
+	var k = stringkey.New("blob")
+	var blob = bytes.Repeat([]byte("abcdefgh"), 4096)
+
+	var c = hamt32.New(hamt32.WithValueCompressor(hamt32.FlateCompressor{Level: flate.BestSpeed}, 4096))
+	var h, _ = c.Put(k, blob)
+	if size := h.SizeInBytes(nil); size >= len(blob)/8 {
+		t.Fatalf("h.SizeInBytes() => %d for a %d byte blob; it was not stored compressed", size, len(blob))
+	}
+	var plain, _ = hamt32.Hamt{}.Put(k, blob)
+	if plain.SizeInBytes(nil) < len(blob) {
+		t.Fatalf("a Hamt without a Compressor stored a %d byte blob in %d bytes", len(blob), plain.SizeInBytes(nil))
+	}
+	if small, _ := c.Put(k, blob[:4095]); small.SizeInBytes(nil) < 4095 {
+		t.Fatal("a value below the threshold was stored compressed")
+	}
+
+	var val, found = h.Get(k)
+	if !found {
+		t.Fatalf("failed to h.Get(%s)", k)
+	}
+	if !bytes.Equal(val.([]byte), blob) {
+		t.Fatalf("h.Get(%s) returned %d bytes, expected %d", k, len(val.([]byte)), len(blob))
+	}
+
+	var deleted bool
+	h, val, deleted = h.Del(k)
+	if !deleted {
+		t.Fatalf("failed to h.Del(%s)", k)
+	}
+	if !bytes.Equal(val.([]byte), blob) {
+		t.Fatalf("h.Del(%s) returned %d bytes, expected %d", k, len(val.([]byte)), len(blob))
+	}
+}
+
+func TestValueInterning32(t *testing.T) {
+	var in = hamt32.NewInterner(
+		func(v interface{}) uint64 { return uint64(len(v.(string))) },
+		func(a, b interface{}) bool { return a.(string) == b.(string) },
+		16)
+
+	var h = hamt32.New(hamt32.WithValueInterner(in))
+	var s = "aaa"
+	for i := 0; i < 1024; i++ {
+		h, _ = h.Put(stringkey.New(s), fmt.Sprintf("val%d", i%3))
+		s = Inc(s)
+	}
+
+	if in.Len() != 3 {
+		t.Fatalf("in.Len(),%d != 3", in.Len())
+	}
+	var vals = make(map[*byte]bool)
+	h.Range(func(_ key.Key, v interface{}) bool {
+		vals[unsafe.StringData(v.(string))] = true
+		return true
+	})
+	if len(vals) != 3 {
+		t.Fatalf("the 1024 values of h are %d distinct strings; want 3", len(vals))
+	}
+
+	// The Interner forgets the least recently interned values.
+	var last key.Key
+	for i := 0; i < 100; i++ {
+		last = stringkey.New(s)
+		h, _ = h.Put(last, fmt.Sprintf("val%d", i))
+		s = Inc(s)
+	}
+	if in.Len() != 16 {
+		t.Fatalf("in.Len(),%d != 16", in.Len())
+	}
+	var v99, _ = h.Get(last)
+	var v = fmt.Sprintf("val%d", 99)
+	if iv := in.Intern(v).(string); unsafe.StringData(iv) != unsafe.StringData(v99.(string)) {
+		t.Fatal("in.Intern() of a recently interned value did not return it")
+	}
+	v = fmt.Sprintf("val%d", 0)
+	if iv := in.Intern(v).(string); unsafe.StringData(iv) != unsafe.StringData(v) {
+		t.Fatal("in.Intern() of a forgotten value did not return it as given")
+	}
+
+	// Hamts without the Interner do not use it.
+	hamt32.Hamt{}.Put(stringkey.New("other"), "other")
+	if in.Len() != 16 {
+		t.Fatalf("in.Len(),%d != 16 after a Put to another Hamt", in.Len())
+	}
+}
+
+func TestApply32(t *testing.T) {
+	var k0 = stringkey.New("aaa")
+	var k1 = stringkey.New("aab")
+
+	var incr = func(old interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+
+	var h = hamt32.Hamt{}.Apply([]hamt32.Op{
+		hamt32.PutOp(k0, 10),
+		hamt32.PutOp(k1, 20),
+		hamt32.UpdateOp(k0, incr),
+		hamt32.DelOp(k1),
+	})
+
+	if h.Nentries() != 1 {
+		t.Fatalf("h.Nentries(),%d != 1", h.Nentries())
+	}
+	if val, _ := h.Get(k0); val != 11 {
+		t.Fatalf("h.Get(%s),%v != 11", k0, val)
+	}
+}
+
+func TestAdaptiveTables32(t *testing.T) {
+	var n = hamt32.TableCapacity * hamt32.TableCapacity
+	var adaptive = func() hamt32.Hamt {
+		return hamt32.New(hamt32.WithGradeTables(false), hamt32.WithFullTableInit(false),
+			hamt32.WithAdaptiveTables(n, 2))
+	}
+	var readAll = func(h hamt32.Hamt, kvs []key.KeyVal) {
+		for _, kv := range kvs {
+			if val, found := h.Get(kv.Key); !found || val != kv.Val {
+				t.Fatalf("h.Get(%s) => %v, %t; expected %v", kv.Key, val, found, kv.Val)
+			}
+		}
+	}
+
+	// A lineage that is only written keeps its compressedTables.
+	var w = adaptive()
+	for _, kv := range KVS[:2*n] {
+		w, _ = w.Put(kv.Key, kv.Val)
+	}
+	if s := w.Stats(); s.FullTables != 0 {
+		t.Fatalf("a lineage only written has %d fullTables", s.FullTables)
+	}
+
+	// A lineage read as often as it is written gets fullTables, by Put along
+	// the hash path of the key.
+	var h = adaptive()
+	for _, kv := range KVS[:n] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	readAll(h, KVS[:n])
+	readAll(h, KVS[:n])
+	h, _ = h.Put(KVS[n].Key, KVS[n].Val)
+	if !strings.Contains(h.String(), "root: fullTable") {
+		t.Fatalf("root table was not promoted to a fullTable; h=%s", h)
+	}
+	if s := h.Stats(); s.FullTables != 2 {
+		t.Fatalf("h has %d fullTables after one Put; want 2", s.FullTables)
+	}
+	readAll(h, KVS[:n+1])
+
+	// Bulk operations promote every table of the top levels.
+	var b = adaptive().PutMany(KVS[:n])
+	readAll(b, KVS[:n])
+	readAll(b, KVS[:n])
+	b = b.PutMany(KVS[n : n+1])
+	if s := b.Stats(); s.FullTables != 1+hamt32.TableCapacity {
+		t.Fatalf("b.PutMany() promoted %d tables; want the root and every depth 1 table", s.FullTables)
+	}
+	if err := b.Validate(); err != nil {
+		t.Fatalf("b.Validate() failed: %s", err)
+	}
+	readAll(b, KVS[:n+1])
+}
+
+func TestPathFilter32(t *testing.T) {
+	var f, err = hamt32.ParsePathFilter("/07/*/23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.String() != "/07/*/23" {
+		t.Fatalf("f.String(),%q != %q", f.String(), "/07/*/23")
+	}
+
+	var h = hamt32.Hamt{}
+	var expected int
+	for _, kv := range KVS[:64*1024] {
+		h, _ = h.Put(kv.Key, kv.Val)
+		if f.Match(kv.Key.Hash30()) {
+			expected++
+		}
+	}
+
+	var kvs = h.KeyValsMatching(f)
+	if len(kvs) != expected {
+		t.Fatalf("len(h.KeyValsMatching(%s)),%d != %d", f, len(kvs), expected)
+	}
+	for _, kv := range kvs {
+		if !f.Match(kv.Key.Hash30()) {
+			t.Fatalf("%s returned for %s", kv.Key.Hash30(), f)
+		}
+	}
+
+	if _, err = hamt32.ParsePathFilter("/07/99"); !errors.Is(err, hamt32.ErrBadPathFilter) {
+		t.Fatalf("hamt32.ParsePathFilter(\"/07/99\") returned %v", err)
+	}
+}
+
+func TestCollidingKeyVals32(t *testing.T) {
+	var kvs = hamttest.CollidingKeyVals(20, "aaa")
+
+	var h = hamt32.Hamt{}
+	for _, kv := range kvs {
+		if kv.Key.Hash30() != kvs[0].Key.Hash30() {
+			t.Fatalf("%s and %s do not collide", kv.Key, kvs[0].Key)
+		}
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	if s := h.Stats(); s.CollisionLeaves != 1 || s.FlatLeaves != 0 {
+		t.Fatalf("h.Stats() has %d collisionLeaves and %d flatLeaves; want 1 and 0", s.CollisionLeaves, s.FlatLeaves)
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() failed: %s", err)
+	}
+	for _, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %t; expected %v", kv.Key, val, found, kv.Val)
+		}
+	}
+}
+
+func TestCollisionChunks32(t *testing.T) {
+	// Every key collides; so they all land in one collisionLeaf, in chunks
+	// of 8 pairs.
+	var zero = hamt32.New(hamt32.WithHasher(hamt32.HasherFunc(func([]byte) uint64 { return 0 })))
+
+	for _, n := range []int{8, 9, 16, 17, 25} {
+		var keys = make([]key.Key, n)
+		var h = zero
+		for i := range keys {
+			keys[i] = stringkey.New(fmt.Sprintf("k%02d", i))
+			h, _ = h.Put(keys[i], i)
+			if err := h.Validate(); err != nil {
+				t.Fatalf("after Put(%s) of %d keys: %s", keys[i], i+1, err)
+			}
+		}
+
+		// Delete front to back, back to front, and from the middle chunk
+		// boundaries out; every order must keep the chunks compact.
+		var orders = [][]int{make([]int, n), make([]int, n), nil}
+		for i := 0; i < n; i++ {
+			orders[0][i], orders[1][i] = i, n-1-i
+		}
+		for i := 7; i < n; i += 8 {
+			orders[2] = append(orders[2], i, i+1)
+		}
+		for i := 0; i < n; i++ {
+			if i%8 != 7 && i%8 != 0 || i == 0 {
+				orders[2] = append(orders[2], i)
+			}
+		}
+
+		for _, order := range orders {
+			var dh = h
+			var deleted = make(map[int]bool)
+			for _, i := range order {
+				if deleted[i] || i >= n {
+					continue
+				}
+				var val interface{}
+				var found bool
+				if dh, val, found = dh.Del(keys[i]); !found || val != i {
+					t.Fatalf("n=%d: Del(%s) => %v, %t; want %d, true", n, keys[i], val, found, i)
+				}
+				deleted[i] = true
+				if err := dh.Validate(); err != nil {
+					t.Fatalf("n=%d: after Del(%s): %s", n, keys[i], err)
+				}
+				for j, k := range keys {
+					if val, found := dh.Get(k); found == deleted[j] || found && val != j {
+						t.Fatalf("n=%d: after Del(%s): Get(%s) => %v, %t", n, keys[i], k, val, found)
+					}
+				}
+			}
+			if !dh.IsEmpty() {
+				t.Fatalf("n=%d: Hamt has %d entries after deleting every key", n, dh.Nentries())
+			}
+		}
+	}
+}
+
+func TestIterByInsertion32(t *testing.T) {
+	var kvs = hamttest.Shuffle(KVS[:1024])
+
+	var o hamt32.OrderedHamt
+	for _, kv := range kvs {
+		o, _ = o.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range kvs[:512] {
+		o, _, _ = o.Del(kv.Key)
+	}
+	o, _ = o.Put(kvs[600].Key, -1) // update keeps kvs[600] in place
+
+	var i = 512
+	o.IterByInsertion(func(k key.Key, v interface{}) bool {
+		if !k.Equals(kvs[i].Key) {
+			t.Fatalf("IterByInsertion: key %s != expected key %s", k, kvs[i].Key)
+		}
+		i++
+		return true
+	})
+	if i != len(kvs) {
+		t.Fatalf("IterByInsertion visited %d keys; expected %d", i-512, len(kvs)-512)
+	}
+
+	// Used as a queue, the sequence numbers issued, which IterByInsertion
+	// walks, stay proportional to the entries.
+	var q hamt32.OrderedHamt
+	for i, kv := range KVS[:10000] {
+		q, _ = q.Put(kv.Key, kv.Val)
+		if i >= 8 {
+			q, _, _ = q.Del(KVS[i-8].Key)
+		}
+	}
+	var seq uint64
+	fmt.Sscanf(q.String(), "OrderedHamt{ seq: %d", &seq)
+	if seq > 2*8+32 {
+		t.Fatalf("after 10000 Puts and 9992 Dels of the oldest, the sequence number is %d", seq)
+	}
+	i = 9992
+	q.IterByInsertion(func(k key.Key, v interface{}) bool {
+		if !k.Equals(KVS[i].Key) || v != KVS[i].Val {
+			t.Fatalf("IterByInsertion of the queue: %s=%v; expected %s=%v", k, v, KVS[i].Key, KVS[i].Val)
+		}
+		i++
+		return true
+	})
+	if i != 10000 {
+		t.Fatalf("IterByInsertion of the queue visited %d keys; expected 8", i-9992)
+	}
+}
+
+func TestIndexedMap32(t *testing.T) {
+	// index every key by the parity of its int value
+	var parity = func(k key.Key, v interface{}) (key.Key, bool) {
+		return stringkey.New(fmt.Sprintf("%d", v.(int)%2)), true
+	}
+	var even, odd = stringkey.New("0"), stringkey.New("1")
+
+	var m = hamt32.NewIndexedMap(parity)
+	for _, kv := range KVS[:100] {
+		m, _ = m.Put(kv.Key, kv.Val)
+	}
+	m, _ = m.Put(KVS[0].Key, 1) // moves KVS[0] from even to odd
+	m, _, _ = m.Del(KVS[1].Key) // drops an odd key
+
+	if n := len(m.Lookup(0, even)); n != 49 {
+		t.Fatalf("len(m.Lookup(0, even)),%d != 49", n)
+	}
+	if n := len(m.Lookup(0, odd)); n != 50 {
+		t.Fatalf("len(m.Lookup(0, odd)),%d != 50", n)
+	}
+}
+
+func TestMove32(t *testing.T) {
+	var k0, k1, k2 = KVS[0].Key, KVS[1].Key, KVS[2].Key
+
+	var h = hamt32.Hamt{}
+	h, _ = h.Put(k0, 0)
+	h, _ = h.Put(k1, 1)
+
+	if _, moved := h.Move(k0, k1); moved {
+		t.Fatalf("h.Move(%s, %s) replaced an existing key", k0, k1)
+	}
+
+	var nh, moved = h.Move(k0, k2)
+	if !moved {
+		t.Fatalf("h.Move(%s, %s) failed", k0, k2)
+	}
+	if _, found := nh.Get(k0); found {
+		t.Fatalf("h.Move(%s, %s) left %s", k0, k2, k0)
+	}
+	if val, _ := nh.Get(k2); val != 0 || nh.Nentries() != 2 {
+		t.Fatalf("h.Move(%s, %s) => %s", k0, k2, nh)
+	}
+
+	nh, moved = nh.MoveOver(k2, k1)
+	if val, _ := nh.Get(k1); !moved || val != 0 || nh.Nentries() != 1 {
+		t.Fatalf("h.MoveOver(%s, %s) => %s", k2, k1, nh)
+	}
+}
+
+func TestKeyNormalizer32(t *testing.T) {
+	var norm = hamt32.New(hamt32.WithKeyNormalizer(hamt32.ChainNormalizers(hamt32.TrimSpace, hamt32.FoldCase)))
+	var h, _ = norm.Put(stringkey.New(" Foo "), 1)
+
+	var added bool
+	h, added = h.Put(stringkey.New("FOO"), 2)
+	if added {
+		t.Fatal("h.Put(\"FOO\") added a new key")
+	}
+	if val, found := h.Get(stringkey.New("foo")); !found || val != 2 {
+		t.Fatalf("h.Get(\"foo\") => %v, %t", val, found)
+	}
+	if _, _, deleted := h.Del(stringkey.New("fOo")); !deleted {
+		t.Fatal("h.Del(\"fOo\") failed")
+	}
+
+	// Other Hamts do not normalize their keys.
+	var other, _ = hamt32.Hamt{}.Put(stringkey.New("Foo"), 1)
+	if _, found := other.Get(stringkey.New("foo")); found {
+		t.Fatal("a Hamt without a key normalizer found \"foo\" for \"Foo\"")
+	}
+
+	// The Unicode normalizers.
+	var nfc, _ = hamt32.New(hamt32.WithKeyNormalizer(normalize.NFC)).Put(stringkey.New("caf\u00e9"), 1)
+	if val, found := nfc.Get(stringkey.New("cafe\u0301")); !found || val != 1 {
+		t.Fatalf("nfc.Get(\"cafe\\u0301\") => %v, %t", val, found)
+	}
+	if _, found := nfc.Get(stringkey.New("CAF\u00c9")); found {
+		t.Fatal("NFC folded the case of a key")
+	}
+	var nfkc, _ = hamt32.New(hamt32.WithKeyNormalizer(normalize.NFKC)).Put(stringkey.New("\ufb01le"), 1)
+	if val, found := nfkc.Get(stringkey.New("file")); !found || val != 1 {
+		t.Fatalf("nfkc.Get(\"file\") => %v, %t", val, found)
+	}
+	var fold, _ = hamt32.New(hamt32.WithKeyNormalizer(hamt32.FoldCase)).Put(stringkey.New("\u00c9"), 1)
+	if _, found := fold.Get(stringkey.New("\u00e9")); found {
+		t.Fatal("FoldCase folded a non-ASCII letter")
+	}
+}
+
+func TestStoredBytes32(t *testing.T) {
+	var k0, k1 = stringkey.New("a"), stringkey.New("bb")
+	var h = hamt32.Hamt{}
+	h, _ = h.Put(k0, "12345")
+	h, _ = h.Put(k1, []byte("123"))
+	h, _ = h.Put(k0, "1")
+
+	if n := h.Stats().StoredBytes; n != 7 {
+		t.Fatalf("h.Stats().StoredBytes,%d != 7", n)
+	}
+
+	h, _, _ = h.Del(k0)
+	h, _, _ = h.Del(k1)
+
+	if n := h.Stats().StoredBytes; n != 0 || !h.IsEmpty() {
+		t.Fatalf("h.Stats().StoredBytes,%d != 0 for empty Hamt", n)
+	}
+
+	// A SizeFunc is given the keys as they were given to Put; even under a
+	// Hasher.
+	var sized = hamt32.New(
+		hamt32.WithHasher(hamt32.HasherFunc(func([]byte) uint64 { return 0 })),
+		hamt32.WithSizeFunc(func(k key.Key, v interface{}) int {
+			if _, isString := k.(*stringkey.StringKey); !isString {
+				t.Fatalf("SizeFunc given a %T key", k)
+			}
+			return 10
+		}))
+	sized, _ = sized.Put(k0, 1)
+	sized, _ = sized.Put(k1, 2)
+	if n := sized.Stats().StoredBytes; n != 20 {
+		t.Fatalf("sized.Stats().StoredBytes,%d != 20", n)
+	}
+
+	// Other Hamts use the default sizing rules.
+	if n := h.PutMany([]key.KeyVal{{Key: k0, Val: 1}}).Stats().StoredBytes; n != 1 {
+		t.Fatalf("a Hamt without a SizeFunc => StoredBytes,%d != 1", n)
+	}
+
+	// The keys of the keys package, and any other key, are sized too.
+	var kh = hamt32.Hamt{}.PutMany([]key.KeyVal{
+		{Key: keys.NewString("abc"), Val: nil},
+		{Key: keys.NewBytes([]byte{1, 2}), Val: nil},
+		{Key: keys.NewComposite("t", 1), Val: nil},
+		{Key: keys.NewUUID([16]byte{}), Val: nil},
+		{Key: hamttest.CollidingKeyVals(1, "abcd")[0].Key, Val: nil},
+	})
+	if n := kh.Stats().StoredBytes; n != 3+2+9+16+4 {
+		t.Fatalf("kh.Stats().StoredBytes,%d != %d", n, 3+2+9+16+4)
+	}
+}
+
+func TestWithoutOnly32(t *testing.T) {
+	var h = hamt32.Hamt{}.WithAssertLevel(hamt32.AssertExpensive)
+	for _, kv := range KVS[:100] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var w = h.Without(KVS[0].Key, KVS[1].Key, KVS[200].Key)
+	if w.Nentries() != 98 {
+		t.Fatalf("w.Nentries(),%d != 98", w.Nentries())
+	}
+	if _, found := w.Get(KVS[1].Key); found {
+		t.Fatalf("w.Get(%s) found a removed key", KVS[1].Key)
+	}
+
+	var o = h.Only(KVS[0].Key, KVS[1].Key, KVS[200].Key)
+	if o.Nentries() != 2 {
+		t.Fatalf("o.Nentries(),%d != 2", o.Nentries())
+	}
+	if val, _ := o.Get(KVS[1].Key); val != KVS[1].Val {
+		t.Fatalf("o.Get(%s),%v != %v", KVS[1].Key, val, KVS[1].Val)
+	}
+	if o.AssertLevel() != h.AssertLevel() {
+		t.Fatalf("o.AssertLevel(),%s != %s", o.AssertLevel(), h.AssertLevel())
+	}
+
+	var ks = make([]key.Key, 50)
+	for i := range ks {
+		ks[i] = KVS[2*i].Key
+	}
+	w = h.Without(ks...)
+	o = h.Only(ks...)
+	if w.Nentries()+o.Nentries() != h.Nentries() {
+		t.Fatalf("w.Nentries(),%d + o.Nentries(),%d != %d", w.Nentries(), o.Nentries(), h.Nentries())
+	}
+	if err := w.Merge(o, nil).Validate(); err != nil {
+		t.Fatalf("w.Merge(o).Validate() failed: %s", err)
+	}
+	if !w.Merge(o, nil).Equal(h, nil) {
+		t.Fatal("w.Merge(o) is not Equal to h")
+	}
+}
+
+func TestAssertLevel32(t *testing.T) {
+	for _, lvl := range []hamt32.AssertLevel{hamt32.AssertCheap, hamt32.AssertExpensive} {
+		if n := hamt32.New(hamt32.WithAssertLevel(lvl)); n.AssertLevel() != lvl {
+			t.Fatalf("New(WithAssertLevel(%s)).AssertLevel() => %s", lvl, n.AssertLevel())
+		}
+
+		var h = hamt32.Hamt{}.WithAssertLevel(lvl)
+		for _, kv := range KVS[:1000] {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		for _, kv := range KVS[:500] {
+			h, _, _ = h.Del(kv.Key)
+		}
+		if h.AssertLevel() != lvl {
+			t.Fatalf("h.AssertLevel(),%s != %s", h.AssertLevel(), lvl)
+		}
+		if err := h.Validate(); err != nil {
+			t.Fatalf("%s: h.Validate() failed: %s", lvl, err)
+		}
+	}
+}
+
+func TestCopyHook32(t *testing.T) {
+	var nops int
+	var h = hamt32.New(hamt32.WithHasher(hashers.XXHash64{}), hamt32.WithCopyHook(func(op string, k key.Key, copied, pathLen int) {
+		nops++
+		if _, isStringKey := k.(*stringkey.StringKey); !isStringKey {
+			t.Fatalf("%s(%s) gave the copy hook a key of type %T", op, k, k)
+		}
+		if copied > pathLen {
+			t.Fatalf("%s(%s) copied %d tables; more than pathLen,%d", op, k, copied, pathLen)
+		}
+	}))
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS[:1000] {
+		h, _, _ = h.Del(kv.Key)
+	}
+
+	if nops != 2000 {
+		t.Fatalf("copy hook called %d times; expected 2000", nops)
+	}
+}
+
+func TestHashQuality32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	if dqs := h.HashQuality(); dqs != nil {
+		t.Fatalf("empty Hamt HashQuality() = %v; expected nil", dqs)
+	}
+
+	for _, kv := range KVS[:10000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var dqs = h.HashQuality()
+	if len(dqs) == 0 || dqs[0].Tables != 1 || dqs[0].Depth != 0 {
+		t.Fatalf("bad root DepthQuality: %v", dqs)
+	}
+	if dqs[0].Skew > 3.0 {
+		t.Fatalf("root table is poorly distributed: %s", dqs[0])
+	}
+}
+
+func TestBiMap32(t *testing.T) {
+	var byName = func(v interface{}) key.Key { return stringkey.New(v.(string)) }
+	var m = hamt32.NewBiMap(byName)
+
+	m, _ = m.Put(stringkey.New("id1"), "alice")
+	m, _ = m.Put(stringkey.New("id2"), "bob")
+	m, _ = m.Put(stringkey.New("id1"), "carol")
+
+	if _, found := m.GetKey(stringkey.New("alice")); found {
+		t.Fatal("m.GetKey(alice) found a replaced value")
+	}
+	if k, _ := m.GetKey(stringkey.New("carol")); k == nil || k.String() != "id1" {
+		t.Fatalf("m.GetKey(carol),%v != id1", k)
+	}
+
+	// "bob" moves from id2 to id3
+	var m2, _ = m.Put(stringkey.New("id3"), "bob")
+	if m2.Nentries() != 2 {
+		t.Fatalf("m2.Nentries(),%d != 2", m2.Nentries())
+	}
+	if _, found := m2.Get(stringkey.New("id2")); found {
+		t.Fatal("m2.Get(id2) found a key whose value was taken")
+	}
+	if k, _ := m.GetKey(stringkey.New("bob")); k.String() != "id2" {
+		t.Fatalf("old version changed: m.GetKey(bob),%s != id2", k)
+	}
+
+	m2, _, _ = m2.DelValue(stringkey.New("bob"))
+	if _, found := m2.Get(stringkey.New("id3")); found || m2.Nentries() != 1 {
+		t.Fatal("m2.DelValue(bob) did not remove id3")
+	}
+}
+
+func TestMergeSorted32(t *testing.T) {
+	var base = hamt32.Hamt{}
+	for _, kv := range KVS[:2000] {
+		base, _ = base.Put(kv.Key, kv.Key.String())
+	}
+
+	var delta []key.KeyVal
+	for _, kv := range KVS[1000:3000] {
+		delta = append(delta, key.KeyVal{kv.Key, kv.Key.String() + "!"})
+	}
+	delta = append(delta, hamttest.CollidingKeyVals(8, "zzz")...)
+
+	var expected = base
+	for _, kv := range delta {
+		expected, _ = expected.Put(kv.Key, kv.Val)
+	}
+
+	delta = hamttest.Shuffle(delta)
+	hamt32.SortEntries(delta)
+	var merged = base.MergeSorted(hamt32.SliceEntries(delta))
+
+	if err := merged.Validate(); err != nil {
+		t.Fatalf("merged.Validate() failed: %s", err)
+	}
+	if merged.Stats() != expected.Stats() {
+		t.Fatalf("merged.Stats(),%+v != %+v", merged.Stats(), expected.Stats())
+	}
+	for _, kv := range append(KVS[:3000:3000], delta...) {
+		var ev, _ = expected.Get(kv.Key)
+		if mv, found := merged.Get(kv.Key); !found || mv != ev {
+			t.Fatalf("merged.Get(%s) => %v, %t; expected %v", kv.Key, mv, found, ev)
+		}
+	}
+	if base.Nentries() != 2000 {
+		t.Fatal("MergeSorted modified the original Hamt")
+	}
+}
+
+func TestFromSortedEntries32(t *testing.T) {
+	var kvs = append([]key.KeyVal(nil), KVS[:5000]...)
+	kvs = append(kvs, hamttest.CollidingKeyVals(8, "zzz")...)
+	hamt32.SortEntries(kvs)
+
+	var h, err = hamt32.FromSortedEntries(hamt32.SliceEntries(kvs))
+	if err != nil {
+		t.Fatalf("FromSortedEntries failed: %s", err)
+	}
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() failed: %s", err)
+	}
+	if h.Nentries() != uint(len(kvs)) {
+		t.Fatalf("h.Nentries(),%d != %d", h.Nentries(), len(kvs))
+	}
+	for _, kv := range kvs {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %t; expected %v", kv.Key, val, found, kv.Val)
+		}
+	}
+
+	kvs[0], kvs[len(kvs)-1] = kvs[len(kvs)-1], kvs[0]
+	_, err = hamt32.FromSortedEntries(hamt32.SliceEntries(kvs))
+	if !errors.Is(err, hamt32.ErrUnsortedEntries) {
+		t.Fatalf("FromSortedEntries of unsorted entries returned %v", err)
+	}
+}
+
+func TestShadow32(t *testing.T) {
+	var s = hamt32.NewShadow(hamt32.Hamt{})
+	for _, kv := range KVS[:1000] {
+		s, _ = s.Put(kv.Key, kv.Val)
+	}
+	var s1 = s
+	for _, kv := range KVS[:500] {
+		s, _, _ = s.Del(kv.Key)
+	}
+	for _, kv := range KVS[:1000] {
+		s.Get(kv.Key)
+		s1.Get(kv.Key)
+	}
+
+	var s2 = hamt32.NewShadow(s.Hamt())
+	if s2.Nentries() != 500 {
+		t.Fatalf("s2.Nentries(),%d != 500", s2.Nentries())
+	}
+}
+
+func TestView32(t *testing.T) {
+	var h = hamt32.New().PutMany(KVS[:1000])
+	var v = h.ReadOnly()
+
+	// The View has the query methods of a Hamt, and none that make a new
+	// version.
+	for _, name := range []string{"Put", "Del", "PutMany", "DelMany", "Update", "Merge", "Builder"} {
+		if _, found := reflect.TypeOf(v).MethodByName(name); found {
+			t.Fatalf("View has the Hamt method %s", name)
+		}
+	}
+
+	if v.IsEmpty() || v.Nentries() != h.Nentries() {
+		t.Fatalf("v.IsEmpty(),%t v.Nentries(),%d; want false, %d", v.IsEmpty(), v.Nentries(), h.Nentries())
+	}
+	if v.LongString("") != h.LongString("") {
+		t.Fatal("v.LongString() differs from h.LongString()")
+	}
+	if !reflect.DeepEqual(v.HashQuality(), h.HashQuality()) {
+		t.Fatalf("v.HashQuality(),%v != h.HashQuality(),%v", v.HashQuality(), h.HashQuality())
+	}
+	if !reflect.DeepEqual(v.Keys(), h.Keys()) || !reflect.DeepEqual(v.Values(), h.Values()) {
+		t.Fatal("v.Keys() or v.Values() differs from the Hamt")
+	}
+
+	var n int
+	v.Range(func(k key.Key, val interface{}) bool {
+		if hv, found := h.Get(k); !found || hv != val {
+			t.Fatalf("v.Range() saw %s=%v; h.Get() => %v, %t", k, val, hv, found)
+		}
+		n++
+		return true
+	})
+	for range v.All() {
+		n++
+	}
+	var it = v.Iter()
+	for _, _, ok := it.Next(); ok; _, _, ok = it.Next() {
+		n++
+	}
+	v.RangeKeys(func(key.Key) bool {
+		n++
+		return true
+	})
+	if n != 4*1000 {
+		t.Fatalf("Range(), All(), Iter() and RangeKeys() saw %d pairs; want %d", n, 4*1000)
+	}
+
+	// The View keeps showing the version it was made of, whatever versions
+	// the owner makes after.
+	var h1, _ = h.Put(KVS[1000].Key, KVS[1000].Val)
+	h1, _, _ = h1.Del(KVS[0].Key)
+	if _, found := v.Get(KVS[1000].Key); found {
+		t.Fatalf("v.Get(%s) found a key put after the View was made", KVS[1000].Key)
+	}
+	if val, found := v.Get(KVS[0].Key); !found || val != KVS[0].Val {
+		t.Fatalf("v.Get(%s) => %v, %t; want %v, true", KVS[0].Key, val, found, KVS[0].Val)
+	}
+	if v.Nentries() != 1000 || h1.ReadOnly().Nentries() != 1000 {
+		t.Fatalf("v.Nentries(),%d h1.ReadOnly().Nentries(),%d; want 1000", v.Nentries(), h1.ReadOnly().Nentries())
+	}
+
+	var empty = hamt32.Hamt{}.ReadOnly()
+	if !empty.IsEmpty() || empty.Nentries() != 0 || len(empty.Keys()) != 0 {
+		t.Fatal("the View of an empty Hamt is not empty")
+	}
+}
+
+func TestKeys32(t *testing.T) {
+	var ks = []key.Key{
+		keys.NewString("aaa"),
+		keys.NewInt64(-42),
+		keys.NewUint64(42),
+		keys.NewUUID([16]byte{0: 0xde, 1: 0xad, 15: 0x01}),
+		keys.NewComposite("users", 42),
+		keys.NewBytes([]byte{0xca, 0xfe}),
+	}
+
+	if ks[0].Hash30() != stringkey.New("aaa").Hash30() {
+		t.Fatalf("keys.String hash %s != stringkey hash", ks[0].Hash30())
+	}
+
+	var h = hamt32.Hamt{}
+	for i, k := range ks {
+		h, _ = h.Put(k, i)
+	}
+	if h.Nentries() != uint(len(ks)) {
+		t.Fatalf("h.Nentries(),%d != %d", h.Nentries(), len(ks))
+	}
+
+	if val, found := h.Get(keys.NewComposite("users", 42)); !found || val != 4 {
+		t.Fatalf("h.Get(users/42) => %v, %t", val, found)
+	}
+	if _, found := h.Get(keys.NewComposite("users", 43)); found {
+		t.Fatal("h.Get(users/43) found a key never Put")
+	}
+	if _, found := h.Get(keys.NewInt64(42)); found {
+		t.Fatal("h.Get(Int64(42)) found the Uint64(42) key")
+	}
+	if s := ks[3].String(); s != "dead0000-0000-0000-0000-000000000001" {
+		t.Fatalf("UUID String() = %q", s)
+	}
+
+	var b = []byte{0xca, 0xfe}
+	var bk = keys.NewBytes(b)
+	b[0] = 0
+	if val, found := h.Get(bk); !found || val != 5 {
+		t.Fatalf("h.Get(Bytes(cafe)) => %v, %t", val, found)
+	}
+	if s := bk.String(); s != "cafe" {
+		t.Fatalf("Bytes String() = %q", s)
+	}
+	if bk.Hash30() != keys.NewString("\xca\xfe").Hash30() {
+		t.Fatalf("keys.Bytes hash %s != keys.String hash", bk.Hash30())
+	}
+}
+
+func TestRangeKeys32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var seen = make(map[string]bool)
+	h.RangeKeys(func(k key.Key) bool {
+		seen[k.String()] = true
+		return true
+	})
+	if len(seen) != 1000 {
+		t.Fatalf("RangeKeys visited %d keys; expected 1000", len(seen))
+	}
+
+	var n int
+	h.ReadOnly().RangeKeys(func(k key.Key) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("RangeKeys did not stop; visited %d keys", n)
+	}
+}
+
+func TestNamespace32(t *testing.T) {
+	var tenant = hamt32.Hamt{}
+	tenant, _ = tenant.Put(stringkey.New("foo"), 1)
+
+	var ns = hamt32.Namespace{}.Mount("acme", tenant)
+	ns, _ = ns.Put(stringkey.New("acme/bar"), 2)
+	ns, _ = ns.Put(stringkey.New("other/bar"), 3)
+
+	if val, found := ns.Get(stringkey.New("acme/foo")); !found || val != 1 {
+		t.Fatalf("ns.Get(acme/foo) => %v, %t; expected 1", val, found)
+	}
+	if ns.Nentries() != 3 {
+		t.Fatalf("ns.Nentries(),%d != 3", ns.Nentries())
+	}
+
+	var acme, _ = ns.Mounted("acme")
+	if val, _ := acme.Get(stringkey.New("bar")); val != 2 {
+		t.Fatalf("acme.Get(bar),%v != 2", val)
+	}
+	if tenant.Nentries() != 1 {
+		t.Fatal("Put through the Namespace modified the mounted Hamt")
+	}
+
+	var ns1, old, found = ns.Unmount("acme")
+	if !found || old.Nentries() != 2 {
+		t.Fatalf("ns.Unmount(acme) => %s, %t", old, found)
+	}
+	if _, found := ns1.Get(stringkey.New("acme/foo")); found {
+		t.Fatal("ns1.Get(acme/foo) found a key of an unmounted Hamt")
+	}
+	if val, _ := ns1.Get(stringkey.New("other/bar")); val != 3 {
+		t.Fatalf("ns1.Get(other/bar),%v != 3", val)
+	}
+}
+
+func TestNewOptions32(t *testing.T) {
+	var full = hamt32.New(hamt32.WithFullTableInit(true), hamt32.WithGradeTables(false))
+	var comp = hamt32.New(hamt32.WithFullTableInit(false), hamt32.WithGradeTables(false))
+
+	var saveFullTableInit = hamt32.FullTableInit
+	hamt32.FullTableInit = !hamt32.FullTableInit
+	defer func() { hamt32.FullTableInit = saveFullTableInit }()
+
+	for _, kv := range KVS[:1000] {
+		full, _ = full.Put(kv.Key, kv.Val)
+		comp, _ = comp.Put(kv.Key, kv.Val)
+	}
+
+	if !strings.Contains(full.String(), "root: fullTable{") {
+		t.Fatalf("full Hamt does not have a fullTable root: %s", full)
+	}
+	if !strings.Contains(comp.String(), "root: compressedTable{") {
+		t.Fatalf("comp Hamt does not have a compressedTable root: %s", comp)
+	}
+	if only := full.Only(KVS[0].Key); !strings.Contains(only.String(), "root: fullTable{") {
+		t.Fatalf("full.Only() does not have a fullTable root: %s", only)
+	}
+	if only := comp.Only(KVS[0].Key); !strings.Contains(only.String(), "root: compressedTable{") {
+		t.Fatalf("comp.Only() does not have a compressedTable root: %s", only)
+	}
+
+	for _, kv := range KVS[:1000] {
+		full, _, _ = full.Del(kv.Key)
+	}
+	if !full.IsEmpty() {
+		t.Fatalf("full Hamt not empty after deleting every key: %s", full)
+	}
+}
+
+func TestIter32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	if _, _, ok := h.Iter().Next(); ok {
+		t.Fatal("Iter of an empty Hamt returned a pair")
+	}
+
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var seen = make(map[string]interface{})
+	var it = h.Iter()
+	for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+		seen[k.String()] = v
+	}
+	if len(seen) != 1000 {
+		t.Fatalf("Iter returned %d distinct keys; expected 1000", len(seen))
+	}
+	for _, kv := range KVS[:1000] {
+		if seen[kv.Key.String()] != kv.Val {
+			t.Fatalf("Iter returned %v for %s; expected %v", seen[kv.Key.String()], kv.Key, kv.Val)
+		}
+	}
+}
+
+func TestRange32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var n int
+	h.Range(func(k key.Key, v interface{}) bool {
+		if val, _ := h.Get(k); val != v {
+			t.Fatalf("Range gave %v for %s; h.Get() gives %v", v, k, val)
+		}
+		n++
+		return true
+	})
+	if n != 1000 {
+		t.Fatalf("Range visited %d pairs; expected 1000", n)
+	}
+
+	var visited int
+	h.Range(func(k key.Key, v interface{}) bool {
+		visited++
+		return visited < 10
+	})
+	if visited != 10 {
+		t.Fatalf("Range did not stop; visited %d pairs", visited)
+	}
+}
+
+func TestAll32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var n int
+	for k, v := range h.All() {
+		if val, _ := h.Get(k); val != v {
+			t.Fatalf("All gave %v for %s; h.Get() gives %v", v, k, val)
+		}
+		n++
+	}
+	if n != 1000 {
+		t.Fatalf("All yielded %d pairs; expected 1000", n)
+	}
+
+	var nkeys, nvals int
+	for range h.AllKeys() {
+		nkeys++
+	}
+	for range h.AllValues() {
+		nvals++
+		if nvals == 10 {
+			break
+		}
+	}
+	if nkeys != 1000 || nvals != 10 {
+		t.Fatalf("AllKeys yielded %d keys, AllValues %d values; expected 1000, 10", nkeys, nvals)
+	}
+}
+
+func TestKeysValues32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	if len(h.Keys()) != 0 || len(h.Values()) != 0 {
+		t.Fatal("empty Hamt has Keys() or Values()")
+	}
+
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var ks, vs = h.Keys(), h.Values()
+	if len(ks) != 1000 || len(vs) != 1000 {
+		t.Fatalf("len(Keys()),%d, len(Values()),%d != 1000", len(ks), len(vs))
+	}
+	for i, k := range ks {
+		if val, _ := h.Get(k); val != vs[i] {
+			t.Fatalf("Values()[%d],%v != h.Get(%s),%v", i, vs[i], k, val)
+		}
+	}
+}
+
+func TestMerge32(t *testing.T) {
+	var base = hamt32.Hamt{}
+	for _, kv := range KVS[:2000] {
+		base, _ = base.Put(kv.Key, kv.Val)
+	}
+
+	// a and b share most of base's structure
+	var a, b = base, base
+	for _, kv := range KVS[1000:3000] {
+		a, _ = a.Put(kv.Key, kv.Val.(int)+1)
+	}
+	for _, kv := range KVS[1500:4000] {
+		b, _ = b.Put(kv.Key, kv.Val.(int)+2)
+	}
+	for _, kv := range hamttest.CollidingKeyVals(8, "zzz") {
+		b, _ = b.Put(kv.Key, kv.Val)
+	}
+
+	var sum = func(_ key.Key, v1, v2 interface{}) interface{} {
+		if v1 == v2 {
+			return v1
+		}
+		return v1.(int) + v2.(int)
+	}
+	var merged = a.Merge(b, sum)
+
+	var expected = a
+	b.Range(func(k key.Key, v interface{}) bool {
+		if v1, found := a.Get(k); found {
+			v = sum(k, v1, v)
+		}
+		expected, _ = expected.Put(k, v)
+		return true
+	})
+
+	if err := merged.Validate(); err != nil {
+		t.Fatalf("merged.Validate() failed: %s", err)
+	}
+	if merged.Stats() != expected.Stats() {
+		t.Fatalf("merged.Stats(),%+v != %+v", merged.Stats(), expected.Stats())
+	}
+	expected.Range(func(k key.Key, v interface{}) bool {
+		if mv, found := merged.Get(k); !found || mv != v {
+			t.Fatalf("merged.Get(%s) => %v, %t; expected %v", k, mv, found, v)
+		}
+		return true
+	})
+
+	if !hamt32.Same(a.Merge(hamt32.Hamt{}, nil), a) {
+		t.Fatal("merging an empty Hamt did not return the same Hamt")
+	}
+}
+
+// TestMergeBytes32 merges versions of a Hamt with uncomparable values; the
+// leaves they share must not be compared with ==.
+func TestMergeBytes32(t *testing.T) {
+	var base = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		base, _ = base.Put(kv.Key, []byte(kv.Key.String()))
+	}
+
+	var a, b = base, base
+	a, _ = a.Put(KVS[1000].Key, []byte("a"))
+	b, _ = b.Put(KVS[10].Key, []byte("b"))
+
+	var merged = a.Merge(b, nil)
+	if merged.Nentries() != 1001 {
+		t.Fatalf("merged.Nentries() => %d; expected 1001", merged.Nentries())
+	}
+	if v, _ := merged.Get(KVS[1000].Key); !bytes.Equal(v.([]byte), []byte("a")) {
+		t.Fatalf("merged.Get(%s) => %q; expected \"a\"", KVS[1000].Key, v)
+	}
+	if v, _ := merged.Get(KVS[10].Key); !bytes.Equal(v.([]byte), []byte("b")) {
+		t.Fatalf("merged.Get(%s) => %q; expected \"b\"", KVS[10].Key, v)
+	}
+}
+
+func TestSame32(t *testing.T) {
+	var h = hamt32.New().PutMany(KVS[:1000])
+
+	// Shared lineage: copies of a version, and the results of operations
+	// that change nothing, are the same version.
+	var c = h
+	if !hamt32.Same(h, c) || !hamt32.Same(hamt32.Hamt{}, hamt32.Hamt{}) {
+		t.Fatal("Same() is false for copies of one version")
+	}
+	if h1, _, deleted := h.Del(KVS[1000].Key); deleted || !hamt32.Same(h1, h) {
+		t.Fatal("Del() of an absent key made a new version")
+	}
+
+	// Diverged lineages: every Put or Del makes a new version, even one
+	// with the same entries as another.
+	var a, _ = h.Put(KVS[1000].Key, KVS[1000].Val)
+	var b, _ = h.Put(KVS[1000].Key, KVS[1000].Val)
+	if hamt32.Same(a, h) {
+		t.Fatal("Same() is true for a version and its parent")
+	}
+	if hamt32.Same(a, b) {
+		t.Fatal("Same() is true for two versions put apart from one parent")
+	}
+	if !a.Equal(b, nil) {
+		t.Fatal("the two versions put apart from one parent are not Equal")
+	}
+
+	var back, _, _ = a.Del(KVS[1000].Key)
+	if hamt32.Same(back, h) {
+		t.Fatal("Same() is true for a version put and deleted back to its parent")
+	}
+	if hamt32.Same(h, hamt32.Hamt{}) || hamt32.Same(hamt32.Hamt{}, h) {
+		t.Fatal("Same() is true for a version and the empty Hamt")
+	}
+}
+
+func TestEqual32(t *testing.T) {
+	var a = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		a, _ = a.Put(kv.Key, kv.Val)
+	}
+
+	// b has the same entries, inserted in another order, with an extra key
+	// put and deleted; so its tables are not shared with a.
+	var b = hamt32.Hamt{}
+	for _, kv := range hamttest.Shuffle(KVS[:1001]) {
+		b, _ = b.Put(kv.Key, kv.Val)
+	}
+	b, _, _ = b.Del(KVS[1000].Key)
+
+	if !a.Equal(b, nil) || !b.Equal(a, nil) {
+		t.Fatal("a and b are not Equal")
+	}
+
+	var c, _ = a.Put(KVS[500].Key, -1)
+	if a.Equal(c, nil) {
+		t.Fatal("a is Equal to a version with a changed value")
+	}
+	var sameSign = func(v1, v2 interface{}) bool { return (v1.(int) < 0) == (v2.(int) < 0) }
+	var d, _ = a.Put(KVS[500].Key, KVS[500].Val.(int)+1)
+	if !a.Equal(d, sameSign) {
+		t.Fatal("a is not Equal to d with a custom value equality")
+	}
+	if !a.Equal(a, nil) {
+		t.Fatal("a is not Equal to itself")
+	}
+}
+
+// TestEqualBytes32 compares versions of a Hamt with uncomparable values;
+// the leaves they share must not be compared with ==.
+func TestEqualBytes32(t *testing.T) {
+	var a = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		a, _ = a.Put(kv.Key, []byte(kv.Key.String()))
+	}
+
+	var b, _ = a.Put(KVS[10].Key, []byte(KVS[10].Key.String()))
+	if !a.Equal(b, nil) {
+		t.Fatal("a is not Equal to a version with an equal value")
+	}
+	var c, _ = a.Put(KVS[10].Key, []byte("c"))
+	if a.Equal(c, nil) {
+		t.Fatal("a is Equal to a version with a changed value")
+	}
+}
+
+func TestDiff32(t *testing.T) {
+	var from = hamt32.Hamt{}
+	for _, kv := range KVS[:2000] {
+		from, _ = from.Put(kv.Key, kv.Val)
+	}
+
+	var to = from
+	to, _ = to.Put(KVS[10].Key, -10)
+	to, _ = to.Put(KVS[10].Key, -10)
+	to, _, _ = to.Del(KVS[20].Key)
+	to, _ = to.Put(KVS[2000].Key, KVS[2000].Val)
+	to, _ = to.Put(KVS[30].Key, KVS[30].Val) // not a change
+
+	var changes = hamt32.Diff(from, to)
+	if len(changes) != 3 {
+		t.Fatalf("Diff returned %d changes; expected 3: %v", len(changes), changes)
+	}
+
+	var kinds = make(map[hamt32.ChangeKind]int)
+	var ops []hamt32.Op
+	for _, c := range changes {
+		kinds[c.Kind]++
+		ops = append(ops, c.Op())
+	}
+	if kinds[hamt32.Added] != 1 || kinds[hamt32.Removed] != 1 || kinds[hamt32.Modified] != 1 {
+		t.Fatalf("Diff returned the wrong kinds of changes: %v", changes)
+	}
+
+	if !from.Apply(ops).Equal(to, nil) {
+		t.Fatal("applying the Diff to from does not give to")
+	}
+	if len(hamt32.Diff(to, to)) != 0 {
+		t.Fatal("Diff of a Hamt to itself is not empty")
+	}
+	var rebuilt = hamt32.Hamt{}
+	for _, kv := range hamttest.Shuffle(KVS[:2000]) {
+		rebuilt, _ = rebuilt.Put(kv.Key, kv.Val)
+	}
+	if changes = hamt32.Diff(rebuilt, from); len(changes) != 0 {
+		t.Fatalf("Diff of equal, unshared Hamts returned %v", changes)
+	}
+	if changes = hamt32.Diff(hamt32.Hamt{}, to); len(changes) != int(to.Nentries()) {
+		t.Fatalf("Diff from an empty Hamt returned %d changes; expected %d", len(changes), to.Nentries())
+	}
+}
+
+// TestDiffOrder32 checks that Diff returns its Changes in hash path order,
+// also where one Hamt has a leaf and the other a table.
+func TestDiffOrder32(t *testing.T) {
+	var from = hamt32.Hamt{}.PutMany(KVS[:1000])
+	var to = from
+	for i, kv := range hamttest.Shuffle(KVS[:1000])[:400] {
+		if i%2 == 0 {
+			to, _, _ = to.Del(kv.Key)
+		} else {
+			to, _ = to.Put(kv.Key, -1)
+		}
+	}
+	to = to.PutMany(KVS[1000:1500])
+
+	var changes = hamt32.Diff(from, to)
+	if len(changes) != 900 {
+		t.Fatalf("Diff returned %d changes; expected 900", len(changes))
+	}
+	for i := 1; i < len(changes); i++ {
+		var a, b = changes[i-1].Key.Hash30(), changes[i].Key.Hash30()
+		for depth := uint(0); depth <= hamt32.MaxDepth; depth++ {
+			if a.Index(depth) != b.Index(depth) {
+				if a.Index(depth) > b.Index(depth) {
+					t.Fatalf("Diff returned %s before %s", changes[i-1], changes[i])
+				}
+				break
+			}
+		}
+	}
+}
+
+// TestDiffBytes32 diffs versions of a Hamt with uncomparable values, as do
+// Watcher.Observe and Txn.Commit; the leaves they share must not be compared
+// with ==.
+func TestWatcherClose32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:10])
+	var w = hamt32.NewWatcher(hamt32.Hamt{}, 1)
+
+	var observed = make(chan struct{})
+	go func() {
+		w.Observe(h) // blocks on the second Change, as none are received
+		close(observed)
+	}()
+	for len(w.C) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var closed = make(chan struct{})
+	go func() {
+		w.Close()
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Close blocked behind an Observe whose Changes are not received")
+	}
+	<-observed
+
+	var n int
+	for range w.C {
+		n++
+	}
+	if n != 1 {
+		t.Fatalf("the closed Watcher delivered %d Changes; want the 1 buffered", n)
+	}
+}
+
+func TestDiffBytes32(t *testing.T) {
+	var from = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		from, _ = from.Put(kv.Key, []byte(kv.Key.String()))
+	}
+
+	var to, _ = from.Put(KVS[10].Key, []byte("to"))
+	var changes = hamt32.Diff(from, to)
+	if len(changes) != 1 || changes[0].Kind != hamt32.Modified {
+		t.Fatalf("Diff returned %v; expected one Modified change", changes)
+	}
+
+	var w = hamt32.NewWatcher(from, 1)
+	w.Observe(to)
+	if c := <-w.C; !c.Key.Equals(KVS[10].Key) {
+		t.Fatalf("the Watcher sent %v; expected the change of %s", c, KVS[10].Key)
+	}
+	w.Close()
+
+	var a = hamt32.NewAtomicHamt(from)
+	var tx = a.Begin()
+	tx.Get(KVS[20].Key)
+	tx.Put(KVS[30].Key, []byte("tx"))
+	a.Store(to)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit() after an unrelated change failed: %s", err)
+	}
+}
+
+func TestBuilder32(t *testing.T) {
+	var orig = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		orig, _ = orig.Put(kv.Key, kv.Val)
+	}
+	var origStats = orig.Stats()
+
+	var b = orig.Builder()
+	for _, kv := range KVS[500:3000] {
+		b.Put(kv.Key, kv.Val.(int)+1)
+	}
+	for _, kv := range KVS[:700] {
+		if _, found := b.Del(kv.Key); !found {
+			t.Fatalf("b.Del(%s) did not find the key", kv.Key)
+		}
+	}
+	var h = b.Freeze()
+
+	var expected = orig
+	for _, kv := range KVS[500:3000] {
+		expected, _ = expected.Put(kv.Key, kv.Val.(int)+1)
+	}
+	for _, kv := range KVS[:700] {
+		expected, _, _ = expected.Del(kv.Key)
+	}
+
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() failed: %s", err)
+	}
+	if !h.Equal(expected, nil) || h.Stats() != expected.Stats() {
+		t.Fatalf("built Hamt %s != expected %s", h, expected)
+	}
+	if orig.Stats() != origStats {
+		t.Fatal("Builder modified the original Hamt")
+	}
+	if val, _ := orig.Get(KVS[600].Key); val != KVS[600].Val {
+		t.Fatalf("Builder modified a value of the original Hamt; %v", val)
+	}
+
+	// keep building after Freeze; h must not change
+	for _, kv := range KVS[700:3000] {
+		b.Del(kv.Key)
+	}
+	if !b.IsEmpty() || b.Freeze().Nentries() != 0 {
+		t.Fatalf("Builder not empty after deleting every key: %s", b)
+	}
+	if !h.Equal(expected, nil) {
+		t.Fatal("Builder modified a frozen Hamt")
+	}
+}
+
+func TestPutMany32(t *testing.T) {
+	var h = hamt32.Hamt{}
+	for _, kv := range KVS[:1000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var kvs = hamttest.Shuffle(KVS[500:2000])
+	kvs = append(kvs, key.KeyVal{KVS[0].Key, -1}, key.KeyVal{KVS[0].Key, -2})
+	var first = kvs[0]
+
+	var h1 = h.PutMany(kvs)
+	if h1.Nentries() != 2000 {
+		t.Fatalf("h1.Nentries(),%d != 2000", h1.Nentries())
+	}
+	if val, _ := h1.Get(KVS[0].Key); val != -2 {
+		t.Fatalf("h1.Get(%s),%v != -2; the last value should win", KVS[0].Key, val)
+	}
+	if kvs[0] != first {
+		t.Fatal("PutMany modified its argument")
+	}
+	if h.Nentries() != 1000 {
+		t.Fatal("PutMany modified the original Hamt")
+	}
+}
+
+func TestDelMany32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:2000])
+
+	var keys = make([]key.Key, 0, 1001)
+	for _, kv := range KVS[1000:2000] {
+		keys = append(keys, kv.Key)
+	}
+	keys = append(keys, KVS[2000].Key)
+
+	var h1, vals = h.DelMany(keys)
+	if h1.Nentries() != 1000 {
+		t.Fatalf("h1.Nentries(),%d != 1000", h1.Nentries())
+	}
+	for i, kv := range KVS[1000:2000] {
+		if vals[i] != kv.Val {
+			t.Fatalf("vals[%d],%v != %v", i, vals[i], kv.Val)
+		}
+		if _, found := h1.Get(kv.Key); found {
+			t.Fatalf("h1.Get(%s) found a deleted key", kv.Key)
+		}
+	}
+	if vals[1000] != nil {
+		t.Fatalf("vals[1000],%v != nil for a missing key", vals[1000])
+	}
+	if h.Nentries() != 2000 {
+		t.Fatal("DelMany modified the original Hamt")
+	}
+	if err := h1.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFromMapToMap32(t *testing.T) {
+	var m = make(map[string]interface{}, 1000)
+	for _, kv := range KVS[:1000] {
+		m[kv.Key.String()] = kv.Val
+	}
+
+	var h = hamt32.FromMap(m)
+	if h.Nentries() != 1000 {
+		t.Fatalf("h.Nentries(),%d != 1000", h.Nentries())
+	}
+	for _, kv := range KVS[:1000] {
+		if val, found := h.Get(kv.Key); !found || val != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %t; want %v, true", kv.Key, val, found, kv.Val)
+		}
+	}
+
+	if !reflect.DeepEqual(h.ToMap(), m) {
+		t.Fatal("h.ToMap() != the map h was made from")
+	}
+}
+
+func TestMarshalBinary32(t *testing.T) {
+	// Built by Put, rather than PutMany, so the trie holds the leaves of
+	// every path of Put.
+	var h = hamt32.Hamt{}
+	for _, kv := range KVS[:2000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+
+	var data, err = h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h1 hamt32.Hamt
+	if err = h1.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Equal(h1, nil) {
+		t.Fatal("the unmarshaled Hamt is not Equal to the original")
+	}
+	if h1.Stats() != h.Stats() {
+		t.Fatalf("h1.Stats(),%+v != h.Stats(),%+v", h1.Stats(), h.Stats())
+	}
+	if data1, _ := h1.MarshalBinary(); !bytes.Equal(data1, data) {
+		t.Fatal("the unmarshaled Hamt does not marshal to the same snapshot")
+	}
+
+	var empty hamt32.Hamt
+	if data, _ = empty.MarshalBinary(); h1.UnmarshalBinary(data) != nil || !h1.IsEmpty() {
+		t.Fatal("failed to round trip an empty Hamt")
+	}
+
+	data, _ = h.MarshalBinary()
+	for _, bad := range [][]byte{data[:len(data)-1], data[1:], append(data, 0)} {
+		err = h1.UnmarshalBinary(bad)
+		if !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+			t.Fatalf("UnmarshalBinary() of a corrupt snapshot => %v", err)
+		}
+	}
+	if !h1.IsEmpty() {
+		t.Fatal("a failed UnmarshalBinary modified its receiver")
+	}
+
+	var h2, _ = h.Put(KVS[0].Key, struct{}{})
+	_, err = h2.MarshalBinary()
+	var kerr *hamt32.KeyError
+	if !errors.As(err, &kerr) || kerr.Key != KVS[0].Key {
+		t.Fatalf("MarshalBinary() of an unsupported value => %v", err)
+	}
+
+	// The Codec is set per Hamt; h2 still has StringCodec.
+	var h3, _ = hamt32.New(hamt32.WithCodec(structCodec{})).Put(KVS[0].Key, struct{}{})
+	if data, err = h3.MarshalBinary(); err != nil {
+		t.Fatal(err)
+	}
+	var h4 = hamt32.New(hamt32.WithCodec(structCodec{}))
+	if err = h4.UnmarshalBinary(data); err != nil || !h4.Equal(h3, nil) {
+		t.Fatalf("failed to round trip a Hamt with its own Codec: %v", err)
+	}
+	if _, err = h2.MarshalBinary(); err == nil {
+		t.Fatal("WithCodec() changed the Codec of another Hamt")
+	}
+}
+
+func TestGob32(t *testing.T) {
+	type session struct {
+		H    hamt32.Hamt
+		Vals map[string]interface{}
+	}
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+	var in = session{h, map[string]interface{}{"h": h}}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(in); err != nil {
+		t.Fatal(err)
+	}
+	var out session
+	if err := gob.NewDecoder(&buf).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.H.Equal(h, nil) {
+		t.Fatal("the gob decoded Hamt is not Equal to the original")
+	}
+	if h1, ok := out.Vals["h"].(hamt32.Hamt); !ok || !h1.Equal(h, nil) {
+		t.Fatalf("the gob decoded interface{} value %T is not Equal to the original", out.Vals["h"])
+	}
+}
+
+func TestEncodeDecode32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:5000])
+
+	var buf bytes.Buffer
+	if err := h.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := h.MarshalBinary(); !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("Encode() wrote a different snapshot than MarshalBinary()")
+	}
+
+	// io.MultiReader hides the io.ByteReader of buf; so Decode buffers it.
+	var h1, err = hamt32.Decode(io.MultiReader(&buf), hamt32.WithFullTableInit(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h1.Equal(h, nil) {
+		t.Fatal("the decoded Hamt is not Equal to the original")
+	}
+
+	if err = h.Encode(&failWriter{10000}); err != errWriteFailed {
+		t.Fatalf("Encode() to a failing io.Writer => %v", err)
+	}
+	if _, err = hamt32.Decode(strings.NewReader("HAMT")); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+		t.Fatalf("Decode() of a truncated snapshot => %v", err)
+	}
+}
+
+func TestDigest32(t *testing.T) {
+	var a = hamt32.New(hamt32.WithMerkle(true)).PutMany(KVS[:2000])
+
+	// b holds the same pairs, put in another order with extra keys put and
+	// deleted; so its trie has tables a's does not.
+	var b = hamt32.Hamt{}
+	for _, kv := range hamttest.Shuffle(KVS[:3000]) {
+		b, _ = b.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS[2000:3000] {
+		b, _, _ = b.Del(kv.Key)
+	}
+
+	var ad, err = a.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bd, _ := b.Digest(); bd != ad {
+		t.Fatal("Hamts with the same key/val pairs have different Digests")
+	}
+
+	var a1 = a
+	for _, kv := range KVS[:100] {
+		a1, _ = a1.Put(kv.Key, -1)
+		var b1, _ = b.Put(kv.Key, -1)
+		var a1d, _ = a1.Digest()
+		if b1d, _ := b1.Digest(); a1d != b1d || a1d == ad {
+			t.Fatalf("after Put(%s, -1) a1.Digest()=%x, b1.Digest()=%x, a.Digest()=%x", kv.Key, a1d, b1d, ad)
+		}
+		a1, _, _ = a1.Del(kv.Key)
+		b, _, _ = b.Del(kv.Key)
+		a1d, _ = a1.Digest()
+		if b1d, _ := b.Digest(); a1d != b1d {
+			t.Fatalf("after Del(%s) a1.Digest()=%x != b.Digest()=%x", kv.Key, a1d, b1d)
+		}
+	}
+	if ad1, _ := a.Digest(); ad1 != ad {
+		t.Fatal("a's Digest changed")
+	}
+
+	var a2, _ = a.Put(KVS[0].Key, struct{}{})
+	_, err = a2.Digest()
+	var kerr *hamt32.KeyError
+	if !errors.As(err, &kerr) || kerr.Op != "Digest" {
+		t.Fatalf("Digest() of an unsupported value => %v", err)
+	}
+
+	// Only keeps WithMerkle; its tables carry their digests, as do those of
+	// a Merkle Hamt built by putting the same pairs into a Builder.
+	var ks = make([]key.Key, 500)
+	var mb, pb = hamt32.New(hamt32.WithMerkle(true)).Builder(), hamt32.Hamt{}.Builder()
+	for i := range ks {
+		ks[i] = KVS[2*i].Key
+		mb.Put(KVS[2*i].Key, KVS[2*i].Val)
+		pb.Put(KVS[2*i].Key, KVS[2*i].Val)
+	}
+	var o, m, p = a.Only(ks...), mb.Freeze(), pb.Freeze()
+	var od, _ = o.Digest()
+	if pd, _ := p.Digest(); od != pd {
+		t.Fatal("a.Only() and a plain Hamt of the same pairs have different Digests")
+	}
+	if o.SizeInBytes(nil) != m.SizeInBytes(nil) || o.SizeInBytes(nil) == p.SizeInBytes(nil) {
+		t.Fatal("the tables of a.Only() do not carry their digests")
+	}
+}
+
+func TestHasher32(t *testing.T) {
+	var calls int
+	var fnv1a = hamt32.HasherFunc(func(data []byte) uint64 {
+		calls++
+		var h = fnv.New64a()
+		h.Write(data)
+		return h.Sum64()
+	})
+
+	var h = hamt32.New(hamt32.WithHasher(fnv1a)).PutMany(KVS[:2000])
+	if calls == 0 {
+		t.Fatal("the Hasher was not called")
+	}
+	for _, kv := range KVS[:2000] {
+		if v, found := h.Get(kv.Key); !found || v != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %v", kv.Key, v, found)
+		}
+	}
+	for k, v := range h.All() {
+		if _, isStringKey := k.(*stringkey.StringKey); !isStringKey {
+			t.Fatalf("h.All() returned a key of type %T", k)
+		}
+		if v1, _ := h.Get(k); v1 != v {
+			t.Fatalf("h.Get(%s) => %v; want %v", k, v1, v)
+		}
+	}
+
+	// Only keeps the Hasher.
+	calls = 0
+	var o = h.Only(KVS[0].Key, KVS[1].Key)
+	if o, _ = o.Put(KVS[2].Key, KVS[2].Val); calls != 3 {
+		t.Fatalf("the Hasher was called %d times by Only() and Put(); want 3", calls)
+	}
+
+	// A Hasher putting every key in one collisionLeaf.
+	var c = hamt32.New(hamt32.WithHasher(hamt32.HasherFunc(func([]byte) uint64 { return 0 })))
+	for _, kv := range KVS[:100] {
+		c, _ = c.Put(kv.Key, kv.Val)
+	}
+	if c.Nentries() != 100 {
+		t.Fatalf("c.Nentries()=%d; want 100", c.Nentries())
+	}
+	for _, kv := range KVS[:100] {
+		var v, found = c.Get(kv.Key)
+		if !found || v != kv.Val {
+			t.Fatalf("c.Get(%s) => %v, %v", kv.Key, v, found)
+		}
+		var deleted bool
+		if c, v, deleted = c.Del(kv.Key); !deleted || v != kv.Val {
+			t.Fatalf("c.Del(%s) => %v, %v", kv.Key, v, deleted)
+		}
+	}
+	if !c.IsEmpty() {
+		t.Fatalf("c.Nentries()=%d after deleting every key", c.Nentries())
+	}
+
+	var data, err = h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h1 hamt32.Hamt
+	if h1, err = hamt32.Decode(bytes.NewReader(data), hamt32.WithHasher(fnv1a)); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h1.ToMap(), h.ToMap()) {
+		t.Fatal("the decoded Hamt is not equal to the encoded one")
+	}
+	if _, err = hamt32.Decode(bytes.NewReader(data)); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+		t.Fatalf("Decode() without the Hasher => %v", err)
+	}
+
+	// Hamts with different Hashers are compared, and merged, pair by pair.
+	var plain = hamt32.Hamt{}.PutMany(KVS[:2000])
+	if !h.Equal(plain, nil) || !plain.Equal(h, nil) {
+		t.Fatal("Hamts of the same pairs and different Hashers are not Equal")
+	}
+	if changes := hamt32.Diff(h, plain); len(changes) != 0 {
+		t.Fatalf("Diff() of Hamts of the same pairs and different Hashers => %v", changes)
+	}
+	var more, _ = plain.Put(KVS[2000].Key, KVS[2000].Val)
+	if changes := hamt32.Diff(h, more); len(changes) != 1 || changes[0].Kind != hamt32.Added {
+		t.Fatalf("Diff() of a Hamt with one more pair and another Hasher => %v", changes)
+	}
+	var merged = h.Merge(more, nil)
+	if err = merged.Validate(); err != nil || merged.Nentries() != 2001 {
+		t.Fatalf("Merge() of a Hamt with another Hasher => %d entries, %v", merged.Nentries(), err)
+	}
+	if !merged.Equal(more, nil) {
+		t.Fatal("Merge() of a Hamt with another Hasher lost a pair")
+	}
+}
+
+func TestStatsShape32(t *testing.T) {
+	if s := (hamt32.Hamt{}).Stats(); s != (hamt32.Stats{}) || s.Occupancy() != 0 {
+		t.Fatalf("Stats() of an empty Hamt => %+v", s)
+	}
+
+	var h = hamt32.New(hamt32.WithFullTableInit(false), hamt32.WithGradeTables(false))
+	for _, kv := range KVS[:2000] {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+	var s = h.Stats()
+	if s.FullTables != 0 || s.CompressedTables == 0 {
+		t.Fatalf("Stats() of a Hamt of compressedTables => %+v", s)
+	}
+
+	var n uint
+	for _, m := range s.EntriesPerDepth {
+		n += m
+	}
+	if n != s.Nentries {
+		t.Fatalf("sum of s.EntriesPerDepth,%d != s.Nentries,%d", n, s.Nentries)
+	}
+	if s.EntriesPerDepth[0] != 0 {
+		t.Fatalf("s.EntriesPerDepth[0],%d != 0 for %d entries", s.EntriesPerDepth[0], s.Nentries)
+	}
+	var ntables = s.CompressedTables + s.FullTables
+	if s.TableEntries != ntables-1+s.FlatLeaves+s.CollisionLeaves {
+		t.Fatalf("s.TableEntries,%d is not the number of tables and leaves under the root; %+v", s.TableEntries, s)
+	}
+	if o := s.Occupancy(); o <= 0 || o > 1 {
+		t.Fatalf("s.Occupancy()=%f", o)
+	}
+
+	var f = hamt32.New(hamt32.WithFullTableInit(true), hamt32.WithGradeTables(false)).PutMany(KVS[:2000])
+	var fs = f.Stats()
+	if fs.CompressedTables != 0 || fs.FullTables != ntables || fs.EntriesPerDepth != s.EntriesPerDepth {
+		t.Fatalf("Stats() of the fullTable Hamt => %+v; the compressedTable Hamt => %+v", fs, s)
+	}
+}
+
+func TestSizeInBytes32(t *testing.T) {
+	if n := (hamt32.Hamt{}).SizeInBytes(nil); n != 0 {
+		t.Fatalf("SizeInBytes(nil) of an empty Hamt => %d", n)
+	}
+
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+	var n = h.SizeInBytes(nil)
+	if n <= h.Stats().StoredBytes+1000*int(unsafe.Sizeof(key.KeyVal{})) {
+		t.Fatalf("h.SizeInBytes(nil),%d is not more than its pairs and leaves", n)
+	}
+
+	var zero = h.SizeInBytes(func(interface{}) int { return 0 })
+	var hundred = h.SizeInBytes(func(interface{}) int { return 100 })
+	if hundred-zero != 100*1000 {
+		t.Fatalf("SizeInBytes() with 100 byte values is %d bytes more than with 0 byte values; want %d", hundred-zero, 100*1000)
+	}
+
+	var h1, _ = h.Put(KVS[1000].Key, KVS[1000].Val)
+	if n1 := h1.SizeInBytes(nil); n1 <= n {
+		t.Fatalf("h1.SizeInBytes(nil),%d <= h.SizeInBytes(nil),%d after a Put", n1, n)
+	}
+}
+
+func TestDumpTree32(t *testing.T) {
+	type node struct {
+		Type     string
+		Index    *uint
+		Depth    uint
+		HashPath string
+		NodeMap  string
+		Keys     []string
+		Entries  []node
+	}
+	var tree struct {
+		Width    uint
+		Nentries uint
+		Root     *node
+	}
+
+	var buf bytes.Buffer
+	if err := (hamt32.Hamt{}).DumpTree(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &tree); err != nil || tree.Root != nil || tree.Width != hamt32.TableCapacity {
+		t.Fatalf("DumpTree() of an empty Hamt => %s; err=%v", buf.Bytes(), err)
+	}
+
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+	buf.Reset()
+	if err := h.DumpTree(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Nentries != h.Nentries() || tree.Root == nil || tree.Root.Index != nil || tree.Root.HashPath != "/" {
+		t.Fatalf("DumpTree() => nentries=%d root=%+v", tree.Nentries, tree.Root)
+	}
+
+	var keys = make(map[string]bool)
+	var check func(n *node)
+	check = func(n *node) {
+		switch n.Type {
+		case "compressedTable", "fullTable":
+			var nodeMap uint64
+			for i := range n.Entries {
+				var e = &n.Entries[i]
+				nodeMap |= 1 << *e.Index
+				if !strings.HasPrefix(e.HashPath, n.HashPath) {
+					t.Fatalf("entry hashPath %s is not under table hashPath %s", e.HashPath, n.HashPath)
+				}
+				check(e)
+			}
+			if fmt.Sprintf("%#x", nodeMap) != n.NodeMap {
+				t.Fatalf("table %s nodeMap=%s; its entries make %#x", n.HashPath, n.NodeMap, nodeMap)
+			}
+		case "flatLeaf", "collisionLeaf":
+			for _, k := range n.Keys {
+				keys[k] = true
+			}
+		default:
+			t.Fatalf("unknown node type %q", n.Type)
+		}
+	}
+	check(tree.Root)
+	if len(keys) != 1000 {
+		t.Fatalf("DumpTree() wrote %d keys; want 1000", len(keys))
+	}
+}
+
+func TestMultimap32(t *testing.T) {
+	var m hamt32.Multimap
+	var versions []hamt32.Multimap
+	for i := 0; i < 5; i++ {
+		for _, kv := range KVS[:100] {
+			m = m.PutAppend(kv.Key, i)
+		}
+		versions = append(versions, m)
+	}
+
+	if m.Nkeys() != 100 || m.Nvalues() != 500 {
+		t.Fatalf("m.Nkeys()=%d, m.Nvalues()=%d; want 100, 500", m.Nkeys(), m.Nvalues())
+	}
+	for i, v := range versions {
+		var vs = v.GetAll(KVS[0].Key)
+		if len(vs) != i+1 || v.Count(KVS[0].Key) != i+1 {
+			t.Fatalf("versions[%d].GetAll(%s) => %v", i, KVS[0].Key, vs)
+		}
+		for j, val := range vs {
+			if val != j {
+				t.Fatalf("versions[%d].GetAll(%s) => %v; not in append order", i, KVS[0].Key, vs)
+			}
+		}
+	}
+	if vs := m.GetAll(KVS[100].Key); vs != nil || m.Count(KVS[100].Key) != 0 {
+		t.Fatalf("m.GetAll() of a missing key => %v", vs)
+	}
+
+	var nkeys int
+	m.Range(func(k key.Key, vs []interface{}) bool {
+		if len(vs) != 5 {
+			t.Fatalf("m.Range() gave %s %d values; want 5", k, len(vs))
+		}
+		nkeys++
+		return true
+	})
+	if nkeys != 100 {
+		t.Fatalf("m.Range() visited %d keys; want 100", nkeys)
+	}
+
+	var m1, vs, deleted = m.DelAll(KVS[0].Key)
+	if !deleted || len(vs) != 5 || m1.Nkeys() != 99 || m1.Nvalues() != 495 {
+		t.Fatalf("m.DelAll(%s) => %v, %t; Nkeys=%d Nvalues=%d", KVS[0].Key, vs, deleted, m1.Nkeys(), m1.Nvalues())
+	}
+	if _, _, deleted = m1.DelAll(KVS[0].Key); deleted {
+		t.Fatal("DelAll() of a deleted key returned true")
+	}
+}
+
+func TestUpdate32(t *testing.T) {
+	var calls int
+	var h = hamt32.New(hamt32.WithHasher(hamt32.HasherFunc(func(data []byte) uint64 {
+		calls++
+		return hashers.XXHash64{}.Hash(data)
+	})))
+
+	var incr = func(old interface{}, found bool) (interface{}, bool) {
+		if !found {
+			return 1, true
+		}
+		return old.(int) + 1, true
+	}
+	for i := 0; i < 3; i++ {
+		for _, kv := range KVS[:1000] {
+			h = h.Update(kv.Key, incr)
+		}
+	}
+	if calls != 3000 {
+		t.Fatalf("3000 Updates hashed %d keys", calls)
+	}
+	if h.Nentries() != 1000 {
+		t.Fatalf("h.Nentries()=%d; want 1000", h.Nentries())
+	}
+	for _, kv := range KVS[:1000] {
+		if v, _ := h.Get(kv.Key); v != 3 {
+			t.Fatalf("h.Get(%s) => %v; want 3", kv.Key, v)
+		}
+	}
+
+	var drop = func(interface{}, bool) (interface{}, bool) { return nil, false }
+	if h1 := h.Update(KVS[1000].Key, drop); !hamt32.Same(h1, h) {
+		t.Fatal("Update() deleting a missing key returned a new Hamt")
+	}
+	for _, kv := range KVS[:500] {
+		h = h.Update(kv.Key, drop)
+	}
+	if h.Nentries() != 500 {
+		t.Fatalf("h.Nentries()=%d after deleting 500 keys; want 500", h.Nentries())
+	}
+	if _, found := h.Get(KVS[0].Key); found {
+		t.Fatalf("h.Get(%s) found a key deleted by Update()", KVS[0].Key)
+	}
+}
+
+func TestReplaceIf32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:100])
+
+	if h1, replaced := h.ReplaceIf(KVS[0].Key, -1, "new", nil); replaced || !hamt32.Same(h1, h) {
+		t.Fatalf("ReplaceIf() with the wrong expected value => %t", replaced)
+	}
+	if h1, replaced := h.ReplaceIf(KVS[100].Key, nil, "new", nil); replaced || !hamt32.Same(h1, h) {
+		t.Fatalf("ReplaceIf() of a missing key => %t", replaced)
+	}
+
+	var h1, replaced = h.ReplaceIf(KVS[0].Key, KVS[0].Val, "new", nil)
+	if !replaced || h1.Nentries() != h.Nentries() {
+		t.Fatalf("ReplaceIf() with the expected value => %t", replaced)
+	}
+	if v, _ := h1.Get(KVS[0].Key); v != "new" {
+		t.Fatalf("h1.Get(%s) => %v; want \"new\"", KVS[0].Key, v)
+	}
+	if v, _ := h.Get(KVS[0].Key); v != KVS[0].Val {
+		t.Fatalf("ReplaceIf() changed the original Hamt; h.Get(%s) => %v", KVS[0].Key, v)
+	}
+
+	var sameParity = func(a, b interface{}) bool { return a.(int)%2 == b.(int)%2 }
+	if _, replaced = h.ReplaceIf(KVS[1].Key, KVS[1].Val.(int)+2, 0, sameParity); !replaced {
+		t.Fatal("ReplaceIf() ignored eq")
+	}
+}
+
+func TestStringKeys32(t *testing.T) {
+	var h hamt32.Hamt
+	for i := 0; i < 1000; i++ {
+		h, _ = h.PutString(strconv.Itoa(i), i)
+	}
+	for i := 0; i < 1000; i++ {
+		if v, found := h.GetString(strconv.Itoa(i)); !found || v != i {
+			t.Fatalf("h.GetString(%d) => %v, %t", i, v, found)
+		}
+		if v, _ := h.Get(stringkey.New(strconv.Itoa(i))); v != i {
+			t.Fatalf("h.Get(stringkey.New(%d)) => %v", i, v)
+		}
+	}
+
+	var h1, _ = hamt32.Hamt{}.Put(stringkey.New("a"), 1)
+	if v, found := h1.GetString("a"); !found || v != 1 {
+		t.Fatalf("h1.GetString(\"a\") of a *stringkey.StringKey => %v, %t", v, found)
+	}
+
+	var allocs = testing.AllocsPerRun(100, func() {
+		h.GetString("500")
+	})
+	if allocs != 0 {
+		t.Fatalf("GetString() made %v allocations; want 0", allocs)
+	}
+
+	var fh = hamt32.New(hamt32.WithKeyNormalizer(hamt32.FoldCase))
+	fh, _ = fh.PutString("ABC", 1)
+	if v, found := fh.GetString("abc"); !found || v != 1 {
+		t.Fatalf("FoldCase: fh.GetString(\"abc\") => %v, %t", v, found)
+	}
+
+	if _, err := h.MarshalBinary(); err != nil {
+		t.Fatalf("h.MarshalBinary() after PutString() => %s", err)
+	}
+
+	var v interface{}
+	var deleted bool
+	for i := 0; i < 1000; i++ {
+		if h, v, deleted = h.DelString(strconv.Itoa(i)); !deleted || v != i {
+			t.Fatalf("h.DelString(%d) => %v, %t", i, v, deleted)
+		}
+	}
+	if !h.IsEmpty() {
+		t.Fatalf("h.Nentries()=%d after DelString() of every key", h.Nentries())
+	}
+}
+
+func TestHashKey32(t *testing.T) {
+	var calls int
+	var hasher = hamt32.HasherFunc(func(data []byte) uint64 {
+		calls++
+		return hashers.XXHash64{}.Hash(data)
+	})
+	var h = hamt32.New(hamt32.WithHasher(hasher)).PutMany(KVS[:100])
+
+	calls = 0
+	var k = h.HashKey(KVS[0].Key)
+	var incr = func(old interface{}, found bool) (interface{}, bool) {
+		return old.(int) + 1, true
+	}
+	for i := 0; i < 100; i++ {
+		h = h.Update(k, incr)
+	}
+	if v, _ := h.Get(k); v != KVS[0].Val.(int)+100 {
+		t.Fatalf("h.Get(%s) => %v; want %d", k, v, KVS[0].Val.(int)+100)
+	}
+	if v, _ := h.Get(KVS[0].Key); v != KVS[0].Val.(int)+100 {
+		t.Fatalf("h.Get() of the unhashed key => %v; want %d", v, KVS[0].Val.(int)+100)
+	}
+	if calls != 2 {
+		t.Fatalf("HashKey() and 100 Updates of its key hashed %d keys; want 1 plus the unhashed Get", calls)
+	}
+	for k1 := range h.AllKeys() {
+		if _, isStringKey := k1.(*stringkey.StringKey); !isStringKey {
+			t.Fatalf("h.AllKeys() returned a key of type %T", k1)
+		}
+	}
+
+	// Another Hamt, with its own Hasher, hashes k again.
+	var other = hamt32.New(hamt32.WithHasher(hasher)).PutMany(KVS[:100])
+	calls = 0
+	if v, found := other.Get(k); !found || v != KVS[0].Val {
+		t.Fatalf("other.Get(%s) => %v, %t", k, v, found)
+	}
+	if calls != 1 {
+		t.Fatalf("other.Get() of h's hashed key hashed %d keys; want 1", calls)
+	}
+
+	var plain = hamt32.Hamt{}.PutMany(KVS[:100])
+	if v, found := plain.Get(plain.HashKey(KVS[1].Key)); !found || v != KVS[1].Val {
+		t.Fatalf("plain.Get(plain.HashKey(%s)) => %v, %t", KVS[1].Key, v, found)
+	}
+
+	// With only a key normalizer, the key is not normalized again.
+	var norms int
+	var fold = func(k key.Key) key.Key {
+		norms++
+		return hamt32.FoldCase(k)
+	}
+	var n, _ = hamt32.New(hamt32.WithKeyNormalizer(fold)).Put(stringkey.New("Foo"), 0)
+	norms = 0
+	var nk = n.HashKey(stringkey.New("FOO"))
+	for i := 0; i < 10; i++ {
+		n = n.Update(nk, incr)
+	}
+	if v, found := n.Get(nk); !found || v != 10 || norms != 1 {
+		t.Fatalf("n.Get(%s) => %v, %t after normalizing %d keys; want 10, true, 1", nk, v, found, norms)
+	}
+}
+
+func TestSortedRange32(t *testing.T) {
+	var h = hamt32.New(hamt32.WithHasher(hashers.XXHash64{})).PutMany(KVS[:1000])
+	var less = func(a, b key.Key) bool {
+		return a.String() < b.String()
+	}
+
+	var prev string
+	var n int
+	h.SortedRange(less, func(k key.Key, v interface{}) bool {
+		if _, isStringKey := k.(*stringkey.StringKey); !isStringKey {
+			t.Fatalf("h.SortedRange() gave a key of type %T", k)
+		}
+		if n > 0 && k.String() <= prev {
+			t.Fatalf("h.SortedRange() gave %s after %s", k, prev)
+		}
+		if val, _ := h.Get(k); val != v {
+			t.Fatalf("h.SortedRange() gave %s => %v; h.Get() => %v", k, v, val)
+		}
+		prev = k.String()
+		n++
+		return true
+	})
+	if n != 1000 {
+		t.Fatalf("h.SortedRange() gave %d pairs; want 1000", n)
+	}
+
+	n = 0
+	h.SortedRange(less, func(k key.Key, v interface{}) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("h.SortedRange() went on for %d pairs after fn returned false", n)
+	}
+}
+
+func TestRangeBetween32(t *testing.T) {
+	var h = hamt32.New(hamt32.WithHasher(hashers.XXHash64{})).PutMany(KVS[:1000])
+	var less = func(a, b key.Key) bool {
+		return a.String() < b.String()
+	}
+
+	var lo, hi = KVS[100].Key, KVS[200].Key
+	var want int
+	for _, kv := range KVS[:1000] {
+		if !less(kv.Key, lo) && less(kv.Key, hi) {
+			want++
+		}
+	}
+
+	var prev string
+	var n int
+	h.RangeBetween(lo, hi, less, func(k key.Key, v interface{}) bool {
+		if less(k, lo) || !less(k, hi) {
+			t.Fatalf("h.RangeBetween(%s, %s) gave %s", lo, hi, k)
+		}
+		if n > 0 && k.String() <= prev {
+			t.Fatalf("h.RangeBetween() gave %s after %s", k, prev)
+		}
+		if val, _ := h.Get(k); val != v {
+			t.Fatalf("h.RangeBetween() gave %s => %v; h.Get() => %v", k, v, val)
+		}
+		prev = k.String()
+		n++
+		return true
+	})
+	if n != want || n == 0 {
+		t.Fatalf("h.RangeBetween(%s, %s) gave %d pairs; want %d", lo, hi, n, want)
+	}
+
+	n = 0
+	h.RangeBetween(hi, lo, less, func(k key.Key, v interface{}) bool {
+		n++
+		return true
+	})
+	if n != 0 {
+		t.Fatalf("h.RangeBetween(%s, %s) of an empty range gave %d pairs", hi, lo, n)
+	}
+}
+
+func TestIterSeek32(t *testing.T) {
+	var h = hamt32.New(hamt32.WithHasher(hashers.XXHash64{})).PutMany(KVS[:1000])
+
+	var ks []key.Key
+	var it = h.Iter()
+	for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+		ks = append(ks, k)
+	}
+
+	for _, i := range []int{0, 1, 499, 999} {
+		it = h.Iter()
+		it.Seek(h.HashKey(ks[i]).Hash30())
+		var j = i
+		for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+			if !k.Equals(ks[j]) {
+				t.Fatalf("after Seek(ks[%d]): key %d is %s; want %s", i, j, k, ks[j])
+			}
+			j++
+		}
+		if j != len(ks) {
+			t.Fatalf("after Seek(ks[%d]): Next() stopped at key %d", i, j)
+		}
+	}
+
+	// Page through a version of h with every page's first key deleted.
+	var nh = h
+	var n int
+	it = nh.Iter()
+	for {
+		var k, _, ok = it.Next()
+		if !ok {
+			break
+		}
+		n++
+		if n%10 == 0 {
+			var hv = nh.HashKey(k).Hash30()
+			nh, _, _ = nh.Del(k)
+			it = nh.Iter()
+			it.Seek(hv)
+		}
+	}
+	if n != 1000 {
+		t.Fatalf("paging with Seek() across versions saw %d keys; want 1000", n)
+	}
+
+	it = hamt32.Hamt{}.Iter()
+	it.Seek(0)
+	if _, _, ok := it.Next(); ok {
+		t.Fatal("Next() after Seek() of an empty Hamt returned a pair")
+	}
+}
+
+func TestIterCursor32(t *testing.T) {
+	var collide = hamt32.HasherFunc(func(data []byte) uint64 {
+		return uint64(data[0]) // so every key collides with 1/26 of the others
+	})
+	for _, hasher := range []hamt32.Hasher{nil, collide} {
+		var h = hamt32.New(hamt32.WithHasher(hasher)).PutMany(KVS[:1000])
+
+		var want []key.Key
+		var it = h.Iter()
+		for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+			want = append(want, k)
+		}
+
+		// Checkpoint after every 7th pair, and resume from the checkpoint.
+		var got []key.Key
+		it = h.Iter()
+		for {
+			var k, _, ok = it.Next()
+			if !ok {
+				break
+			}
+			got = append(got, k)
+			if len(got)%7 == 0 {
+				var err error
+				it, err = h.IterAt(it.Cursor())
+				if err != nil {
+					t.Fatalf("h.IterAt(it.Cursor()) failed: %s", err)
+				}
+			}
+		}
+		if len(got) != len(want) {
+			t.Fatalf("resuming every 7th pair returned %d pairs; want %d", len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].Equals(want[i]) {
+				t.Fatalf("resuming every 7th pair: pair %d is %s; want %s", i, got[i], want[i])
+			}
+		}
+
+		// Resume half way on a later version with more keys.
+		it = h.Iter()
+		for i := 0; i < 500; i++ {
+			it.Next()
+		}
+		var cursor = it.Cursor()
+		var nh = h.PutMany(KVS[1000:2000])
+		it, _ = nh.IterAt(cursor)
+		var seen = make(map[string]bool)
+		for k, _, ok := it.Next(); ok; k, _, ok = it.Next() {
+			seen[k.String()] = true
+		}
+		for _, k := range want[500:] {
+			if !seen[k.String()] {
+				t.Fatalf("resuming on a later version skipped %s", k)
+			}
+		}
+		for _, k := range want[:500] {
+			if seen[k.String()] {
+				t.Fatalf("resuming on a later version returned %s again", k)
+			}
+		}
+	}
+
+	var empty hamt32.Hamt
+	var badCursors = [][]byte{nil, {0}, {1}, {1, 0}, {1, 0, 0, 0}, {1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, 0}}
+	for _, cursor := range badCursors {
+		if _, err := empty.IterAt(cursor); !errors.Is(err, hamt32.ErrBadCursor) {
+			t.Fatalf("IterAt(%v) => %v; want ErrBadCursor", cursor, err)
+		}
+	}
+}
+
+func TestReverseIter32(t *testing.T) {
+	var collide = hamt32.HasherFunc(func(data []byte) uint64 {
+		return uint64(data[0]) << 7 * uint64(data[2])
+	})
+	for _, hasher := range []hamt32.Hasher{nil, collide} {
+		var h = hamt32.New(hamt32.WithHasher(hasher)).PutMany(KVS[:1000])
+
+		var ks = h.Keys()
+		var n = len(ks)
+		var it = h.ReverseIter()
+		var i = n
+		for k, v, ok := it.Next(); ok; k, v, ok = it.Next() {
+			i--
+			if !k.Equals(ks[i]) {
+				t.Fatalf("h.ReverseIter() key %d is %s; want %s", n-1-i, k, ks[i])
+			}
+			if val, _ := h.Get(k); val != v {
+				t.Fatalf("h.ReverseIter() gave %s => %v; h.Get() => %v", k, v, val)
+			}
+		}
+		if i != 0 {
+			t.Fatalf("h.ReverseIter() stopped with %d keys left", i)
+		}
+
+		// Seek to the last key at or before ks[499], which is ks[499] or,
+		// if ks[499] is in a collisionLeaf, the last key of its leaf.
+		var hv = h.HashKey(ks[499]).Hash30()
+		it = h.ReverseIter()
+		it.Seek(hv)
+		var k, _, _ = it.Next()
+		if h.HashKey(k).Hash30() != hv {
+			t.Fatalf("after Seek(ks[499]), Next() => %s at %s; want hash path %s", k, h.HashKey(k).Hash30(), hv)
+		}
+
+		// Checkpoint after every 7th pair, and resume from the checkpoint.
+		var got []key.Key
+		it = h.ReverseIter()
+		for {
+			var k, _, ok = it.Next()
+			if !ok {
+				break
+			}
+			got = append(got, k)
+			if len(got)%7 == 0 {
+				var err error
+				it, err = h.IterAt(it.Cursor())
+				if err != nil {
+					t.Fatalf("h.IterAt(it.Cursor()) failed: %s", err)
+				}
+			}
+		}
+		if len(got) != n {
+			t.Fatalf("resuming every 7th pair returned %d pairs; want %d", len(got), n)
+		}
+		for i := range got {
+			if !got[i].Equals(ks[n-1-i]) {
+				t.Fatalf("resuming every 7th pair: pair %d is %s; want %s", i, got[i], ks[n-1-i])
+			}
+		}
+	}
+}
+
+func TestPage32(t *testing.T) {
+	var collide = hamt32.HasherFunc(func(data []byte) uint64 {
+		return uint64(data[0]) << 7 * uint64(data[2])
+	})
+	for _, hasher := range []hamt32.Hasher{nil, collide} {
+		var h = hamt32.New(hamt32.WithHasher(hasher)).PutMany(KVS[:1000])
+		var ks = h.Keys()
+
+		for _, pg := range []struct{ offset, limit, n uint }{
+			{0, 10, 10}, {5, 10, 10}, {990, 100, 10}, {999, 1, 1}, {1000, 10, 0}, {0, 0, 0}, {0, 2000, 1000},
+		} {
+			var kvs = h.Page(pg.offset, pg.limit)
+			if uint(len(kvs)) != pg.n {
+				t.Fatalf("h.Page(%d, %d) returned %d pairs; want %d", pg.offset, pg.limit, len(kvs), pg.n)
+			}
+			for i, kv := range kvs {
+				if !kv.Key.Equals(ks[pg.offset+uint(i)]) {
+					t.Fatalf("h.Page(%d, %d)[%d] is %s; want %s", pg.offset, pg.limit, i, kv.Key, ks[pg.offset+uint(i)])
+				}
+				if val, _ := h.Get(kv.Key); val != kv.Val {
+					t.Fatalf("h.Page(%d, %d) gave %s => %v; h.Get() => %v", pg.offset, pg.limit, kv.Key, kv.Val, val)
+				}
+			}
+		}
+	}
+
+	// Page skips subtrees by the pairs each table counts; the counts must
+	// follow the edits of a Builder, and the grading of tables, too.
+	var b = hamt32.New(hamt32.WithGradeTables(true), hamt32.WithAssertLevel(hamt32.AssertExpensive)).Builder()
+	for _, kv := range KVS[:2000] {
+		b.Put(kv.Key, kv.Val)
+	}
+	for _, kv := range KVS[:1500] {
+		b.Del(kv.Key)
+	}
+	var h = b.Freeze()
+	if err := h.Validate(); err != nil {
+		t.Fatalf("h.Validate() after Builder edits failed: %s", err)
+	}
+	var ks = h.Keys()
+	for offset := uint(0); offset < h.Nentries(); offset += 37 {
+		var kvs = h.Page(offset, 3)
+		for i, kv := range kvs {
+			if !kv.Key.Equals(ks[offset+uint(i)]) {
+				t.Fatalf("h.Page(%d, 3)[%d] is %s; want %s", offset, i, kv.Key, ks[offset+uint(i)])
+			}
+		}
+	}
+}
+
+func TestMapValues32(t *testing.T) {
+	var collide = hamt32.HasherFunc(func(data []byte) uint64 {
+		return uint64(data[0]) << 7 * uint64(data[2])
+	})
+	for _, hasher := range []hamt32.Hasher{nil, collide} {
+		var h = hamt32.New(hamt32.WithHasher(hasher), hamt32.WithMerkle(true)).PutMany(KVS[:1000])
+		var double = func(k key.Key, v interface{}) interface{} {
+			return fmt.Sprint(k, "=", v.(int)*2)
+		}
+		var nh = h.MapValues(double)
+
+		if err := nh.Validate(); err != nil {
+			t.Fatalf("nh.Validate() failed: %s", err)
+		}
+		if nh.Nentries() != 1000 {
+			t.Fatalf("nh.Nentries(),%d != 1000", nh.Nentries())
+		}
+		for _, kv := range KVS[:1000] {
+			if v, _ := nh.Get(kv.Key); v != double(kv.Key, kv.Val) {
+				t.Fatalf("nh.Get(%s) => %v; want %v", kv.Key, v, double(kv.Key, kv.Val))
+			}
+			if v, _ := h.Get(kv.Key); v != kv.Val {
+				t.Fatalf("h.Get(%s) => %v after MapValues; want %v", kv.Key, v, kv.Val)
+			}
+		}
+
+		var want = hamt32.New(hamt32.WithHasher(hasher), hamt32.WithMerkle(true))
+		for _, kv := range KVS[:1000] {
+			want, _ = want.Put(kv.Key, double(kv.Key, kv.Val))
+		}
+		var s, ws = nh.Stats(), want.Stats()
+		if s.StoredBytes != ws.StoredBytes || s.CompressedTables != ws.CompressedTables || s.FullTables != ws.FullTables {
+			t.Fatalf("nh.Stats() => %+v; want %+v", s, ws)
+		}
+		var d, _ = nh.Digest()
+		var wd, _ = want.Digest()
+		if d != wd {
+			t.Fatal("nh.Digest() != Digest() of the same pairs Put one by one")
+		}
+	}
+}
+
+func TestFilter32(t *testing.T) {
+	var collide = hamt32.HasherFunc(func(data []byte) uint64 {
+		return uint64(data[0]) << 7 * uint64(data[2])
+	})
+	for _, hasher := range []hamt32.Hasher{nil, collide} {
+		var h = hamt32.New(hamt32.WithHasher(hasher), hamt32.WithMerkle(true)).PutMany(KVS[:1000])
+		var even = func(k key.Key, v interface{}) bool {
+			return v.(int)%2 == 0
+		}
+		var nh = h.Filter(even)
+
+		if err := nh.Validate(); err != nil {
+			t.Fatalf("nh.Validate() failed: %s", err)
+		}
+		if nh.Nentries() != 500 || h.Nentries() != 1000 {
+			t.Fatalf("nh.Nentries(),%d != 500 or h.Nentries(),%d != 1000", nh.Nentries(), h.Nentries())
+		}
+
+		var want = h
+		for _, kv := range KVS[:1000] {
+			if !even(kv.Key, kv.Val) {
+				want, _, _ = want.Del(kv.Key)
+			}
+		}
+		var s, ws = nh.Stats(), want.Stats()
+		if s.StoredBytes != ws.StoredBytes || s.CompressedTables != ws.CompressedTables || s.FullTables != ws.FullTables {
+			t.Fatalf("nh.Stats() => %+v; want %+v", s, ws)
+		}
+		var d, _ = nh.Digest()
+		var wd, _ = want.Digest()
+		if d != wd {
+			t.Fatal("nh.Digest() != Digest() of the same pairs Deleted one by one")
+		}
+
+		if all := h.Filter(func(key.Key, interface{}) bool { return true }); !all.Equal(h, nil) {
+			t.Fatal("h.Filter(true) != h")
+		}
+		if none := h.Filter(func(key.Key, interface{}) bool { return false }); !none.IsEmpty() {
+			t.Fatalf("h.Filter(false) => %s; want an empty Hamt", none)
+		}
+	}
+}
+
+func TestFind32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+
+	var calls int
+	var k, v, found = h.Find(func(k key.Key, v interface{}) bool {
+		calls++
+		return v.(int)%100 == 42
+	})
+	if !found || v.(int)%100 != 42 {
+		t.Fatalf("h.Find(v%%100 == 42) => %s, %v, %t", k, v, found)
+	}
+	if val, _ := h.Get(k); val != v {
+		t.Fatalf("h.Find() => %s, %v; h.Get() => %v", k, v, val)
+	}
+
+	var ks = h.Keys()
+	if !k.Equals(ks[calls-1]) {
+		t.Fatalf("h.Find() => %s after %d calls; want the first match, %s", k, calls, ks[calls-1])
+	}
+	for _, k1 := range ks[:calls-1] {
+		if v1, _ := h.Get(k1); v1.(int)%100 == 42 {
+			t.Fatalf("h.Find() => %s; but %s, before it, matches", k, k1)
+		}
+	}
+
+	if k, v, found = h.Find(func(key.Key, interface{}) bool { return false }); found || k != nil || v != nil {
+		t.Fatalf("h.Find(false) => %s, %v, %t", k, v, found)
+	}
+}
+
+func TestCountWhere32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+
+	var n = h.CountWhere(func(k key.Key, v interface{}) bool {
+		return v.(int)%10 == 3
+	})
+	if n != 100 {
+		t.Fatalf("h.CountWhere(v%%10 == 3) => %d; want 100", n)
+	}
+	if n = h.CountWhere(nil); n != 1000 {
+		t.Fatalf("h.CountWhere(nil) => %d; want 1000", n)
+	}
+	if n = (hamt32.Hamt{}).CountWhere(nil); n != 0 {
+		t.Fatalf("Hamt{}.CountWhere(nil) => %d; want 0", n)
+	}
+}
+
+func TestAtomicHamt32(t *testing.T) {
+	var a hamt32.AtomicHamt
+	if !a.Load().IsEmpty() {
+		t.Fatal("the zero AtomicHamt does not hold an empty Hamt")
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(kvs []key.KeyVal) {
+			defer wg.Done()
+			for _, kv := range kvs {
+				a.Update(func(h hamt32.Hamt) hamt32.Hamt {
+					h, _ = h.Put(kv.Key, kv.Val)
+					return h
+				})
+				if _, found := a.Load().Get(kv.Key); !found {
+					t.Errorf("a.Load().Get(%s) after a.Update() found nothing", kv.Key)
+				}
+			}
+		}(KVS[g*100 : (g+1)*100])
+	}
+	wg.Wait()
+
+	var h, ver = a.LoadVersion()
+	if h.Nentries() != 800 {
+		t.Fatalf("after concurrent Updates, a.Load().Nentries(),%d != 800", h.Nentries())
+	}
+	for _, kv := range KVS[:800] {
+		if v, _ := h.Get(kv.Key); v != kv.Val {
+			t.Fatalf("a.Load().Get(%s) => %v; want %v", kv.Key, v, kv.Val)
+		}
+	}
+
+	var nh, _ = h.Put(KVS[800].Key, KVS[800].Val)
+	if !a.CompareAndSwap(ver, nh) {
+		t.Fatal("a.CompareAndSwap() of the current Version failed")
+	}
+	if a.CompareAndSwap(ver, hamt32.Hamt{}) {
+		t.Fatal("a.CompareAndSwap() of a stale Version succeeded")
+	}
+	if a.Load().Nentries() != 801 {
+		t.Fatalf("a.Load().Nentries(),%d != 801", a.Load().Nentries())
+	}
+
+	// A Version is of one Store, not of an equal Hamt.
+	a.Store(nh)
+	var _, ver1 = a.LoadVersion()
+	a.Store(nh)
+	if a.CompareAndSwap(ver1, hamt32.Hamt{}) {
+		t.Fatal("a.CompareAndSwap() of the Version of a replaced, equal Hamt succeeded")
+	}
+
+	a.Store(hamt32.Hamt{})
+	if !a.Load().IsEmpty() {
+		t.Fatal("a.Load() after a.Store(Hamt{}) is not empty")
+	}
+	var _, ver2 = hamt32.NewAtomicHamt(nh).LoadVersion()
+	if hamt32.NewAtomicHamt(nh).CompareAndSwap(ver2, hamt32.Hamt{}) {
+		t.Fatal("CompareAndSwap() of the Version of another AtomicHamt succeeded")
+	}
+	var z hamt32.AtomicHamt
+	if !z.CompareAndSwap(hamt32.Version{}, nh) || z.Load().Nentries() != 801 {
+		t.Fatal("CompareAndSwap() of the zero Version on the zero AtomicHamt failed")
+	}
+}
+
+// TestAtomicHamtConcurrent32 races writers by Update, by CompareAndSwap
+// and by Txn against readers of the versions they make; run it with -race.
+// Every write reads the count n, as a uint, and puts n+1 as the count and
+// as the value of a key of its own. So the writes are linearizable only if
+// they wrote each count of 1..nwrites once, and every version read holds
+// the count n and exactly the values 1..n.
+func TestAtomicHamtConcurrent32(t *testing.T) {
+	const nwriters, nops = 6, 50
+	var count = keys.NewString("count")
+	var a = hamt32.NewAtomicHamt(hamt32.Hamt{}.PutMany([]key.KeyVal{{Key: count, Val: uint(0)}}))
+
+	var inc = func(h hamt32.Hamt, k key.Key) (hamt32.Hamt, int) {
+		var n, _ = h.Get(count)
+		var nn = int(n.(uint)) + 1
+		if nn%4 == 0 {
+			runtime.Gosched() // widen the window for a racing write
+		}
+		h, _ = h.Put(count, uint(nn))
+		h, _ = h.Put(k, nn)
+		return h, nn
+	}
+	var check = func(h hamt32.Hamt) (int, error) {
+		var n, _ = h.Get(count)
+		var seen = make([]bool, int(n.(uint))+1)
+		var nvals int
+		h.Range(func(_ key.Key, v interface{}) bool {
+			if v, ok := v.(int); ok {
+				if v < 1 || v >= len(seen) || seen[v] {
+					nvals = -1
+					return false
+				}
+				seen[v] = true
+				nvals++
+			}
+			return true
+		})
+		if nvals != len(seen)-1 || h.Nentries() != uint(len(seen)) {
+			return 0, fmt.Errorf("a version of count %d holds %d of the values 1..%d in %d entries", n, nvals, n, h.Nentries())
+		}
+		return len(seen) - 1, nil
+	}
+
+	var done = make(chan struct{})
+	var rwg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		rwg.Add(1)
+		go func() {
+			defer rwg.Done()
+			var last int
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				var n, err = check(a.Load())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n < last {
+					t.Errorf("a reader saw count %d after count %d", n, last)
+					return
+				}
+				last = n
+				runtime.Gosched()
+			}
+		}()
+	}
+
+	var wrote = make([][]int, nwriters)
+	var wwg sync.WaitGroup
+	for g := 0; g < nwriters; g++ {
+		wwg.Add(1)
+		go func(g int) {
+			defer wwg.Done()
+			for i := 0; i < nops; i++ {
+				var k = KVS[g*nops+i].Key
+				var n int
+				switch g % 3 {
+				case 0:
+					a.Update(func(h hamt32.Hamt) hamt32.Hamt {
+						h, n = inc(h, k)
+						return h
+					})
+				case 1:
+					for {
+						var h, ver = a.LoadVersion()
+						var nh hamt32.Hamt
+						nh, n = inc(h, k)
+						if a.CompareAndSwap(ver, nh) {
+							break
+						}
+					}
+				case 2:
+					var err = a.Atomically(func(tx *hamt32.Txn) error {
+						var v, _ = tx.Get(count)
+						n = int(v.(uint)) + 1
+						if n%4 == 0 {
+							runtime.Gosched()
+						}
+						tx.Put(count, uint(n))
+						tx.Put(k, n)
+						return nil
+					})
+					if err != nil {
+						t.Errorf("a.Atomically() failed: %s", err)
+					}
+				}
+				wrote[g] = append(wrote[g], n)
+			}
+		}(g)
+	}
+	wwg.Wait()
+	close(done)
+	rwg.Wait()
+
+	var counts = make([]bool, nwriters*nops+1)
+	for g := range wrote {
+		for i, n := range wrote[g] {
+			if n < 1 || n >= len(counts) || counts[n] {
+				t.Fatalf("write %d of writer %d wrote count %d twice, or out of 1..%d", i, g, n, nwriters*nops)
+			}
+			if i > 0 && n <= wrote[g][i-1] {
+				t.Fatalf("writer %d wrote count %d after count %d", g, n, wrote[g][i-1])
+			}
+			counts[n] = true
+		}
+	}
+	if n, err := check(a.Load()); err != nil || n != nwriters*nops {
+		t.Fatalf("the last version has count %d, %v; want %d", n, err, nwriters*nops)
+	}
+}
+
+func TestVersionStamp32(t *testing.T) {
+	var put = func(kv key.KeyVal) func(h hamt32.Hamt) hamt32.Hamt {
+		return func(h hamt32.Hamt) hamt32.Hamt {
+			h, _ = h.Put(kv.Key, kv.Val)
+			return h
+		}
+	}
+	var stamp = func(a *hamt32.AtomicHamt) uint64 {
+		var _, ver = a.LoadVersion()
+		return ver.Stamp()
+	}
+
+	var z hamt32.AtomicHamt
+	if s := stamp(&z); s != 0 {
+		t.Fatalf("the zero AtomicHamt has stamp %d; want 0", s)
+	}
+
+	var a = hamt32.NewAtomicHamt(hamt32.Hamt{})
+	if s := stamp(a); s != 1 {
+		t.Fatalf("NewAtomicHamt() has stamp %d; want 1", s)
+	}
+	a.Store(hamt32.Hamt{}) // an equal Hamt is a new version
+	a.Update(put(KVS[0]))
+	var h, ver = a.LoadVersion()
+	a.CompareAndSwap(ver, h)
+	if a.CompareAndSwap(ver, hamt32.Hamt{}) {
+		t.Fatal("a.CompareAndSwap() of a stale Version succeeded")
+	}
+	if s := stamp(a); s != 4 {
+		t.Fatalf("after Store, Update and CompareAndSwap, the stamp is %d; want 4", s)
+	}
+
+	h, s, err := a.UpdateIfVersion(4, put(KVS[1]))
+	if err != nil || s != 5 || h.Nentries() != 2 || stamp(a) != 5 {
+		t.Fatalf("a.UpdateIfVersion(4) => %d entries, %d, %v; want 2 entries, 5", h.Nentries(), s, err)
+	}
+	var called bool
+	h, s, err = a.UpdateIfVersion(4, func(h hamt32.Hamt) hamt32.Hamt {
+		called = true
+		return h
+	})
+	if !errors.Is(err, hamt32.ErrStaleVersion) || called || s != 5 || h.Nentries() != 2 {
+		t.Fatalf("a.UpdateIfVersion(4) of stamp 5 => %d entries, %d, %v, fn called %t", h.Nentries(), s, err, called)
+	}
+
+	// A commit is a version; a Txn without writes is not.
+	var tx = a.Begin()
+	tx.Get(KVS[0].Key)
+	tx.Commit()
+	tx = a.Begin()
+	tx.Put(KVS[2].Key, KVS[2].Val)
+	tx.Commit()
+	if s := stamp(a); s != 6 {
+		t.Fatalf("after two Txns, one writing, the stamp is %d; want 6", s)
+	}
+
+	// Concurrent conditional updates: every version gets a stamp, once.
+	const ngoroutines, nops = 8, 50
+	var wg sync.WaitGroup
+	var stamps = make([][]uint64, ngoroutines)
+	for g := 0; g < ngoroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < nops; i++ {
+				var _, ver = a.LoadVersion()
+				var s = ver.Stamp()
+				var err error
+				for {
+					_, s, err = a.UpdateIfVersion(s, put(KVS[100+g*nops+i]))
+					if err == nil {
+						break
+					}
+				}
+				stamps[g] = append(stamps[g], s)
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	var seen = make(map[uint64]bool)
+	for _, ss := range stamps {
+		for _, s := range ss {
+			if seen[s] || s <= 6 || s > 6+ngoroutines*nops {
+				t.Fatalf("UpdateIfVersion() returned stamp %d twice, or out of 7..%d", s, 6+ngoroutines*nops)
+			}
+			seen[s] = true
+		}
+	}
+	if s := stamp(a); s != 6+ngoroutines*nops || a.Load().Nentries() != 3+ngoroutines*nops {
+		t.Fatalf("after concurrent updates, stamp %d and %d entries", s, a.Load().Nentries())
+	}
+}
+
+func TestTxn32(t *testing.T) {
+	var a = hamt32.NewAtomicHamt(hamt32.Hamt{}.PutMany(KVS[:3]))
+	var k0, k1, k2 = KVS[0].Key, KVS[1].Key, KVS[2].Key
+
+	// A key read by the Txn changes: conflict.
+	var tx = a.Begin()
+	tx.Get(k0)
+	a.Update(func(h hamt32.Hamt) hamt32.Hamt {
+		h, _ = h.Put(k0, -1)
+		return h
+	})
+	tx.Put(k1, 100)
+	if err := tx.Commit(); !errors.Is(err, hamt32.ErrTxnConflict) {
+		t.Fatalf("tx.Commit() after its read key changed => %v; want ErrTxnConflict", err)
+	}
+	if v, _ := a.Load().Get(k1); v != KVS[1].Val {
+		t.Fatalf("a.Load().Get(%s) => %v after a failed Commit", k1, v)
+	}
+
+	// Another key changes: the writes are applied to the current Hamt.
+	tx = a.Begin()
+	tx.Get(k0)
+	tx.Put(k1, 100)
+	tx.Del(k2)
+	a.Update(func(h hamt32.Hamt) hamt32.Hamt {
+		h, _ = h.Put(KVS[3].Key, KVS[3].Val)
+		return h
+	})
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("tx.Commit() after an unrelated change failed: %s", err)
+	}
+	var h = a.Load()
+	if v, _ := h.Get(k1); v != 100 {
+		t.Fatalf("a.Load().Get(%s) => %v after Commit; want 100", k1, v)
+	}
+	if _, found := h.Get(k2); found {
+		t.Fatalf("a.Load().Get(%s) found the key deleted by the Txn", k2)
+	}
+	if v, _ := h.Get(KVS[3].Key); v != KVS[3].Val {
+		t.Fatalf("Commit() lost the unrelated change of %s", KVS[3].Key)
+	}
+
+	// The keys of the Txn are compared as normalized by the Hamt.
+	var fold, _ = hamt32.New(hamt32.WithKeyNormalizer(hamt32.FoldCase)).Put(stringkey.New("abc"), 1)
+	var na = hamt32.NewAtomicHamt(fold)
+	tx = na.Begin()
+	tx.Get(stringkey.New("ABC"))
+	na.Update(func(h hamt32.Hamt) hamt32.Hamt {
+		h, _ = h.Put(stringkey.New("abc"), 100)
+		return h
+	})
+	tx.Put(stringkey.New("ABC"), 2)
+	if err := tx.Commit(); !errors.Is(err, hamt32.ErrTxnConflict) {
+		t.Fatalf("tx.Commit() after its read key changed under another case => %v; want ErrTxnConflict", err)
+	}
+	if v, _ := na.Load().Get(stringkey.New("abc")); v != 100 {
+		t.Fatalf("na.Load().Get(\"abc\") => %v after a failed Commit; want 100", v)
+	}
+
+	// Concurrent transfers between two accounts keep their sum.
+	var acct0, acct1 = keys.NewString("acct0"), keys.NewString("acct1")
+	a.Store(hamt32.Hamt{}.PutMany([]key.KeyVal{{Key: acct0, Val: 1000}, {Key: acct1, Val: 1000}}))
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var from, to = acct0, acct1
+				if (g+i)%2 == 0 {
+					from, to = to, from
+				}
+				var err = a.Atomically(func(tx *hamt32.Txn) error {
+					var fv, _ = tx.Get(from)
+					var tv, _ = tx.Get(to)
+					tx.Put(from, fv.(int)-i)
+					tx.Put(to, tv.(int)+i)
+					return nil
+				})
+				if err != nil {
+					t.Errorf("a.Atomically() failed: %s", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	h = a.Load()
+	var v0, _ = h.Get(acct0)
+	var v1, _ = h.Get(acct1)
+	if v0.(int)+v1.(int) != 2000 {
+		t.Fatalf("after concurrent transfers, %d + %d != 2000", v0, v1)
+	}
+
+	var errStop = errors.New("stop")
+	if err := a.Atomically(func(tx *hamt32.Txn) error {
+		tx.Put(acct0, 0)
+		return errStop
+	}); err != errStop {
+		t.Fatalf("a.Atomically() => %v; want the error of fn", err)
+	}
+	if v, _ := a.Load().Get(acct0); v != v0 {
+		t.Fatalf("a.Atomically() of a failing fn wrote %v", v)
+	}
+}
+
+func TestWatcher32(t *testing.T) {
+	var a = hamt32.NewAtomicHamt(hamt32.Hamt{}.PutMany(KVS[:1000]))
+	var w = hamt32.NewWatcher(a.Load(), 0)
+
+	var done = make(chan []hamt32.Change)
+	go func() {
+		var changes []hamt32.Change
+		for c := range w.C {
+			changes = append(changes, c)
+		}
+		done <- changes
+	}()
+
+	var start = a.Load()
+	for i := 0; i < 10; i++ {
+		w.Observe(a.Update(func(h hamt32.Hamt) hamt32.Hamt {
+			h, _ = h.Put(KVS[1000+i].Key, KVS[1000+i].Val) // Added
+			h, _, _ = h.Del(KVS[i].Key)                    // Removed
+			h, _ = h.Put(KVS[100+i].Key, -1)               // Modified
+			return h
+		}))
+	}
+	w.Observe(a.Load()) // no Changes
+	w.Close()
+	w.Observe(hamt32.Hamt{}) // ignored after Close
+
+	var changes = <-done
+	if len(changes) != 30 {
+		t.Fatalf("the Watcher delivered %d Changes; want 30", len(changes))
+	}
+	var kinds = make(map[hamt32.ChangeKind]int)
+	var ops = make([]hamt32.Op, len(changes))
+	for i, c := range changes {
+		kinds[c.Kind]++
+		ops[i] = c.Op()
+	}
+	if kinds[hamt32.Added] != 10 || kinds[hamt32.Removed] != 10 || kinds[hamt32.Modified] != 10 {
+		t.Fatalf("the Watcher delivered %v Changes; want 10 of each kind", kinds)
+	}
+	if !start.Apply(ops).Equal(a.Load(), nil) {
+		t.Fatal("the Changes of the Watcher, applied to the first version, do not make the last")
+	}
+}
+
+func TestDecodeCompact32(t *testing.T) {
+	// Craft snapshots, of StringCodec records, that Decode rejects.
+	var head, _ = hamt32.Hamt{}.MarshalBinary()
+	var magic = head[:len(head)-1]
+	var record = func(buf []byte, k string, v int) []byte {
+		buf = append(binary.AppendUvarint(buf, uint64(len(k))), k...)
+		var val = binary.AppendVarint([]byte{3}, int64(v)) // valInt
+		return append(binary.AppendUvarint(buf, uint64(len(val))), val...)
+	}
+	var root = func(nentries int, idx uint) []byte {
+		var buf = binary.AppendUvarint(append([]byte(nil), magic...), uint64(nentries))
+		return binary.AppendUvarint(append(buf, 1), uint64(1)<<idx) // compressedTable
+	}
+	var a, b = stringkey.New("a"), stringkey.New("b")
+
+	// "a" twice in a collisionLeaf.
+	var dup = append(root(2, a.Hash30().Index(0)), 4, 2) // collisionLeaf of 2
+	dup = record(record(dup, "a", 1), "a", 2)
+
+	// "b" at the index of "a".
+	var idx = a.Hash30().Index(0)
+	if idx == b.Hash30().Index(0) {
+		t.Fatal("a and b share an index")
+	}
+	var misplaced = record(append(root(1, idx), 3), "b", 3) // flatLeaf
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+		k    key.Key
+		v    int
+	}{{"duplicate", dup, a, 2}, {"misplaced", misplaced, b, 3}} {
+		if _, err := hamt32.Decode(bytes.NewReader(tc.data)); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+			t.Fatalf("Decode() of a %s key => %v; want ErrCorruptSnapshot", tc.name, err)
+		}
+		var h, err = hamt32.DecodeCompact(bytes.NewReader(tc.data), hamt32.WithFullTableInit(true))
+		if err != nil {
+			t.Fatalf("DecodeCompact() of a %s key failed: %s", tc.name, err)
+		}
+		if v, found := h.Get(tc.k); !found || v != tc.v || h.Nentries() != 1 || h.Validate() != nil {
+			t.Fatalf("DecodeCompact() of a %s key => %s; want %s=%d only", tc.name, h, tc.k, tc.v)
+		}
+		if h.Stats().FullTables != 1 {
+			t.Fatalf("DecodeCompact() did not rebuild the root table by the options given")
+		}
+		if _, err = hamt32.DecodeCompact(bytes.NewReader(tc.data[:len(tc.data)-1])); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+			t.Fatalf("DecodeCompact() of a truncated snapshot => %v; want ErrCorruptSnapshot", err)
+		}
+	}
+
+	// A valid snapshot loads as by Decode.
+	var h = hamt32.Hamt{}.PutMany(KVS[:1000])
+	var data, _ = h.MarshalBinary()
+	if nh, err := hamt32.DecodeCompact(bytes.NewReader(data)); err != nil || !nh.Equal(h, nil) {
+		t.Fatalf("DecodeCompact() of a valid snapshot => %v", err)
+	}
+}
+
+func TestReplica32(t *testing.T) {
+	var a = hamt32.New(hamt32.WithMerkle(true)).PutMany(KVS[:5000])
+	var b = a.PutMany(KVS[5000:5010])
+	for i, kv := range KVS[:10] {
+		b, _, _ = b.Del(kv.Key)
+		b, _ = b.Put(KVS[100+i].Key, -1)
+	}
+
+	var ad, _ = a.Digest()
+	var snap, err = b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Based on a, the replica of b carries the changed nodes only.
+	var buf bytes.Buffer
+	if err = b.WriteReplica(&buf, ad, hamt32.Hamt{}, a); err != nil {
+		t.Fatalf("b.WriteReplica() failed: %s", err)
+	}
+	if buf.Len() > len(snap)/4 {
+		t.Fatalf("the replica of b based on a is %d bytes; the snapshot of b %d", buf.Len(), len(snap))
+	}
+	var replica = buf.Bytes()
+
+	var nb hamt32.Hamt
+	if nb, err = a.ReadReplica(bytes.NewReader(replica)); err != nil {
+		t.Fatalf("a.ReadReplica() failed: %s", err)
+	}
+	if !nb.Equal(b, nil) || nb.Nentries() != b.Nentries() || nb.Stats().StoredBytes != b.Stats().StoredBytes {
+		t.Fatal("a.ReadReplica() of the replica of b is not b")
+	}
+	var bd, _ = b.Digest()
+	if nbd, _ := nb.Digest(); nbd != bd {
+		t.Fatal("a.ReadReplica() of the replica of b does not have the Digest of b")
+	}
+
+	// A replica based on a can only be read by a.
+	if _, err = b.ReadReplica(bytes.NewReader(replica)); !errors.Is(err, hamt32.ErrReplicaBase) {
+		t.Fatalf("b.ReadReplica() of a replica based on a => %v; want ErrReplicaBase", err)
+	}
+	var bad = append([]byte(nil), replica...)
+	bad[20] ^= 1 // in the Digest of b
+	if _, err = a.ReadReplica(bytes.NewReader(bad)); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+		t.Fatalf("a.ReadReplica() of a replica of another Digest => %v; want ErrCorruptSnapshot", err)
+	}
+	if _, err = a.ReadReplica(bytes.NewReader(replica[:len(replica)-1])); !errors.Is(err, hamt32.ErrCorruptSnapshot) {
+		t.Fatalf("a.ReadReplica() of a truncated replica => %v; want ErrCorruptSnapshot", err)
+	}
+
+	// An unknown remote Digest gets every node, readable by any version.
+	buf.Reset()
+	if err = b.WriteReplica(&buf, bd); err != nil {
+		t.Fatalf("b.WriteReplica() of no history failed: %s", err)
+	}
+	if nb, err = (hamt32.Hamt{}).ReadReplica(&buf); err != nil || !nb.Equal(b, nil) {
+		t.Fatalf("Hamt{}.ReadReplica() of a full replica => %v; want b", err)
+	}
+
+	// And the empty version too.
+	buf.Reset()
+	if err = (hamt32.Hamt{}).WriteReplica(&buf, bd, b); err != nil {
+		t.Fatalf("Hamt{}.WriteReplica() failed: %s", err)
+	}
+	if nb, err = b.ReadReplica(&buf); err != nil || !nb.IsEmpty() {
+		t.Fatalf("b.ReadReplica() of an empty replica => %s, %v", nb, err)
+	}
+}
+
+func TestNilKey32(t *testing.T) {
+	var h = hamt32.Hamt{}.PutMany(KVS[:10])
+	var ops = map[string]func(){
+		"Get":         func() { h.Get(nil) },
+		"Put":         func() { h.Put(nil, 1) },
+		"Del":         func() { h.Del(nil) },
+		"Update":      func() { h.Update(nil, nil) },
+		"Move":        func() { h.Move(nil, KVS[0].Key) },
+		"MoveOver":    func() { h.MoveOver(KVS[0].Key, nil) },
+		"Builder.Put": func() { h.Builder().Put(nil, 1) },
+		"MergeSorted": func() { h.PutMany([]key.KeyVal{{Key: nil, Val: 1}}) },
+	}
+	for op, fn := range ops {
+		func() {
+			defer func() {
+				var err, _ = recover().(error)
+				var kerr *hamt32.KeyError
+				if !errors.As(err, &kerr) || kerr.Op != op || !errors.Is(err, hamt32.ErrNilKey) {
+					t.Errorf("%s of a nil key panicked with %v; want a *KeyError wrapping ErrNilKey", op, err)
+				}
+			}()
+			fn()
+		}()
+	}
+
+	var nilKey = []key.KeyVal{{Key: nil, Val: 1}}
+	var _, err = hamt32.FromSortedEntries(hamt32.SliceEntries(nilKey))
+	if !errors.Is(err, hamt32.ErrNilKey) {
+		t.Fatalf("FromSortedEntries() of a nil key => %v; want ErrNilKey", err)
+	}
+
+	// A snapshot of one entry under a chain of tables deeper than MaxDepth.
+	var data, _ = hamt32.Hamt{}.MarshalBinary()
+	data[len(data)-1] = 1
+	for d := uint(0); d <= hamt32.MaxDepth+1; d++ {
+		data = append(data, 1, 1) // a compressedTable with an entry at index 0
+	}
+	_, err = hamt32.Decode(bytes.NewReader(data))
+	if !errors.Is(err, hamt32.ErrCorruptSnapshot) || !errors.Is(err, hamt32.ErrDepthExhausted) {
+		t.Fatalf("Decode() of a snapshot too deep => %v; want ErrCorruptSnapshot and ErrDepthExhausted", err)
+	}
+}
+
 func BenchmarkHamt32Get(b *testing.B) {
 	var name = fmt.Sprintf("BenchmarkHamt32Get#%d", b.N)
 	log.Printf("BenchmarkHamt32Get: b.N=%d", b.N)
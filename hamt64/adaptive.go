@@ -0,0 +1,129 @@
+package hamt64
+
+import (
+	"sync/atomic"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// adaptivePolicy is the WithAdaptiveTables setting of a Hamt lineage, and
+// the counts of the reads and writes of the lineage so far.
+type adaptivePolicy struct {
+	threshold uint
+	depth     uint
+	reads     atomic.Uint64
+	writes    atomic.Uint64
+}
+
+// WithAdaptiveTables lets a Hamt lineage pick its own table types as it
+// grows and is used, rather than relying only on the table policy. Once a
+// Hamt holds threshold or more entries, and its lineage has been read by Get
+// at least as often as it has been written, the compressedTables of the top
+// depth levels are promoted to fullTables: by Put and Del along the hash
+// path of their key, and by Builder.Freeze, MergeSorted, PutMany,
+// FromSortedEntries, and Merge across the whole top levels. Those tables are
+// visited by every operation, so they are the ones that benefit most from
+// the fullTable's O(1) indexing; a lineage that is mostly written keeps its
+// compressedTables, which are cheaper to copy.
+//
+// The lineage is every Hamt derived from the Hamt returned by New(); they
+// share the counts of reads and writes. A threshold of
+// TableCapacity*TableCapacity and a depth of 2 (ie. the root table and the
+// depth 1 tables) suit most Hamts.
+func WithAdaptiveTables(threshold, depth uint) Option {
+	return func(cfg *config) {
+		cfg.adaptive = &adaptivePolicy{threshold: threshold, depth: depth}
+	}
+}
+
+// noteRead counts a read of the lineage of h, for WithAdaptiveTables.
+func (h Hamt) noteRead() {
+	if h.cfg != nil && h.cfg.adaptive != nil {
+		h.cfg.adaptive.reads.Add(1)
+	}
+}
+
+// promoting counts n writes of the lineage of nh, and returns the
+// adaptivePolicy of nh if its tables are now to be promoted, or nil.
+func (nh *Hamt) promoting(n int) *adaptivePolicy {
+	if nh.cfg == nil || nh.cfg.adaptive == nil {
+		return nil
+	}
+	var p = nh.cfg.adaptive
+	var writes = p.writes.Add(uint64(n))
+	if p.depth == 0 || nh.nentries < p.threshold || nh.root == nil || p.reads.Load() < writes {
+		return nil
+	}
+	return p
+}
+
+// adapt() is ONLY called on a fresh copy of the current Hamt, after persist().
+// It counts the Put or Del of a key with the hash path h60, and promotes the
+// tables along that path per WithAdaptiveTables.
+func (nh *Hamt) adapt(h60 key.HashVal60) {
+	if p := nh.promoting(1); p != nil {
+		nh.root = promoteTables(nh.root, h60, 0, p.depth)
+	}
+}
+
+// adaptAll is adapt for a bulk operation of n writes. It promotes every
+// table of the top levels, rather than those along one hash path.
+func (nh *Hamt) adaptAll(n int) {
+	if p := nh.promoting(n); p != nil {
+		nh.root = promoteAllTables(nh.root, 0, p.depth)
+	}
+}
+
+// promoteTables returns t, or a copy of t, where t and the tables below it
+// along the hash path h60, above maxDepth, are fullTables.
+func promoteTables(t tableI, h60 key.HashVal60, depth, maxDepth uint) tableI {
+	if ct, isCompressed := t.(*compressedTable); isCompressed {
+		t = upgradeToFullTable(ct.hashPath, ct.entries())
+	}
+
+	if depth+1 >= maxDepth {
+		return t
+	}
+
+	var idx = h60.Index(depth)
+	if child, isTable := t.get(idx).(tableI); isTable {
+		var newChild = promoteTables(child, h60, depth+1, maxDepth)
+		if newChild != child {
+			t = t.replace(idx, newChild)
+		}
+	}
+
+	return t
+}
+
+// promoteAllTables returns t, or a copy of t, where t and every table below
+// it, above maxDepth, are fullTables. t is copied at most once.
+func promoteAllTables(t tableI, depth, maxDepth uint) tableI {
+	var fresh bool
+	if ct, isCompressed := t.(*compressedTable); isCompressed {
+		t = upgradeToFullTable(ct.hashPath, ct.entries())
+		fresh = true
+	}
+
+	if depth+1 >= maxDepth {
+		return t
+	}
+
+	for _, ent := range t.entries() {
+		var child, isTable = ent.node.(tableI)
+		if !isTable {
+			continue
+		}
+		var newChild = promoteAllTables(child, depth+1, maxDepth)
+		switch {
+		case newChild == child:
+		case fresh:
+			setInPlace(t, ent.idx, newChild)
+		default:
+			t = t.replace(ent.idx, newChild)
+			fresh = true
+		}
+	}
+
+	return t
+}
@@ -0,0 +1,205 @@
+package hamt64
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// AssertLevel selects how much checking a Hamt does of its own invariants
+// after every Put and Del. A failed check panics with a description of the
+// broken invariant.
+type AssertLevel uint8
+
+const (
+	// AssertOff does no checking; this is the default.
+	AssertOff AssertLevel = iota
+
+	// AssertCheap checks the tables along the hash path of the modified key.
+	AssertCheap
+
+	// AssertExpensive re-validates every table and leaf of the new Hamt with
+	// Validate(). This is O(n) per modification; it is meant for soaking a
+	// staging deployment, not for production.
+	AssertExpensive
+)
+
+var assertLevelStr = []string{"AssertOff", "AssertCheap", "AssertExpensive"}
+
+func (lvl AssertLevel) String() string {
+	if int(lvl) < len(assertLevelStr) {
+		return assertLevelStr[lvl]
+	}
+	return fmt.Sprintf("AssertLevel(%d)", uint8(lvl))
+}
+
+// WithAssertLevel returns the same Hamt set to check its invariants at the
+// given AssertLevel. The level is carried by every Hamt derived from it. It
+// is the WithAssertLevel Option of New() for an existing Hamt, eg. the zero
+// Hamt.
+func (h Hamt) WithAssertLevel(lvl AssertLevel) Hamt {
+	h.assert = lvl
+	return h
+}
+
+// AssertLevel returns the AssertLevel of the Hamt.
+func (h Hamt) AssertLevel() AssertLevel {
+	return h.assert
+}
+
+// check() is called on the new Hamt after every modification.
+func (nh *Hamt) check(op string, k key.Key) {
+	var err error
+	switch nh.assert {
+	case AssertOff:
+		return
+	case AssertCheap:
+		err = nh.validatePath(k.Hash60())
+	case AssertExpensive:
+		err = nh.Validate()
+	}
+	if err != nil {
+		log.Panicf("%s(%s): %s", op, k, err)
+	}
+}
+
+// Validate checks every table and leaf of the Hamt against the invariants
+// of the datastructure. It returns nil if the Hamt is valid, otherwise an
+// error wrapping ErrInvariant.
+func (h Hamt) Validate() error {
+	if h.IsEmpty() {
+		if h.nentries != 0 {
+			return fmt.Errorf("%w: empty Hamt has nentries=%d", ErrInvariant, h.nentries)
+		}
+		return nil
+	}
+
+	var n, err = validateTree(h.root, 0)
+	if err != nil {
+		return err
+	}
+	if n != h.nentries {
+		return fmt.Errorf("%w: Hamt has %d key/val pairs; nentries=%d", ErrInvariant, n, h.nentries)
+	}
+
+	return nil
+}
+
+// validatePath validates, non-recursively, the tables along the hash path
+// h60.
+func (h Hamt) validatePath(h60 key.HashVal60) error {
+	var curTable = h.root
+	for depth := uint(0); curTable != nil; depth++ {
+		if err := validateTable(curTable, depth); err != nil {
+			return err
+		}
+		curTable, _ = curTable.get(h60.Index(depth)).(tableI)
+	}
+	return nil
+}
+
+// validateTree validates t and everything below it. It returns the number
+// of key/val pairs found.
+func validateTree(t tableI, depth uint) (uint, error) {
+	if err := validateTable(t, depth); err != nil {
+		return 0, err
+	}
+
+	var n uint
+	for _, ent := range t.entries() {
+		switch x := ent.node.(type) {
+		case tableI:
+			var m, err = validateTree(x, depth+1)
+			if err != nil {
+				return 0, err
+			}
+			n += m
+		case *collisionLeaf:
+			var kvs = x.keyVals()
+			for i, kv := range kvs {
+				if kv.Key.Hash60() != x.Hash60() {
+					return 0, fmt.Errorf("%w: %s in %s does not collide", ErrInvariant, kv.Key, x)
+				}
+				if KeyVals(kvs[:i]).contains(kv.Key) {
+					return 0, fmt.Errorf("%w: %s is in %s more than once", ErrInvariant, kv.Key, x)
+				}
+			}
+			for ci := 1; ci < len(x.chunks); ci++ {
+				if len(x.chunks[ci-1])+len(x.chunks[ci]) <= collisionChunkSize {
+					return 0, fmt.Errorf("%w: chunks %d and %d of %s fit in one", ErrInvariant, ci-1, ci, x)
+				}
+			}
+			n += uint(x.nkeyvals())
+		case leafI:
+			n += uint(len(x.keyVals()))
+		}
+	}
+
+	if n != t.nkeyvals() {
+		return 0, fmt.Errorf("%w: %s counts %d key/val pairs; found %d", ErrInvariant, t, t.nkeyvals(), n)
+	}
+
+	return n, nil
+}
+
+// validateTable checks the invariants of a single table at depth.
+func validateTable(t tableI, depth uint) error {
+	if depth > MaxDepth {
+		return fmt.Errorf("%w: %w: %s is deeper than MaxDepth,%d", ErrInvariant, ErrDepthExhausted, t, MaxDepth)
+	}
+
+	var pathMask key.HashVal60
+	if depth > 0 {
+		pathMask = key.HashPathMask60(depth - 1)
+	}
+	if t.Hash60()&^pathMask != 0 {
+		return fmt.Errorf("%w: %s found at depth %d", ErrInvariant, t, depth)
+	}
+
+	switch x := t.(type) {
+	case *compressedTable:
+		if bitCount64(x.nodeMap) != uint(len(x.nodes)) {
+			return fmt.Errorf("%w: %s nodeMap=%s does not match len(nodes)=%d", ErrInvariant, x, nodeMapString(x.nodeMap), len(x.nodes))
+		}
+	case *fullTable:
+		var n uint
+		for _, node := range x.nodes {
+			if node != nil {
+				n++
+			}
+		}
+		if n != x.numEnts {
+			return fmt.Errorf("%w: %s has %d entries", ErrInvariant, x, n)
+		}
+	}
+
+	if t.nentries() == 0 {
+		return fmt.Errorf("%w: %s is empty", ErrInvariant, t)
+	}
+
+	for _, ent := range t.entries() {
+		if ent.node == nil {
+			return fmt.Errorf("%w: %s has a nil entry at %d", ErrInvariant, t, ent.idx)
+		}
+		var h60 = ent.node.Hash60()
+		if h60.Index(depth) != ent.idx {
+			return fmt.Errorf("%w: %s found at index %d of %s", ErrInvariant, ent.node, ent.idx, t)
+		}
+		if depth > 0 && h60&key.HashPathMask60(depth-1) != t.Hash60()&key.HashPathMask60(depth-1) {
+			return fmt.Errorf("%w: %s is not on the hash path of %s", ErrInvariant, ent.node, t)
+		}
+	}
+
+	return nil
+}
+
+// checkAll() is called on the new Hamt after a modification of many keys.
+func (nh *Hamt) checkAll(op string) {
+	if nh.assert == AssertOff {
+		return
+	}
+	if err := nh.Validate(); err != nil {
+		log.Panicf("%s: %s", op, err)
+	}
+}
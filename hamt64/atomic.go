@@ -0,0 +1,121 @@
+package hamt64
+
+import "sync/atomic"
+
+// AtomicHamt holds the current version of a Hamt, to be shared by
+// concurrent readers and writers. Since a Hamt is immutable, a Load returns
+// a version that no writer can change; writers race only to replace the
+// current version, by Store, CompareAndSwap, Update or UpdateIfVersion.
+// The zero AtomicHamt holds the zero Hamt. An AtomicHamt must not be copied
+// after first use.
+//
+// Load, LoadVersion, Store, CompareAndSwap, Update and UpdateIfVersion are
+// atomic, and sequentially consistent, as the operations of sync/atomic. So
+// every Hamt Loaded after a Store is fully visible to the loading goroutine.
+type AtomicHamt struct {
+	p atomic.Pointer[published]
+}
+
+// published is a Hamt made current in an AtomicHamt, with its stamp.
+type published struct {
+	h     Hamt
+	stamp uint64
+}
+
+// next returns the Hamt h published after p.
+func (p *published) next(h Hamt) *published {
+	var stamp uint64
+	if p != nil {
+		stamp = p.stamp
+	}
+	return &published{h, stamp + 1}
+}
+
+// Version is a handle on one Hamt made current in an AtomicHamt, as
+// returned by LoadVersion. Versions are equal only if they are of the same
+// Store, CompareAndSwap or Update, however equal their Hamts. The zero
+// Version is of the zero AtomicHamt.
+type Version struct {
+	p *published
+}
+
+// Stamp returns the version stamp of v. The stamp of the zero AtomicHamt is
+// 0, and every Hamt made current gets the stamp of the one it replaced plus
+// one; so the Hamt given to NewAtomicHamt has stamp 1. Unlike a Version, a
+// stamp can be kept outside the process, eg. as an HTTP ETag, and given
+// back to UpdateIfVersion.
+func (v Version) Stamp() uint64 {
+	if v.p == nil {
+		return 0
+	}
+	return v.p.stamp
+}
+
+// NewAtomicHamt returns a new AtomicHamt holding h.
+func NewAtomicHamt(h Hamt) *AtomicHamt {
+	var a = new(AtomicHamt)
+	a.Store(h)
+	return a
+}
+
+// Load returns the current Hamt.
+func (a *AtomicHamt) Load() Hamt {
+	if p := a.p.Load(); p != nil {
+		return p.h
+	}
+	return Hamt{}
+}
+
+// LoadVersion returns the current Hamt, and its Version for CompareAndSwap.
+func (a *AtomicHamt) LoadVersion() (Hamt, Version) {
+	if p := a.p.Load(); p != nil {
+		return p.h, Version{p}
+	}
+	return Hamt{}, Version{}
+}
+
+// Store makes h the current Hamt.
+func (a *AtomicHamt) Store(h Hamt) {
+	for {
+		var p = a.p.Load()
+		if a.p.CompareAndSwap(p, p.next(h)) {
+			return
+		}
+	}
+}
+
+// CompareAndSwap makes nh the current Hamt if the current Hamt is still the
+// one of old, and returns whether it did.
+func (a *AtomicHamt) CompareAndSwap(old Version, nh Hamt) bool {
+	return a.p.CompareAndSwap(old.p, old.p.next(nh))
+}
+
+// Update makes fn(h) of the current Hamt h the current Hamt, and returns it.
+// If another goroutine replaces the current Hamt while fn runs, fn is called
+// again with the new current Hamt; so fn may be called more than once and
+// must not have side effects.
+func (a *AtomicHamt) Update(fn func(h Hamt) Hamt) Hamt {
+	for {
+		var h, ver = a.LoadVersion()
+		var nh = fn(h)
+		if a.CompareAndSwap(ver, nh) {
+			return nh
+		}
+	}
+}
+
+// UpdateIfVersion makes fn(h) of the current Hamt h the current Hamt, if h
+// still has the given stamp, and returns it with its stamp. Otherwise fn is
+// not called, and the current Hamt is returned, with its stamp and
+// ErrStaleVersion; as is when another goroutine replaces h while fn runs.
+func (a *AtomicHamt) UpdateIfVersion(stamp uint64, fn func(h Hamt) Hamt) (Hamt, uint64, error) {
+	var h, ver = a.LoadVersion()
+	if ver.Stamp() == stamp {
+		var nh = fn(h)
+		if a.CompareAndSwap(ver, nh) {
+			return nh, stamp + 1, nil
+		}
+		h, ver = a.LoadVersion()
+	}
+	return h, ver.Stamp(), ErrStaleVersion
+}
@@ -0,0 +1,108 @@
+package hamt64
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// ValueKeyFunc extracts the key under which a value is indexed by a BiMap.
+type ValueKeyFunc func(v interface{}) key.Key
+
+// BiMap is a functional Hamt that also maps every value, via a ValueKeyFunc,
+// back to its key. The mapping is one-to-one: no two keys of a BiMap have
+// values with the same value key. Both directions are Hamts and both are
+// updated with every Put and Del; so each version of a BiMap is consistent.
+type BiMap struct {
+	h   Hamt
+	rev Hamt // value key -> key
+	fn  ValueKeyFunc
+}
+
+// NewBiMap returns an empty BiMap that indexes values by fn.
+func NewBiMap(fn ValueKeyFunc) BiMap {
+	return BiMap{fn: fn}
+}
+
+// IsEmpty returns true if the BiMap has no entries.
+func (m BiMap) IsEmpty() bool {
+	return m.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the BiMap.
+func (m BiMap) Nentries() uint {
+	return m.h.Nentries()
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found.
+func (m BiMap) Get(k key.Key) (interface{}, bool) {
+	return m.h.Get(k)
+}
+
+// GetKey retrieves the key whose value has the value key vk. The bool
+// represents whether such a key was found.
+func (m BiMap) GetKey(vk key.Key) (key.Key, bool) {
+	var k, found = m.rev.get(vk)
+	if !found {
+		return nil, false
+	}
+	return k.(key.Key), true
+}
+
+// Put inserts a key/val pair, returning a new BiMap and a bool indicating
+// if the key/val pair was added(true) or merely updated(false). If another
+// key already has a value with the same value key, that key is deleted; so
+// the BiMap stays one-to-one.
+func (m BiMap) Put(k key.Key, v interface{}) (BiMap, bool) {
+	var nm = m
+	var vk = m.fn(v)
+
+	var old, found = m.h.Get(k)
+	if found {
+		nm.rev, _, _ = nm.rev.del(m.fn(old))
+	}
+
+	if owner, taken := nm.rev.get(vk); taken {
+		var ok = owner.(key.Key)
+		if !m.h.normalizeKey(ok).Equals(m.h.normalizeKey(k)) {
+			nm.h, _, _ = nm.h.Del(ok)
+		}
+	}
+
+	nm.h, _ = nm.h.Put(k, v)
+	nm.rev, _ = nm.rev.put(vk, k)
+
+	return nm, !found
+}
+
+// Del removes a key, and its value key, returning a new BiMap, the key's
+// value and a bool indicating whether the key was found (and therefor
+// deleted).
+func (m BiMap) Del(k key.Key) (BiMap, interface{}, bool) {
+	var nh, old, deleted = m.h.Del(k)
+	if !deleted {
+		return m, nil, false
+	}
+
+	var nm = m
+	nm.h = nh
+	nm.rev, _, _ = nm.rev.del(m.fn(old))
+
+	return nm, old, true
+}
+
+// DelValue removes the key whose value has the value key vk, returning a
+// new BiMap, the removed key and a bool indicating whether it was found.
+func (m BiMap) DelValue(vk key.Key) (BiMap, key.Key, bool) {
+	var k, found = m.GetKey(vk)
+	if !found {
+		return m, nil, false
+	}
+	var nm, _, _ = m.Del(k)
+	return nm, k, true
+}
+
+func (m BiMap) String() string {
+	return fmt.Sprintf("BiMap{ h: %s }", m.h)
+}
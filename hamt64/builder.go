@@ -0,0 +1,302 @@
+package hamt64
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Builder is a transient, mutable, version of a Hamt; like Clojure's
+// transients. The first time a Put or Del passes through a table of the
+// original Hamt, that table is copied and the copy is owned by the Builder;
+// from then on the Builder modifies its own tables in place. So loading many
+// entries does not create an intermediate Hamt, and path copy, per entry.
+//
+// Freeze returns the immutable Hamt built so far. A Builder is not safe for
+// concurrent use.
+type Builder struct {
+	h       Hamt
+	cfg     config
+	owned   map[tableI]bool
+	nwrites int // Puts and Dels since the last Freeze; see WithAdaptiveTables
+}
+
+// builderStep is one table on the path to a key, and the index of the next
+// node of the path in that table.
+type builderStep struct {
+	t   tableI
+	idx uint
+}
+
+// Builder returns a new Builder starting from h; h itself is not changed.
+func (h Hamt) Builder() *Builder {
+	return &Builder{h: h, cfg: h.conf(), owned: make(map[tableI]bool)}
+}
+
+// IsEmpty returns true if the Builder has no entries.
+func (b *Builder) IsEmpty() bool {
+	return b.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the Builder.
+func (b *Builder) Nentries() uint {
+	return b.h.Nentries()
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found.
+func (b *Builder) Get(k key.Key) (interface{}, bool) {
+	return b.h.Get(k)
+}
+
+// Freeze returns the immutable Hamt of the Builder's current entries. The
+// Builder gives up ownership of its tables; so it can keep being used
+// without affecting the returned Hamt.
+func (b *Builder) Freeze() Hamt {
+	b.owned = make(map[tableI]bool)
+	var h = b.h
+	h.adaptAll(b.nwrites)
+	b.nwrites = 0
+	h.seal()
+	h.checkAll("Freeze")
+	return h
+}
+
+// Put inserts a key/val pair into the Builder. It returns true if the pair
+// was added, or false if the key's value was merely updated.
+func (b *Builder) Put(k key.Key, v interface{}) bool {
+	mustKey("Builder.Put", k)
+	return b.put(k, b.cfg.storeVal(v))
+}
+
+// put is Put without the Interner and Compressor; v is stored exactly as
+// given.
+func (b *Builder) put(k key.Key, v interface{}) bool {
+	k = b.h.hashKey(k)
+	b.nwrites++
+
+	if b.h.IsEmpty() {
+		b.h.root = createRootTable(b.cfg, newFlatLeaf(k, v))
+		b.owned[b.h.root] = true
+		b.h.nentries = 1
+		b.h.nbytes = b.cfg.entrySize(k, v)
+		return true
+	}
+
+	var path, t = b.ownPath(k)
+	var depth = uint(len(path))
+	var idx = k.Hash60().Index(depth)
+
+	var added bool
+	switch n := t.get(idx).(type) {
+	case nil:
+		b.propagate(path, t, b.insertInPlace(t, idx, newFlatLeaf(k, v)))
+		added = true
+	case leafI:
+		if n.Hash60() == k.Hash60() {
+			var newLeaf leafI
+			var old, found = n.get(k)
+			newLeaf, added = n.put(k, v)
+			if found {
+				b.h.nbytes -= b.cfg.entrySize(k, old)
+			}
+			setInPlace(t, idx, newLeaf)
+		} else {
+			setInPlace(t, idx, createTable(b.cfg, depth+1, n, *newFlatLeaf(k, v)))
+			added = true
+		}
+	}
+
+	if added {
+		b.h.nentries++
+		addKeyValsInPlace(path, 1)
+	}
+	b.h.nbytes += b.cfg.entrySize(k, v)
+
+	return added
+}
+
+// Del removes a key from the Builder, returning the key's value and a bool
+// indicating whether the key was found (and therefor deleted).
+func (b *Builder) Del(k key.Key) (interface{}, bool) {
+	mustKey("Builder.Del", k)
+	k = b.h.hashKey(k)
+
+	if _, found := b.h.get(k); !found {
+		return nil, false
+	}
+
+	var path, t = b.ownPath(k)
+	var idx = k.Hash60().Index(uint(len(path)))
+
+	// The ancestors of t are counted down first; propagate may remove or
+	// downgrade them.
+	addKeyValsInPlace(path, -1)
+
+	var newLeaf, val, _ = t.get(idx).(leafI).del(k)
+	if newLeaf == nil {
+		b.propagate(path, t, b.removeInPlace(t, idx))
+	} else {
+		setInPlace(t, idx, newLeaf)
+	}
+
+	b.h.nentries--
+	b.h.nbytes -= b.cfg.entrySize(k, val)
+	b.nwrites++
+
+	return decompressVal(val), true
+}
+
+// ownPath makes sure every table on k's hash path, down to the last table,
+// is owned by the Builder. It returns the owned tables above the last table,
+// with the index of their child, and the last table.
+func (b *Builder) ownPath(k key.Key) ([]builderStep, tableI) {
+	var h60 = k.Hash60()
+
+	b.h.root = b.own(b.h.root)
+	var t = b.h.root
+
+	var path []builderStep
+	for depth := uint(0); ; depth++ {
+		var idx = h60.Index(depth)
+		var child, isTable = t.get(idx).(tableI)
+		if !isTable {
+			return path, t
+		}
+
+		var owned = b.own(child)
+		if owned != child {
+			setInPlace(t, idx, owned)
+		}
+
+		path = append(path, builderStep{t, idx})
+		t = owned
+	}
+}
+
+// own returns t if the Builder owns it, otherwise a copy of t that it owns.
+func (b *Builder) own(t tableI) tableI {
+	if b.owned[t] {
+		return t
+	}
+
+	switch x := t.(type) {
+	case *compressedTable:
+		t = x.copy()
+	case *fullTable:
+		t = x.copy()
+	}
+	b.owned[t] = true
+
+	return t
+}
+
+// propagate replaces the owned table t, at the end of path, with nt; nt may
+// be nil, in which case t is removed from its parent.
+func (b *Builder) propagate(path []builderStep, t, nt tableI) {
+	for i := len(path) - 1; i >= 0; i-- {
+		if nt == t {
+			return
+		}
+		var parent = path[i].t
+		if nt != nil {
+			setInPlace(parent, path[i].idx, nt)
+			return
+		}
+		t, nt = parent, b.removeInPlace(parent, path[i].idx)
+	}
+	if nt != t {
+		b.h.root = nt
+	}
+}
+
+// addKeyValsInPlace adds n to the count of key/val pairs of every owned
+// table of path.
+func addKeyValsInPlace(path []builderStep, n int) {
+	for _, step := range path {
+		switch x := step.t.(type) {
+		case *compressedTable:
+			x.numKeyVals = uint(int(x.numKeyVals) + n)
+		case *fullTable:
+			x.numKeyVals = uint(int(x.numKeyVals) + n)
+		}
+	}
+}
+
+// setInPlace sets the existing entry idx of the owned table t to node.
+func setInPlace(t tableI, idx uint, node nodeI) {
+	switch x := t.(type) {
+	case *compressedTable:
+		var i = bitCount64(x.nodeMap & (uint64(1<<idx) - 1))
+		x.numKeyVals = x.numKeyVals - nodeKeyVals(x.nodes[i]) + nodeKeyVals(node)
+		x.nodes[i] = node
+	case *fullTable:
+		x.numKeyVals = x.numKeyVals - nodeKeyVals(x.nodes[idx]) + nodeKeyVals(node)
+		x.nodes[idx] = node
+	}
+}
+
+// insertInPlace adds node as the entry idx of the owned table t. It returns
+// t, or the new table t was upgraded to.
+func (b *Builder) insertInPlace(t tableI, idx uint, node nodeI) tableI {
+	switch x := t.(type) {
+	case *compressedTable:
+		var nodeBit = uint64(1 << idx)
+		var i = bitCount64(x.nodeMap & (nodeBit - 1))
+		x.nodes = append(x.nodes, nil)
+		copy(x.nodes[i+1:], x.nodes[i:])
+		x.nodes[i] = node
+		x.nodeMap |= nodeBit
+		x.numKeyVals += nodeKeyVals(node)
+
+		if b.cfg.gradeTables && uint(len(x.nodes)) >= b.cfg.upgradeThreshold {
+			b.cfg.noteGraded(true)
+			var nt = upgradeToFullTable(x.hashPath, x.entries())
+			b.owned[nt] = true
+			return nt
+		}
+	case *fullTable:
+		x.nodes[idx] = node
+		x.numEnts++
+		x.numKeyVals += nodeKeyVals(node)
+	}
+	return t
+}
+
+// removeInPlace removes the entry idx of the owned table t. It returns t,
+// the new table t was downgraded to, or nil if t is now empty.
+func (b *Builder) removeInPlace(t tableI, idx uint) tableI {
+	switch x := t.(type) {
+	case *compressedTable:
+		var nodeBit = uint64(1 << idx)
+		var i = bitCount64(x.nodeMap & (nodeBit - 1))
+		x.numKeyVals -= nodeKeyVals(x.nodes[i])
+		copy(x.nodes[i:], x.nodes[i+1:])
+		x.nodes[len(x.nodes)-1] = nil
+		x.nodes = x.nodes[:len(x.nodes)-1]
+		x.nodeMap &^= nodeBit
+
+		if x.nodeMap == 0 {
+			return nil
+		}
+	case *fullTable:
+		x.numKeyVals -= nodeKeyVals(x.nodes[idx])
+		x.nodes[idx] = nil
+		x.numEnts--
+
+		if x.numEnts == 0 {
+			return nil
+		}
+		if b.cfg.gradeTables && x.numEnts < b.cfg.downgradeThreshold {
+			b.cfg.noteGraded(false)
+			var nt = downgradeToCompressedTable(x.hashPath, x.entries())
+			b.owned[nt] = true
+			return nt
+		}
+	}
+	return t
+}
+
+func (b *Builder) String() string {
+	return fmt.Sprintf("Builder{ %s }", b.h)
+}
@@ -7,50 +7,94 @@ import (
 	"github.com/lleo/go-hamt-key"
 )
 
+// collisionChunkSize is the maximum number of key.KeyVal pairs in each chunk
+// of a collisionLeaf.
+const collisionChunkSize = 8
+
+// collisionLeaf stores its key.KeyVal pairs in fixed size chunks. Chunks are
+// never modified once the leaf is built; so a new leaf shares every chunk
+// with the old leaf except the one chunk it changes, or the two chunks del()
+// merges. Hence put() and del() copy at most collisionChunkSize pairs plus
+// the chunk pointers, rather than every pair of a large (eg. adversarial)
+// bucket.
 type collisionLeaf struct {
-	kvs []key.KeyVal
+	chunks [][]key.KeyVal
 }
 
 func newCollisionLeaf(kvs []key.KeyVal) *collisionLeaf {
 	leaf := new(collisionLeaf)
-	leaf.kvs = append(leaf.kvs, kvs...)
+
+	for len(kvs) > 0 {
+		var n = len(kvs)
+		if n > collisionChunkSize {
+			n = collisionChunkSize
+		}
+		var chunk = make([]key.KeyVal, n)
+		copy(chunk, kvs[:n])
+		leaf.chunks = append(leaf.chunks, chunk)
+		kvs = kvs[n:]
+	}
 
 	return leaf
 }
 
 func (l collisionLeaf) Hash60() key.HashVal60 {
-	// valid because ALL l.kvs[*].Key.Hash60() MUST be the same key.HashVal60
-	return l.kvs[0].Key.Hash60()
+	// valid because ALL l.chunks[*][*].Key.Hash60() MUST be the same key.HashVal60
+	return l.chunks[0][0].Key.Hash60()
 }
 
 func (l collisionLeaf) String() string {
-	var kvstrs = make([]string, len(l.kvs))
-	for i := 0; i < len(l.kvs); i++ {
-		kvstrs[i] = l.kvs[i].String()
+	var kvs = l.keyVals()
+	var kvstrs = make([]string, len(kvs))
+	for i := 0; i < len(kvs); i++ {
+		kvstrs[i] = kvs[i].String()
 	}
 	var jkvstr = strings.Join(kvstrs, ",")
 
 	return fmt.Sprintf("collisionLeaf{kvs:[]key.KeyVal{%s}}", jkvstr)
 }
 
-func (l collisionLeaf) get(key key.Key) (interface{}, bool) {
-	for i := 0; i < len(l.kvs); i++ {
-		if l.kvs[i].Key.Equals(key) {
-			return l.kvs[i].Val, true
+// find returns the chunk and index within that chunk of key_, or -1, -1 if
+// key_ is not in this leaf.
+func (l collisionLeaf) find(key_ key.Key) (int, int) {
+	for ci, chunk := range l.chunks {
+		for i := 0; i < len(chunk); i++ {
+			if chunk[i].Key.Equals(key_) {
+				return ci, i
+			}
 		}
 	}
-	return nil, false
+	return -1, -1
 }
 
+func (l collisionLeaf) get(key key.Key) (interface{}, bool) {
+	var ci, i = l.find(key)
+	if ci < 0 {
+		return nil, false
+	}
+	return l.chunks[ci][i].Val, true
+}
+
+// copy returns a new collisionLeaf sharing every chunk with l.
 func (l collisionLeaf) copy() *collisionLeaf {
 	var nl = new(collisionLeaf)
 
-	// keep key.KeyVal containers, only this splice is new
-	nl.kvs = append(nl.kvs, l.kvs...)
+	// keep the chunks, only this splice of chunk pointers is new
+	nl.chunks = make([][]key.KeyVal, len(l.chunks))
+	copy(nl.chunks, l.chunks)
 
 	return nl
 }
 
+// nkeyvals returns the number of key.KeyVal pairs in the leaf.
+func (l collisionLeaf) nkeyvals() int {
+	var n int
+	for _, chunk := range l.chunks {
+		n += len(chunk)
+	}
+	return n
+}
+
 // put insertes a new key,val pair into the leaf node, and returns a new leaf
 // and a bool representing if the new leaf is bigger (ie accumulated key/val pair).
 func (l collisionLeaf) put(key_ key.Key, val interface{}) (leafI, bool) {
@@ -59,17 +103,27 @@ func (l collisionLeaf) put(key_ key.Key, val interface{}) (leafI, bool) {
 	// check if key_ is exact match of current key
 	// if exact match create new key.KeyVal container and update Val
 	// and return new leaf & bool
-	for i := 0; i < len(l.kvs); i++ {
-		if nl.kvs[i].Key.Equals(key_) { // Key.Equal() checks equal-by-value
+	if ci, i := l.find(key_); ci >= 0 {
+		var chunk = make([]key.KeyVal, len(l.chunks[ci]))
+		copy(chunk, l.chunks[ci])
 
-			// new key.KeyVal container, and keep the old l.kvs[i].Key object.
-			nl.kvs[i] = key.KeyVal{l.kvs[i].Key, val}
+		// new key.KeyVal container, and keep the old key.Key object.
+		chunk[i] = key.KeyVal{l.chunks[ci][i].Key, val}
+		nl.chunks[ci] = chunk
 
-			return nl, false // key,val was not added, merely replaced Val
-		}
+		return nl, false // key,val was not added, merely replaced Val
+	}
+
+	var last = len(l.chunks) - 1
+	if len(l.chunks[last]) < collisionChunkSize {
+		var chunk = make([]key.KeyVal, len(l.chunks[last])+1)
+		copy(chunk, l.chunks[last])
+		chunk[len(chunk)-1] = key.KeyVal{key_, val}
+		nl.chunks[last] = chunk
+	} else {
+		nl.chunks = append(nl.chunks, []key.KeyVal{key.KeyVal{key_, val}})
 	}
 
-	nl.kvs = append(nl.kvs, key.KeyVal{key_, val})
 	return nl, true // key_,val was added
 }
 
@@ -77,37 +131,51 @@ func (l collisionLeaf) put(key_ key.Key, val interface{}) (leafI, bool) {
 // remove matching key.KeyVal container, and return a new leafI, the removed
 // value, and a bool indicating if the key_ was found&removed.
 func (l collisionLeaf) del(key_ key.Key) (leafI, interface{}, bool) {
-
-	if len(l.kvs) == 2 {
-		// exhaustive search
-		// if key_ found new leaf will be a flatLeaf.
-		if l.kvs[0].Key.Equals(key_) {
-			return newFlatLeaf(l.kvs[1].Key, l.kvs[1].Val), l.kvs[0].Val, true
-		}
-		if l.kvs[1].Key.Equals(key_) {
-			return newFlatLeaf(l.kvs[0].Key, l.kvs[0].Val), l.kvs[1].Val, true
-		}
-
+	var ci, i = l.find(key_)
+	if ci < 0 {
 		// key_ not found, hence no deletion occured
 		return nil, nil, false
 	}
 
-	var nl = l.copy()
+	var retVal = l.chunks[ci][i].Val
 
-	for i := 0; i < len(l.kvs); i++ {
-		if l.kvs[i].Key.Equals(key_) {
-			var retVal = l.kvs[i].Val
+	if l.nkeyvals() == 2 {
+		// if key_ found new leaf will be a flatLeaf.
+		var kvs = l.keyVals()
+		if kvs[0].Key.Equals(key_) {
+			return newFlatLeaf(kvs[1].Key, kvs[1].Val), retVal, true
+		}
+		return newFlatLeaf(kvs[0].Key, kvs[0].Val), retVal, true
+	}
 
-			// removing the i'th element of a slice; wiki/SliceTricks "Delete"
-			nl.kvs = append(nl.kvs[:i], nl.kvs[i+1:]...)
+	var nl = l.copy()
 
-			return nl, retVal, true
-		}
+	var chunk = make([]key.KeyVal, len(l.chunks[ci])-1)
+	copy(chunk, l.chunks[ci][:i])
+	copy(chunk[i:], l.chunks[ci][i+1:])
+
+	// Merge the shrunk chunk with a neighbour it fits in; so no two adjacent
+	// chunks fit in one, and a leaf of n pairs keeps at most
+	// 2n/collisionChunkSize+1 chunks however it was deleted from.
+	switch {
+	case ci+1 < len(l.chunks) && len(chunk)+len(l.chunks[ci+1]) <= collisionChunkSize:
+		chunk = append(chunk, l.chunks[ci+1]...)
+		// removing the ci+1'th chunk; wiki/SliceTricks "Delete"
+		nl.chunks = append(nl.chunks[:ci+1], nl.chunks[ci+2:]...)
+	case ci > 0 && len(l.chunks[ci-1])+len(chunk) <= collisionChunkSize:
+		chunk = append(append(make([]key.KeyVal, 0, len(l.chunks[ci-1])+len(chunk)), l.chunks[ci-1]...), chunk...)
+		nl.chunks = append(nl.chunks[:ci-1], nl.chunks[ci:]...)
+		ci--
 	}
+	nl.chunks[ci] = chunk
 
-	return nil, nil, false
+	return nl, retVal, true
 }
 
 func (l collisionLeaf) keyVals() []key.KeyVal {
-	return l.kvs
+	var kvs = make([]key.KeyVal, 0, l.nkeyvals())
+	for _, chunk := range l.chunks {
+		kvs = append(kvs, chunk...)
+	}
+	return kvs
 }
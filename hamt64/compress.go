@@ -0,0 +1,89 @@
+package hamt64
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"log"
+)
+
+// Compressor is the interface for a codec that Put uses to transparently
+// compress large []byte values before they are stored in a leaf; see
+// WithValueCompressor. Get and Del reverse the compression with Decompress.
+//
+// Any fast block compressor (eg. snappy) can be wrapped to satisfy this
+// interface. FlateCompressor is provided as a standard library default.
+type Compressor interface {
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// WithValueCompressor sets the Compressor that Put applies to every []byte
+// value whose length meets or exceeds threshold. By default, or if c is nil,
+// values are stored as given. Values already stored remember the Compressor
+// they were compressed with; so a Hamt decoded with another Compressor can
+// still read them.
+func WithValueCompressor(c Compressor, threshold int) Option {
+	return func(cfg *config) {
+		cfg.compressor = c
+		cfg.compressThreshold = threshold
+	}
+}
+
+// compressedVal is how a compressed value is stored in a leaf. The type of
+// the leaf's val is the "compressed" flag; so flatLeaf and collisionLeaf do
+// not need to know anything about compression.
+type compressedVal struct {
+	c    Compressor
+	data []byte
+}
+
+// compressVal returns the value to be stored in a leaf for v, per the
+// WithValueCompressor setting of cfg.
+func (cfg config) compressVal(v interface{}) interface{} {
+	if cfg.compressor == nil {
+		return v
+	}
+	if bs, ok := v.([]byte); ok && len(bs) >= cfg.compressThreshold {
+		return compressedVal{cfg.compressor, cfg.compressor.Compress(bs)}
+	}
+	return v
+}
+
+// decompressVal returns the user's value for v as stored in a leaf.
+func decompressVal(v interface{}) interface{} {
+	var cv, ok = v.(compressedVal)
+	if !ok {
+		return v
+	}
+	var bs, err = cv.c.Decompress(cv.data)
+	if err != nil {
+		log.Panicf("decompressVal: failed to Decompress %d bytes; %s", len(cv.data), err)
+	}
+	return bs
+}
+
+// FlateCompressor implements Compressor with compress/flate at the given
+// compression Level.
+type FlateCompressor struct {
+	Level int
+}
+
+// Compress is required for Compressor.
+func (fc FlateCompressor) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	var w, err = flate.NewWriter(&buf, fc.Level)
+	if err != nil {
+		log.Panicf("FlateCompressor.Compress: bad Level %d; %s", fc.Level, err)
+	}
+	w.Write(src)
+	w.Close()
+	return buf.Bytes()
+}
+
+// Decompress is required for Compressor.
+func (fc FlateCompressor) Decompress(src []byte) ([]byte, error) {
+	var r = flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
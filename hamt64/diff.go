@@ -0,0 +1,217 @@
+package hamt64
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// ChangeKind identifies the kind of a Change.
+type ChangeKind uint8
+
+const (
+	// Added is the ChangeKind of a key only in the newer Hamt.
+	Added ChangeKind = iota
+	// Removed is the ChangeKind of a key only in the older Hamt.
+	Removed
+	// Modified is the ChangeKind of a key with different values.
+	Modified
+)
+
+var changeKindStr = []string{"Added", "Removed", "Modified"}
+
+func (kind ChangeKind) String() string {
+	if int(kind) < len(changeKindStr) {
+		return changeKindStr[kind]
+	}
+	return fmt.Sprintf("ChangeKind(%d)", uint8(kind))
+}
+
+// Change describes the difference of one key between two Hamts. Old is nil
+// for an Added key; New is nil for a Removed key.
+type Change struct {
+	Kind ChangeKind
+	Key  key.Key
+	Old  interface{}
+	New  interface{}
+}
+
+// Op returns the Op that applies the Change to the older Hamt.
+func (c Change) Op() Op {
+	if c.Kind == Removed {
+		return DelOp(c.Key)
+	}
+	return PutOp(c.Key, c.New)
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("Change{%s, %s, %v, %v}", c.Kind, c.Key, c.Old, c.New)
+}
+
+// Diff returns the Changes that turn the Hamt from into the Hamt to, in hash
+// path order. Values are compared with reflect.DeepEqual.
+//
+// Tables shared by from and to are skipped without being visited; so the
+// Diff of two versions of a Hamt costs about as much as the changes between
+// them. If from and to normalize or hash their keys differently, every pair
+// of each is looked up in the other; the Modified and Removed Changes are
+// then in the hash path order of from, followed by the Added Changes in
+// that of to.
+func Diff(from, to Hamt) []Change {
+	var d differ
+	switch {
+	case from.root == to.root:
+	case !from.sameLayout(to):
+		d.diffPairs(from, to)
+	case from.IsEmpty():
+		d.all(to.root, Added)
+	case to.IsEmpty():
+		d.all(from.root, Removed)
+	default:
+		d.diffNodes(from.root, to.root, 0)
+	}
+	return d.changes
+}
+
+type differ struct {
+	changes []Change
+}
+
+// diffPairs records the Changes from the Hamt from to the Hamt to, whose
+// tries are laid out differently, pair by pair.
+func (d *differ) diffPairs(from, to Hamt) {
+	from.Range(func(k key.Key, oldVal interface{}) bool {
+		var newVal, found = to.get(k)
+		switch {
+		case !found:
+			d.changes = append(d.changes, Change{Kind: Removed, Key: k, Old: oldVal})
+		case !reflect.DeepEqual(oldVal, decompressVal(newVal)):
+			d.changes = append(d.changes, Change{Kind: Modified, Key: k, Old: oldVal, New: decompressVal(newVal)})
+		}
+		return true
+	})
+	to.Range(func(k key.Key, newVal interface{}) bool {
+		if _, found := from.get(k); !found {
+			d.changes = append(d.changes, Change{Kind: Added, Key: k, New: newVal})
+		}
+		return true
+	})
+}
+
+// all records every pair under node as a Change of kind.
+func (d *differ) all(node nodeI, kind ChangeKind) {
+	var record = func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			var c = Change{Kind: kind, Key: userKey(kv.Key)}
+			if kind == Added {
+				c.New = decompressVal(kv.Val)
+			} else {
+				c.Old = decompressVal(kv.Val)
+			}
+			d.changes = append(d.changes, c)
+		}
+		return true
+	}
+
+	walkNode(node, record)
+}
+
+// diffNodes records the Changes from node a to node b. The nodes are tables
+// at depth, or entries of tables at depth-1.
+func (d *differ) diffNodes(a, b nodeI, depth uint) {
+	if sameTable(a, b) {
+		return
+	}
+
+	if at, isTable := a.(tableI); isTable {
+		if bt, isTable := b.(tableI); isTable {
+			var aents, bents = at.entries(), bt.entries()
+			var i, j int
+			for i < len(aents) || j < len(bents) {
+				switch {
+				case j == len(bents) || (i < len(aents) && aents[i].idx < bents[j].idx):
+					d.all(aents[i].node, Removed)
+					i++
+				case i == len(aents) || bents[j].idx < aents[i].idx:
+					d.all(bents[j].node, Added)
+					j++
+				default:
+					d.diffNodes(aents[i].node, bents[j].node, depth+1)
+					i++
+					j++
+				}
+			}
+			return
+		}
+	}
+
+	// The tries have different shapes here, a leaf against a table; merge
+	// the pairs of both sides in hash path order. Both roots are tables, so
+	// depth > 0.
+	var akvs, bkvs = subtreeKeyVals(a), subtreeKeyVals(b)
+	var i, j int
+	for i < len(akvs) || j < len(bkvs) {
+		switch {
+		case j == len(bkvs) || (i < len(akvs) && hashPathLess(akvs[i].Key.Hash60(), bkvs[j].Key.Hash60())):
+			d.changes = append(d.changes, Change{Kind: Removed, Key: userKey(akvs[i].Key), Old: decompressVal(akvs[i].Val)})
+			i++
+		case i == len(akvs) || hashPathLess(bkvs[j].Key.Hash60(), akvs[i].Key.Hash60()):
+			d.changes = append(d.changes, Change{Kind: Added, Key: userKey(bkvs[j].Key), New: decompressVal(bkvs[j].Val)})
+			j++
+		default:
+			// The pairs of one hash value, ie. of a collisionLeaf on either
+			// side.
+			var h60 = akvs[i].Key.Hash60()
+			var i0, j0 = i, j
+			for i < len(akvs) && akvs[i].Key.Hash60() == h60 {
+				i++
+			}
+			for j < len(bkvs) && bkvs[j].Key.Hash60() == h60 {
+				j++
+			}
+			d.diffKeyVals(akvs[i0:i], bkvs[j0:j])
+		}
+	}
+}
+
+// diffKeyVals records the Changes from the pairs akvs to the pairs bkvs, all
+// of the same hash value.
+func (d *differ) diffKeyVals(akvs, bkvs []key.KeyVal) {
+	for _, bkv := range bkvs {
+		var ai = indexOfKey(akvs, bkv.Key)
+		if ai < 0 {
+			d.changes = append(d.changes, Change{Kind: Added, Key: userKey(bkv.Key), New: decompressVal(bkv.Val)})
+			continue
+		}
+		var oldVal, newVal = decompressVal(akvs[ai].Val), decompressVal(bkv.Val)
+		if !reflect.DeepEqual(oldVal, newVal) {
+			d.changes = append(d.changes, Change{Modified, userKey(bkv.Key), oldVal, newVal})
+		}
+	}
+	for _, akv := range akvs {
+		if indexOfKey(bkvs, akv.Key) < 0 {
+			d.changes = append(d.changes, Change{Kind: Removed, Key: userKey(akv.Key), Old: decompressVal(akv.Val)})
+		}
+	}
+}
+
+// indexOfKey returns the index of the pair of k in kvs, or -1.
+func indexOfKey(kvs []key.KeyVal, k key.Key) int {
+	for i, kv := range kvs {
+		if kv.Key.Equals(k) {
+			return i
+		}
+	}
+	return -1
+}
+
+// subtreeKeyVals returns every pair under node, in hash path order.
+func subtreeKeyVals(node nodeI) []key.KeyVal {
+	var kvs []key.KeyVal
+	walkNode(node, func(l leafI) bool {
+		kvs = append(kvs, l.keyVals()...)
+		return true
+	})
+	return kvs
+}
@@ -0,0 +1,81 @@
+package hamt64
+
+import (
+	"reflect"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Equal returns true if h and other have the same keys with equal values.
+// Values are compared with eq; if eq is nil, reflect.DeepEqual is used.
+//
+// Subtrees shared by h and other, eg. because they are versions of the same
+// Hamt, are equal without being visited; so comparing two versions costs
+// about as much as the changes between them. If h and other normalize or
+// hash their keys differently, every pair of h is looked up in other.
+func (h Hamt) Equal(other Hamt, eq func(v1, v2 interface{}) bool) bool {
+	if h.nentries != other.nentries {
+		return false
+	}
+	if h.root == other.root {
+		return true
+	}
+	if eq == nil {
+		eq = reflect.DeepEqual
+	}
+
+	// Given equal nentries, every pair of h being in other is enough.
+	if !h.sameLayout(other) {
+		var equal = true
+		h.Range(func(k key.Key, v interface{}) bool {
+			var v2, found = other.get(k)
+			equal = found && eq(v, decompressVal(v2))
+			return equal
+		})
+		return equal
+	}
+	return equalNodes(h.root, other.root, 0, eq)
+}
+
+// equalNodes returns true if every key/val pair under a is in b. The nodes
+// a and b are at depth, or are entries of tables at depth-1.
+func equalNodes(a, b nodeI, depth uint, eq func(v1, v2 interface{}) bool) bool {
+	if sameTable(a, b) {
+		return true
+	}
+
+	if at, isTable := a.(tableI); isTable {
+		if bt, isTable := b.(tableI); isTable {
+			for _, ent := range at.entries() {
+				var bn = bt.get(ent.idx)
+				if bn == nil || !equalNodes(ent.node, bn, depth+1, eq) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	// The tries have different shapes here; compare pair by pair. Both roots
+	// are tables, so depth > 0.
+	var equal = true
+	var inB = func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			var v, found = lookupNode(b, depth-1, kv.Key)
+			if !found || !eq(decompressVal(kv.Val), decompressVal(v)) {
+				equal = false
+				return false
+			}
+		}
+		return true
+	}
+
+	switch x := a.(type) {
+	case tableI:
+		walkTable(x, inB)
+	case leafI:
+		inB(x)
+	}
+
+	return equal
+}
@@ -0,0 +1,87 @@
+package hamt64
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// The error values returned, or wrapped, by every error returning function
+// of this package. Callers should test for them with errors.Is().
+var (
+	// ErrNilKey is returned when a nil key.Key is given. The methods that do
+	// not return an error, eg. Get, Put and Del, panic with a *KeyError
+	// wrapping it.
+	ErrNilKey = errors.New("hamt64: nil key")
+
+	// ErrKeyNotFound is returned, or wrapped, by the error returning APIs
+	// built on a Hamt, eg. the Map of package v2, when a key is not in it.
+	// The methods of a Hamt itself return a false "found" bool instead.
+	ErrKeyNotFound = errors.New("hamt64: key not found")
+
+	// ErrCorruptSnapshot is returned when a serialized Hamt can not be
+	// decoded into a valid Hamt.
+	ErrCorruptSnapshot = errors.New("hamt64: corrupt snapshot")
+
+	// ErrStoreUnavailable is returned, or wrapped, when the store a Hamt is
+	// persisted to, eg. a hamtcbor.BlockStore, can not be read from or
+	// written to.
+	ErrStoreUnavailable = errors.New("hamt64: store unavailable")
+
+	// ErrDepthExhausted is wrapped, with ErrCorruptSnapshot or ErrInvariant,
+	// when a table is found deeper than MaxDepth.
+	ErrDepthExhausted = errors.New("hamt64: depth exhausted")
+
+	// ErrBadPathFilter is returned when a PathFilter pattern can not be
+	// parsed.
+	ErrBadPathFilter = errors.New("hamt64: bad path filter")
+
+	// ErrInvariant is returned when Validate finds a broken invariant of the
+	// datastructure.
+	ErrInvariant = errors.New("hamt64: invariant violated")
+
+	// ErrUnsortedEntries is returned when entries that must be sorted by
+	// hash path are not.
+	ErrUnsortedEntries = errors.New("hamt64: entries not sorted by hash path")
+
+	// ErrBadCursor is returned when a Cursor can not be decoded.
+	ErrBadCursor = errors.New("hamt64: bad cursor")
+
+	// ErrTxnConflict is returned by Txn.Commit when a key the Txn read or
+	// wrote was changed in the AtomicHamt since the Txn began.
+	ErrTxnConflict = errors.New("hamt64: transaction conflict")
+
+	// ErrStaleVersion is returned by AtomicHamt.UpdateIfVersion when the
+	// stamp given is not of the current Hamt.
+	ErrStaleVersion = errors.New("hamt64: stale version")
+
+	// ErrReplicaBase is returned by ReadReplica when the replica is based on
+	// another version than the one reading it.
+	ErrReplicaBase = errors.New("hamt64: replica of another base")
+)
+
+// KeyError records the operation and key that caused an error. Use
+// errors.As() to retrieve it, and errors.Is() to test the underlying Err.
+type KeyError struct {
+	Op  string
+	Key key.Key
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return fmt.Sprintf("%s(%v): %s", e.Op, e.Key, e.Err)
+}
+
+// Unwrap returns the underlying error value.
+func (e *KeyError) Unwrap() error {
+	return e.Err
+}
+
+// mustKey panics with a *KeyError wrapping ErrNilKey if k is nil; op is the
+// method k was given to.
+func mustKey(op string, k key.Key) {
+	if k == nil {
+		panic(&KeyError{op, nil, ErrNilKey})
+	}
+}
@@ -0,0 +1,81 @@
+package hamt64
+
+import (
+	"unsafe"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// SizeInBytes returns an estimate of the memory retained by the Hamt: its
+// tables, leaves, and the key/val pairs they hold. Every node is visited
+// once. Subtrees shared with other versions of the Hamt are counted in full;
+// so the estimates of two versions do not add up to the memory of both.
+//
+// The tables and leaves are counted by their Go sizes. Each key/val pair
+// counts as in StoredBytes; unless sizeVal is not nil, in which case a key
+// counts by the default rules of WithSizeFunc and each value counts
+// sizeVal(v). Compressed values are counted by their compressed size.
+func (h Hamt) SizeInBytes(sizeVal func(v interface{}) int) int {
+	if h.IsEmpty() {
+		return 0
+	}
+	return tableFootprint(h.conf(), h.root, sizeVal)
+}
+
+func tableFootprint(cfg config, t tableI, sizeVal func(v interface{}) int) int {
+	var n int
+	switch x := t.(type) {
+	case *compressedTable:
+		n = int(unsafe.Sizeof(*x)) + cap(x.nodes)*int(unsafe.Sizeof(nodeI(nil)))
+		if x.digest != nil {
+			n += int(unsafe.Sizeof(*x.digest))
+		}
+	case *fullTable:
+		n = int(unsafe.Sizeof(*x))
+		if x.digest != nil {
+			n += int(unsafe.Sizeof(*x.digest))
+		}
+	}
+
+	for _, ent := range t.entries() {
+		switch x := ent.node.(type) {
+		case tableI:
+			n += tableFootprint(cfg, x, sizeVal)
+		case *flatLeaf:
+			n += int(unsafe.Sizeof(*x)) + pairFootprint(cfg, x.key, x.val, sizeVal)
+		case flatLeaf:
+			n += int(unsafe.Sizeof(x)) + pairFootprint(cfg, x.key, x.val, sizeVal)
+		case *collisionLeaf:
+			n += int(unsafe.Sizeof(*x)) + cap(x.chunks)*int(unsafe.Sizeof([]key.KeyVal(nil)))
+			for _, chunk := range x.chunks {
+				n += cap(chunk) * int(unsafe.Sizeof(key.KeyVal{}))
+				for _, kv := range chunk {
+					n += pairFootprint(cfg, kv.Key, kv.Val, sizeVal)
+				}
+			}
+		}
+	}
+
+	return n
+}
+
+// pairFootprint returns the size of a key/val pair as stored in a leaf,
+// beyond the interface values that hold them.
+func pairFootprint(cfg config, k key.Key, v interface{}, sizeVal func(v interface{}) int) int {
+	var n int
+	if hk, isHashed := k.(hashedKey); isHashed {
+		n += int(unsafe.Sizeof(hk))
+		k = hk.Key
+	}
+	if cv, isCompressed := v.(compressedVal); isCompressed {
+		n += int(unsafe.Sizeof(cv))
+	}
+
+	if sizeVal == nil {
+		return n + cfg.entrySize(k, v)
+	}
+	if cv, isCompressed := v.(compressedVal); isCompressed {
+		return n + sizeOf(k) + cap(cv.data)
+	}
+	return n + sizeOf(k) + sizeVal(v)
+}
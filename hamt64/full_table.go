@@ -9,10 +9,11 @@ import (
 )
 
 type fullTable struct {
-	hashPath key.HashVal60 // depth*nBits of hash to get to this location in the Trie
-	depth    uint
-	numEnts  uint
-	nodes    [TableCapacity]nodeI
+	hashPath   key.HashVal60 // depth*nBits of hash to get to this location in the Trie
+	numEnts    uint
+	nodes      [TableCapacity]nodeI
+	numKeyVals uint        // key/val pairs in the subtree; see nkeyvals()
+	digest     *nodeDigest // set once complete, for WithMerkle Hamts; see seal()
 }
 
 func createRootFullTable(leaf leafI) tableI {
@@ -20,9 +21,9 @@ func createRootFullTable(leaf leafI) tableI {
 
 	var ft = new(fullTable)
 	//ft.hashPath = 0
-	//ft.depth = 0
 	ft.numEnts = 1
 	ft.nodes[idx] = leaf
+	ft.numKeyVals = nodeKeyVals(leaf)
 
 	return ft
 }
@@ -30,7 +31,7 @@ func createRootFullTable(leaf leafI) tableI {
 func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 	var retTable = new(fullTable)
 	retTable.hashPath = leaf1.Hash60() & key.HashPathMask60(depth-1)
-	retTable.depth = depth
+	retTable.numKeyVals = nodeKeyVals(leaf1) + 1
 
 	var curTable = retTable
 	var hashPath = retTable.hashPath
@@ -54,7 +55,7 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 
 		var newTable = new(fullTable)
 		newTable.hashPath = hashPath
-		newTable.depth = d + 1
+		newTable.numKeyVals = retTable.numKeyVals
 
 		curTable.numEnts = 1
 		curTable.nodes[idx1] = newTable
@@ -81,13 +82,11 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 		// leaf1.Hash60() == leaf2.Hash60() all the way to MaxDepth;
 		// because Hamt.createTable() is called only once, and after a
 		// leaf1.Hash60() == leaf2.Hash60() check. It is here for completeness.
-		log.Printf("full_table.go:createFullTable: SHOULD NOT BE CALLED")
 
 		// Check if the path of leaf1 is not equal to the one leaf2 just traversed.
 		if leaf1.Hash60() != leaf2.Hash60() {
-			log.Printf("MaxDepth=%d; d=%d; idx1=%d; idx2=%d", MaxDepth, d, idx1, idx2)
-			log.Panicf("createFullTable: %s,0x%06x != %s,0x%06x",
-				leaf1.Hash60(), leaf1.Hash60(), leaf2.Hash60(), leaf2.Hash60())
+			log.Panicf("createFullTable: %s,0x%06x != %s,0x%06x; MaxDepth=%d; d=%d; idx1=%d; idx2=%d",
+				leaf1.Hash60(), leaf1.Hash60(), leaf2.Hash60(), leaf2.Hash60(), MaxDepth, d, idx1, idx2)
 		}
 
 		// Just for completeness; leaf1.Hash60() == leaf2.hash60()
@@ -98,14 +97,14 @@ func createFullTable(depth uint, leaf1 leafI, leaf2 flatLeaf) tableI {
 	return retTable
 }
 
-func upgradeToFullTable(hashPath key.HashVal60, depth uint, tabEnts []tableEntry) tableI {
+func upgradeToFullTable(hashPath key.HashVal60, tabEnts []tableEntry) tableI {
 	var ft = new(fullTable)
 	ft.hashPath = hashPath
-	ft.depth = depth
 	ft.numEnts = uint(len(tabEnts))
 
 	for _, ent := range tabEnts {
 		ft.nodes[ent.idx] = ent.node
+		ft.numKeyVals += nodeKeyVals(ent.node)
 	}
 
 	return ft
@@ -120,8 +119,8 @@ func (t fullTable) Hash60() key.HashVal60 {
 func (t fullTable) copy() *fullTable {
 	var nt = new(fullTable)
 	nt.hashPath = t.hashPath
-	nt.depth = t.depth
 	nt.numEnts = t.numEnts
+	nt.numKeyVals = t.numKeyVals
 	//for i := 0; i < len(t.nodes); i++ {
 	//	nt.nodes[i] = t.nodes[i]
 	//}
@@ -135,6 +134,11 @@ func (t fullTable) nentries() uint {
 	return t.numEnts
 }
 
+// nkeyvals() is required for tableI
+func (t fullTable) nkeyvals() uint {
+	return t.numKeyVals
+}
+
 // This function MUST return the slice of tableEntry structs from lowest
 // tableEntry.idx to highest tableEntry.idx .
 func (t fullTable) entries() []tableEntry {
@@ -155,11 +159,12 @@ func (t fullTable) get(idx uint) nodeI {
 	return t.nodes[idx]
 }
 
-func (t fullTable) insert(idx uint, entry nodeI) tableI {
+func (t fullTable) insert(cfg config, idx uint, entry nodeI) tableI {
 	// t.nodes[idx] == nil
 	var nt = t.copy()
 	nt.nodes[idx] = entry
 	nt.numEnts++
+	nt.numKeyVals += nodeKeyVals(entry)
 	return nt
 }
 
@@ -167,39 +172,43 @@ func (t fullTable) replace(idx uint, entry nodeI) tableI {
 	// t.nodes[idx] != nil
 	var nt = t.copy()
 	nt.nodes[idx] = entry
+	nt.numKeyVals += nodeKeyVals(entry) - nodeKeyVals(t.nodes[idx])
 	return nt
 }
 
 //func (t fullTable) remove(idx uint) nodeI {
-func (t fullTable) remove(idx uint) tableI {
+func (t fullTable) remove(cfg config, idx uint) tableI {
 	// t.nodes[idx] != nil
 	var nt = t.copy()
 	nt.nodes[idx] = nil
 	nt.numEnts--
-
-	if GradeTables && nt.numEnts < DowngradeThreshold {
-		return downgradeToCompressedTable(nt.hashPath, nt.depth, nt.entries())
-	}
+	nt.numKeyVals -= nodeKeyVals(t.nodes[idx])
 
 	if nt.numEnts == 0 {
 		return nil
 	}
 
+	if cfg.gradeTables && nt.numEnts < cfg.downgradeThreshold {
+		cfg.noteGraded(false)
+		return downgradeToCompressedTable(nt.hashPath, nt.entries())
+	}
+
 	return nt
 }
 
-// String() is required for nodeI
+// String() is required for nodeI. A table does not record its depth, so
+// the whole hashPath is printed, zero past the table's own levels.
 func (t fullTable) String() string {
 	// fullTable{hashPath:/%d/%d/%d/%d/%d/%d, nentries:%d,}
-	return fmt.Sprintf("fullTable{hashPath:%s, nentries()=%d, depth=%d}", t.hashPath.HashPathString(t.depth), t.nentries(), t.depth)
+	return fmt.Sprintf("fullTable{hashPath:%s, nentries()=%d}", t.hashPath, t.nentries())
 }
 
 // LongString() is required for tableI
-func (t fullTable) LongString(indent string, recurse bool) string {
+func (t fullTable) LongString(indent string, depth uint, recurse bool) string {
 	//var strs = make([]string, 2+len(t.nodes))
 	var strs = make([]string, 2+t.nentries())
 
-	strs[0] = indent + fmt.Sprintf("fullTable{hashPath:%s, nentries()=%d, t.depth=%d,", t.hashPath.HashPathString(t.depth), t.nentries(), t.depth)
+	strs[0] = indent + fmt.Sprintf("fullTable{hashPath:%s, nentries()=%d, depth=%d,", t.hashPath.HashPathString(depth), t.nentries(), depth)
 
 	var j int
 	for i, n := range t.nodes {
@@ -209,7 +218,7 @@ func (t fullTable) LongString(indent string, recurse bool) string {
 		if n != nil {
 			if tt, ok := n.(tableI); ok {
 				if recurse {
-					strs[1+j] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]:\n%s", i, tt.LongString(indent+fullIndent, recurse))
+					strs[1+j] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]:\n%s", i, tt.LongString(indent+fullIndent, depth+1, recurse))
 				} else {
 					strs[1+j] = indent + fmt.Sprintf(halfIndent+"t.nodes[%d]: %s", i, tt.String())
 				}
@@ -0,0 +1,21 @@
+package hamt64
+
+import "encoding/gob"
+
+// A Hamt is registered with gob; so it can be sent as the value of an
+// interface{}, eg. in a gob-backed session store.
+func init() {
+	gob.Register(Hamt{})
+}
+
+// GobEncode implements gob.GobEncoder. The encoding is the snapshot made by
+// MarshalBinary; so the keys and values are encoded by the Codec of h, and
+// the table nodes and leaves need no gob registration of their own.
+func (h Hamt) GobEncode() ([]byte, error) {
+	return h.MarshalBinary()
+}
+
+// GobDecode implements gob.GobDecoder; see UnmarshalBinary.
+func (h *Hamt) GobDecode(data []byte) error {
+	return h.UnmarshalBinary(data)
+}
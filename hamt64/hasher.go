@@ -0,0 +1,137 @@
+package hamt64
+
+import (
+	"reflect"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Hasher is the interface of a hash function for the keys of a Hamt created
+// by New(WithHasher(hasher)). Hash is given the bytes of a key: the string of
+// a *stringkey.StringKey, otherwise the String() of the key. It MUST return
+// the same value for equal keys. The 64 bit result is folded down to the 30
+// or 60 bits of a hash path.
+type Hasher interface {
+	Hash(data []byte) uint64
+}
+
+// HasherFunc adapts an ordinary function to the Hasher interface.
+type HasherFunc func(data []byte) uint64
+
+// Hash is required for Hasher.
+func (fn HasherFunc) Hash(data []byte) uint64 {
+	return fn(data)
+}
+
+// WithHasher sets the Hasher of the keys of the Hamt. When hasher is nil, the
+// default, keys are placed by their own Hash30() and Hash60() methods; for
+// *stringkey.StringKey that is the FNV-1 hash.
+//
+// The Hasher is carried by every Hamt derived from the new Hamt, and is used
+// by Get, Put, Del and every other operation that places a key. Merge, Diff
+// and Equal walk the tries of both Hamts side by side only when both place
+// their keys alike; otherwise they compare the Hamts pair by pair, which
+// visits every pair. A snapshot must be unmarshaled with the Hasher it was
+// marshaled with, or it is reported as corrupt.
+func WithHasher(hasher Hasher) Option {
+	return func(cfg *config) {
+		cfg.hasher = hasher
+	}
+}
+
+// HashKey returns k, normalized by the key normalizer and hashed by the
+// Hasher of the Hamt, for reuse; eg. in a loop of Updates of the same key.
+// Get, Put, Del, Update and ReplaceIf of the returned key, on h or any Hamt
+// derived from it, do not normalize or hash it again. Keys given to Range
+// and the other methods that return keys are always the unwrapped k.
+//
+// Without a Hasher, the hash values are those of k itself, which the keys
+// of go-hamt-key and of the keys package compute once, when they are made.
+func (h Hamt) HashKey(k key.Key) key.Key {
+	return h.hashKey(k)
+}
+
+// hashedKey is a key.Key normalized by the key normalizer of cfg, whose hash
+// values were computed by the Hasher of cfg, or by the key itself if cfg has
+// no Hasher. It is how keys are stored in the leaves of a Hamt with a Hasher
+// or a key normalizer; userKey unwraps it wherever a key is handed back to
+// the user.
+type hashedKey struct {
+	key.Key
+	h30 key.HashVal30
+	h60 key.HashVal60
+	cfg *config
+}
+
+// hashKey applies the key normalizer of the Hamt to k, then, if the Hamt has
+// a Hasher or a key normalizer, wraps k in a hashedKey; so neither is applied
+// again. A hashedKey of the same config is returned as is.
+func (h Hamt) hashKey(k key.Key) key.Key {
+	if hk, isHashed := k.(hashedKey); isHashed && hk.cfg == h.cfg {
+		return hk
+	}
+
+	k = h.normalizeKey(userKey(k))
+	if h.cfg == nil || (h.cfg.hasher == nil && h.cfg.normalizer == nil) {
+		return k
+	}
+	if h.cfg.hasher == nil {
+		return hashedKey{Key: k, h30: k.Hash30(), h60: k.Hash60(), cfg: h.cfg}
+	}
+
+	var data []byte
+	if sk, isStringKey := k.(*stringkey.StringKey); isStringKey {
+		data = []byte(sk.Str())
+	} else {
+		data = []byte(k.String())
+	}
+
+	var hv = h.cfg.hasher.Hash(data)
+	var h32 = uint32(hv>>32) ^ uint32(hv)
+	return hashedKey{
+		Key: k,
+		h30: key.HashVal30((h32 >> 30) ^ (h32 & (1<<30 - 1))),
+		h60: key.HashVal60((hv >> 60) ^ (hv & (1<<60 - 1))),
+		cfg: h.cfg,
+	}
+}
+
+// userKey returns the key the user gave for k.
+func userKey(k key.Key) key.Key {
+	if hk, isHashed := k.(hashedKey); isHashed {
+		return hk.Key
+	}
+	return k
+}
+
+func (k hashedKey) Hash30() key.HashVal30 {
+	return k.h30
+}
+
+func (k hashedKey) Hash60() key.HashVal60 {
+	return k.h60
+}
+
+func (k hashedKey) Equals(k1 key.Key) bool {
+	return k.Key.Equals(userKey(k1))
+}
+
+// sameLayout returns true if h and other are known to normalize and hash
+// their keys alike; so the same index of their tables holds the same keys.
+// Key normalizers are functions, which can not be compared; so only Hamts
+// of the same lineage, or without normalizers, have the same layout.
+func (h Hamt) sameLayout(other Hamt) bool {
+	if h.cfg == other.cfg {
+		return true
+	}
+	var a, b = h.conf(), other.conf()
+	if a.normalizer != nil || b.normalizer != nil {
+		return false
+	}
+	if a.hasher == nil || b.hasher == nil {
+		return a.hasher == b.hasher
+	}
+	var t = reflect.TypeOf(a.hasher)
+	return t == reflect.TypeOf(b.hasher) && t.Comparable() && a.hasher == b.hasher
+}
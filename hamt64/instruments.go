@@ -0,0 +1,53 @@
+package hamt64
+
+import "time"
+
+// Instruments receives the events of the Hamts created by
+// New(WithInstruments(in)): the upgrades and downgrades of their tables, and
+// the latency of every Get, Put and Del. Its methods are called
+// synchronously, possibly from many goroutines at once; so they MUST be
+// cheap and safe for concurrent use.
+type Instruments interface {
+	// TableGraded is called when a compressedTable is upgraded to a
+	// fullTable, with upgrade true, or a fullTable is downgraded to a
+	// compressedTable, per the GradeTables policy of the Hamt.
+	TableGraded(upgrade bool)
+
+	// ObserveOp is called with the duration of every "Get", "Put" and "Del".
+	ObserveOp(op string, d time.Duration)
+}
+
+// WithInstruments sets the Instruments of the Hamt. By default, or if in is
+// nil, no events are reported and Get, Put and Del are not timed. Only the
+// Hamts derived from the Hamt returned by New() report to in.
+func WithInstruments(in Instruments) Option {
+	return func(cfg *config) {
+		cfg.instruments = in
+	}
+}
+
+// noteGraded reports the upgrade, or downgrade, of a table to the
+// Instruments of cfg.
+func (cfg config) noteGraded(upgrade bool) {
+	if cfg.instruments != nil {
+		cfg.instruments.TableGraded(upgrade)
+	}
+}
+
+// opStart returns the time an op of h starts, for observeOp; or the zero
+// Time, without reading the clock, if h has no Instruments.
+func (h Hamt) opStart() time.Time {
+	if h.cfg == nil || h.cfg.instruments == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// observeOp reports the duration of op, started at start, to the
+// Instruments of h.
+func (h Hamt) observeOp(op string, start time.Time) {
+	if h.cfg == nil || h.cfg.instruments == nil {
+		return
+	}
+	h.cfg.instruments.ObserveOp(op, time.Since(start))
+}
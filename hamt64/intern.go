@@ -0,0 +1,107 @@
+package hamt64
+
+import (
+	"container/list"
+	"log"
+	"sync"
+)
+
+// Interner is a value interning table. Values that are identical, as decided
+// by the user supplied hash and equal functions, are replaced by the first
+// instance the Interner saw. That allows many keys to share one stored value.
+//
+// An Interner holds at most a given number of distinct values; when it is
+// full, the least recently interned value is forgotten. A forgotten value
+// stays shared by the keys that already hold it, but a new identical value
+// becomes a new canonical instance. It is intended for enum-like or small
+// document values where the number of distinct values is much smaller than
+// the number of keys. An Interner is safe for concurrent use, so it can be
+// shared by several Hamts.
+type Interner struct {
+	hash  func(interface{}) uint64
+	equal func(a, b interface{}) bool
+	max   int
+	mu    sync.Mutex
+	vals  map[uint64][]*list.Element
+	lru   *list.List // of internedVal, most recently interned first
+}
+
+// internedVal is a value held by an Interner, and its hash.
+type internedVal struct {
+	hv  uint64
+	val interface{}
+}
+
+// WithValueInterner sets the Interner used by Put to intern every value
+// before it is stored; interning happens before the value is compressed.
+// By default, or if in is nil, values are not interned.
+func WithValueInterner(in *Interner) Option {
+	return func(cfg *config) {
+		cfg.interner = in
+	}
+}
+
+// NewInterner creates an empty Interner holding at most max values, using
+// hash and equal to identify identical values. Values that are equal MUST
+// have the same hash. NewInterner panics if max is less than 1.
+func NewInterner(hash func(interface{}) uint64, equal func(a, b interface{}) bool, max int) *Interner {
+	if max < 1 {
+		log.Panicf("NewInterner: max %d < 1", max)
+	}
+	var in = new(Interner)
+	in.hash = hash
+	in.equal = equal
+	in.max = max
+	in.vals = make(map[uint64][]*list.Element)
+	in.lru = list.New()
+	return in
+}
+
+// Intern returns the canonical instance of v. If no value identical to v
+// is held, v becomes the canonical instance, and the least recently
+// interned value is forgotten if the Interner is full.
+func (in *Interner) Intern(v interface{}) interface{} {
+	var hv = in.hash(v)
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for _, e := range in.vals[hv] {
+		if iv := e.Value.(internedVal).val; in.equal(iv, v) {
+			in.lru.MoveToFront(e)
+			return iv
+		}
+	}
+
+	in.vals[hv] = append(in.vals[hv], in.lru.PushFront(internedVal{hv, v}))
+	if in.lru.Len() > in.max {
+		in.forget(in.lru.Back())
+	}
+
+	return v
+}
+
+// forget removes the value of e from the Interner.
+func (in *Interner) forget(e *list.Element) {
+	var hv = in.lru.Remove(e).(internedVal).hv
+	var es = in.vals[hv]
+	for i := range es {
+		if es[i] == e {
+			es = append(es[:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(in.vals, hv)
+	} else {
+		in.vals[hv] = es
+	}
+}
+
+// Len returns the number of distinct values held by the Interner.
+func (in *Interner) Len() int {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.lru.Len()
+}
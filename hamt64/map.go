@@ -0,0 +1,29 @@
+package hamt64
+
+import (
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// FromMap returns a new Hamt with an entry for every key/val pair of m; each
+// key becomes a *stringkey.StringKey.
+func FromMap(m map[string]interface{}) Hamt {
+	var kvs = make([]key.KeyVal, 0, len(m))
+	for s, v := range m {
+		kvs = append(kvs, key.KeyVal{Key: stringkey.New(s), Val: v})
+	}
+	return Hamt{}.PutMany(kvs)
+}
+
+// ToMap returns a new built-in map with an entry for every key/val pair of
+// h, keyed by the String() of the key. For keys other than
+// *stringkey.StringKey, distinct keys with the same String() collapse into
+// one entry.
+func (h Hamt) ToMap() map[string]interface{} {
+	var m = make(map[string]interface{}, h.nentries)
+	h.Range(func(k key.Key, v interface{}) bool {
+		m[k.String()] = v
+		return true
+	})
+	return m
+}
@@ -0,0 +1,58 @@
+package hamt64
+
+import "github.com/lleo/go-hamt-key"
+
+// Move renames oldKey to newKey; it deletes oldKey and inserts its value
+// under newKey, producing a single new version of the Hamt. Move fails,
+// returning the original Hamt and false, if oldKey is not found or newKey
+// already exists. See MoveOver() to replace an existing newKey.
+func (h Hamt) Move(oldKey, newKey key.Key) (Hamt, bool) {
+	mustKey("Move", oldKey)
+	mustKey("Move", newKey)
+	return h.move("Move", oldKey, newKey, false)
+}
+
+// MoveOver is Move, except that the value of an existing newKey is replaced
+// rather than causing the Move to fail.
+func (h Hamt) MoveOver(oldKey, newKey key.Key) (Hamt, bool) {
+	mustKey("MoveOver", oldKey)
+	mustKey("MoveOver", newKey)
+	return h.move("MoveOver", oldKey, newKey, true)
+}
+
+// move is Move, or MoveOver if over is true. The trie is descended twice:
+// once to remove oldKey, and once, in the Hamt without oldKey, to find
+// newKey and insert it there.
+func (h Hamt) move(op string, oldKey, newKey key.Key, over bool) (Hamt, bool) {
+	oldKey, newKey = h.hashKey(oldKey), h.hashKey(newKey)
+
+	if oldKey.Equals(newKey) {
+		var _, found = h.get(oldKey)
+		return h, found
+	}
+
+	// The value is moved exactly as it was stored; so it is not interned or
+	// compressed again.
+	var path, leaf, idx = h.find(oldKey)
+	var nh, val, deleted = h.unlinkAt(oldKey, path, leaf, idx)
+	if !deleted {
+		return h, false
+	}
+
+	path, leaf, idx = nh.find(newKey)
+	if leaf != nil && !over {
+		if _, exists := leaf.get(newKey); exists {
+			return h, false
+		}
+	}
+	nh, _ = nh.linkAt(newKey, val, path, leaf, idx)
+
+	nh.adapt(oldKey.Hash60())
+	nh.adapt(newKey.Hash60())
+	nh.seal()
+	nh.check(op, oldKey)
+	nh.check(op, newKey)
+	nh.reportCopies(op, h, newKey)
+
+	return nh, true
+}
@@ -0,0 +1,146 @@
+package hamt64
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// NamespaceSep is the separator between the mount prefix and the rest of a
+// key routed by a Namespace.
+const NamespaceSep = "/"
+
+// Namespace is a functional map of whole Hamts mounted under key prefixes.
+// Get, Put and Del route a *stringkey.StringKey "prefix/rest" to the Hamt
+// mounted at "prefix", as the key "rest"; every other key goes to the
+// Namespace's own, unmounted, Hamt.
+//
+// Mount and Unmount only touch the table of mounts; so an application can
+// snapshot, swap, or drop a tenant's entire keyspace in one step.
+type Namespace struct {
+	h      Hamt // keys not under a mount
+	mounts Hamt // stringkey(prefix) -> Hamt
+}
+
+// Mount returns a new Namespace with sub mounted at prefix, replacing any
+// Hamt mounted there.
+func (ns Namespace) Mount(prefix string, sub Hamt) Namespace {
+	ns.mounts, _ = ns.mounts.put(stringkey.New(prefix), sub)
+	return ns
+}
+
+// Unmount returns a new Namespace without the Hamt mounted at prefix, that
+// Hamt and a bool indicating if a Hamt was mounted at prefix.
+func (ns Namespace) Unmount(prefix string) (Namespace, Hamt, bool) {
+	var mounts, sub, found = ns.mounts.del(stringkey.New(prefix))
+	if !found {
+		return ns, Hamt{}, false
+	}
+	ns.mounts = mounts
+	return ns, sub.(Hamt), true
+}
+
+// Mounted returns the Hamt mounted at prefix and a bool indicating if one
+// was found.
+func (ns Namespace) Mounted(prefix string) (Hamt, bool) {
+	var sub, found = ns.mounts.get(stringkey.New(prefix))
+	if !found {
+		return Hamt{}, false
+	}
+	return sub.(Hamt), true
+}
+
+// Prefixes returns the prefixes of every mounted Hamt.
+func (ns Namespace) Prefixes() []string {
+	var prefixes = make([]string, 0, ns.mounts.Nentries())
+	ns.mounts.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			prefixes = append(prefixes, kv.Key.String())
+		}
+		return true
+	})
+	return prefixes
+}
+
+// IsEmpty returns true if the Namespace has no entries; mounted Hamts may
+// be empty.
+func (ns Namespace) IsEmpty() bool {
+	return ns.Nentries() == 0
+}
+
+// Nentries returns the number of entries in the Namespace, including those
+// of every mounted Hamt.
+func (ns Namespace) Nentries() uint {
+	var n = ns.h.Nentries()
+	ns.mounts.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			n += kv.Val.(Hamt).Nentries()
+		}
+		return true
+	})
+	return n
+}
+
+// route returns the prefix of k's mount, and k relative to that mount. If k
+// is not under a mount, prefix is "".
+func (ns Namespace) route(k key.Key) (prefix string, sub Hamt, rk key.Key) {
+	var sk, ok = k.(*stringkey.StringKey)
+	if !ok {
+		return "", ns.h, k
+	}
+
+	var i = strings.Index(sk.Str(), NamespaceSep)
+	if i < 0 {
+		return "", ns.h, k
+	}
+
+	prefix = sk.Str()[:i]
+	var m, found = ns.mounts.get(stringkey.New(prefix))
+	if !found {
+		return "", ns.h, k
+	}
+
+	return prefix, m.(Hamt), stringkey.New(sk.Str()[i+len(NamespaceSep):])
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found.
+func (ns Namespace) Get(k key.Key) (interface{}, bool) {
+	var _, sub, rk = ns.route(k)
+	return sub.Get(rk)
+}
+
+// Put inserts a key/val pair, returning a new Namespace and a bool
+// indicating if the key/val pair was added(true) or merely updated(false).
+func (ns Namespace) Put(k key.Key, v interface{}) (Namespace, bool) {
+	var prefix, sub, rk = ns.route(k)
+	var nsub, added = sub.Put(rk, v)
+	return ns.replace(prefix, nsub), added
+}
+
+// Del removes a key, returning a new Namespace, the key's value and a bool
+// indicating whether the key was found (and therefor deleted).
+func (ns Namespace) Del(k key.Key) (Namespace, interface{}, bool) {
+	var prefix, sub, rk = ns.route(k)
+	var nsub, val, deleted = sub.Del(rk)
+	if !deleted {
+		return ns, nil, false
+	}
+	return ns.replace(prefix, nsub), val, true
+}
+
+// replace returns a new Namespace with the Hamt routed to by prefix
+// replaced by sub.
+func (ns Namespace) replace(prefix string, sub Hamt) Namespace {
+	if prefix == "" {
+		ns.h = sub
+		return ns
+	}
+	return ns.Mount(prefix, sub)
+}
+
+func (ns Namespace) String() string {
+	return fmt.Sprintf("Namespace{ nmounts: %d, h: %s }", ns.mounts.Nentries(), ns.h)
+}
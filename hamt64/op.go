@@ -0,0 +1,85 @@
+package hamt64
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// OpKind identifies the kind of mutation an Op describes.
+type OpKind uint8
+
+const (
+	// OpPut is the OpKind of an Op created by PutOp.
+	OpPut OpKind = iota
+	// OpDel is the OpKind of an Op created by DelOp.
+	OpDel
+	// OpUpdate is the OpKind of an Op created by UpdateOp.
+	OpUpdate
+)
+
+var opKindStr = []string{"Put", "Del", "Update"}
+
+func (kind OpKind) String() string {
+	if int(kind) < len(opKindStr) {
+		return opKindStr[kind]
+	}
+	return fmt.Sprintf("OpKind(%d)", uint8(kind))
+}
+
+// UpdateFunc is given the current value of a key and whether the key was
+// found. It returns the new value for the key and whether the key should be
+// kept (true) or deleted (false).
+type UpdateFunc func(old interface{}, found bool) (new interface{}, keep bool)
+
+// Op describes a mutation of a Hamt as data; so mutations can be logged,
+// replicated, batched, and replayed with Hamt.Apply(). The Key, Kind and Val
+// fields are the same for hamt64 and hamt64, only an OpUpdate carries code.
+type Op struct {
+	Kind OpKind
+	Key  key.Key
+	Val  interface{}
+	Fn   UpdateFunc
+}
+
+// PutOp returns an Op that does h.Put(k, v).
+func PutOp(k key.Key, v interface{}) Op {
+	return Op{Kind: OpPut, Key: k, Val: v}
+}
+
+// DelOp returns an Op that does h.Del(k).
+func DelOp(k key.Key) Op {
+	return Op{Kind: OpDel, Key: k}
+}
+
+// UpdateOp returns an Op that replaces the value of k with the result of fn.
+func UpdateOp(k key.Key, fn UpdateFunc) Op {
+	return Op{Kind: OpUpdate, Key: k, Fn: fn}
+}
+
+func (op Op) String() string {
+	switch op.Kind {
+	case OpPut:
+		return fmt.Sprintf("Op{Put, %s, %v}", op.Key, op.Val)
+	default:
+		return fmt.Sprintf("Op{%s, %s}", op.Kind, op.Key)
+	}
+}
+
+// Apply applies each Op in order and returns the resulting Hamt.
+func (h Hamt) Apply(ops []Op) Hamt {
+	for _, op := range ops {
+		switch op.Kind {
+		case OpPut:
+			h, _ = h.Put(op.Key, op.Val)
+		case OpDel:
+			h, _, _ = h.Del(op.Key)
+		case OpUpdate:
+			h = h.Update(op.Key, op.Fn)
+		default:
+			log.Panicf("Hamt.Apply: unknown Op Kind %s", op.Kind)
+		}
+	}
+	return h
+}
@@ -0,0 +1,111 @@
+package hamt64
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// PathFilter matches hash paths against a pattern of the same form as
+// HashVal60.String() (eg. "/07/23/*/04"). Every element of the pattern is
+// either an index, for that depth of the hash path, or "*" which matches any
+// index. A pattern shorter than MaxDepth+1 elements matches every hash path
+// that starts with it; so "/07" selects the whole subtree under index 7 of
+// the root table.
+type PathFilter struct {
+	idxs []int // -1 is the "*" wildcard
+}
+
+// ParsePathFilter converts a pattern string into a PathFilter. The empty
+// pattern or "/" matches every hash path.
+func ParsePathFilter(pat string) (PathFilter, error) {
+	var f PathFilter
+
+	pat = strings.TrimPrefix(pat, "/")
+	if pat == "" {
+		return f, nil
+	}
+
+	var parts = strings.Split(pat, "/")
+	if uint(len(parts)) > MaxDepth+1 {
+		return f, fmt.Errorf("%w: %q has %d elements; more than MaxDepth+1,%d", ErrBadPathFilter, pat, len(parts), MaxDepth+1)
+	}
+
+	f.idxs = make([]int, len(parts))
+	for i, part := range parts {
+		if part == "*" {
+			f.idxs[i] = -1
+			continue
+		}
+		var idx, err = strconv.ParseUint(part, 10, 8)
+		if err != nil || uint(idx) >= TableCapacity {
+			return PathFilter{}, fmt.Errorf("%w: element %d of %q, %q, is not \"*\" or an index less than %d", ErrBadPathFilter, i, pat, part, TableCapacity)
+		}
+		f.idxs[i] = int(idx)
+	}
+
+	return f, nil
+}
+
+// Match reports whether the hash path h60 matches the PathFilter.
+func (f PathFilter) Match(h60 key.HashVal60) bool {
+	return f.MatchPrefix(h60, MaxDepth+1)
+}
+
+// MatchPrefix reports whether the first depth indexes of the hash path h60
+// match the PathFilter. When it returns false no hash path starting with
+// those indexes can match; so a whole table at that depth can be skipped.
+func (f PathFilter) MatchPrefix(h60 key.HashVal60, depth uint) bool {
+	for d, idx := range f.idxs {
+		if uint(d) >= depth {
+			break
+		}
+		if idx >= 0 && uint(idx) != h60.Index(uint(d)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f PathFilter) String() string {
+	var strs = make([]string, len(f.idxs))
+	for i, idx := range f.idxs {
+		if idx < 0 {
+			strs[i] = "*"
+		} else {
+			strs[i] = fmt.Sprintf("%02d", idx)
+		}
+	}
+	return "/" + strings.Join(strs, "/")
+}
+
+// KeyValsMatching returns the key/val pairs of every entry whose hash path
+// matches f. Tables whose hash path can not match are not visited.
+func (h Hamt) KeyValsMatching(f PathFilter) []key.KeyVal {
+	if h.IsEmpty() {
+		return nil
+	}
+	return appendMatching(nil, h.root, 0, f)
+}
+
+func appendMatching(kvs []key.KeyVal, t tableI, depth uint, f PathFilter) []key.KeyVal {
+	for _, ent := range t.entries() {
+		// depth+1 indexes of ent.node's hash path are known at this point.
+		if !f.MatchPrefix(ent.node.Hash60(), depth+1) {
+			continue
+		}
+		switch n := ent.node.(type) {
+		case tableI:
+			kvs = appendMatching(kvs, n, depth+1, f)
+		case leafI:
+			if f.Match(n.Hash60()) {
+				for _, kv := range n.keyVals() {
+					kvs = append(kvs, key.KeyVal{Key: userKey(kv.Key), Val: decompressVal(kv.Val)})
+				}
+			}
+		}
+	}
+	return kvs
+}
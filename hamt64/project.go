@@ -0,0 +1,28 @@
+package hamt64
+
+import "github.com/lleo/go-hamt-key"
+
+// Without returns a version of the Hamt without any of the given keys. The
+// keys are deleted in one pass by DelMany; so the tables on the paths of
+// several keys are copied once, and the tables untouched by the deletions
+// are shared with the original Hamt.
+func (h Hamt) Without(keys ...key.Key) Hamt {
+	var nh, _ = h.DelMany(keys)
+	return nh
+}
+
+// Only returns a Hamt holding only the entries of the given keys that are
+// found in the original Hamt. It is built by one Builder, and has the
+// configuration and AssertLevel of the original Hamt. The values are shared
+// with the original Hamt exactly as stored; they are not interned or
+// compressed again.
+func (h Hamt) Only(keys ...key.Key) Hamt {
+	var b = Hamt{assert: h.assert, cfg: h.cfg}.Builder()
+	for _, k := range keys {
+		k = h.hashKey(k)
+		if v, found := h.get(k); found {
+			b.put(k, v)
+		}
+	}
+	return b.Freeze()
+}
@@ -0,0 +1,237 @@
+package hamt64
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// replicaMagic starts every replica made by WriteReplica; the last byte is
+// the format version.
+const replicaMagic = "HAMT64R\x01"
+
+// tagBaseNode replaces, in a replica, a node the peer already has: the node
+// at the same place in the version the replica is based on.
+const tagBaseNode = tagCollisionLeaf + 1
+
+// WriteReplica writes to w a replica of h for a peer whose current version
+// has the root Digest remote. If remote is the Digest of one of the
+// versions of history, the replica is based on it: only the nodes of h that
+// differ from the nodes at the same place in that version are written, the
+// others by reference. Otherwise the replica is of every node of h. The peer
+// rebuilds h with ReadReplica.
+//
+// Comparing nodes costs a Digest of each; so the versions should be made by
+// New(WithMerkle(true)), which remembers them. Keys and values are encoded
+// by the Codec of h, and the errors are those of Encode.
+func (h Hamt) WriteReplica(w io.Writer, remote [sha256.Size]byte, history ...Hamt) error {
+	var based bool
+	var base Hamt
+	for _, v := range history {
+		if d, err := v.Digest(); err == nil && d == remote {
+			based, base = true, v
+			break
+		}
+	}
+
+	var target, err = h.Digest()
+	if err != nil {
+		return err
+	}
+
+	var e = replicaEncoder{snapshotEncoder{w: bufio.NewWriter(w), codec: h.Codec()}}
+	e.buf = append(e.buf, replicaMagic...)
+	e.buf = append(e.buf, target[:]...)
+	if based {
+		e.buf = append(append(e.buf, 1), remote[:]...)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+	e.buf = binary.AppendUvarint(e.buf, uint64(h.nentries))
+	if err = e.flush(); err != nil {
+		return err
+	}
+	if !h.IsEmpty() {
+		if err = e.node(h.root, base.root); err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
+
+// ReadReplica returns the version written by WriteReplica, from the replica
+// read from r. A replica based on a version must be read by that version;
+// otherwise an error wrapping ErrReplicaBase is returned. The nodes the
+// replica refers to are shared with h; the others are decoded as by
+// UnmarshalBinary, and stored through the configuration of h.
+//
+// Besides the errors of Decode, a replica that does not rebuild the Digest
+// of the version it was written from returns an error wrapping
+// ErrCorruptSnapshot.
+func (h Hamt) ReadReplica(r io.Reader) (Hamt, error) {
+	var br, ok = r.(snapshotReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	var d = snapshotDecoder{r: br, cfg: h.cfg}
+
+	var head, err = d.next(uint64(len(replicaMagic)) + sha256.Size + 1)
+	if err != nil {
+		return Hamt{}, err
+	}
+	if string(head[:len(replicaMagic)]) != replicaMagic {
+		return Hamt{}, fmt.Errorf("%w: bad replica magic or version", ErrCorruptSnapshot)
+	}
+	var target [sha256.Size]byte
+	copy(target[:], head[len(replicaMagic):])
+
+	var base Hamt
+	switch head[len(head)-1] {
+	case 0:
+	case 1:
+		var remote []byte
+		if remote, err = d.next(sha256.Size); err != nil {
+			return Hamt{}, err
+		}
+		var local [sha256.Size]byte
+		if local, err = h.Digest(); err != nil {
+			return Hamt{}, err
+		}
+		if !bytes.Equal(local[:], remote) {
+			return Hamt{}, fmt.Errorf("%w: based on %x", ErrReplicaBase, remote)
+		}
+		base = h
+	default:
+		return Hamt{}, fmt.Errorf("%w: bad replica base flag %d", ErrCorruptSnapshot, head[len(head)-1])
+	}
+
+	var nentries uint64
+	if nentries, err = d.uvarint(); err != nil {
+		return Hamt{}, err
+	}
+
+	var rd = replicaDecoder{&d}
+	var root nodeI
+	if nentries > 0 {
+		if root, err = rd.node(0, base.root); err != nil {
+			return Hamt{}, err
+		}
+	}
+	var nh Hamt
+	if nh, err = d.hamt(h, root, nentries); err != nil {
+		return Hamt{}, err
+	}
+
+	var digest [sha256.Size]byte
+	if digest, err = nh.Digest(); err != nil {
+		return Hamt{}, err
+	}
+	if digest != target {
+		return Hamt{}, fmt.Errorf("%w: replica does not rebuild its Digest", ErrCorruptSnapshot)
+	}
+	return nh, nil
+}
+
+// replicaEncoder writes a replica, a node at a time.
+type replicaEncoder struct {
+	snapshotEncoder
+}
+
+// node writes the encoding of node, and everything below it, referring to
+// the nodes equal to those of base at the same place.
+func (e *replicaEncoder) node(node, base nodeI) error {
+	if base != nil {
+		var same, err = sameDigest(e.codec, node, base)
+		if err != nil {
+			return err
+		}
+		if same {
+			e.buf = append(e.buf, tagBaseNode)
+			return e.flush()
+		}
+	}
+
+	var t, isTable = node.(tableI)
+	if !isTable {
+		return e.snapshotEncoder.node(node)
+	}
+	if err := e.table(t); err != nil {
+		return err
+	}
+	var bt, _ = base.(tableI)
+	for _, ent := range t.entries() {
+		var b nodeI
+		if bt != nil {
+			b = bt.get(ent.idx)
+		}
+		if err := e.node(ent.node, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameDigest returns true if the nodes a and b are both tables, or both
+// leaves, with the same digest; leaves are encoded by c.
+func sameDigest(c Codec, a, b nodeI) (bool, error) {
+	if sameTable(a, b) {
+		return true, nil
+	}
+
+	var da, db *nodeDigest
+	var err error
+	var at, aIsTable = a.(tableI)
+	var bt, bIsTable = b.(tableI)
+	switch {
+	case aIsTable && bIsTable:
+		if da, err = digestTable(c, at); err == nil {
+			db, err = digestTable(c, bt)
+		}
+	case !aIsTable && !bIsTable:
+		if da, err = digestLeaf(c, a.(leafI)); err == nil {
+			db, err = digestLeaf(c, b.(leafI))
+		}
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return da.sum == db.sum, nil
+}
+
+// replicaDecoder consumes a replica made by WriteReplica.
+type replicaDecoder struct {
+	d *snapshotDecoder
+}
+
+// node decodes the next node, found in a table at depth-1; base is the node
+// at the same place in the version the replica is based on, or nil.
+func (rd replicaDecoder) node(depth uint, base nodeI) (nodeI, error) {
+	var tag, err = rd.d.byte()
+	if err != nil {
+		return nil, err
+	}
+
+	if tag == tagBaseNode {
+		if base == nil {
+			return nil, fmt.Errorf("%w: reference to a node the base does not have", ErrCorruptSnapshot)
+		}
+		var n, nbytes = countTree(Hamt{cfg: rd.d.cfg}.conf(), base)
+		rd.d.nentries += uint(n)
+		rd.d.nbytes += nbytes
+		return base, nil
+	}
+
+	var bt, _ = base.(tableI)
+	return rd.d.tagged(tag, depth, func(idx uint) (nodeI, error) {
+		var b nodeI
+		if bt != nil {
+			b = bt.get(idx)
+		}
+		return rd.node(depth+1, b)
+	})
+}
@@ -0,0 +1,28 @@
+package hamt64
+
+import (
+	"iter"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// All returns an iter.Seq2 of every key/val pair of the Hamt, in hash path
+// order; ie. `for k, v := range h.All() {...}`. It is Range() as a
+// range-over-func iterator.
+func (h Hamt) All() iter.Seq2[key.Key, interface{}] {
+	return h.Range
+}
+
+// AllKeys returns an iter.Seq of every key of the Hamt; see RangeKeys().
+func (h Hamt) AllKeys() iter.Seq[key.Key] {
+	return h.RangeKeys
+}
+
+// AllValues returns an iter.Seq of every value of the Hamt.
+func (h Hamt) AllValues() iter.Seq[interface{}] {
+	return func(yield func(interface{}) bool) {
+		h.Range(func(_ key.Key, v interface{}) bool {
+			return yield(v)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package hamt64
+
+import (
+	"log"
+	"reflect"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// Shadow is a debugging decorator of a Hamt. Every operation is run against
+// both the Hamt and a plain Go map, keyed by the String() of the normalized
+// key, and the results are compared. Any divergence panics with the
+// operation, the key, and both results.
+//
+// Each modification copies the whole map, so that every version of a Shadow
+// keeps its own; Shadow is for tests and debugging only.
+type Shadow struct {
+	h Hamt
+	m map[string]interface{}
+}
+
+// NewShadow returns a Shadow of h.
+func NewShadow(h Hamt) Shadow {
+	var s = Shadow{h, make(map[string]interface{}, h.Nentries())}
+	h.walk(func(l leafI) bool {
+		for _, kv := range l.keyVals() {
+			s.m[kv.Key.String()] = decompressVal(kv.Val)
+		}
+		return true
+	})
+	s.verifyNentries("NewShadow", nil)
+	return s
+}
+
+// Hamt returns the shadowed Hamt.
+func (s Shadow) Hamt() Hamt {
+	return s.h
+}
+
+// IsEmpty returns true if the shadowed Hamt has no entries.
+func (s Shadow) IsEmpty() bool {
+	var empty = s.h.IsEmpty()
+	if empty != (len(s.m) == 0) {
+		log.Panicf("Shadow.IsEmpty(): Hamt=%t; map has %d entries", empty, len(s.m))
+	}
+	return empty
+}
+
+// Nentries returns the number of entries in the shadowed Hamt.
+func (s Shadow) Nentries() uint {
+	s.verifyNentries("Nentries", nil)
+	return s.h.Nentries()
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found.
+func (s Shadow) Get(k key.Key) (interface{}, bool) {
+	var val, found = s.h.Get(k)
+	var mval, mfound = s.m[s.h.normalizeKey(k).String()]
+	s.verify("Get", k, val, found, mval, mfound)
+	return val, found
+}
+
+// Put inserts a key/val pair into both the Hamt and the map, returning a
+// new Shadow and a bool indicating if the key/val pair was added(true) or
+// merely updated(false).
+func (s Shadow) Put(k key.Key, v interface{}) (Shadow, bool) {
+	var ns = s.copy()
+	var added bool
+	ns.h, added = s.h.Put(k, v)
+
+	var ks = s.h.normalizeKey(k).String()
+	var _, existed = s.m[ks]
+	ns.m[ks] = v
+
+	if added == existed {
+		log.Panicf("Shadow.Put(%s): Hamt added=%t; map already had key=%t", k, added, existed)
+	}
+	ns.verifyNentries("Put", k)
+
+	return ns, added
+}
+
+// Del removes a key from both the Hamt and the map, returning a new Shadow,
+// the key's value and a bool indicating whether the key was found (and
+// therefor deleted).
+func (s Shadow) Del(k key.Key) (Shadow, interface{}, bool) {
+	var ns = s.copy()
+	var val interface{}
+	var deleted bool
+	ns.h, val, deleted = s.h.Del(k)
+
+	var ks = s.h.normalizeKey(k).String()
+	var mval, mfound = s.m[ks]
+	delete(ns.m, ks)
+
+	s.verify("Del", k, val, deleted, mval, mfound)
+	ns.verifyNentries("Del", k)
+
+	return ns, val, deleted
+}
+
+func (s Shadow) copy() Shadow {
+	var ns = Shadow{s.h, make(map[string]interface{}, len(s.m)+1)}
+	for ks, v := range s.m {
+		ns.m[ks] = v
+	}
+	return ns
+}
+
+func (s Shadow) verify(op string, k key.Key, val interface{}, found bool, mval interface{}, mfound bool) {
+	if found != mfound || !reflect.DeepEqual(val, mval) {
+		log.Panicf("Shadow.%s(%s): Hamt=(%v, %t); map=(%v, %t); h=%s", op, k, val, found, mval, mfound, s.h)
+	}
+}
+
+func (s Shadow) verifyNentries(op string, k key.Key) {
+	if s.h.Nentries() != uint(len(s.m)) {
+		log.Panicf("Shadow.%s(%v): Hamt.Nentries()=%d; map has %d entries; h=%s", op, k, s.h.Nentries(), len(s.m), s.h)
+	}
+}
+
+func (s Shadow) String() string {
+	return "Shadow{ " + s.h.String() + " }"
+}
@@ -0,0 +1,131 @@
+package hamt64
+
+import (
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Sizer is implemented by keys and values that know how many bytes they
+// occupy.
+type Sizer interface {
+	Size() int
+}
+
+// WithSizeFunc sets a function used instead of the default sizing rules to
+// calculate the number of bytes of each key/val pair stored in a Hamt. By
+// default, or if fn is nil, a key or val counts Size() bytes if it is a
+// Sizer, as are the keys of the keys package, and len() bytes if it is a
+// []byte, string or *stringkey.StringKey. Any other key counts the len() of
+// its String(), and any other val zero bytes. fn is given the key as
+// normalized, never the wrapper a Hamt stores it in.
+//
+// A Hamt keeps the total incrementally as entries are Put and Deleted; so
+// only the Hamts derived from the Hamt returned by New() use fn.
+func WithSizeFunc(fn func(k key.Key, v interface{}) int) Option {
+	return func(cfg *config) {
+		cfg.sizeFunc = fn
+	}
+}
+
+// Stats is a summary of the contents, and the shape, of a Hamt.
+type Stats struct {
+	// Nentries is the number of key/val pairs in the Hamt.
+	Nentries uint
+
+	// StoredBytes is the total size, per WithSizeFunc, of every key/val pair in
+	// the Hamt.
+	StoredBytes int
+
+	// CompressedTables and FullTables are the number of tables of each type.
+	CompressedTables uint
+	FullTables       uint
+
+	// FlatLeaves and CollisionLeaves are the number of leaves of each type.
+	FlatLeaves      uint
+	CollisionLeaves uint
+
+	// TableEntries is the number of non-nil entries of every table.
+	TableEntries uint
+
+	// EntriesPerDepth is the number of key/val pairs in leaves of tables at
+	// each depth.
+	EntriesPerDepth [MaxDepth + 1]uint
+}
+
+// Occupancy returns the average fraction of the TableCapacity entries of a
+// table that are not nil; or zero for an empty Hamt.
+func (s Stats) Occupancy() float64 {
+	var ntables = s.CompressedTables + s.FullTables
+	if ntables == 0 {
+		return 0
+	}
+	return float64(s.TableEntries) / float64(ntables*TableCapacity)
+}
+
+// Stats returns the Stats of the Hamt. Nentries and StoredBytes are kept
+// as the Hamt is modified; the other counts are found by visiting every
+// table, but not every key/val pair.
+func (h Hamt) Stats() Stats {
+	var s = Stats{
+		Nentries:    h.nentries,
+		StoredBytes: h.nbytes,
+	}
+	if !h.IsEmpty() {
+		s.addTable(h.root, 0)
+	}
+	return s
+}
+
+func (s *Stats) addTable(t tableI, depth uint) {
+	switch t.(type) {
+	case *compressedTable:
+		s.CompressedTables++
+	case *fullTable:
+		s.FullTables++
+	}
+
+	var ents = t.entries()
+	s.TableEntries += uint(len(ents))
+	for _, ent := range ents {
+		switch x := ent.node.(type) {
+		case tableI:
+			s.addTable(x, depth+1)
+		case *flatLeaf, flatLeaf:
+			s.FlatLeaves++
+			s.EntriesPerDepth[depth]++
+		case *collisionLeaf:
+			s.CollisionLeaves++
+			s.EntriesPerDepth[depth] += uint(x.nkeyvals())
+		}
+	}
+}
+
+// entrySize returns the size of a key/val pair, as stored in a leaf.
+func (cfg config) entrySize(k key.Key, v interface{}) int {
+	k = userKey(k)
+	if cv, isCompressed := v.(compressedVal); isCompressed {
+		v = cv.data
+	}
+
+	if cfg.sizeFunc != nil {
+		return cfg.sizeFunc(k, v)
+	}
+
+	return sizeOf(k) + sizeOf(v)
+}
+
+func sizeOf(x interface{}) int {
+	switch x := x.(type) {
+	case Sizer:
+		return x.Size()
+	case []byte:
+		return len(x)
+	case string:
+		return len(x)
+	case *stringkey.StringKey:
+		return len(x.Str())
+	case key.Key:
+		return len(x.String())
+	}
+	return 0
+}
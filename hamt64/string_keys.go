@@ -0,0 +1,81 @@
+package hamt64
+
+import (
+	"github.com/lleo/go-hamt-functional/keys"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// GetString is Get of the *stringkey.StringKey of s. Unless the Hamt has a
+// key normalizer or a Hasher, the key is never made: s is hashed in place and
+// compared with the strings of the stored keys; so GetString makes no
+// allocations.
+func (h Hamt) GetString(s string) (interface{}, bool) {
+	if h.cfg != nil && (h.cfg.normalizer != nil || h.cfg.hasher != nil) {
+		return h.Get(stringkey.New(s))
+	}
+	h.noteRead()
+	var val, found = h.getString(s)
+	return decompressVal(val), found
+}
+
+// PutString is Put of the *stringkey.StringKey of s.
+func (h Hamt) PutString(s string, v interface{}) (Hamt, bool) {
+	return h.Put(stringkey.New(s), v)
+}
+
+// DelString is Del of the *stringkey.StringKey of s.
+func (h Hamt) DelString(s string) (Hamt, interface{}, bool) {
+	return h.Del(stringkey.New(s))
+}
+
+// getString is get of the *stringkey.StringKey of s, for a Hamt without a
+// key normalizer or Hasher. A keys.String has the same hash values as the
+// *stringkey.StringKey of the same string, and hashing it does not allocate.
+func (h Hamt) getString(s string) (val interface{}, found bool) {
+	if h.IsEmpty() {
+		return //nil, false
+	}
+
+	var h60 = keys.NewString(s).Hash60()
+
+	var curTable = h.root
+
+	for depth := uint(0); depth <= MaxDepth; depth++ {
+		switch n := curTable.get(h60.Index(depth)).(type) {
+		case nil:
+			return //nil, false
+		case *flatLeaf:
+			return n.getString(s)
+		case flatLeaf:
+			return n.getString(s)
+		case *collisionLeaf:
+			for _, chunk := range n.chunks {
+				for _, kv := range chunk {
+					if isStringKey(kv.Key, s) {
+						return kv.Val, true
+					}
+				}
+			}
+			return //nil, false
+		case tableI:
+			curTable = n
+		}
+	}
+
+	panic("SHOULD NEVER BE REACHED")
+}
+
+// getString is get of the *stringkey.StringKey of s.
+func (l flatLeaf) getString(s string) (interface{}, bool) {
+	if isStringKey(l.key, s) {
+		return l.val, true
+	}
+	return nil, false
+}
+
+// isStringKey returns true if k is the *stringkey.StringKey of s.
+func isStringKey(k key.Key, s string) bool {
+	var sk, ok = k.(*stringkey.StringKey)
+	return ok && sk.Str() == s
+}
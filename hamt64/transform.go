@@ -0,0 +1,147 @@
+package hamt64
+
+import "github.com/lleo/go-hamt-key"
+
+// MapValues returns a new Hamt with the keys of h, where the value of each
+// key k is fn(k, v) of its value v in h. The new Hamt has the same shape as
+// h: every table is copied once, with new leaves, and no key is hashed or
+// placed again. A loop of Range and Put would copy the path of every key
+// instead. The new values are interned and compressed as by Put.
+func (h Hamt) MapValues(fn func(k key.Key, v interface{}) interface{}) Hamt {
+	if h.IsEmpty() {
+		return h
+	}
+
+	var nh = h
+	nh.nbytes = 0
+	nh.root = nh.mapValuesTable(h.root, fn)
+	nh.seal()
+	nh.checkAll("MapValues")
+	return nh
+}
+
+// mapValuesTable returns a copy of t, and of every table under it, with the
+// values of every leaf mapped by fn. It adds the size of the new entries to
+// nh.nbytes.
+func (nh *Hamt) mapValuesTable(t tableI, fn func(k key.Key, v interface{}) interface{}) tableI {
+	var nt tableI
+	switch x := t.(type) {
+	case *compressedTable:
+		nt = x.copy()
+	case *fullTable:
+		nt = x.copy()
+	}
+
+	for _, ent := range nt.entries() {
+		switch x := ent.node.(type) {
+		case tableI:
+			setInPlace(nt, ent.idx, nh.mapValuesTable(x, fn))
+		case leafI:
+			var kvs = x.keyVals()
+			for i, kv := range kvs {
+				kvs[i].Val = nh.conf().storeVal(fn(userKey(kv.Key), decompressVal(kv.Val)))
+				nh.nbytes += nh.conf().entrySize(kv.Key, kvs[i].Val)
+			}
+			if len(kvs) == 1 {
+				setInPlace(nt, ent.idx, newFlatLeaf(kvs[0].Key, kvs[0].Val))
+			} else {
+				setInPlace(nt, ent.idx, newCollisionLeaf(kvs))
+			}
+		}
+	}
+
+	return nt
+}
+
+// Filter returns a new Hamt with only the key/val pairs of h for which pred
+// returns true. It is one walk of the trie: leaves failing pred are
+// dropped, emptied tables are removed, and fullTables left with fewer than
+// DowngradeThreshold entries are downgraded, as by Del. Subtrees where every
+// pair passes are shared with h.
+func (h Hamt) Filter(pred func(k key.Key, v interface{}) bool) Hamt {
+	if h.IsEmpty() {
+		return h
+	}
+
+	var nh = h
+	var root, changed = nh.filterTable(h.root, 0, pred)
+	if !changed {
+		return h
+	}
+	if root == nil {
+		return Hamt{assert: h.assert, cfg: h.cfg}
+	}
+	nh.root = root
+	nh.seal()
+	nh.checkAll("Filter")
+	return nh
+}
+
+// filterTable returns t without the pairs failing pred, or nil if none pass,
+// and whether any pair failed. It subtracts the failing pairs from
+// nh.nentries and nh.nbytes.
+func (nh *Hamt) filterTable(t tableI, depth uint, pred func(k key.Key, v interface{}) bool) (tableI, bool) {
+	var ents = t.entries()
+	var kept = make([]tableEntry, 0, len(ents))
+	var changed bool
+	for _, ent := range ents {
+		var node nodeI
+		var nodeChanged bool
+		switch x := ent.node.(type) {
+		case tableI:
+			var nt tableI
+			nt, nodeChanged = nh.filterTable(x, depth+1, pred)
+			if nt != nil {
+				node = nt
+			}
+		case leafI:
+			var nl leafI
+			nl, nodeChanged = nh.filterLeaf(x, pred)
+			if nl != nil {
+				node = nl
+			}
+		}
+		if node != nil {
+			kept = append(kept, tableEntry{ent.idx, node})
+		}
+		changed = changed || nodeChanged
+	}
+
+	if !changed {
+		return t, false
+	}
+	if len(kept) == 0 {
+		return nil, true
+	}
+
+	var cfg = nh.conf()
+	if _, isFull := t.(*fullTable); isFull && !(cfg.gradeTables && uint(len(kept)) < cfg.downgradeThreshold) {
+		return upgradeToFullTable(t.Hash60(), kept), true
+	}
+	return downgradeToCompressedTable(t.Hash60(), kept), true
+}
+
+// filterLeaf returns l without the pairs failing pred, or nil if none pass,
+// and whether any pair failed.
+func (nh *Hamt) filterLeaf(l leafI, pred func(k key.Key, v interface{}) bool) (leafI, bool) {
+	var kvs = l.keyVals()
+	var kept = kvs[:0]
+	for _, kv := range kvs {
+		if pred(userKey(kv.Key), decompressVal(kv.Val)) {
+			kept = append(kept, kv)
+		} else {
+			nh.nentries--
+			nh.nbytes -= nh.conf().entrySize(kv.Key, kv.Val)
+		}
+	}
+
+	switch {
+	case len(kept) == len(kvs):
+		return l, false
+	case len(kept) == 0:
+		return nil, true
+	case len(kept) == 1:
+		return newFlatLeaf(kept[0].Key, kept[0].Val), true
+	}
+	return newCollisionLeaf(kept), true
+}
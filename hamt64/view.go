@@ -0,0 +1,79 @@
+package hamt64
+
+import (
+	"iter"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// View is a read-only view of a Hamt. It exposes only the query methods of
+// a Hamt; so a consumer handed a View can not create new versions of the
+// Hamt it was made from. The owner of the Hamt keeps control of its lineage.
+type View struct {
+	h Hamt
+}
+
+// ReadOnly returns a View of the Hamt.
+func (h Hamt) ReadOnly() View {
+	return View{h}
+}
+
+// IsEmpty returns true if the viewed Hamt has no entries.
+func (v View) IsEmpty() bool {
+	return v.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the viewed Hamt.
+func (v View) Nentries() uint {
+	return v.h.Nentries()
+}
+
+// Get retrieves the value for a given key from the viewed Hamt. The bool
+// represents whether the key was found.
+func (v View) Get(k key.Key) (interface{}, bool) {
+	return v.h.Get(k)
+}
+
+func (v View) String() string {
+	return "View{ " + v.h.String() + " }"
+}
+
+func (v View) LongString(indent string) string {
+	return v.h.LongString(indent)
+}
+
+// HashQuality returns the HashQuality() of the viewed Hamt.
+func (v View) HashQuality() []DepthQuality {
+	return v.h.HashQuality()
+}
+
+// RangeKeys calls fn for every key of the viewed Hamt; see Hamt.RangeKeys().
+func (v View) RangeKeys(fn func(k key.Key) bool) {
+	v.h.RangeKeys(fn)
+}
+
+// Iter returns an Iter over the viewed Hamt; see Hamt.Iter().
+func (v View) Iter() *Iter {
+	return v.h.Iter()
+}
+
+// Range calls fn for every key/val pair of the viewed Hamt; see
+// Hamt.Range().
+func (v View) Range(fn func(k key.Key, v interface{}) bool) {
+	v.h.Range(fn)
+}
+
+// All returns an iter.Seq2 of the viewed Hamt; see Hamt.All().
+func (v View) All() iter.Seq2[key.Key, interface{}] {
+	return v.h.All()
+}
+
+// Keys returns every key of the viewed Hamt; see Hamt.Keys().
+func (v View) Keys() []key.Key {
+	return v.h.Keys()
+}
+
+// Values returns every value of the viewed Hamt; see Hamt.Values().
+func (v View) Values() []interface{} {
+	return v.h.Values()
+}
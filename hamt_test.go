@@ -1,22 +1,46 @@
 package hamt_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	stderrors "errors"
 	"flag"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"log"
 	"math/rand"
+	"net/http/httptest"
 	"os"
+	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/lleo/go-hamt-functional"
+	"github.com/lleo/go-hamt-functional/grpcserver"
 	"github.com/lleo/go-hamt-functional/hamt32"
 	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-functional/hamtcbor"
+	"github.com/lleo/go-hamt-functional/hamtg"
+	"github.com/lleo/go-hamt-functional/hamthttp"
+	"github.com/lleo/go-hamt-functional/hamtmsgpack"
+	"github.com/lleo/go-hamt-functional/hamtprom"
+	"github.com/lleo/go-hamt-functional/hamtsql"
+	"github.com/lleo/go-hamt-functional/hamttest"
+	"github.com/lleo/go-hamt-functional/hashers"
+	hamtv2 "github.com/lleo/go-hamt-functional/v2"
 	"github.com/lleo/go-hamt-key"
 	"github.com/lleo/go-hamt-key/stringkey"
-	"github.com/lleo/stringutil"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 // In a Hamt32 with 3149824 entries, there is 32 collisionLeafs. The creation
@@ -35,7 +59,7 @@ var KVS []key.KeyVal
 var TestHamt32 hamt32.Hamt
 var TestHamt64 hamt64.Hamt
 
-var Inc = stringutil.Lower.Inc
+var Inc = hamttest.Inc
 
 var StartTime = make(map[string]time.Time)
 var RunTime = make(map[string]time.Duration)
@@ -302,39 +326,19 @@ func buildKeyVals(prefix string, num int, initStr string, initVal int) []key.Key
 	var name = fmt.Sprintf("%s++buildKeyVals#%d", prefix, num)
 	StartTime[name] = time.Now()
 
-	var kvs = make([]key.KeyVal, num)
-	var s = initStr
-
-	var limit = initVal + num
-	for i := initVal; i < limit; i++ {
-		var k = stringkey.New(s)
-
-		kvs[i] = key.KeyVal{k, i}
-		s = Inc(s)
-	}
+	var kvs = hamttest.SequentialKeyVals(num, initStr, initVal)
 
 	RunTime[name] = time.Since(StartTime[name])
 	return kvs
 }
 
-//First genRandomizedSvs() copies []KeyVal passed in. Then it randomizes that
+//First genRandomizedKvs() copies []KeyVal passed in. Then it randomizes that
 //copy in-place. Finnally, it returns the randomized copy.
 func genRandomizedKvs(kvs []key.KeyVal) []key.KeyVal {
 	var name = "genRandomizedKvs"
 	StartTime[name] = time.Now()
 
-	var randKvs = make([]key.KeyVal, len(kvs))
-	copy(randKvs, kvs)
-
-	//From: https://en.wikipedia.org/wiki/Fisher%E2%80%93Yates_shuffle#The_modern_algorithm
-	var n = len(randKvs) // n is the number of elements
-	var limit = n - 1
-	for i := 0; i < limit; /* aka i_max = n-2 */ i++ {
-		j := n - rand.Intn(i+1) - 1 // i <= j < n
-		// j_min = 0   => n - (i_max + 1) - 1 = n - (n-2 + 1) - 1 = n-n+2-1-1 = 0
-		// j_max = n-1 => n - 0 - 1 = n - 1
-		randKvs[i], randKvs[j] = randKvs[j], randKvs[i]
-	}
+	var randKvs = hamttest.Shuffle(kvs)
 
 	RunTime[name] = time.Since(StartTime[name])
 	return randKvs
@@ -419,3 +423,961 @@ func TestGlobal64(t *testing.T) {
 		t.Fatalf("new h1.Nentries(),%d != 1", h1.Nentries())
 	}
 }
+
+func TestMinimize(t *testing.T) {
+	// The "bug" needs op 13 and op 57, in that order.
+	var fails = func(idxs []int) bool {
+		var saw13 bool
+		for _, i := range idxs {
+			if i == 13 {
+				saw13 = true
+			}
+			if i == 57 && saw13 {
+				return true
+			}
+		}
+		return false
+	}
+
+	var min = hamttest.Minimize(100, fails)
+	if len(min) != 2 || min[0] != 13 || min[1] != 57 {
+		t.Fatalf("Minimize() = %v; expected [13 57]", min)
+	}
+
+	if min = hamttest.Minimize(100, func([]int) bool { return false }); min != nil {
+		t.Fatalf("Minimize() of a passing sequence = %v; expected nil", min)
+	}
+}
+
+func TestOpLog(t *testing.T) {
+	var ops = []hamttest.LogOp{
+		{Key: "aaa", Val: "1"},
+		{Key: "a b\"c", Val: "two words"},
+		{Del: true, Key: "aaa"},
+	}
+
+	var buf bytes.Buffer
+	if err := hamttest.WriteOpLog(&buf, ops); err != nil {
+		t.Fatalf("WriteOpLog failed: %s", err)
+	}
+	var ops1, err = hamttest.ReadOpLog(&buf)
+	if err != nil {
+		t.Fatalf("ReadOpLog failed: %s", err)
+	}
+	if len(ops1) != len(ops) {
+		t.Fatalf("ReadOpLog returned %d ops; expected %d", len(ops1), len(ops))
+	}
+	for i := range ops {
+		if ops1[i] != ops[i] {
+			t.Fatalf("ops1[%d],%s != %s", i, ops1[i], ops[i])
+		}
+	}
+
+	if _, err = hamttest.ReadOpLog(strings.NewReader("get \"aaa\"\n")); err == nil {
+		t.Fatal("ReadOpLog accepted an unknown operation")
+	}
+
+	var s = hamt32.NewShadow(hamt32.Hamt{})
+	for _, op := range ops1 {
+		if op.Del {
+			s, _, _ = s.Del(stringkey.New(op.Key))
+		} else {
+			s, _ = s.Put(stringkey.New(op.Key), op.Val)
+		}
+	}
+	if s.Nentries() != 1 {
+		t.Fatalf("replayed OpLog has %d entries; expected 1", s.Nentries())
+	}
+}
+
+// dropEvery is a Map that loses every n'th Put.
+type dropEvery struct {
+	hamttest.Map
+	n, puts int
+}
+
+func (m *dropEvery) Put(k key.Key, v interface{}) bool {
+	m.puts++
+	if m.puts%m.n == 0 {
+		return true
+	}
+	return m.Map.Put(k, v)
+}
+
+func TestRunOps(t *testing.T) {
+	var ops = hamttest.RandomOps(5000, 500, 0.3, rand.New(rand.NewSource(1)))
+
+	var maps = map[string]hamttest.Map{
+		"hamt32.Hamt":    &hamttest.Persistent[hamt32.Hamt]{},
+		"hamt64.Hamt":    &hamttest.Persistent[hamt64.Hamt]{},
+		"hamt32.Builder": hamt32.Hamt{}.Builder(),
+		"hamt64.Builder": hamt64.Hamt{}.Builder(),
+		"Model":          hamttest.Model{},
+	}
+	for name, m := range maps {
+		if err := hamttest.RunOps(m, ops); err != nil {
+			t.Fatalf("RunOps(%s) => %v", name, err)
+		}
+	}
+
+	var newBuggy = func() hamttest.Map {
+		return &dropEvery{&hamttest.Persistent[hamt64.Hamt]{}, 100, 0}
+	}
+	var err = hamttest.RunOps(newBuggy(), ops)
+	var mismatch *hamttest.Mismatch
+	if !stderrors.As(err, &mismatch) || mismatch.Index < 99 {
+		t.Fatalf("RunOps() of a Map losing every 100th Put => %v", err)
+	}
+
+	var min = hamttest.Minimize(len(ops), hamttest.OpsFail(newBuggy, ops))
+	if len(min) != 100 {
+		t.Fatalf("Minimize() of a Map losing every 100th Put => %d ops; want 100", len(min))
+	}
+}
+
+func TestFacade(t *testing.T) {
+	for _, w := range []hamt.Width{hamt.Hamt32, hamt.Hamt64} {
+		var h = hamt.New(w, hamt.WithGradeTables(false), hamt.WithHasher(hashers.XXHash64{}))
+		if s := h.String(); s != "Hamt{ nentries: 0, root: nil }" {
+			t.Fatalf("Width %d: empty h.String() => %q", w, s)
+		}
+		if h.Width() != w {
+			t.Fatalf("hamt.New(%d).Width() => %d", w, h.Width())
+		}
+		for _, kv := range KVS[:1000] {
+			h, _ = h.Put(kv.Key, kv.Val)
+		}
+		if h.Nentries() != 1000 {
+			t.Fatalf("Width %d: h.Nentries(),%d != 1000", w, h.Nentries())
+		}
+
+		var val, found = h.Get(KVS[10].Key)
+		if !found || val != KVS[10].Val {
+			t.Fatalf("Width %d: h.Get(%s) => %v, %t", w, KVS[10].Key, val, found)
+		}
+
+		var deleted bool
+		h, val, deleted = h.Del(KVS[10].Key)
+		if !deleted || val != KVS[10].Val || h.Nentries() != 999 {
+			t.Fatalf("Width %d: h.Del(%s) => %v, %t", w, KVS[10].Key, val, deleted)
+		}
+
+		var n int
+		h.Range(func(k key.Key, v interface{}) bool {
+			n++
+			return true
+		})
+		if n != 999 {
+			t.Fatalf("Width %d: h.Range() visited %d entries; want 999", w, n)
+		}
+		if s := h.LongString("  "); !strings.HasPrefix(s, "  Hamt{ nentries: 999, root:\n") {
+			t.Fatalf("Width %d: h.LongString() => %q...", w, s[:40])
+		}
+
+		var copies int
+		var in = hamt.NewInterner(func(v interface{}) uint64 { return uint64(v.(int)) }, func(a, b interface{}) bool { return a == b }, 10)
+		var h2 = hamt.New(w, hamt.WithAssertLevel(hamt.AssertCheap), hamt.WithValueInterner(in),
+			hamt.WithCopyHook(func(op string, k key.Key, copied, pathLen int) { copies++ }))
+		h2, _ = h2.Put(KVS[0].Key, 0)
+		if copies != 1 {
+			t.Fatalf("Width %d: the CopyHook was called %d times; want 1", w, copies)
+		}
+
+		switch w {
+		case hamt.Hamt32:
+			if _, ok := h.Unwrap().(hamt32.Hamt); !ok {
+				t.Fatalf("h.Unwrap() is a %T", h.Unwrap())
+			}
+			if lvl := h2.Unwrap().(hamt32.Hamt).AssertLevel(); lvl != hamt32.AssertCheap {
+				t.Fatalf("hamt.WithAssertLevel(AssertCheap) => %s", lvl)
+			}
+		case hamt.Hamt64:
+			if _, ok := h.Unwrap().(hamt64.Hamt); !ok {
+				t.Fatalf("h.Unwrap() is a %T", h.Unwrap())
+			}
+			if lvl := h2.Unwrap().(hamt64.Hamt).AssertLevel(); lvl != hamt64.AssertCheap {
+				t.Fatalf("hamt.WithAssertLevel(AssertCheap) => %s", lvl)
+			}
+		}
+	}
+}
+
+func TestV2Map(t *testing.T) {
+	var m = hamtv2.New[int](hamtv2.WithAssertLevel(hamt64.AssertCheap))
+
+	var k = stringkey.New("aaa")
+	if _, err := m.Get(k); !stderrors.Is(err, hamtv2.ErrKeyNotFound) || !stderrors.Is(err, hamt64.ErrKeyNotFound) {
+		t.Fatalf("m.Get(%s) on empty Map returned %v", k, err)
+	}
+
+	var m1, err = m.Put(k, 1)
+	if err != nil {
+		t.Fatalf("m.Put(%s) failed: %s", k, err)
+	}
+	if val, err := m1.Get(k); err != nil || val != 1 {
+		t.Fatalf("m1.Get(%s) => %d, %v; expected 1", k, val, err)
+	}
+
+	m1, err = m1.PutAll(KVS[:1000])
+	if err != nil {
+		t.Fatalf("m1.PutAll() failed: %s", err)
+	}
+	if m1.Len() != 1000 {
+		t.Fatalf("m1.Len(),%d != 1000", m1.Len())
+	}
+
+	var sum int
+	m1.Range(func(_ key.Key, v int) bool {
+		sum += v
+		return true
+	})
+	if sum != 999*1000/2 {
+		t.Fatalf("sum of values,%d != %d", sum, 999*1000/2)
+	}
+
+	if _, _, err = m.Del(k); !stderrors.Is(err, hamtv2.ErrKeyNotFound) {
+		t.Fatalf("m.Del(%s) on empty Map returned %v", k, err)
+	}
+	if _, err = m.Put(nil, 1); !stderrors.Is(err, hamtv2.ErrNilKey) {
+		t.Fatalf("m.Put(nil) returned %v", err)
+	}
+	var me, _ = hamtv2.New[error]().Put(k, nil)
+	if val, err := me.Get(k); err != nil || val != nil {
+		t.Fatalf("me.Get(%s) => %v, %v; expected the nil error", k, val, err)
+	}
+	me.Range(func(_ key.Key, v error) bool {
+		if v != nil {
+			t.Fatalf("me.Range() passed %v; expected the nil error", v)
+		}
+		return true
+	})
+	if _, val, err := me.Del(k); err != nil || val != nil {
+		t.Fatalf("me.Del(%s) => %v, %v; expected the nil error", k, val, err)
+	}
+	var nilkvs = []key.KeyVal{{Key: k, Val: nil}}
+	if _, err = hamtv2.New[error]().PutAll(nilkvs); err != nil {
+		t.Fatalf("PutAll() of a nil error failed: %s", err)
+	}
+	if _, err = hamtv2.New[int]().PutAll(nilkvs); err == nil {
+		t.Fatal("PutAll() of a nil int succeeded")
+	}
+
+	// PutAll accepts the nil of a pointer V, as Put does.
+	var mp hamtv2.Map[*int]
+	if mp, err = hamtv2.New[*int]().PutAll(nilkvs); err != nil {
+		t.Fatalf("PutAll() of a nil *int failed: %s", err)
+	}
+	if val, err := mp.Get(k); err != nil || val != nil {
+		t.Fatalf("mp.Get(%s) => %v, %v; expected the nil *int", k, val, err)
+	}
+	var pp, _ = hamtv2.New[*int]().Put(k, nil)
+	if !mp.Unwrap().Equal(pp.Unwrap(), nil) {
+		t.Fatal("PutAll() of a nil *int stored a value other than Put() of it")
+	}
+}
+
+func TestHamtg(t *testing.T) {
+	var h = hamtg.Hamt[*stringkey.StringKey, string]{}
+
+	var k = stringkey.New("aaa")
+	var h1, added = h.Put(k, "one")
+	if !added {
+		t.Fatalf("failed to add k=%s", k)
+	}
+
+	var val, found = h1.Get(k)
+	if !found || val != "one" {
+		t.Fatalf("h1.Get(%s) => %q, %t; expected \"one\"", k, val, found)
+	}
+	if val, found = h.Get(k); found || val != "" {
+		t.Fatalf("h.Get(%s) on empty Hamt => %q, %t", k, val, found)
+	}
+
+	var h2, old, deleted = h1.Del(k)
+	if !deleted || old != "one" || !h2.IsEmpty() {
+		t.Fatalf("h1.Del(%s) => %s, %q, %t", k, h2, old, deleted)
+	}
+	var he, _ = hamtg.Hamt[*stringkey.StringKey, error]{}.Put(k, nil)
+	if val, found := he.Get(k); !found || val != nil {
+		t.Fatalf("he.Get(%s) => %v, %t; expected the nil error", k, val, found)
+	}
+	if _, val, deleted := he.Del(k); !deleted || val != nil {
+		t.Fatalf("he.Del(%s) => %v, %t; expected the nil error", k, val, deleted)
+	}
+}
+
+func TestHamtCBOR(t *testing.T) {
+	var a = hamt64.New().PutMany(KVS[:1000])
+	var b = hamt64.New(hamt64.WithFullTableInit(true))
+	for _, kv := range hamttest.Shuffle(KVS[:1000]) {
+		b, _ = b.Put(kv.Key, kv.Val)
+	}
+	var k = stringkey.New("nested")
+	var v = map[string]interface{}{"b": []interface{}{1.5, -3, "x"}, "a": []byte{1}, "c": nil}
+	a, _ = a.Put(k, v)
+	b, _ = b.Put(k, v)
+
+	var adata, err = hamtcbor.Marshal(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bdata, _ = hamtcbor.Marshal(b)
+	if !bytes.Equal(adata, bdata) {
+		t.Fatal("Hamts with the same entries marshaled to different CBOR")
+	}
+
+	var h hamt64.Hamt
+	if h, err = hamtcbor.Unmarshal(adata); err != nil {
+		t.Fatal(err)
+	}
+	if !h.Equal(a, nil) {
+		t.Fatal("the unmarshaled Hamt is not Equal to the original")
+	}
+
+	for _, bad := range [][]byte{adata[:len(adata)-1], append(adata, 0), {0xa1, 0x61, 'a'}} {
+		if _, err = hamtcbor.Unmarshal(bad); !stderrors.Is(err, hamt64.ErrCorruptSnapshot) {
+			t.Fatalf("Unmarshal() of corrupt CBOR => %v", err)
+		}
+	}
+	// {"b": 1, "a": 2} is valid CBOR, but not deterministic.
+	if _, err = hamtcbor.Unmarshal([]byte{0xa2, 0x61, 'b', 0x01, 0x61, 'a', 0x02}); !stderrors.Is(err, hamt64.ErrCorruptSnapshot) {
+		t.Fatalf("Unmarshal() of an unordered map => %v", err)
+	}
+}
+
+func TestHamtMsgpack(t *testing.T) {
+	var h = hamt64.New().PutMany(KVS[:1000])
+	var vals = []interface{}{
+		nil, true, -1, -40, -40000, -3000000000, 200, 70000, uint64(1 << 63),
+		float32(0.5), 2.25, strings.Repeat("s", 40), []byte{1, 2},
+		map[string]interface{}{"x": []interface{}{"y", false}},
+	}
+	for i, v := range vals {
+		h, _ = h.Put(stringkey.New(fmt.Sprintf("val%d", i)), v)
+	}
+
+	var data, err = hamtmsgpack.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h1 hamt64.Hamt
+	if h1, err = hamtmsgpack.Unmarshal(data); err != nil {
+		t.Fatal(err)
+	}
+	// float32(0.5) decodes as a float64
+	if !h1.Equal(h, func(v1, v2 interface{}) bool {
+		if f, ok := v2.(float32); ok {
+			v2 = float64(f)
+		}
+		return reflect.DeepEqual(v1, v2)
+	}) {
+		t.Fatal("the unmarshaled Hamt is not Equal to the original")
+	}
+
+	// {"a": 1, "b": [true, nil]} as encoded by another msgpack library
+	h1, err = hamtmsgpack.Unmarshal([]byte{0x82, 0xa1, 'a', 0x01, 0xa1, 'b', 0x92, 0xc3, 0xc0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := h1.Get(stringkey.New("b")); !reflect.DeepEqual(val, []interface{}{true, nil}) {
+		t.Fatalf("h1.Get(\"b\") => %#v", val)
+	}
+
+	for _, bad := range [][]byte{data[:len(data)-1], append(data, 0), {0x81, 0x01, 0x01}} {
+		if _, err = hamtmsgpack.Unmarshal(bad); !stderrors.Is(err, hamt64.ErrCorruptSnapshot) {
+			t.Fatalf("Unmarshal() of corrupt msgpack => %v", err)
+		}
+	}
+}
+
+// failWriter fails every Write after the first n bytes.
+type failWriter struct {
+	n int
+}
+
+var errWriteFailed = stderrors.New("write failed")
+
+func (w *failWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		var n = w.n
+		w.n = 0
+		return n, errWriteFailed
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// structCodec is a Codec, of hamt32 and hamt64, of *stringkey.StringKey
+// keys and struct{} values.
+type structCodec struct{}
+
+func (structCodec) AppendKey(buf []byte, k key.Key) ([]byte, error) {
+	return hamt64.StringCodec{}.AppendKey(buf, k)
+}
+
+func (structCodec) DecodeKey(data []byte) (key.Key, error) {
+	return hamt64.StringCodec{}.DecodeKey(data)
+}
+
+func (structCodec) AppendVal(buf []byte, v interface{}) ([]byte, error) {
+	if v != struct{}{} {
+		return buf, fmt.Errorf("structCodec can not encode a value of type %T", v)
+	}
+	return buf, nil
+}
+
+func (structCodec) DecodeVal(data []byte) (interface{}, error) {
+	if len(data) != 0 {
+		return nil, fmt.Errorf("structCodec value has %d bytes", len(data))
+	}
+	return struct{}{}, nil
+}
+
+func TestHamtHTTP(t *testing.T) {
+	var a = hamt64.NewAtomicHamt(hamt64.Hamt{}.PutMany(KVS[:250]))
+	var s = hamthttp.NewServer(a)
+	var get = func(target string, body interface{}) int {
+		var rec = httptest.NewRecorder()
+		s.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		switch b := body.(type) {
+		case nil:
+		case *[]byte:
+			*b = rec.Body.Bytes()
+		default:
+			if err := json.Unmarshal(rec.Body.Bytes(), body); rec.Code == 200 && err != nil {
+				t.Fatalf("GET %s returned bad JSON: %s", target, err)
+			}
+		}
+		return rec.Code
+	}
+
+	// Page through every entry of the current version.
+	var seen = make(map[string]bool)
+	var target = "/entries?limit=100"
+	var npages int
+	for {
+		var page hamthttp.Page
+		if code := get(target, &page); code != 200 {
+			t.Fatalf("GET %s => %d", target, code)
+		}
+		npages++
+		if page.Nentries != 250 {
+			t.Fatalf("GET %s => nentries %d; want 250", target, page.Nentries)
+		}
+		for _, e := range page.Entries {
+			if seen[e.Key] {
+				t.Fatalf("GET /entries returned %s twice", e.Key)
+			}
+			seen[e.Key] = true
+		}
+		if page.Next == "" {
+			break
+		}
+		target = "/entries?limit=100&cursor=" + page.Next
+	}
+	if len(seen) != 250 || npages != 3 {
+		t.Fatalf("GET /entries returned %d keys in %d pages; want 250 in 3", len(seen), npages)
+	}
+
+	// Snapshots and the Diff of labeled versions.
+	var v1 = s.Label("v1")
+	a.Update(func(h hamt64.Hamt) hamt64.Hamt {
+		h, _ = h.Put(KVS[250].Key, KVS[250].Val)
+		h, _, _ = h.Del(KVS[0].Key)
+		return h
+	})
+	var data []byte
+	if code := get("/snapshots/v1", &data); code != 200 {
+		t.Fatalf("GET /snapshots/v1 => %d", code)
+	}
+	var h hamt64.Hamt
+	if err := h.UnmarshalBinary(data); err != nil || !h.Equal(v1, nil) {
+		t.Fatalf("GET /snapshots/v1 is not the snapshot of v1: %v", err)
+	}
+	var changes []hamthttp.Change
+	if code := get("/diff?from=v1&to=current", &changes); code != 200 {
+		t.Fatalf("GET /diff => %d", code)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("GET /diff?from=v1&to=current => %v; want 2 Changes", changes)
+	}
+	for _, c := range changes {
+		if (c.Kind != "Added" || c.Key != KVS[250].Key.String()) && (c.Kind != "Removed" || c.Key != KVS[0].Key.String()) {
+			t.Fatalf("GET /diff?from=v1&to=current returned %+v", c)
+		}
+	}
+
+	s.Unlabel("v1")
+	for _, target := range []string{"/snapshots/v1", "/diff?from=v1&to=current"} {
+		if code := get(target, nil); code != 404 {
+			t.Fatalf("GET %s of an unlabeled version => %d; want 404", target, code)
+		}
+	}
+	for _, target := range []string{"/entries?limit=0", "/entries?cursor=AA"} {
+		if code := get(target, nil); code != 400 {
+			t.Fatalf("GET %s => %d; want 400", target, code)
+		}
+	}
+	var rec = httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("POST", "/entries", nil))
+	if rec.Code != 405 {
+		t.Fatalf("POST /entries => %d; want 405", rec.Code)
+	}
+}
+
+func TestGRPCServer(t *testing.T) {
+	var a = hamt64.NewAtomicHamt(hamt64.Hamt{})
+	var s = grpcserver.NewServer(a)
+	var ctx = context.Background()
+
+	for i := 0; i < 250; i++ {
+		var req = &grpcserver.PutRequest{Key: fmt.Sprintf("k%03d", i), Val: []byte{byte(i)}}
+		if resp, err := s.Put(ctx, req); err != nil || !resp.Changed || resp.Version != uint64(i)+2 {
+			t.Fatalf("s.Put(%s) => %+v, %v", req.Key, resp, err)
+		}
+	}
+	if resp, err := s.Get(ctx, &grpcserver.GetRequest{Key: "k010"}); err != nil || !resp.Found || !bytes.Equal(resp.Val, []byte{10}) {
+		t.Fatalf("s.Get(k010) => %+v, %v", resp, err)
+	}
+
+	// Page through every entry of the current version.
+	var seen = make(map[string]bool)
+	var req = &grpcserver.ScanRequest{Limit: 100}
+	var npages int
+	for {
+		var page, err = s.Scan(ctx, req)
+		if err != nil {
+			t.Fatalf("s.Scan(%+v) failed: %s", req, err)
+		}
+		npages++
+		for _, e := range page.Entries {
+			if seen[e.Key] {
+				t.Fatalf("s.Scan() returned %s twice", e.Key)
+			}
+			seen[e.Key] = true
+		}
+		if len(page.Next) == 0 {
+			break
+		}
+		req = &grpcserver.ScanRequest{Limit: 100, Cursor: page.Next}
+	}
+	if len(seen) != 250 || npages != 3 {
+		t.Fatalf("s.Scan() returned %d keys in %d pages; want 250 in 3", len(seen), npages)
+	}
+
+	// Snapshots and the Diff of labeled versions.
+	var snap, err = s.Snapshot(ctx, &grpcserver.SnapshotRequest{Label: "v1"})
+	if err != nil {
+		t.Fatalf("s.Snapshot(v1) failed: %s", err)
+	}
+	var h hamt64.Hamt
+	if err = h.UnmarshalBinary(snap.Data); err != nil || !h.Equal(a.Load(), nil) {
+		t.Fatalf("s.Snapshot(v1) is not the snapshot of the current version: %v", err)
+	}
+	s.Put(ctx, &grpcserver.PutRequest{Key: "new", Val: []byte("x")})
+	if resp, err := s.Del(ctx, &grpcserver.DelRequest{Key: "k000", IfVersion: snap.Version + 1}); err != nil || !resp.Changed {
+		t.Fatalf("s.Del(k000) of the current version => %+v, %v", resp, err)
+	}
+	var diff *grpcserver.DiffResponse
+	if diff, err = s.Diff(ctx, &grpcserver.DiffRequest{From: "v1", To: grpcserver.Current}); err != nil {
+		t.Fatalf("s.Diff(v1, current) failed: %s", err)
+	}
+	if len(diff.Changes) != 2 {
+		t.Fatalf("s.Diff(v1, current) => %+v; want 2 Changes", diff.Changes)
+	}
+	for _, c := range diff.Changes {
+		if (c.Kind != "Added" || c.Key != "new") && (c.Kind != "Removed" || c.Key != "k000" || !bytes.Equal(c.Old, []byte{0})) {
+			t.Fatalf("s.Diff(v1, current) returned %+v", c)
+		}
+	}
+
+	// A write of a stale version fails.
+	if _, err = s.Put(ctx, &grpcserver.PutRequest{Key: "k001", Val: nil, IfVersion: snap.Version}); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("s.Put() of a stale version => %v; want FailedPrecondition", err)
+	}
+	if resp, _ := s.Get(ctx, &grpcserver.GetRequest{Key: "k001"}); !bytes.Equal(resp.Val, []byte{1}) {
+		t.Fatalf("s.Put() of a stale version wrote %v", resp.Val)
+	}
+
+	s.Unlabel("v1")
+	if _, err = s.Diff(ctx, &grpcserver.DiffRequest{From: "v1", To: grpcserver.Current}); status.Code(err) != codes.NotFound {
+		t.Fatalf("s.Diff() of an unlabeled version => %v; want NotFound", err)
+	}
+	for _, req := range []*grpcserver.ScanRequest{{Limit: -1}, {Cursor: []byte{0}}} {
+		if _, err = s.Scan(ctx, req); status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("s.Scan(%+v) => %v; want InvalidArgument", req, err)
+		}
+	}
+}
+
+func TestPrometheusCollector(t *testing.T) {
+	var a = hamt64.NewAtomicHamt(hamt64.Hamt{})
+	var c = hamtprom.NewCollector(a)
+	a.Store(hamt64.New(hamt64.WithGradeTables(true), hamt64.WithInstruments(c)))
+
+	var reg = prometheus.NewPedanticRegistry()
+	reg.MustRegister(c)
+
+	a.Update(func(h hamt64.Hamt) hamt64.Hamt {
+		for i := 0; i < 5000; i++ {
+			h, _ = h.Put(stringkey.New(fmt.Sprint(i)), i)
+		}
+		for i := 0; i <= 4000; i++ {
+			h, _, _ = h.Del(stringkey.New(fmt.Sprint(i)))
+		}
+		return h
+	})
+
+	var mfs, err = reg.Gather()
+	if err != nil {
+		t.Fatalf("reg.Gather() failed: %s", err)
+	}
+	var values = make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			switch {
+			case m.GetGauge() != nil:
+				values[mf.GetName()] += m.GetGauge().GetValue()
+			case m.GetCounter() != nil:
+				values[mf.GetName()] += m.GetCounter().GetValue()
+			case m.GetHistogram() != nil:
+				values[mf.GetName()] += float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	var h = a.Load()
+	if values["hamt_entries"] != float64(h.Nentries()) || values["hamt_entries_by_depth"] != float64(h.Nentries()) {
+		t.Fatalf("hamt_entries=%v, hamt_entries_by_depth=%v; want %d", values["hamt_entries"], values["hamt_entries_by_depth"], h.Nentries())
+	}
+	var s = h.Stats()
+	if values["hamt_tables"] != float64(s.CompressedTables+s.FullTables) {
+		t.Fatalf("hamt_tables=%v; want %d", values["hamt_tables"], s.CompressedTables+s.FullTables)
+	}
+	if values["hamt_table_upgrades_total"] == 0 || values["hamt_table_downgrades_total"] == 0 {
+		t.Fatalf("hamt_table_upgrades_total=%v, hamt_table_downgrades_total=%v; want both > 0", values["hamt_table_upgrades_total"], values["hamt_table_downgrades_total"])
+	}
+	if values["hamt_op_duration_seconds"] != 5000+4001 {
+		t.Fatalf("hamt_op_duration_seconds counted %v ops; want %d", values["hamt_op_duration_seconds"], 5000+4001)
+	}
+}
+
+// fakeSQL is a database/sql driver of single table databases, just enough
+// for hamtsql: a CREATE is recorded, a DELETE forgets the rows, an INSERT
+// appends one, and a SELECT returns the first two columns of every row. A
+// transaction is undone by restoring the rows it began with.
+type fakeSQL struct {
+	mu     sync.Mutex
+	create string
+	rows   [][]driver.Value
+	saved  [][]driver.Value
+}
+
+func (db *fakeSQL) Connect(context.Context) (driver.Conn, error) { return db, nil }
+func (db *fakeSQL) Driver() driver.Driver                        { return db }
+func (db *fakeSQL) Open(string) (driver.Conn, error)             { return db, nil }
+func (db *fakeSQL) Close() error                                 { return nil }
+func (db *fakeSQL) Begin() (driver.Tx, error) {
+	db.saved = append([][]driver.Value(nil), db.rows...)
+	return db, nil
+}
+func (db *fakeSQL) Commit() error   { return nil }
+func (db *fakeSQL) Rollback() error { db.rows = db.saved; return nil }
+func (db *fakeSQL) Prepare(query string) (driver.Stmt, error) {
+	return fakeStmt{db, query}, nil
+}
+
+type fakeStmt struct {
+	db    *fakeSQL
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	switch strings.Fields(s.query)[0] {
+	case "CREATE":
+		s.db.create = s.query
+	case "DELETE":
+		s.db.rows = nil
+	case "INSERT":
+		s.db.rows = append(s.db.rows, append([]driver.Value(nil), args...))
+	}
+	return driver.RowsAffected(1), nil
+}
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{rows: s.db.rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return []string{"key", "value"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0][:2])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestHamtSQL(t *testing.T) {
+	var fake = new(fakeSQL)
+	var db = sql.OpenDB(fake)
+	defer db.Close()
+
+	var h = hamt64.New().PutMany(KVS[:1000])
+	h, _ = h.Put(stringkey.New("bytes"), []byte{1, 2})
+	var size = hamtsql.Column{Name: "size", Type: "INTEGER", Value: func(_ key.Key, v interface{}) interface{} {
+		return len(fmt.Sprint(v))
+	}}
+	var err = hamtsql.Dump(db, "entries", h, size)
+	if err != nil {
+		t.Fatalf("hamtsql.Dump() failed: %s", err)
+	}
+	if !strings.Contains(fake.create, "entries (key BLOB PRIMARY KEY, value BLOB NOT NULL, size INTEGER)") {
+		t.Fatalf("hamtsql.Dump() created the table by %q", fake.create)
+	}
+	if len(fake.rows) != 1001 || len(fake.rows[0]) != 3 {
+		t.Fatalf("hamtsql.Dump() wrote %d rows", len(fake.rows))
+	}
+
+	var h1 hamt64.Hamt
+	if h1, err = hamtsql.Load(db, "entries", hamt64.WithFullTableInit(true)); err != nil {
+		t.Fatalf("hamtsql.Load() failed: %s", err)
+	}
+	if !h1.Equal(h, reflect.DeepEqual) || h1.Stats().CompressedTables != 0 {
+		t.Fatal("hamtsql.Load() did not load the Hamt dumped, by the options given")
+	}
+
+	// A repaired table may hold a key twice; the last row wins.
+	fake.rows = append(fake.rows, []driver.Value{fake.rows[0][0], []byte{3, 2}}) // valInt 1
+	if h1, err = hamtsql.Load(db, "entries"); err != nil || h1.Nentries() != 1001 {
+		t.Fatalf("hamtsql.Load() of a repeated key => %v, %v", h1, err)
+	}
+	var k0, _ = hamt64.StringCodec{}.DecodeKey(fake.rows[0][0].([]byte))
+	if v, _ := h1.Get(k0); v != 1 {
+		t.Fatalf("hamtsql.Load() of a repeated key %s kept %v; want the last value 1", k0, v)
+	}
+
+	// A value the Codec can not encode leaves the table as it was.
+	var bad, _ = h.Put(stringkey.New("bad"), struct{}{})
+	var kerr *hamt64.KeyError
+	if err = hamtsql.Dump(db, "entries", bad); !stderrors.As(err, &kerr) {
+		t.Fatalf("hamtsql.Dump() of an unencodable value => %v; want a *KeyError", err)
+	}
+	if len(fake.rows) != 1002 {
+		t.Fatalf("a failed hamtsql.Dump() left %d rows", len(fake.rows))
+	}
+	fake.rows[0][1] = []byte{99}
+	if _, err = hamtsql.Load(db, "entries"); err == nil || !strings.Contains(err.Error(), "row 1") {
+		t.Fatalf("hamtsql.Load() of a bad value => %v", err)
+	}
+	if err = hamtsql.Dump(db, "entries; DROP", h); err == nil {
+		t.Fatal("hamtsql.Dump() into a bad table name succeeded")
+	}
+}
+
+func TestHamtCBORIPLD(t *testing.T) {
+	var h = hamt64.Hamt{}.PutMany(KVS[:2000])
+	var store = hamtcbor.MemBlockStore{}
+
+	var root, err = hamtcbor.MarshalIPLD(h, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store) < 2 {
+		t.Fatalf("MarshalIPLD() of %d entries wrote %d blocks", h.Nentries(), len(store))
+	}
+	if !strings.HasPrefix(root.String(), "bafy") {
+		t.Fatalf("root.String(),%s is not a base32 CIDv1 of a dag-cbor block", root)
+	}
+
+	var h1 hamt64.Hamt
+	if h1, err = hamtcbor.UnmarshalIPLD(store, root); err != nil {
+		t.Fatal(err)
+	}
+	if !h1.Equal(h, nil) {
+		t.Fatal("the unmarshaled Hamt is not Equal to the original")
+	}
+
+	var shuffled = hamt64.Hamt{}.PutMany(hamttest.Shuffle(KVS[:2000]))
+	if root1, _ := hamtcbor.MarshalIPLD(shuffled, hamtcbor.MemBlockStore{}); root1 != root {
+		t.Fatal("equal Hamts were written as different IPLD HashMaps")
+	}
+
+	var small = hamtcbor.MemBlockStore{}
+	var root2, _ = hamtcbor.MarshalIPLD(h, small, hamtcbor.WithBitWidth(4), hamtcbor.WithBucketSize(1))
+	if h1, err = hamtcbor.UnmarshalIPLD(small, root2); err != nil || !h1.Equal(h, nil) {
+		t.Fatalf("failed to round trip a bitWidth=4 bucketSize=1 HashMap; err=%v", err)
+	}
+
+	for c := range small {
+		if c == root2 {
+			continue
+		}
+		var block = small[c]
+		small[c] = append(block[:len(block):len(block)], 0)
+		if _, err = hamtcbor.UnmarshalIPLD(small, root2); !stderrors.Is(err, hamt64.ErrCorruptSnapshot) {
+			t.Fatalf("UnmarshalIPLD() with a block not matching its CID => %v", err)
+		}
+		delete(small, c)
+		if _, err = hamtcbor.UnmarshalIPLD(small, root2); !stderrors.Is(err, hamtcbor.ErrStoreUnavailable) || !stderrors.Is(err, hamt64.ErrStoreUnavailable) {
+			t.Fatalf("UnmarshalIPLD() with a missing block => %v", err)
+		}
+		break
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	var long = strings.Repeat("0123456789", 10)
+	var tests = []struct {
+		seed uint64
+		data string
+		want uint64
+	}{
+		{0, "", 0xef46db3751d8e999},
+		{0, "a", 0xd24ec4f1a98c6e5b},
+		{0, "abc", 0x44bc2cf5ad770999},
+		{0, "Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1},
+		{1, "", 0xd5afba1336a3be4b},
+	}
+	for _, test := range tests {
+		if got := (hashers.XXHash64{Seed: test.seed}).Hash([]byte(test.data)); got != test.want {
+			t.Fatalf("XXHash64{%d}.Hash(%q)=%#x; want %#x", test.seed, test.data, got, test.want)
+		}
+	}
+
+	var h = hamt64.New(hamt64.WithHasher(hashers.XXHash64{}))
+	var h32 = hamt32.New(hamt32.WithHasher(hashers.XXHash64{}))
+	for i := 0; i < 1000; i++ {
+		var k = stringkey.New(fmt.Sprintf("%s%d", long, i))
+		h, _ = h.Put(k, i)
+		h32, _ = h32.Put(k, i)
+	}
+	for i := 0; i < 1000; i++ {
+		var k = stringkey.New(fmt.Sprintf("%s%d", long, i))
+		if v, found := h.Get(k); !found || v != i {
+			t.Fatalf("h.Get(%s) => %v, %v", k, v, found)
+		}
+		if v, found := h32.Get(k); !found || v != i {
+			t.Fatalf("h32.Get(%s) => %v, %v", k, v, found)
+		}
+	}
+}
+
+func TestSipHash(t *testing.T) {
+	var secret [16]byte
+	var msg = make([]byte, 15)
+	for i := range secret {
+		secret[i] = byte(i)
+	}
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+
+	// The test vectors of the SipHash paper and reference implementation.
+	var s = hashers.NewSipHash(secret)
+	if got := s.Hash(nil); got != 0x726fdb47dd0e0e31 {
+		t.Fatalf("SipHash.Hash(nil)=%#x; want 0x726fdb47dd0e0e31", got)
+	}
+	if got := s.Hash(msg); got != 0xa129ca6149be45e5 {
+		t.Fatalf("SipHash.Hash(00..0e)=%#x; want 0xa129ca6149be45e5", got)
+	}
+
+	var other = secret
+	other[0] = 1
+	if hashers.NewSipHash(other).Hash(msg) == s.Hash(msg) {
+		t.Fatal("SipHashes of different secrets have the same hash")
+	}
+
+	var h = hamt64.New(hamt64.WithHasher(s)).PutMany(KVS[:1000])
+	for _, kv := range KVS[:1000] {
+		if v, found := h.Get(kv.Key); !found || v != kv.Val {
+			t.Fatalf("h.Get(%s) => %v, %v", kv.Key, v, found)
+		}
+	}
+}
+
+// longKeyData is 128 bytes; the key length where the hash function
+// dominates Put and Get.
+var longKeyData = []byte(strings.Repeat("0123456789abcdef", 8))
+
+func BenchmarkHashFNV1(b *testing.B) {
+	b.SetBytes(int64(len(longKeyData)))
+	for i := 0; i < b.N; i++ {
+		var h = fnv.New64()
+		h.Write(longKeyData)
+		h.Sum64()
+	}
+}
+
+func BenchmarkHashXXHash64(b *testing.B) {
+	b.SetBytes(int64(len(longKeyData)))
+	for i := 0; i < b.N; i++ {
+		hashers.XXHash64{}.Hash(longKeyData)
+	}
+}
+
+func BenchmarkHashSipHash(b *testing.B) {
+	var s = hashers.NewSipHash([16]byte{})
+	b.SetBytes(int64(len(longKeyData)))
+	for i := 0; i < b.N; i++ {
+		s.Hash(longKeyData)
+	}
+}
+
+// longKeyVals returns n key/val pairs whose keys are 128 bytes long.
+func longKeyVals(n int) []key.KeyVal {
+	var prefix = string(longKeyData[:120])
+	var kvs = make([]key.KeyVal, n)
+	for i := range kvs {
+		kvs[i] = key.KeyVal{Key: stringkey.New(fmt.Sprintf("%s%08d", prefix, i)), Val: i}
+	}
+	return kvs
+}
+
+func benchmarkHasherPut(b *testing.B, opts ...hamt64.Option) {
+	var kvs = longKeyVals(b.N)
+	b.ResetTimer()
+
+	var h = hamt64.New(opts...)
+	for _, kv := range kvs {
+		h, _ = h.Put(kv.Key, kv.Val)
+	}
+}
+
+func benchmarkHasherGet(b *testing.B, opts ...hamt64.Option) {
+	var kvs = longKeyVals(b.N)
+	var h = hamt64.New(opts...).PutMany(kvs)
+	b.ResetTimer()
+
+	for _, kv := range kvs {
+		if _, found := h.Get(kv.Key); !found {
+			b.Fatalf("h.Get(%s) not found", kv.Key)
+		}
+	}
+}
+
+// The FNV-1 benchmarks use the hash values stringkey.New computed when the
+// keys were built; the Hasher benchmarks hash every key on every Put and Get.
+func BenchmarkLongKeyPutFNV1(b *testing.B) {
+	benchmarkHasherPut(b)
+}
+
+func BenchmarkLongKeyPutXXHash64(b *testing.B) {
+	benchmarkHasherPut(b, hamt64.WithHasher(hashers.XXHash64{}))
+}
+
+func BenchmarkLongKeyGetFNV1(b *testing.B) {
+	benchmarkHasherGet(b)
+}
+
+func BenchmarkLongKeyGetXXHash64(b *testing.B) {
+	benchmarkHasherGet(b, hamt64.WithHasher(hashers.XXHash64{}))
+}
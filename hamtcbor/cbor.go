@@ -0,0 +1,332 @@
+package hamtcbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The CBOR major types; RFC 8949 section 3.1.
+const (
+	majorUint   byte = 0
+	majorNegInt byte = 1
+	majorBytes  byte = 2
+	majorText   byte = 3
+	majorArray  byte = 4
+	majorMap    byte = 5
+	majorTag    byte = 6
+	majorSimple byte = 7
+)
+
+// The CBOR simple values and float heads used by this package.
+const (
+	cborFalse   byte = 0xf4
+	cborTrue    byte = 0xf5
+	cborNull    byte = 0xf6
+	cborFloat32 byte = 0xfa
+	cborFloat64 byte = 0xfb
+)
+
+// errTruncated is returned when the data ends in the middle of an item.
+var errTruncated = errors.New("truncated")
+
+// appendHead appends the head of an item of the major type with argument n,
+// in the shortest form; RFC 8949 section 4.2.1.
+func appendHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(buf, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	if i < 0 {
+		return appendHead(buf, majorNegInt, uint64(-1-i))
+	}
+	return appendHead(buf, majorUint, uint64(i))
+}
+
+func appendText(buf []byte, s string) []byte {
+	return append(appendHead(buf, majorText, uint64(len(s))), s...)
+}
+
+// appendFloat appends f as a float32 if that holds it exactly, otherwise as
+// a float64.
+func appendFloat(buf []byte, f float64) []byte {
+	if f32 := float32(f); float64(f32) == f || math.IsNaN(f) {
+		return binary.BigEndian.AppendUint32(append(buf, cborFloat32), math.Float32bits(f32))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, cborFloat64), math.Float64bits(f))
+}
+
+// appendValue appends the deterministic encoding of v.
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	var err error
+
+	switch x := v.(type) {
+	case nil:
+		return append(buf, cborNull), nil
+	case bool:
+		if x {
+			return append(buf, cborTrue), nil
+		}
+		return append(buf, cborFalse), nil
+	case int:
+		return appendInt(buf, int64(x)), nil
+	case int8:
+		return appendInt(buf, int64(x)), nil
+	case int16:
+		return appendInt(buf, int64(x)), nil
+	case int32:
+		return appendInt(buf, int64(x)), nil
+	case int64:
+		return appendInt(buf, x), nil
+	case uint:
+		return appendHead(buf, majorUint, uint64(x)), nil
+	case uint8:
+		return appendHead(buf, majorUint, uint64(x)), nil
+	case uint16:
+		return appendHead(buf, majorUint, uint64(x)), nil
+	case uint32:
+		return appendHead(buf, majorUint, uint64(x)), nil
+	case uint64:
+		return appendHead(buf, majorUint, x), nil
+	case float32:
+		return appendFloat(buf, float64(x)), nil
+	case float64:
+		return appendFloat(buf, x), nil
+	case string:
+		return appendText(buf, x), nil
+	case []byte:
+		return append(appendHead(buf, majorBytes, uint64(len(x))), x...), nil
+	case []interface{}:
+		buf = appendHead(buf, majorArray, uint64(len(x)))
+		for _, elem := range x {
+			if buf, err = appendValue(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		var ents = make([]mapEntry, 0, len(x))
+		for k, elem := range x {
+			var ent = mapEntry{key: appendText(nil, k)}
+			if ent.val, err = appendValue(nil, elem); err != nil {
+				return nil, err
+			}
+			ents = append(ents, ent)
+		}
+		return appendMap(buf, ents), nil
+	}
+
+	return nil, fmt.Errorf("can not encode a value of type %T", v)
+}
+
+// mapEntry is an encoded key/val pair of a CBOR map.
+type mapEntry struct {
+	key, val []byte
+}
+
+// appendMap appends a map of ents, sorted by the bytewise lexicographic
+// order of their encoded keys; RFC 8949 section 4.2.1.
+func appendMap(buf []byte, ents []mapEntry) []byte {
+	sort.Slice(ents, func(i, j int) bool {
+		return string(ents[i].key) < string(ents[j].key)
+	})
+	buf = appendHead(buf, majorMap, uint64(len(ents)))
+	for _, ent := range ents {
+		buf = append(append(buf, ent.key...), ent.val...)
+	}
+	return buf
+}
+
+// decoder consumes the deterministic CBOR made by this package. It rejects
+// indefinite lengths, and maps whose keys are not in deterministic order.
+type decoder struct {
+	data []byte
+}
+
+// head consumes the head of the next item; for majorSimple the returned
+// argument is the head byte itself.
+func (d *decoder) head() (byte, uint64, error) {
+	if len(d.data) == 0 {
+		return 0, 0, errTruncated
+	}
+	var major, info = d.data[0] >> 5, d.data[0] & 0x1f
+	if major == majorSimple {
+		var b = d.data[0]
+		d.data = d.data[1:]
+		return major, uint64(b), nil
+	}
+
+	var size int
+	switch {
+	case info < 24:
+		d.data = d.data[1:]
+		return major, uint64(info), nil
+	case info <= 27:
+		size = 1 << (info - 24)
+	default:
+		return 0, 0, fmt.Errorf("unsupported additional info %d", info)
+	}
+	if len(d.data) < 1+size {
+		return 0, 0, errTruncated
+	}
+
+	var n uint64
+	for _, b := range d.data[1 : 1+size] {
+		n = n<<8 | uint64(b)
+	}
+	d.data = d.data[1+size:]
+	return major, n, nil
+}
+
+// bytes consumes the n byte content of a byte or text string.
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	var bs = d.data[:n]
+	d.data = d.data[n:]
+	return bs, nil
+}
+
+// text consumes a text string.
+func (d *decoder) text() (string, error) {
+	var major, n, err = d.head()
+	if err != nil {
+		return "", err
+	}
+	if major != majorText {
+		return "", fmt.Errorf("expected a text string; found major type %d", major)
+	}
+	var bs []byte
+	if bs, err = d.bytes(n); err != nil {
+		return "", err
+	}
+	return string(bs), nil
+}
+
+// mapLen consumes the head of a map, returning its number of entries.
+func (d *decoder) mapLen() (uint64, error) {
+	var major, n, err = d.head()
+	if err != nil {
+		return 0, err
+	}
+	if major != majorMap {
+		return 0, fmt.Errorf("expected a map; found major type %d", major)
+	}
+	if n > uint64(len(d.data)) {
+		return 0, errTruncated
+	}
+	return n, nil
+}
+
+// mapKey consumes the text string key of a map entry, checking that its
+// encoding follows prev, the encoding of the previous key.
+func (d *decoder) mapKey(prev []byte) (string, []byte, error) {
+	var start = d.data
+	var k, err = d.text()
+	if err != nil {
+		return "", nil, err
+	}
+	var enc = start[:len(start)-len(d.data)]
+	if prev != nil && string(enc) <= string(prev) {
+		return "", nil, fmt.Errorf("map key %q is not in deterministic order", k)
+	}
+	return k, enc, nil
+}
+
+// value consumes the next item, as decoded by Unmarshal.
+func (d *decoder) value() (interface{}, error) {
+	var major, n, err = d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int(n), nil
+	case majorNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("negative integer -1-%d overflows int64", n)
+		}
+		return int(-1 - int64(n)), nil
+	case majorBytes:
+		var bs []byte
+		if bs, err = d.bytes(n); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), bs...), nil
+	case majorText:
+		var bs []byte
+		if bs, err = d.bytes(n); err != nil {
+			return nil, err
+		}
+		return string(bs), nil
+	case majorArray:
+		if n > uint64(len(d.data)) {
+			return nil, errTruncated
+		}
+		var arr = make([]interface{}, n)
+		for i := range arr {
+			if arr[i], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	case majorMap:
+		if n > uint64(len(d.data)) {
+			return nil, errTruncated
+		}
+		var m = make(map[string]interface{}, n)
+		var prev []byte
+		for i := uint64(0); i < n; i++ {
+			var k string
+			if k, prev, err = d.mapKey(prev); err != nil {
+				return nil, err
+			}
+			if m[k], err = d.value(); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case majorSimple:
+		switch byte(n) {
+		case cborFalse:
+			return false, nil
+		case cborTrue:
+			return true, nil
+		case cborNull:
+			return nil, nil
+		case cborFloat32:
+			var bs []byte
+			if bs, err = d.bytes(4); err != nil {
+				return nil, err
+			}
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(bs))), nil
+		case cborFloat64:
+			var bs []byte
+			if bs, err = d.bytes(8); err != nil {
+				return nil, err
+			}
+			return math.Float64frombits(binary.BigEndian.Uint64(bs)), nil
+		}
+		return nil, fmt.Errorf("unsupported simple value %#x", n)
+	}
+
+	return nil, fmt.Errorf("unsupported major type %d", major)
+}
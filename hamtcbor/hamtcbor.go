@@ -0,0 +1,85 @@
+/*
+Package hamtcbor serializes a hamt64.Hamt as deterministic CBOR (RFC 8949
+section 4.2): one map from key to value whose entries are sorted by their
+encoded keys, with every integer, length and float in its shortest form. So
+Hamts with the same entries serialize to byte-identical data; regardless of
+the order the entries were put in, the table policy, or the process that
+serializes them. That makes the output suitable for content-addressed
+storage.
+
+The keys must be *stringkey.StringKey; they are encoded as text strings. The
+values may be nil, bool, any integer or float type, string, []byte, and
+[]interface{} or map[string]interface{} of those. Decoding returns integers
+as int (or uint64 when they do not fit in an int64), floats as float64,
+arrays as []interface{} and maps as map[string]interface{}.
+
+MarshalIPLD and UnmarshalIPLD instead write and read a Hamt as an IPLD
+HashMap, a trie of dag-cbor blocks in a content-addressed BlockStore; so it
+can be published to, and read from, the IPFS/IPLD ecosystem.
+*/
+package hamtcbor
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Marshal returns the deterministic CBOR encoding of h. A key that is not a
+// *stringkey.StringKey, or a value that can not be encoded, returns a
+// *hamt64.KeyError.
+func Marshal(h hamt64.Hamt) ([]byte, error) {
+	var ents = make([]mapEntry, 0, h.Nentries())
+	var err error
+	h.Range(func(k key.Key, v interface{}) bool {
+		var sk, ok = k.(*stringkey.StringKey)
+		if !ok {
+			err = &hamt64.KeyError{Op: "hamtcbor.Marshal", Key: k, Err: fmt.Errorf("can not encode a key of type %T", k)}
+			return false
+		}
+		var ent = mapEntry{key: appendText(nil, sk.Str())}
+		if ent.val, err = appendValue(nil, v); err != nil {
+			err = &hamt64.KeyError{Op: "hamtcbor.Marshal", Key: k, Err: err}
+			return false
+		}
+		ents = append(ents, ent)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return appendMap(nil, ents), nil
+}
+
+// Unmarshal returns a new Hamt, configured by opts, with the entries of the
+// CBOR made by Marshal. Data that is not a deterministic CBOR map of text
+// strings, with nothing after it, returns an error wrapping
+// hamt64.ErrCorruptSnapshot.
+func Unmarshal(data []byte, opts ...hamt64.Option) (hamt64.Hamt, error) {
+	var d = decoder{data}
+	var n, err = d.mapLen()
+	if err != nil {
+		return hamt64.Hamt{}, fmt.Errorf("%w: %v", hamt64.ErrCorruptSnapshot, err)
+	}
+
+	var b = hamt64.New(opts...).Builder()
+	var prev []byte
+	for i := uint64(0); i < n; i++ {
+		var k string
+		if k, prev, err = d.mapKey(prev); err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("%w: %v", hamt64.ErrCorruptSnapshot, err)
+		}
+		var v interface{}
+		if v, err = d.value(); err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("%w: value of %q: %v", hamt64.ErrCorruptSnapshot, k, err)
+		}
+		b.Put(stringkey.New(k), v)
+	}
+
+	if len(d.data) != 0 {
+		return hamt64.Hamt{}, fmt.Errorf("%w: %d trailing bytes", hamt64.ErrCorruptSnapshot, len(d.data))
+	}
+	return b.Freeze(), nil
+}
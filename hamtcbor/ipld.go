@@ -0,0 +1,489 @@
+package hamtcbor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// The multiformats codes used by the IPLD HashMap encoding.
+const (
+	hashAlgMurmur3x64 = 0x22 // multicodec murmur3-x64-64
+	codecDagCBOR      = 0x71 // multicodec dag-cbor
+	multihashSHA256   = 0x12 // multicodec sha2-256
+	cidVersion        = 1
+	cborTagCID        = 42
+)
+
+// The defaults of the IPLD HashMap spec.
+const (
+	DefaultBitWidth   = 8
+	DefaultBucketSize = 3
+)
+
+// CID is the binary form of a CIDv1 content identifier of a dag-cbor block,
+// hashed with sha2-256.
+type CID string
+
+// cidOf returns the CID of block.
+func cidOf(block []byte) CID {
+	var digest = sha256.Sum256(block)
+	var buf = []byte{cidVersion, codecDagCBOR, multihashSHA256, sha256.Size}
+	return CID(append(buf, digest[:]...))
+}
+
+// cidEncoding is the multibase "b" encoding: lowercase base32 without
+// padding.
+var cidEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// String returns the CID in its usual text form; multibase base32.
+func (c CID) String() string {
+	return "b" + cidEncoding.EncodeToString([]byte(c))
+}
+
+// ErrStoreUnavailable is returned, or wrapped, when a BlockStore can not be
+// read from or written to. It is hamt64.ErrStoreUnavailable.
+var ErrStoreUnavailable = hamt64.ErrStoreUnavailable
+
+// BlockStore is the interface to a content-addressed IPLD block store.
+type BlockStore interface {
+	Get(c CID) ([]byte, error)
+	Put(c CID, block []byte) error
+}
+
+// MemBlockStore is an in memory BlockStore.
+type MemBlockStore map[CID][]byte
+
+// Get is required for BlockStore.
+func (s MemBlockStore) Get(c CID) ([]byte, error) {
+	var block, found = s[c]
+	if !found {
+		return nil, fmt.Errorf("block %s not found", c)
+	}
+	return block, nil
+}
+
+// Put is required for BlockStore.
+func (s MemBlockStore) Put(c CID, block []byte) error {
+	s[c] = block
+	return nil
+}
+
+// ipldConfig is the layout of the IPLD HashMap made by MarshalIPLD.
+type ipldConfig struct {
+	bitWidth   int
+	bucketSize int
+}
+
+// IPLDOption sets one part of the layout of the IPLD HashMap made by
+// MarshalIPLD.
+type IPLDOption func(*ipldConfig)
+
+// WithBitWidth sets the number of hash bits used per level; so each node
+// has 1<<bitWidth slots. It must be from 3 to 8.
+// Default: DefaultBitWidth
+func WithBitWidth(bitWidth int) IPLDOption {
+	return func(cfg *ipldConfig) {
+		cfg.bitWidth = bitWidth
+	}
+}
+
+// WithBucketSize sets the maximum number of entries in a bucket before it
+// is split into a child node.
+// Default: DefaultBucketSize
+func WithBucketSize(bucketSize int) IPLDOption {
+	return func(cfg *ipldConfig) {
+		cfg.bucketSize = bucketSize
+	}
+}
+
+// ipldEntry is a key/val pair of an IPLD HashMap, with its value encoded.
+type ipldEntry struct {
+	key  []byte
+	val  []byte
+	hash uint64
+}
+
+// ipldIndex returns the slot of hash in a node at depth; the bits of the
+// hash are consumed from the most significant.
+func ipldIndex(hash uint64, depth, bitWidth int) uint {
+	return uint(hash>>uint(64-(depth+1)*bitWidth)) & (1<<uint(bitWidth) - 1)
+}
+
+// MarshalIPLD writes h to store as an IPLD HashMap (see
+// https://ipld.io/specs/advanced-data-layouts/hamt/spec/), and returns the
+// CID of its root block. Keys are hashed with murmur3-x64-64, whose bits
+// are consumed most significant first. Nodes are dag-cbor blocks; bit i of a
+// node's map is bit i of the big-endian integer of its bytes, and its
+// entries and bucket entries are in canonical order. So equal Hamts are
+// always written as the same blocks.
+//
+// The keys and values are restricted as for Marshal. A failure of store
+// returns an error wrapping ErrStoreUnavailable.
+func MarshalIPLD(h hamt64.Hamt, store BlockStore, opts ...IPLDOption) (CID, error) {
+	var cfg = ipldConfig{DefaultBitWidth, DefaultBucketSize}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.bitWidth < 3 || cfg.bitWidth > 8 || cfg.bucketSize < 1 {
+		return "", fmt.Errorf("hamtcbor: bad IPLD layout; bitWidth=%d bucketSize=%d", cfg.bitWidth, cfg.bucketSize)
+	}
+
+	var ents = make([]ipldEntry, 0, h.Nentries())
+	var err error
+	h.Range(func(k key.Key, v interface{}) bool {
+		var sk, ok = k.(*stringkey.StringKey)
+		if !ok {
+			err = &hamt64.KeyError{Op: "hamtcbor.MarshalIPLD", Key: k, Err: fmt.Errorf("can not encode a key of type %T", k)}
+			return false
+		}
+		var ent = ipldEntry{key: []byte(sk.Str()), hash: murmur3x64([]byte(sk.Str()))}
+		if ent.val, err = appendValue(nil, v); err != nil {
+			err = &hamt64.KeyError{Op: "hamtcbor.MarshalIPLD", Key: k, Err: err}
+			return false
+		}
+		ents = append(ents, ent)
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var w = ipldWriter{cfg, store}
+	var node []byte
+	if node, err = w.node(ents, 0); err != nil {
+		return "", err
+	}
+	var root = appendMap(nil, []mapEntry{
+		{appendText(nil, "hamt"), node},
+		{appendText(nil, "hashAlg"), appendInt(nil, hashAlgMurmur3x64)},
+		{appendText(nil, "bucketSize"), appendInt(nil, int64(cfg.bucketSize))},
+	})
+	return w.put(root)
+}
+
+// ipldWriter writes the blocks of an IPLD HashMap.
+type ipldWriter struct {
+	cfg   ipldConfig
+	store BlockStore
+}
+
+// put writes block to the store, returning its CID.
+func (w *ipldWriter) put(block []byte) (CID, error) {
+	var c = cidOf(block)
+	if err := w.store.Put(c, block); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrStoreUnavailable, err)
+	}
+	return c, nil
+}
+
+// node returns the encoding of the node at depth holding ents, after
+// writing the blocks of its child nodes.
+func (w *ipldWriter) node(ents []ipldEntry, depth int) ([]byte, error) {
+	var bw = w.cfg.bitWidth
+	sort.Slice(ents, func(i, j int) bool {
+		var ii, ij = ipldIndex(ents[i].hash, depth, bw), ipldIndex(ents[j].hash, depth, bw)
+		if ii != ij {
+			return ii < ij
+		}
+		return bytes.Compare(ents[i].key, ents[j].key) < 0
+	})
+
+	var bitfield = make([]byte, (1<<uint(bw))/8)
+	var elems [][]byte
+	for len(ents) > 0 {
+		var idx = ipldIndex(ents[0].hash, depth, bw)
+		var n = 1
+		for n < len(ents) && ipldIndex(ents[n].hash, depth, bw) == idx {
+			n++
+		}
+		var group = ents[:n]
+		ents = ents[n:]
+
+		bitfield[len(bitfield)-1-int(idx/8)] |= 1 << (idx % 8)
+
+		if len(group) <= w.cfg.bucketSize {
+			var elem = appendHead(nil, majorArray, uint64(len(group)))
+			for _, ent := range group {
+				elem = appendHead(elem, majorArray, 2)
+				elem = append(appendHead(elem, majorBytes, uint64(len(ent.key))), ent.key...)
+				elem = append(elem, ent.val...)
+			}
+			elems = append(elems, elem)
+			continue
+		}
+
+		if (depth+2)*bw > 64 {
+			return nil, fmt.Errorf("hamtcbor: more than %d keys share the %d bit hash of %q", w.cfg.bucketSize, (depth+1)*bw, group[0].key)
+		}
+		var child, err = w.node(group, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		var c CID
+		if c, err = w.put(child); err != nil {
+			return nil, err
+		}
+		var elem = appendHead(nil, majorTag, cborTagCID)
+		elem = append(appendHead(elem, majorBytes, uint64(len(c)+1)), 0)
+		elems = append(elems, append(elem, c...))
+	}
+
+	var buf = appendHead(nil, majorArray, 2)
+	buf = append(appendHead(buf, majorBytes, uint64(len(bitfield))), bitfield...)
+	buf = appendHead(buf, majorArray, uint64(len(elems)))
+	for _, elem := range elems {
+		buf = append(buf, elem...)
+	}
+	return buf, nil
+}
+
+// UnmarshalIPLD returns a new Hamt, configured by opts, with the entries of
+// the IPLD HashMap whose root block is root; eg. one written by MarshalIPLD.
+// Its keys must be byte strings, and are returned as *stringkey.StringKey;
+// the values are restricted as for Unmarshal.
+//
+// A failure of store returns an error wrapping ErrStoreUnavailable.
+// A block that does not match its CID, or is not a valid node of a
+// murmur3-x64-64 HashMap, returns an error wrapping
+// hamt64.ErrCorruptSnapshot.
+func UnmarshalIPLD(store BlockStore, root CID, opts ...hamt64.Option) (hamt64.Hamt, error) {
+	var r = ipldReader{store: store, b: hamt64.New(opts...).Builder()}
+
+	var block, err = r.get(root)
+	if err != nil {
+		return hamt64.Hamt{}, err
+	}
+
+	var d = decoder{block}
+	var n uint64
+	if n, err = d.mapLen(); err != nil {
+		return hamt64.Hamt{}, corrupt(err)
+	}
+	if n != 3 {
+		return hamt64.Hamt{}, corrupt(fmt.Errorf("root has %d fields", n))
+	}
+
+	var bucketSize int
+	var prev []byte
+	for i := uint64(0); i < n; i++ {
+		var k string
+		if k, prev, err = d.mapKey(prev); err != nil {
+			return hamt64.Hamt{}, corrupt(err)
+		}
+		var v interface{}
+		switch k {
+		case "hamt":
+			err = r.node(&d, 0, nil)
+		case "hashAlg":
+			if v, err = d.value(); err == nil && v != hashAlgMurmur3x64 {
+				err = corrupt(fmt.Errorf("unsupported hashAlg %v", v))
+			}
+		case "bucketSize":
+			if v, err = d.value(); err == nil {
+				var isInt bool
+				if bucketSize, isInt = v.(int); !isInt || bucketSize < 1 {
+					err = corrupt(fmt.Errorf("bad bucketSize %v", v))
+				}
+			}
+		default:
+			err = corrupt(fmt.Errorf("unknown root field %q", k))
+		}
+		if err != nil {
+			return hamt64.Hamt{}, corrupt(err)
+		}
+	}
+
+	if len(d.data) != 0 {
+		return hamt64.Hamt{}, corrupt(fmt.Errorf("%d trailing bytes", len(d.data)))
+	}
+	if r.maxBucket > bucketSize {
+		return hamt64.Hamt{}, corrupt(fmt.Errorf("bucket of %d entries; bucketSize=%d", r.maxBucket, bucketSize))
+	}
+
+	return r.b.Freeze(), nil
+}
+
+// corrupt returns err, wrapped by hamt64.ErrCorruptSnapshot if it does not
+// already wrap one of the hamt64 errors.
+func corrupt(err error) error {
+	if errors.Is(err, hamt64.ErrCorruptSnapshot) || errors.Is(err, ErrStoreUnavailable) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", hamt64.ErrCorruptSnapshot, err)
+}
+
+// ipldReader loads the entries of an IPLD HashMap into a Builder.
+type ipldReader struct {
+	store     BlockStore
+	b         *hamt64.Builder
+	bitWidth  int
+	maxBucket int
+}
+
+// get reads the block of c from the store, checking it matches c.
+func (r *ipldReader) get(c CID) ([]byte, error) {
+	var block, err = r.store.Get(c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStoreUnavailable, err)
+	}
+	if cidOf(block) != c {
+		return nil, corrupt(fmt.Errorf("block does not match CID %s", c))
+	}
+	return block, nil
+}
+
+// node consumes the node at depth, whose ancestors are at the slots path.
+func (r *ipldReader) node(d *decoder, depth int, path []uint) error {
+	var major, n, err = d.head()
+	if err != nil {
+		return err
+	}
+	if major != majorArray || n != 2 {
+		return fmt.Errorf("node is not a 2 element array")
+	}
+
+	if major, n, err = d.head(); err != nil {
+		return err
+	}
+	if major != majorBytes {
+		return fmt.Errorf("node map is not a byte string")
+	}
+	var bitfield []byte
+	if bitfield, err = d.bytes(n); err != nil {
+		return err
+	}
+	var bw = bits.TrailingZeros(uint(len(bitfield) * 8))
+	if len(bitfield) == 0 || len(bitfield)*8 != 1<<uint(bw) || bw > 8 || (depth+1)*bw > 64 {
+		return fmt.Errorf("node map of %d bytes at depth %d", len(bitfield), depth)
+	}
+	if depth == 0 {
+		r.bitWidth = bw
+	} else if bw != r.bitWidth {
+		return fmt.Errorf("node map of %d bytes under a root of bitWidth %d", len(bitfield), r.bitWidth)
+	}
+
+	var idxs []uint
+	for idx := uint(0); idx < uint(len(bitfield))*8; idx++ {
+		if bitfield[len(bitfield)-1-int(idx/8)]&(1<<(idx%8)) != 0 {
+			idxs = append(idxs, idx)
+		}
+	}
+
+	if major, n, err = d.head(); err != nil {
+		return err
+	}
+	if major != majorArray || n != uint64(len(idxs)) {
+		return fmt.Errorf("node has %d entries for %d set bits", n, len(idxs))
+	}
+
+	for _, idx := range idxs {
+		if major, n, err = d.head(); err != nil {
+			return err
+		}
+		var slots = append(path[:len(path):len(path)], idx)
+		switch {
+		case major == majorArray:
+			err = r.bucket(d, n, slots)
+		case major == majorTag && n == cborTagCID:
+			err = r.link(d, depth, slots)
+		default:
+			err = fmt.Errorf("node entry of major type %d", major)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bucket consumes the n entries of the bucket at slots.
+func (r *ipldReader) bucket(d *decoder, n uint64, slots []uint) error {
+	if n == 0 || n > uint64(len(d.data)) {
+		return fmt.Errorf("bucket of %d entries", n)
+	}
+	if int(n) > r.maxBucket {
+		r.maxBucket = int(n)
+	}
+
+	var prev []byte
+	for i := uint64(0); i < n; i++ {
+		var major, m, err = d.head()
+		if err != nil {
+			return err
+		}
+		if major != majorArray || m != 2 {
+			return fmt.Errorf("bucket entry is not a 2 element array")
+		}
+		if major, m, err = d.head(); err != nil {
+			return err
+		}
+		if major != majorBytes {
+			return fmt.Errorf("bucket key is not a byte string")
+		}
+		var k []byte
+		if k, err = d.bytes(m); err != nil {
+			return err
+		}
+		if prev != nil && bytes.Compare(k, prev) <= 0 {
+			return fmt.Errorf("bucket key %q is not in canonical order", k)
+		}
+		prev = k
+
+		var hash = murmur3x64(k)
+		for depth, slot := range slots {
+			if ipldIndex(hash, depth, r.bitWidth) != slot {
+				return fmt.Errorf("key %q is not on its hash path", k)
+			}
+		}
+
+		var v interface{}
+		if v, err = d.value(); err != nil {
+			return fmt.Errorf("value of %q: %v", k, err)
+		}
+		r.b.Put(stringkey.New(string(k)), v)
+	}
+
+	return nil
+}
+
+// link consumes the link, at slots, of a node at depth and loads the child
+// node it points to.
+func (r *ipldReader) link(d *decoder, depth int, slots []uint) error {
+	var major, n, err = d.head()
+	if err != nil {
+		return err
+	}
+	if major != majorBytes {
+		return fmt.Errorf("link is not a byte string")
+	}
+	var bs []byte
+	if bs, err = d.bytes(n); err != nil {
+		return err
+	}
+	if len(bs) < 1 || bs[0] != 0 {
+		return fmt.Errorf("link without the identity multibase prefix")
+	}
+
+	var block []byte
+	if block, err = r.get(CID(bs[1:])); err != nil {
+		return err
+	}
+	var cd = decoder{block}
+	if err = r.node(&cd, depth+1, slots); err != nil {
+		return err
+	}
+	if len(cd.data) != 0 {
+		return fmt.Errorf("%d trailing bytes after node", len(cd.data))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package hamtcbor
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// murmur3x64 returns the first 64 bits, h1, of the 128 bit x64 variant of
+// MurmurHash3 with a zero seed; the multicodec "murmur3-x64-64" hash of
+// the IPLD HashMap spec.
+func murmur3x64(data []byte) uint64 {
+	const c1, c2 = 0x87c37b91114253d5, 0x4cf5ad432745937f
+
+	var h1, h2 uint64
+	var n = len(data)
+	for ; len(data) >= 16; data = data[16:] {
+		var k1 = binary.LittleEndian.Uint64(data)
+		var k2 = binary.LittleEndian.Uint64(data[8:])
+
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+		h1 = bits.RotateLeft64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		h2 = bits.RotateLeft64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	// the tail of less than 16 bytes
+	var k1, k2 uint64
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (8 * uint(i-8))
+	}
+	if len(data) > 8 {
+		k2 *= c2
+		k2 = bits.RotateLeft64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+	}
+	for i := len(data) - 1; i >= 0; i-- {
+		if i < 8 {
+			k1 ^= uint64(data[i]) << (8 * uint(i))
+		}
+	}
+	if len(data) > 0 {
+		k1 *= c1
+		k1 = bits.RotateLeft64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1, h2 = fmix64(h1), fmix64(h2)
+	h1 += h2
+
+	return h1
+}
+
+// fmix64 is the finalization mix of MurmurHash3.
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
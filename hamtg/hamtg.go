@@ -0,0 +1,73 @@
+/*
+Package hamtg is a type-parameterized front-end to hamt64. A Hamt[K, V] has
+the same functional Get, Put and Del methods as a hamt64.Hamt, but its keys
+are of type K and its values of type V; so Get returns a V without a type
+assertion at the call site, and Put of a wrong value type does not compile.
+*/
+package hamtg
+
+import (
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+)
+
+// Hamt is an immutable, persistent map from K to V. The zero Hamt is empty
+// and ready to use.
+type Hamt[K key.Key, V any] struct {
+	h hamt64.Hamt
+}
+
+// New returns an empty Hamt configured by opts; see hamt64.New().
+func New[K key.Key, V any](opts ...hamt64.Option) Hamt[K, V] {
+	return Hamt[K, V]{hamt64.New(opts...)}
+}
+
+// Untyped returns the underlying hamt64.Hamt.
+func (h Hamt[K, V]) Untyped() hamt64.Hamt {
+	return h.h
+}
+
+// IsEmpty returns true if the Hamt has no entries.
+func (h Hamt[K, V]) IsEmpty() bool {
+	return h.h.IsEmpty()
+}
+
+// Nentries returns the number of entries in the Hamt.
+func (h Hamt[K, V]) Nentries() uint {
+	return h.h.Nentries()
+}
+
+// Get retrieves the value for a given key. The bool represents whether the
+// key was found; if not, the zero V is returned.
+func (h Hamt[K, V]) Get(k K) (V, bool) {
+	var val, found = h.h.Get(k)
+	if !found {
+		var zero V
+		return zero, false
+	}
+	var v, _ = val.(V) // a nil val of an interface V is the zero V
+	return v, true
+}
+
+// Put inserts a key/val pair, returning a new Hamt and a bool indicating if
+// the key/val pair was added(true) or merely updated(false).
+func (h Hamt[K, V]) Put(k K, v V) (Hamt[K, V], bool) {
+	var nh, added = h.h.Put(k, v)
+	return Hamt[K, V]{nh}, added
+}
+
+// Del removes a key, returning a new Hamt, the key's value and a bool
+// indicating whether the key was found (and therefor deleted).
+func (h Hamt[K, V]) Del(k K) (Hamt[K, V], V, bool) {
+	var nh, val, deleted = h.h.Del(k)
+	if !deleted {
+		var zero V
+		return h, zero, false
+	}
+	var v, _ = val.(V)
+	return Hamt[K, V]{nh}, v, true
+}
+
+func (h Hamt[K, V]) String() string {
+	return h.h.String()
+}
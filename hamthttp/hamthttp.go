@@ -0,0 +1,212 @@
+/*
+Package hamthttp serves the state of a hamt64.AtomicHamt over net/http, so
+it can be observed from dashboards and debugging scripts. A Server is an
+http.Handler of three endpoints:
+
+	GET /entries?limit=N&cursor=C
+	GET /snapshots/{label}
+	GET /diff?from=L1&to=L2
+
+/entries returns a page of the entries of the current version as JSON: at
+most limit of them, 100 by default, and the cursor of the next page, if
+there is one. /snapshots/{label} returns the MarshalBinary snapshot of the
+version named label. /diff returns the Changes from the version named from
+to the version named to as JSON.
+
+Versions are named by Server.Label; the label "current" always names the
+current version of the AtomicHamt. Keys are written as their String(), and
+values as encoding/json writes them.
+*/
+package hamthttp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+)
+
+// Current is the label of the current version of the AtomicHamt.
+const Current = "current"
+
+// The limits of a page of /entries.
+const (
+	DefaultLimit = 100
+	MaxLimit     = 10000
+)
+
+// Server is the http.Handler of the endpoints of the package, for one
+// AtomicHamt. It is safe for concurrent use.
+type Server struct {
+	a   *hamt64.AtomicHamt
+	mux *http.ServeMux
+
+	mu     sync.RWMutex
+	labels map[string]hamt64.Hamt
+}
+
+// NewServer returns a new Server of the versions of a.
+func NewServer(a *hamt64.AtomicHamt) *Server {
+	var s = &Server{a: a, mux: http.NewServeMux(), labels: make(map[string]hamt64.Hamt)}
+	s.mux.HandleFunc("/entries", s.entries)
+	s.mux.HandleFunc("/snapshots/", s.snapshot)
+	s.mux.HandleFunc("/diff", s.diff)
+	return s
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, fmt.Sprintf("method %s not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// Label names the current version of the AtomicHamt label, replacing the
+// version named label before, and returns it. Label panics if label is
+// Current.
+func (s *Server) Label(label string) hamt64.Hamt {
+	if label == Current {
+		panic(fmt.Sprintf("hamthttp: the label %q is reserved", Current))
+	}
+	var h = s.a.Load()
+	s.mu.Lock()
+	s.labels[label] = h
+	s.mu.Unlock()
+	return h
+}
+
+// Unlabel forgets the version named label.
+func (s *Server) Unlabel(label string) {
+	s.mu.Lock()
+	delete(s.labels, label)
+	s.mu.Unlock()
+}
+
+// version returns the version named label.
+func (s *Server) version(label string) (hamt64.Hamt, bool) {
+	if label == Current {
+		return s.a.Load(), true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var h, found = s.labels[label]
+	return h, found
+}
+
+// Entry is an entry of a page of /entries.
+type Entry struct {
+	Key string      `json:"key"`
+	Val interface{} `json:"val"`
+}
+
+// Page is the body of a response of /entries. Next is the cursor of the
+// next page, or empty after the last page.
+type Page struct {
+	Nentries uint    `json:"nentries"`
+	Entries  []Entry `json:"entries"`
+	Next     string  `json:"next,omitempty"`
+}
+
+func (s *Server) entries(w http.ResponseWriter, r *http.Request) {
+	var limit = DefaultLimit
+	if q := r.URL.Query().Get("limit"); q != "" {
+		var n, err = strconv.Atoi(q)
+		if err != nil || n < 1 || n > MaxLimit {
+			http.Error(w, fmt.Sprintf("limit %q is not in 1..%d", q, MaxLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	var h = s.a.Load()
+	var it = h.Iter()
+	if q := r.URL.Query().Get("cursor"); q != "" {
+		var cursor, err = base64.RawURLEncoding.DecodeString(q)
+		if err == nil {
+			it, err = h.IterAt(cursor)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad cursor %q", q), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var page = Page{Nentries: h.Nentries(), Entries: []Entry{}}
+	for len(page.Entries) < limit {
+		var k, v, ok = it.Next()
+		if !ok {
+			break
+		}
+		page.Entries = append(page.Entries, Entry{k.String(), v})
+	}
+	if len(page.Entries) == limit {
+		var cursor = it.Cursor()
+		if _, _, ok := it.Next(); ok {
+			page.Next = base64.RawURLEncoding.EncodeToString(cursor)
+		}
+	}
+
+	writeJSON(w, page)
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	var label = strings.TrimPrefix(r.URL.Path, "/snapshots/")
+	var h, found = s.version(label)
+	if !found {
+		http.Error(w, fmt.Sprintf("no version is labeled %q", label), http.StatusNotFound)
+		return
+	}
+
+	var data, err = h.MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// Change is a Change of the body of a response of /diff.
+type Change struct {
+	Kind string      `json:"kind"`
+	Key  string      `json:"key"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+func (s *Server) diff(w http.ResponseWriter, r *http.Request) {
+	var hs [2]hamt64.Hamt
+	for i, param := range []string{"from", "to"} {
+		var label = r.URL.Query().Get(param)
+		var found bool
+		if hs[i], found = s.version(label); !found {
+			http.Error(w, fmt.Sprintf("no version is labeled %s=%q", param, label), http.StatusNotFound)
+			return
+		}
+	}
+
+	var changes = []Change{}
+	for _, c := range hamt64.Diff(hs[0], hs[1]) {
+		changes = append(changes, Change{c.Kind.String(), c.Key.String(), c.Old, c.New})
+	}
+	writeJSON(w, changes)
+}
+
+// writeJSON writes the JSON of body as the response.
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	var data, err = json.Marshal(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
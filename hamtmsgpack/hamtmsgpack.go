@@ -0,0 +1,77 @@
+/*
+Package hamtmsgpack serializes a hamt64.Hamt as MessagePack, for interop with
+services in other languages: the Hamt is one msgpack map from key to value,
+which any msgpack library decodes into its native map type.
+
+The keys must be *stringkey.StringKey; they are encoded as str. The values
+may be nil, bool, any integer or float type, string, []byte (as bin), and
+[]interface{} or map[string]interface{} of those. Decoding returns integers
+as int (or uint64 when they do not fit in an int64), floats as float64,
+arrays as []interface{} and maps as map[string]interface{}.
+
+Unlike hamtcbor, the order of the entries of a map is not specified; so two
+Hamts with the same entries may encode to different bytes.
+*/
+package hamtmsgpack
+
+import (
+	"fmt"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Marshal returns the MessagePack encoding of h. A key that is not a
+// *stringkey.StringKey, or a value that can not be encoded, returns a
+// *hamt64.KeyError.
+func Marshal(h hamt64.Hamt) ([]byte, error) {
+	var buf = appendMapLen(nil, int(h.Nentries()))
+	var err error
+	h.Range(func(k key.Key, v interface{}) bool {
+		var sk, ok = k.(*stringkey.StringKey)
+		if !ok {
+			err = &hamt64.KeyError{Op: "hamtmsgpack.Marshal", Key: k, Err: fmt.Errorf("can not encode a key of type %T", k)}
+			return false
+		}
+		if buf, err = appendValue(appendStr(buf, sk.Str()), v); err != nil {
+			err = &hamt64.KeyError{Op: "hamtmsgpack.Marshal", Key: k, Err: err}
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Unmarshal returns a new Hamt, configured by opts, with the entries of a
+// msgpack map of str keys; eg. one made by Marshal. Data that is not such a
+// map, with nothing after it, returns an error wrapping
+// hamt64.ErrCorruptSnapshot.
+func Unmarshal(data []byte, opts ...hamt64.Option) (hamt64.Hamt, error) {
+	var d = decoder{data}
+	var n, err = d.mapLen()
+	if err != nil {
+		return hamt64.Hamt{}, fmt.Errorf("%w: %v", hamt64.ErrCorruptSnapshot, err)
+	}
+
+	var b = hamt64.New(opts...).Builder()
+	for i := uint64(0); i < n; i++ {
+		var k string
+		if k, err = d.str(); err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("%w: key: %v", hamt64.ErrCorruptSnapshot, err)
+		}
+		var v interface{}
+		if v, err = d.value(); err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("%w: value of %q: %v", hamt64.ErrCorruptSnapshot, k, err)
+		}
+		b.Put(stringkey.New(k), v)
+	}
+
+	if len(d.data) != 0 {
+		return hamt64.Hamt{}, fmt.Errorf("%w: %d trailing bytes", hamt64.ErrCorruptSnapshot, len(d.data))
+	}
+	return b.Freeze(), nil
+}
@@ -0,0 +1,335 @@
+package hamtmsgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The MessagePack format bytes used by this package; see
+// https://github.com/msgpack/msgpack/blob/master/spec.md
+const (
+	mpNil      byte = 0xc0
+	mpFalse    byte = 0xc2
+	mpTrue     byte = 0xc3
+	mpBin8     byte = 0xc4
+	mpBin16    byte = 0xc5
+	mpBin32    byte = 0xc6
+	mpFloat32  byte = 0xca
+	mpFloat64  byte = 0xcb
+	mpUint8    byte = 0xcc
+	mpUint16   byte = 0xcd
+	mpUint32   byte = 0xce
+	mpUint64   byte = 0xcf
+	mpInt8     byte = 0xd0
+	mpInt16    byte = 0xd1
+	mpInt32    byte = 0xd2
+	mpInt64    byte = 0xd3
+	mpStr8     byte = 0xd9
+	mpStr16    byte = 0xda
+	mpStr32    byte = 0xdb
+	mpArray16  byte = 0xdc
+	mpArray32  byte = 0xdd
+	mpMap16    byte = 0xde
+	mpMap32    byte = 0xdf
+	mpFixMap   byte = 0x80
+	mpFixArray byte = 0x90
+	mpFixStr   byte = 0xa0
+)
+
+// errTruncated is returned when the data ends in the middle of an object.
+var errTruncated = errors.New("truncated")
+
+func appendUint(buf []byte, u uint64) []byte {
+	switch {
+	case u <= 0x7f:
+		return append(buf, byte(u))
+	case u <= math.MaxUint8:
+		return append(buf, mpUint8, byte(u))
+	case u <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, mpUint16), uint16(u))
+	case u <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, mpUint32), uint32(u))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, mpUint64), u)
+}
+
+func appendInt(buf []byte, i int64) []byte {
+	switch {
+	case i >= 0:
+		return appendUint(buf, uint64(i))
+	case i >= -32:
+		return append(buf, byte(i))
+	case i >= math.MinInt8:
+		return append(buf, mpInt8, byte(i))
+	case i >= math.MinInt16:
+		return binary.BigEndian.AppendUint16(append(buf, mpInt16), uint16(i))
+	case i >= math.MinInt32:
+		return binary.BigEndian.AppendUint32(append(buf, mpInt32), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(buf, mpInt64), uint64(i))
+}
+
+// appendLen appends the header of a str, bin, array or map of n elements;
+// fix is the format byte of its fix form, or 0 if it has none.
+func appendLen(buf []byte, fix byte, fixMax int, f8, f16, f32 byte, n int) []byte {
+	switch {
+	case fix != 0 && n <= fixMax:
+		return append(buf, fix|byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		return append(buf, f8, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, f16), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(buf, f32), uint32(n))
+}
+
+func appendStr(buf []byte, s string) []byte {
+	return append(appendLen(buf, mpFixStr, 31, mpStr8, mpStr16, mpStr32, len(s)), s...)
+}
+
+func appendMapLen(buf []byte, n int) []byte {
+	return appendLen(buf, mpFixMap, 15, 0, mpMap16, mpMap32, n)
+}
+
+// appendValue appends the MessagePack encoding of v.
+func appendValue(buf []byte, v interface{}) ([]byte, error) {
+	var err error
+
+	switch x := v.(type) {
+	case nil:
+		return append(buf, mpNil), nil
+	case bool:
+		if x {
+			return append(buf, mpTrue), nil
+		}
+		return append(buf, mpFalse), nil
+	case int:
+		return appendInt(buf, int64(x)), nil
+	case int8:
+		return appendInt(buf, int64(x)), nil
+	case int16:
+		return appendInt(buf, int64(x)), nil
+	case int32:
+		return appendInt(buf, int64(x)), nil
+	case int64:
+		return appendInt(buf, x), nil
+	case uint:
+		return appendUint(buf, uint64(x)), nil
+	case uint8:
+		return appendUint(buf, uint64(x)), nil
+	case uint16:
+		return appendUint(buf, uint64(x)), nil
+	case uint32:
+		return appendUint(buf, uint64(x)), nil
+	case uint64:
+		return appendUint(buf, x), nil
+	case float32:
+		return binary.BigEndian.AppendUint32(append(buf, mpFloat32), math.Float32bits(x)), nil
+	case float64:
+		return binary.BigEndian.AppendUint64(append(buf, mpFloat64), math.Float64bits(x)), nil
+	case string:
+		return appendStr(buf, x), nil
+	case []byte:
+		return append(appendLen(buf, 0, 0, mpBin8, mpBin16, mpBin32, len(x)), x...), nil
+	case []interface{}:
+		buf = appendLen(buf, mpFixArray, 15, 0, mpArray16, mpArray32, len(x))
+		for _, elem := range x {
+			if buf, err = appendValue(buf, elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]interface{}:
+		buf = appendMapLen(buf, len(x))
+		for k, elem := range x {
+			if buf, err = appendValue(appendStr(buf, k), elem); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	return nil, fmt.Errorf("can not encode a value of type %T", v)
+}
+
+// decoder consumes MessagePack data.
+type decoder struct {
+	data []byte
+}
+
+// next consumes n bytes.
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	var bs = d.data[:n]
+	d.data = d.data[n:]
+	return bs, nil
+}
+
+// uint consumes an n byte big endian unsigned integer.
+func (d *decoder) uint(n uint64) (uint64, error) {
+	var bs, err = d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, b := range bs {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
+// mapLen consumes the header of a map, returning its number of entries.
+func (d *decoder) mapLen() (uint64, error) {
+	var bs, err = d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	switch f := bs[0]; {
+	case f&0xf0 == mpFixMap:
+		return uint64(f & 0x0f), nil
+	case f == mpMap16:
+		return d.uint(2)
+	case f == mpMap32:
+		return d.uint(4)
+	}
+	return 0, fmt.Errorf("expected a map; found format %#x", bs[0])
+}
+
+// str consumes a str.
+func (d *decoder) str() (string, error) {
+	var v, err = d.value()
+	if err != nil {
+		return "", err
+	}
+	var s, ok = v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a str; found %T", v)
+	}
+	return s, nil
+}
+
+// value consumes the next object.
+func (d *decoder) value() (interface{}, error) {
+	var bs, err = d.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	var f = bs[0]
+	var n uint64
+	switch {
+	case f <= 0x7f:
+		return int(f), nil
+	case f >= 0xe0:
+		return int(int8(f)), nil
+	case f&0xf0 == mpFixMap:
+		return d.mapOf(uint64(f & 0x0f))
+	case f&0xf0 == mpFixArray:
+		return d.arrayOf(uint64(f & 0x0f))
+	case f&0xe0 == mpFixStr:
+		return d.strOf(uint64(f & 0x1f))
+	}
+
+	switch f {
+	case mpNil:
+		return nil, nil
+	case mpFalse:
+		return false, nil
+	case mpTrue:
+		return true, nil
+	case mpBin8, mpBin16, mpBin32:
+		if n, err = d.uint(1 << (f - mpBin8)); err != nil {
+			return nil, err
+		}
+		if bs, err = d.next(n); err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), bs...), nil
+	case mpFloat32:
+		if n, err = d.uint(4); err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(uint32(n))), nil
+	case mpFloat64:
+		if n, err = d.uint(8); err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(n), nil
+	case mpUint8, mpUint16, mpUint32, mpUint64:
+		if n, err = d.uint(1 << (f - mpUint8)); err != nil {
+			return nil, err
+		}
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int(n), nil
+	case mpInt8, mpInt16, mpInt32, mpInt64:
+		var size = uint64(1) << (f - mpInt8)
+		if n, err = d.uint(size); err != nil {
+			return nil, err
+		}
+		// sign extend the size byte integer
+		var shift = 64 - 8*size
+		return int(int64(n<<shift) >> shift), nil
+	case mpStr8, mpStr16, mpStr32:
+		if n, err = d.uint(1 << (f - mpStr8)); err != nil {
+			return nil, err
+		}
+		return d.strOf(n)
+	case mpArray16, mpArray32:
+		if n, err = d.uint(2 << (f - mpArray16)); err != nil {
+			return nil, err
+		}
+		return d.arrayOf(n)
+	case mpMap16, mpMap32:
+		if n, err = d.uint(2 << (f - mpMap16)); err != nil {
+			return nil, err
+		}
+		return d.mapOf(n)
+	}
+
+	return nil, fmt.Errorf("unsupported format %#x", f)
+}
+
+func (d *decoder) strOf(n uint64) (interface{}, error) {
+	var bs, err = d.next(n)
+	if err != nil {
+		return nil, err
+	}
+	return string(bs), nil
+}
+
+func (d *decoder) arrayOf(n uint64) (interface{}, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	var arr = make([]interface{}, n)
+	var err error
+	for i := range arr {
+		if arr[i], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return arr, nil
+}
+
+func (d *decoder) mapOf(n uint64) (interface{}, error) {
+	if n > uint64(len(d.data)) {
+		return nil, errTruncated
+	}
+	var m = make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		var k, err = d.str()
+		if err != nil {
+			return nil, err
+		}
+		if m[k], err = d.value(); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,133 @@
+/*
+Package hamtprom exports the metrics of a hamt64.AtomicHamt to Prometheus. A
+Collector is a prometheus.Collector of
+
+	hamt_entries                     the key/val pairs of the current version
+	hamt_stored_bytes                their size, per hamt64.WithSizeFunc
+	hamt_tables{type}                the compressed and full tables
+	hamt_leaves{type}                the flat and collision leaves
+	hamt_entries_by_depth{depth}     the pairs in leaves of tables at each depth
+	hamt_table_occupancy             the average fill ratio of the tables
+	hamt_table_upgrades_total        the compressedTables upgraded to fullTables
+	hamt_table_downgrades_total      the fullTables downgraded to compressedTables
+	hamt_op_duration_seconds{op}     the latency of Get, Put and Del
+
+The gauges are read from the Stats of the current version at every scrape;
+Stats visits every table, but not every pair. The counters and the
+histogram are only kept when the Hamts of the AtomicHamt report to the
+Collector, which is also a hamt64.Instruments:
+
+	var a = hamt64.NewAtomicHamt(hamt64.Hamt{})
+	var c = hamtprom.NewCollector(a)
+	a.Store(hamt64.New(hamt64.WithInstruments(c)))
+	prometheus.MustRegister(c)
+*/
+package hamtprom
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+)
+
+// Namespace is the prefix of the names of the metrics.
+const Namespace = "hamt"
+
+// Collector is the prometheus.Collector, and the hamt64.Instruments, of one
+// AtomicHamt. It is safe for concurrent use.
+type Collector struct {
+	a *hamt64.AtomicHamt
+
+	entries        *prometheus.Desc
+	storedBytes    *prometheus.Desc
+	tables         *prometheus.Desc
+	leaves         *prometheus.Desc
+	entriesByDepth *prometheus.Desc
+	occupancy      *prometheus.Desc
+
+	upgrades   prometheus.Counter
+	downgrades prometheus.Counter
+	opDuration *prometheus.HistogramVec
+}
+
+// NewCollector returns a new Collector of the current versions of a.
+func NewCollector(a *hamt64.AtomicHamt) *Collector {
+	var name = func(n string) string {
+		return prometheus.BuildFQName(Namespace, "", n)
+	}
+	return &Collector{
+		a:              a,
+		entries:        prometheus.NewDesc(name("entries"), "The number of key/val pairs of the current version.", nil, nil),
+		storedBytes:    prometheus.NewDesc(name("stored_bytes"), "The total size of the key/val pairs of the current version.", nil, nil),
+		tables:         prometheus.NewDesc(name("tables"), "The number of tables of the current version, by type.", []string{"type"}, nil),
+		leaves:         prometheus.NewDesc(name("leaves"), "The number of leaves of the current version, by type.", []string{"type"}, nil),
+		entriesByDepth: prometheus.NewDesc(name("entries_by_depth"), "The number of key/val pairs in leaves of tables at each depth.", []string{"depth"}, nil),
+		occupancy:      prometheus.NewDesc(name("table_occupancy"), "The average fraction of the entries of a table that are not empty.", nil, nil),
+		upgrades: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "table_upgrades_total",
+			Help:      "The number of compressedTables upgraded to fullTables.",
+		}),
+		downgrades: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "table_downgrades_total",
+			Help:      "The number of fullTables downgraded to compressedTables.",
+		}),
+		opDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "op_duration_seconds",
+			Help:      "The latency of Get, Put and Del.",
+			Buckets:   prometheus.ExponentialBuckets(1e-7, 4, 10),
+		}, []string{"op"}),
+	}
+}
+
+// Describe is required for prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.storedBytes
+	ch <- c.tables
+	ch <- c.leaves
+	ch <- c.entriesByDepth
+	ch <- c.occupancy
+	c.upgrades.Describe(ch)
+	c.downgrades.Describe(ch)
+	c.opDuration.Describe(ch)
+}
+
+// Collect is required for prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	var s = c.a.Load().Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(s.Nentries))
+	ch <- prometheus.MustNewConstMetric(c.storedBytes, prometheus.GaugeValue, float64(s.StoredBytes))
+	ch <- prometheus.MustNewConstMetric(c.tables, prometheus.GaugeValue, float64(s.CompressedTables), "compressed")
+	ch <- prometheus.MustNewConstMetric(c.tables, prometheus.GaugeValue, float64(s.FullTables), "full")
+	ch <- prometheus.MustNewConstMetric(c.leaves, prometheus.GaugeValue, float64(s.FlatLeaves), "flat")
+	ch <- prometheus.MustNewConstMetric(c.leaves, prometheus.GaugeValue, float64(s.CollisionLeaves), "collision")
+	for depth, n := range s.EntriesPerDepth {
+		ch <- prometheus.MustNewConstMetric(c.entriesByDepth, prometheus.GaugeValue, float64(n), strconv.Itoa(depth))
+	}
+	ch <- prometheus.MustNewConstMetric(c.occupancy, prometheus.GaugeValue, s.Occupancy())
+
+	c.upgrades.Collect(ch)
+	c.downgrades.Collect(ch)
+	c.opDuration.Collect(ch)
+}
+
+// TableGraded is required for hamt64.Instruments.
+func (c *Collector) TableGraded(upgrade bool) {
+	if upgrade {
+		c.upgrades.Inc()
+	} else {
+		c.downgrades.Inc()
+	}
+}
+
+// ObserveOp is required for hamt64.Instruments.
+func (c *Collector) ObserveOp(op string, d time.Duration) {
+	c.opDuration.WithLabelValues(op).Observe(d.Seconds())
+}
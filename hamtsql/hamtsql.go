@@ -0,0 +1,158 @@
+/*
+Package hamtsql dumps a hamt64.Hamt into a SQL table, and loads one back, so
+the persisted state of a map can be inspected and repaired with standard SQL
+tooling. The SQL is that of SQLite; any database/sql driver of it will do,
+eg. modernc.org/sqlite or github.com/mattn/go-sqlite3. The package imports
+no driver itself.
+
+The table has the columns
+
+	key   BLOB PRIMARY KEY
+	value BLOB NOT NULL
+
+holding the records the hamt64.Codec of the Hamt makes of each key and
+value, and any metadata Columns given to Dump. With the default StringCodec
+the key is the bytes of the string; so `SELECT CAST(key AS TEXT) ...` lists the keys.
+*/
+package hamtsql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+)
+
+// Column is a metadata column of the table, written by Dump and ignored by
+// Load; eg. the size of each value, or the time of the dump.
+type Column struct {
+	Name string // an SQL identifier: letters, digits and '_'
+	Type string // an SQL type, eg. "INTEGER" or "TEXT"
+
+	// Value returns the value of the column for the entry k/v.
+	Value func(k key.Key, v interface{}) interface{}
+}
+
+// Dump replaces the rows of table in db, creating it if need be, with the
+// entries of h, in one transaction. A key or value the Codec of h can not
+// encode returns a *hamt64.KeyError, and leaves table unchanged.
+func Dump(db *sql.DB, table string, h hamt64.Hamt, cols ...Column) (err error) {
+	var defs = []string{"key BLOB PRIMARY KEY", "value BLOB NOT NULL"}
+	var names = []string{"key", "value"}
+	for _, col := range cols {
+		if !isIdent(col.Name) || strings.ContainsAny(col.Type, ",;()") {
+			return fmt.Errorf("hamtsql: bad column %q of type %q", col.Name, col.Type)
+		}
+		defs = append(defs, col.Name+" "+col.Type)
+		names = append(names, col.Name)
+	}
+	if !isIdent(table) {
+		return fmt.Errorf("hamtsql: bad table name %q", table)
+	}
+
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s", table)); err != nil {
+		return err
+	}
+	var ins *sql.Stmt
+	var params = strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", ")
+	if ins, err = tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(names, ", "), params)); err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	var codec = h.Codec()
+	var args = make([]interface{}, len(names))
+	h.Range(func(k key.Key, v interface{}) bool {
+		var krec, vrec []byte
+		if krec, err = codec.AppendKey(nil, k); err != nil {
+			err = &hamt64.KeyError{Op: "hamtsql.Dump", Key: k, Err: err}
+			return false
+		}
+		if vrec, err = codec.AppendVal(nil, v); err != nil {
+			err = &hamt64.KeyError{Op: "hamtsql.Dump", Key: k, Err: err}
+			return false
+		}
+		args[0], args[1] = krec, vrec
+		for i, col := range cols {
+			args[2+i] = col.Value(k, v)
+		}
+		_, err = ins.Exec(args...)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Load returns a new Hamt, configured by opts as by hamt64.New(), of the
+// rows of table in db, as written by Dump. The rows are put through a
+// Builder; so a key of more than one row, eg. after a repair that dropped
+// the primary key, keeps the value of the last row read. A key or value
+// the Codec of the new Hamt can not decode returns an error naming its row.
+func Load(db *sql.DB, table string, opts ...hamt64.Option) (hamt64.Hamt, error) {
+	if !isIdent(table) {
+		return hamt64.Hamt{}, fmt.Errorf("hamtsql: bad table name %q", table)
+	}
+	var rows, err = db.Query(fmt.Sprintf("SELECT key, value FROM %s", table))
+	if err != nil {
+		return hamt64.Hamt{}, err
+	}
+	defer rows.Close()
+
+	var h = hamt64.New(opts...)
+	var codec = h.Codec()
+	var b = h.Builder()
+	for n := 1; rows.Next(); n++ {
+		var krec, vrec []byte
+		if err = rows.Scan(&krec, &vrec); err != nil {
+			return hamt64.Hamt{}, err
+		}
+		var k key.Key
+		if k, err = codec.DecodeKey(krec); err == nil && k == nil {
+			err = hamt64.ErrNilKey
+		}
+		if err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("hamtsql: key of row %d of %s: %w", n, table, err)
+		}
+		var v interface{}
+		if v, err = codec.DecodeVal(vrec); err != nil {
+			return hamt64.Hamt{}, fmt.Errorf("hamtsql: value of row %d of %s: %w", n, table, err)
+		}
+		b.Put(k, v)
+	}
+	if err = rows.Err(); err != nil {
+		return hamt64.Hamt{}, err
+	}
+	return b.Freeze(), nil
+}
+
+// isIdent returns true if s is a plain SQL identifier; so it can be put in
+// a statement as is.
+func isIdent(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for _, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+/*
+Package hamttest provides the key/value workload generators used by the
+go-hamt-functional tests and benchmarks. They are exported so downstream users
+can measure their own configurations against the same workloads.
+
+All generators return []key.KeyVal where every Key is a *stringkey.StringKey,
+except CollidingKeyVals, whose keys are *CollidingKey.
+
+Minimize and the OpLog functions turn a failing sequence of operations, eg.
+one found by a Shadow, into a minimal reproducer saved as a replayable OpLog
+or a Go fuzz corpus entry.
+
+RunOps is a differential test harness: it drives any Map, eg. a Builder or a
+Persistent Hamt, and a reference Model through the same operations, such as
+those of RandomOps or of an OpLog given to a fuzz target, and reports the
+first divergence.
+*/
+package hamttest
+
+import (
+	"math/rand"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+	"github.com/lleo/stringutil"
+)
+
+// Inc is the string incrementer used by SequentialKeyVals; ie. "aaa", "aab",
+// "aac", ... "aaz", "aba", ...
+var Inc = stringutil.Lower.Inc
+
+// SequentialKeyVals returns num key/val pairs. The keys are the strings
+// starting at initStr, incremented by Inc; the vals are the ints starting at
+// initVal.
+func SequentialKeyVals(num int, initStr string, initVal int) []key.KeyVal {
+	var kvs = make([]key.KeyVal, num)
+	var s = initStr
+
+	for i := 0; i < num; i++ {
+		var k = stringkey.New(s)
+
+		kvs[i] = key.KeyVal{Key: k, Val: initVal + i}
+		s = Inc(s)
+	}
+
+	return kvs
+}
+
+// RandomKeyVals returns num key/val pairs with random lower case keys of
+// strLen characters, drawn from r. The vals are the ints from 0 to num-1.
+// The keys are unique.
+func RandomKeyVals(num int, strLen int, r *rand.Rand) []key.KeyVal {
+	var kvs = make([]key.KeyVal, 0, num)
+	var seen = make(map[string]bool, num)
+	var bs = make([]byte, strLen)
+
+	for len(kvs) < num {
+		for i := range bs {
+			bs[i] = byte('a' + r.Intn(26))
+		}
+		var s = string(bs)
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		kvs = append(kvs, key.KeyVal{Key: stringkey.New(s), Val: len(kvs)})
+	}
+
+	return kvs
+}
+
+// Shuffle copies the []key.KeyVal passed in. Then it randomizes that copy
+// in-place. Finally, it returns the randomized copy.
+func Shuffle(kvs []key.KeyVal) []key.KeyVal {
+	var randKvs = make([]key.KeyVal, len(kvs))
+	copy(randKvs, kvs)
+
+	//From: https://en.wikipedia.org/wiki/Fisher%E2%80%93Yates_shuffle#The_modern_algorithm
+	var n = len(randKvs) // n is the number of elements
+	var limit = n - 1
+	for i := 0; i < limit; /* aka i_max = n-2 */ i++ {
+		j := n - rand.Intn(i+1) - 1 // i <= j < n
+		// j_min = 0   => n - (i_max + 1) - 1 = n - (n-2 + 1) - 1 = n-n+2-1-1 = 0
+		// j_max = n-1 => n - 0 - 1 = n - 1
+		randKvs[i], randKvs[j] = randKvs[j], randKvs[i]
+	}
+
+	return randKvs
+}
+
+// ZipfKeyVals returns num key/val pairs drawn, with repetition, from kvs.
+// The draws are Zipf distributed with parameter s > 1; so kvs[0] is the most
+// frequent, kvs[1] the next most frequent, and so on. This models the skewed
+// access patterns of most real workloads.
+func ZipfKeyVals(kvs []key.KeyVal, num int, s float64, r *rand.Rand) []key.KeyVal {
+	var z = rand.NewZipf(r, s, 1, uint64(len(kvs)-1))
+
+	var zkvs = make([]key.KeyVal, num)
+	for i := range zkvs {
+		zkvs[i] = kvs[z.Uint64()]
+	}
+
+	return zkvs
+}
+
+// CollidingKey is a key.Key whose Hash30() and Hash60() are given, rather
+// than hashed from its string; so any number of distinct CollidingKeys can
+// share a full hash, and land in one collisionLeaf.
+type CollidingKey struct {
+	h30 key.HashVal30
+	h60 key.HashVal60
+	str string
+}
+
+func (k *CollidingKey) Equals(o key.Key) bool {
+	var ck, ok = o.(*CollidingKey)
+	return ok && ck.str == k.str
+}
+
+func (k *CollidingKey) Hash30() key.HashVal30 { return k.h30 }
+func (k *CollidingKey) Hash60() key.HashVal60 { return k.h60 }
+func (k *CollidingKey) String() string        { return k.str }
+
+// CollidingKeyVals returns num key/val pairs whose keys are CollidingKeys
+// with identical Hash30() and Hash60() values, those of the string initStr;
+// so they all land in the same collisionLeaf of a hamt32 or hamt64 Hamt. The
+// keys are the strings starting at initStr, incremented by Inc; the vals are
+// the ints from 0 to num-1.
+//
+// A Hamt made with WithHasher rehashes the String() of every key; so the
+// keys do not collide there. Use a Hasher returning a constant instead.
+func CollidingKeyVals(num int, initStr string) []key.KeyVal {
+	var sk = stringkey.New(initStr)
+
+	var kvs = make([]key.KeyVal, num)
+	for i, s := 0, initStr; i < num; i, s = i+1, Inc(s) {
+		kvs[i] = key.KeyVal{Key: &CollidingKey{sk.Hash30(), sk.Hash60(), s}, Val: i}
+	}
+
+	return kvs
+}
@@ -0,0 +1,78 @@
+package hamttest
+
+// Minimize shrinks a failing sequence of n operations to a minimal failing
+// subsequence with the ddmin delta debugging algorithm. The operations are
+// identified by their indexes; fails is given an ascending subsequence of
+// [0..n) and must return true if replaying just those operations, in that
+// order, still fails.
+//
+// The result is 1-minimal: removing any single index makes it pass. If the
+// whole sequence does not fail, Minimize returns nil.
+func Minimize(n int, fails func(idxs []int) bool) []int {
+	var cur = make([]int, n)
+	for i := range cur {
+		cur[i] = i
+	}
+	if !fails(cur) {
+		return nil
+	}
+
+	var gran = 2
+	for len(cur) >= 2 {
+		var chunks = split(cur, gran)
+		var reduced bool
+
+		for _, chunk := range chunks {
+			if fails(chunk) {
+				cur, gran, reduced = chunk, 2, true
+				break
+			}
+		}
+
+		// With 2 chunks the complements are the chunks themselves.
+		if !reduced && gran > 2 {
+			for i := range chunks {
+				var rest = complement(chunks, i)
+				if fails(rest) {
+					cur, gran, reduced = rest, gran-1, true
+					break
+				}
+			}
+		}
+
+		if !reduced {
+			if gran >= len(cur) {
+				break
+			}
+			gran *= 2
+			if gran > len(cur) {
+				gran = len(cur)
+			}
+		}
+	}
+
+	return cur
+}
+
+// split divides idxs into gran chunks of nearly equal length.
+func split(idxs []int, gran int) [][]int {
+	var chunks = make([][]int, 0, gran)
+	for i := 0; i < gran; i++ {
+		var lo, hi = i * len(idxs) / gran, (i + 1) * len(idxs) / gran
+		if lo < hi {
+			chunks = append(chunks, idxs[lo:hi:hi])
+		}
+	}
+	return chunks
+}
+
+// complement returns a new slice of every chunk except chunks[skip].
+func complement(chunks [][]int, skip int) []int {
+	var rest []int
+	for i, chunk := range chunks {
+		if i != skip {
+			rest = append(rest, chunk...)
+		}
+	}
+	return rest
+}
@@ -0,0 +1,177 @@
+package hamttest
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+)
+
+// Map is the mutable map interface RunOps drives. The Builders of hamt32 and
+// hamt64 are Maps; a persistent Hamt is made into one by Persistent.
+type Map interface {
+	Get(k key.Key) (interface{}, bool)
+	Put(k key.Key, v interface{}) bool
+	Del(k key.Key) (interface{}, bool)
+	Nentries() uint
+}
+
+// PersistentMap is the interface of the persistent Hamts of hamt32, hamt64
+// and hamtg.
+type PersistentMap[H any] interface {
+	Get(k key.Key) (interface{}, bool)
+	Put(k key.Key, v interface{}) (H, bool)
+	Del(k key.Key) (H, interface{}, bool)
+	Nentries() uint
+}
+
+// Persistent is a Map of the successive versions of a persistent Hamt; H
+// is the current version. Eg. `&hamttest.Persistent[hamt64.Hamt]{}`.
+type Persistent[H PersistentMap[H]] struct {
+	H H
+}
+
+// Get is required for Map.
+func (p *Persistent[H]) Get(k key.Key) (interface{}, bool) {
+	return p.H.Get(k)
+}
+
+// Put is required for Map.
+func (p *Persistent[H]) Put(k key.Key, v interface{}) bool {
+	var added bool
+	p.H, added = p.H.Put(k, v)
+	return added
+}
+
+// Del is required for Map.
+func (p *Persistent[H]) Del(k key.Key) (interface{}, bool) {
+	var val interface{}
+	var deleted bool
+	p.H, val, deleted = p.H.Del(k)
+	return val, deleted
+}
+
+// Nentries is required for Map.
+func (p *Persistent[H]) Nentries() uint {
+	return p.H.Nentries()
+}
+
+// Model is the reference model of a Map: a Go map keyed by the String() of
+// each key.
+type Model map[string]interface{}
+
+// Get is required for Map.
+func (m Model) Get(k key.Key) (interface{}, bool) {
+	var v, found = m[k.String()]
+	return v, found
+}
+
+// Put is required for Map.
+func (m Model) Put(k key.Key, v interface{}) bool {
+	var _, found = m[k.String()]
+	m[k.String()] = v
+	return !found
+}
+
+// Del is required for Map.
+func (m Model) Del(k key.Key) (interface{}, bool) {
+	var v, found = m[k.String()]
+	delete(m, k.String())
+	return v, found
+}
+
+// Nentries is required for Map.
+func (m Model) Nentries() uint {
+	return uint(len(m))
+}
+
+// RandomOps returns n operations on the keys "k0" to "k<nkeys-1>", drawn
+// from r. Each operation is a Del with probability delFrac, otherwise a Put
+// of the operation's index as the value.
+func RandomOps(n, nkeys int, delFrac float64, r *rand.Rand) []LogOp {
+	var ops = make([]LogOp, n)
+	for i := range ops {
+		ops[i].Key = "k" + strconv.Itoa(r.Intn(nkeys))
+		if r.Float64() < delFrac {
+			ops[i].Del = true
+		} else {
+			ops[i].Val = strconv.Itoa(i)
+		}
+	}
+	return ops
+}
+
+// Mismatch is the error RunOps returns when a Map diverges from the Model.
+// Index is the index of the operation after which they diverged, or -1
+// for the final comparison of their contents.
+type Mismatch struct {
+	Index int
+	Op    LogOp
+	Got   string
+	Want  string
+}
+
+func (e *Mismatch) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("after every operation: got %s; want %s", e.Got, e.Want)
+	}
+	return fmt.Sprintf("operation %d, %s: got %s; want %s", e.Index, e.Op, e.Got, e.Want)
+}
+
+// RunOps applies ops to m, with *stringkey.StringKey keys and string
+// values, and to a new Model. After each operation the results, the value
+// of the operation's key, and Nentries() must be the same for both; once
+// every operation is applied, every key of the Model must have the same
+// value in m. The first divergence is returned as a *Mismatch.
+func RunOps(m Map, ops []LogOp) error {
+	var model = Model{}
+	for i, op := range ops {
+		var k = stringkey.New(op.Key)
+
+		var got, want string
+		if op.Del {
+			var v, deleted = m.Del(k)
+			var mv, mdeleted = model.Del(k)
+			got, want = fmt.Sprint(v, deleted), fmt.Sprint(mv, mdeleted)
+		} else {
+			got, want = fmt.Sprint(m.Put(k, op.Val)), fmt.Sprint(model.Put(k, op.Val))
+		}
+		if got != want {
+			return &Mismatch{i, op, got, want}
+		}
+
+		var v, found = m.Get(k)
+		var mv, mfound = model.Get(k)
+		got, want = fmt.Sprint("Get ", v, found), fmt.Sprint("Get ", mv, mfound)
+		if got != want {
+			return &Mismatch{i, op, got, want}
+		}
+
+		if n, mn := m.Nentries(), model.Nentries(); n != mn {
+			return &Mismatch{i, op, fmt.Sprint("Nentries ", n), fmt.Sprint("Nentries ", mn)}
+		}
+	}
+
+	for ks, mv := range model {
+		var v, found = m.Get(stringkey.New(ks))
+		if !found || v != mv {
+			return &Mismatch{-1, LogOp{}, fmt.Sprintf("Get(%q) %v, %t", ks, v, found), fmt.Sprintf("%v, true", mv)}
+		}
+	}
+
+	return nil
+}
+
+// OpsFail returns a function for Minimize that is true when RunOps of the
+// chosen subsequence of ops on a new Map, from newMap, fails.
+func OpsFail(newMap func() Map, ops []LogOp) func(idxs []int) bool {
+	return func(idxs []int) bool {
+		var sub = make([]LogOp, len(idxs))
+		for i, idx := range idxs {
+			sub[i] = ops[idx]
+		}
+		return RunOps(newMap(), sub) != nil
+	}
+}
@@ -0,0 +1,101 @@
+package hamttest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LogOp is one operation of an OpLog. The keys of every hamttest generator
+// are strings, so the Key is the key's string; the Val is the value
+// formatted with "%v".
+type LogOp struct {
+	Del bool
+	Key string
+	Val string
+}
+
+func (op LogOp) String() string {
+	if op.Del {
+		return "del " + strconv.Quote(op.Key)
+	}
+	return "put " + strconv.Quote(op.Key) + " " + strconv.Quote(op.Val)
+}
+
+// WriteOpLog writes ops to w as a replayable OpLog; one operation per line,
+// each either `put "key" "val"` or `del "key"`.
+func WriteOpLog(w io.Writer, ops []LogOp) error {
+	var bw = bufio.NewWriter(w)
+	for _, op := range ops {
+		if _, err := fmt.Fprintln(bw, op); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadOpLog reads an OpLog written by WriteOpLog.
+func ReadOpLog(r io.Reader) ([]LogOp, error) {
+	var ops []LogOp
+	var sc = bufio.NewScanner(r)
+	for lineno := 1; sc.Scan(); lineno++ {
+		var op, err = parseLogOp(sc.Text())
+		if err != nil {
+			return nil, fmt.Errorf("ReadOpLog: line %d: %w", lineno, err)
+		}
+		ops = append(ops, op)
+	}
+	return ops, sc.Err()
+}
+
+func parseLogOp(line string) (LogOp, error) {
+	var op LogOp
+	var verb, rest = line, ""
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		verb, rest = line[:i], line[i+1:]
+	}
+
+	var err error
+	switch verb {
+	case "del":
+		op.Del = true
+		op.Key, err = strconv.Unquote(rest)
+	case "put":
+		var kq string
+		if kq, err = strconv.QuotedPrefix(rest); err != nil {
+			return op, err
+		}
+		if op.Key, err = strconv.Unquote(kq); err != nil {
+			return op, err
+		}
+		op.Val, err = strconv.Unquote(strings.TrimPrefix(rest[len(kq):], " "))
+	default:
+		err = fmt.Errorf("unknown operation %q", verb)
+	}
+
+	return op, err
+}
+
+// WriteFuzzCorpus writes ops, as an OpLog, into a Go fuzz corpus entry for
+// the fuzz target fuzzName; ie. the file testdata/fuzz/<fuzzName>/<name> of
+// dir. The fuzz target must take a single []byte argument, the OpLog.
+func WriteFuzzCorpus(dir, fuzzName, name string, ops []LogOp) error {
+	var buf bytes.Buffer
+	if err := WriteOpLog(&buf, ops); err != nil {
+		return err
+	}
+
+	var cdir = filepath.Join(dir, "testdata", "fuzz", fuzzName)
+	if err := os.MkdirAll(cdir, 0755); err != nil {
+		return err
+	}
+
+	var entry = fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", buf.Bytes())
+	return ioutil.WriteFile(filepath.Join(cdir, name), []byte(entry), 0644)
+}
@@ -0,0 +1,73 @@
+package hashers
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// SipHash is SipHash-2-4, a hash keyed by a 128 bit secret. Unlike a seeded
+// hash, no set of colliding keys can be computed without the secret; so it
+// protects a Hamt from keys chosen to collide even when its hash paths, eg.
+// in a snapshot, are visible to clients.
+type SipHash struct {
+	k0, k1 uint64
+}
+
+// NewSipHash returns the SipHash of the 128 bit secret key.
+func NewSipHash(key [16]byte) SipHash {
+	return SipHash{
+		k0: binary.LittleEndian.Uint64(key[:8]),
+		k1: binary.LittleEndian.Uint64(key[8:]),
+	}
+}
+
+// Hash is required for hamt32.Hasher and hamt64.Hasher.
+func (s SipHash) Hash(data []byte) uint64 {
+	var v0 = s.k0 ^ 0x736f6d6570736575
+	var v1 = s.k1 ^ 0x646f72616e646f6d
+	var v2 = s.k0 ^ 0x6c7967656e657261
+	var v3 = s.k1 ^ 0x7465646279746573
+
+	var round = func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	var n = len(data)
+	for ; len(data) >= 8; data = data[8:] {
+		var m = binary.LittleEndian.Uint64(data)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+
+	// The last block is the remaining bytes and the low byte of the length.
+	var m = uint64(n) << 56
+	for i, b := range data {
+		m |= uint64(b) << (8 * uint(i))
+	}
+	v3 ^= m
+	round()
+	round()
+	v0 ^= m
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,301 @@
+/*
+Package keys provides key.Key adapters for the common key types: strings,
+byte slices, int64s, uint64s, 16 byte UUIDs, and composite (prefix, id) keys.
+key.Key is the one key interface of hamt32, hamt64, hamtg and v2; so code
+written against it works with all of them.
+
+Every key is a small value type whose Hash30() and Hash60() values are
+computed once, by its constructor; Get, Put and Del never rehash it. Build
+keys for constants once, and reuse them, to pay neither hashing nor
+allocation per operation.
+
+The hash values are the FNV-1 hash of the key's bytes folded down to 30 and
+60 bits, like the keys of go-hamt-key. So a String key has the same hash
+values as a stringkey.StringKey of the same string; they are still not Equal,
+because they are different types.
+
+Every key also has a Size() method, the number of bytes of its value; so
+the StoredBytes of the Stats of a Hamt count the keys of this package.
+*/
+package keys
+
+import (
+	"encoding/hex"
+	"strconv"
+
+	"github.com/lleo/go-hamt-key"
+)
+
+// hashes holds the precomputed hash values of a key.
+type hashes struct {
+	h30 key.HashVal30
+	h60 key.HashVal60
+}
+
+// Hash30 is required for key.Key
+func (h hashes) Hash30() key.HashVal30 {
+	return h.h30
+}
+
+// Hash60 is required for key.Key
+func (h hashes) Hash60() key.HashVal60 {
+	return h.h60
+}
+
+// fnv1 computes the 32 and 64 bit FNV-1 hashes of its input together.
+type fnv1 struct {
+	h32 uint32
+	h64 uint64
+}
+
+func newFnv1() fnv1 {
+	return fnv1{2166136261, 14695981039346656037}
+}
+
+func (f *fnv1) writeByte(b byte) {
+	f.h32 *= 16777619
+	f.h32 ^= uint32(b)
+	f.h64 *= 1099511628211
+	f.h64 ^= uint64(b)
+}
+
+func (f *fnv1) writeString(s string) {
+	for i := 0; i < len(s); i++ {
+		f.writeByte(s[i])
+	}
+}
+
+func (f *fnv1) writeUint64(u uint64) {
+	for i := uint(0); i < 8; i++ {
+		f.writeByte(byte(u >> (8 * i)))
+	}
+}
+
+func (f fnv1) hashes() hashes {
+	return hashes{
+		key.HashVal30((f.h32 >> 30) ^ (f.h32 & (1<<30 - 1))),
+		key.HashVal60((f.h64 >> 60) ^ (f.h64 & (1<<60 - 1))),
+	}
+}
+
+// String is a key.Key of a string.
+type String struct {
+	hashes
+	s string
+}
+
+// NewString returns the String key of s.
+func NewString(s string) String {
+	var f = newFnv1()
+	f.writeString(s)
+	return String{f.hashes(), s}
+}
+
+// Equals is required for key.Key
+func (k String) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(String)
+	return ok && k2.s == k.s
+}
+
+// String is required for key.Key
+func (k String) String() string {
+	return k.s
+}
+
+// Size is the number of bytes of the string; it is the Size of the Sizer of
+// hamt32 and hamt64.
+func (k String) Size() int {
+	return len(k.s)
+}
+
+// Bytes is a key.Key of a byte slice. The bytes are copied; changing the
+// slice given to NewBytes does not change the key.
+type Bytes struct {
+	hashes
+	b string
+}
+
+// NewBytes returns the Bytes key of b.
+func NewBytes(b []byte) Bytes {
+	var f = newFnv1()
+	f.writeString(string(b))
+	return Bytes{f.hashes(), string(b)}
+}
+
+// Bytes returns a copy of the bytes of the key.
+func (k Bytes) Bytes() []byte {
+	return []byte(k.b)
+}
+
+// Equals is required for key.Key
+func (k Bytes) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(Bytes)
+	return ok && k2.b == k.b
+}
+
+// String is required for key.Key; it is the hex encoding of the bytes.
+func (k Bytes) String() string {
+	return hex.EncodeToString([]byte(k.b))
+}
+
+// Size is the number of bytes of the key.
+func (k Bytes) Size() int {
+	return len(k.b)
+}
+
+// Int64 is a key.Key of an int64.
+type Int64 struct {
+	hashes
+	i int64
+}
+
+// NewInt64 returns the Int64 key of i.
+func NewInt64(i int64) Int64 {
+	var f = newFnv1()
+	f.writeUint64(uint64(i))
+	return Int64{f.hashes(), i}
+}
+
+// Int64 returns the int64 value of the key.
+func (k Int64) Int64() int64 {
+	return k.i
+}
+
+// Equals is required for key.Key
+func (k Int64) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(Int64)
+	return ok && k2.i == k.i
+}
+
+// String is required for key.Key
+func (k Int64) String() string {
+	return strconv.FormatInt(k.i, 10)
+}
+
+// Size is the 8 bytes of an int64.
+func (k Int64) Size() int {
+	return 8
+}
+
+// Uint64 is a key.Key of a uint64.
+type Uint64 struct {
+	hashes
+	u uint64
+}
+
+// NewUint64 returns the Uint64 key of u.
+func NewUint64(u uint64) Uint64 {
+	var f = newFnv1()
+	f.writeUint64(u)
+	return Uint64{f.hashes(), u}
+}
+
+// Uint64 returns the uint64 value of the key.
+func (k Uint64) Uint64() uint64 {
+	return k.u
+}
+
+// Equals is required for key.Key
+func (k Uint64) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(Uint64)
+	return ok && k2.u == k.u
+}
+
+// String is required for key.Key
+func (k Uint64) String() string {
+	return strconv.FormatUint(k.u, 10)
+}
+
+// Size is the 8 bytes of a uint64.
+func (k Uint64) Size() int {
+	return 8
+}
+
+// UUID is a key.Key of a 16 byte UUID.
+type UUID struct {
+	hashes
+	id [16]byte
+}
+
+// NewUUID returns the UUID key of id.
+func NewUUID(id [16]byte) UUID {
+	var f = newFnv1()
+	for _, b := range id {
+		f.writeByte(b)
+	}
+	return UUID{f.hashes(), id}
+}
+
+// UUID returns the 16 bytes of the key.
+func (k UUID) UUID() [16]byte {
+	return k.id
+}
+
+// Equals is required for key.Key
+func (k UUID) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(UUID)
+	return ok && k2.id == k.id
+}
+
+// String is required for key.Key; it is the canonical 8-4-4-4-12 hex form.
+func (k UUID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], k.id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], k.id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], k.id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], k.id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], k.id[10:])
+	return string(buf[:])
+}
+
+// Size is the 16 bytes of a UUID.
+func (k UUID) Size() int {
+	return 16
+}
+
+// Composite is a key.Key of a (prefix, id) pair; eg. a table name and a row
+// id.
+type Composite struct {
+	hashes
+	prefix string
+	id     uint64
+}
+
+// NewComposite returns the Composite key of (prefix, id).
+func NewComposite(prefix string, id uint64) Composite {
+	var f = newFnv1()
+	f.writeString(prefix)
+	f.writeByte(0) // so ("a", id) and ("a\x00", id) differ
+	f.writeUint64(id)
+	return Composite{f.hashes(), prefix, id}
+}
+
+// Prefix returns the prefix of the key.
+func (k Composite) Prefix() string {
+	return k.prefix
+}
+
+// ID returns the id of the key.
+func (k Composite) ID() uint64 {
+	return k.id
+}
+
+// Equals is required for key.Key
+func (k Composite) Equals(k1 key.Key) bool {
+	var k2, ok = k1.(Composite)
+	return ok && k2.prefix == k.prefix && k2.id == k.id
+}
+
+// String is required for key.Key
+func (k Composite) String() string {
+	return k.prefix + "/" + strconv.FormatUint(k.id, 10)
+}
+
+// Size is the number of bytes of the prefix plus the 8 bytes of the id.
+func (k Composite) Size() int {
+	return len(k.prefix) + 8
+}
@@ -0,0 +1,35 @@
+/*
+Package normalize provides the Unicode key normalizers of hamt32 and hamt64
+Hamts; ie. `hamt64.New(hamt64.WithKeyNormalizer(normalize.NFC))`. It is
+apart from hamt32 and hamt64 so that only the users of these normalizers
+need golang.org/x/text.
+*/
+package normalize
+
+import (
+	"github.com/lleo/go-hamt-key"
+	"github.com/lleo/go-hamt-key/stringkey"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NFC is a key normalizer that puts *stringkey.StringKey keys in Unicode
+// Normalization Form C; so eg. "e\u0301" and "\u00e9" are the same key.
+func NFC(k key.Key) key.Key {
+	return normalizeForm(norm.NFC, k)
+}
+
+// NFKC is a key normalizer that puts *stringkey.StringKey keys in Unicode
+// Normalization Form KC; so compatibility characters, eg. the ligature
+// "\ufb01", are also the same key as the letters they stand for.
+func NFKC(k key.Key) key.Key {
+	return normalizeForm(norm.NFKC, k)
+}
+
+// normalizeForm returns k in the Unicode normalization form f.
+func normalizeForm(f norm.Form, k key.Key) key.Key {
+	var sk, ok = k.(*stringkey.StringKey)
+	if !ok || f.IsNormalString(sk.Str()) {
+		return k
+	}
+	return stringkey.New(f.String(sk.Str()))
+}
@@ -0,0 +1,180 @@
+/*
+Package hamt is version 2 of the go-hamt-functional API.
+
+It presents the redesigned surface over the hamt64 implementation: a
+constructor taking functional options, methods that return errors rather
+than "found" bools, values of a generic type, iteration and bulk loading.
+The hamt32 and hamt64 packages are unchanged; a v2 Map is a thin wrapper of
+a hamt64.Hamt, and Unwrap and Wrap convert between the two so code can
+migrate one call site at a time.
+*/
+package hamt
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/lleo/go-hamt-functional/hamt64"
+	"github.com/lleo/go-hamt-key"
+)
+
+// The error values returned, or wrapped, by the methods of a Map; test for
+// them with errors.Is(). They are the values of hamt64; so errors.Is() of
+// either matches.
+var (
+	ErrKeyNotFound = hamt64.ErrKeyNotFound
+	ErrNilKey      = hamt64.ErrNilKey
+)
+
+// KeyError records the operation and key that caused an error.
+type KeyError = hamt64.KeyError
+
+// Option configures a new Map.
+type Option func(*options)
+
+type options struct {
+	table []hamt64.Option
+}
+
+// WithAssertLevel sets the hamt64.AssertLevel of the Map; see
+// hamt64.WithAssertLevel.
+func WithAssertLevel(lvl hamt64.AssertLevel) Option {
+	return func(o *options) {
+		o.table = append(o.table, hamt64.WithAssertLevel(lvl))
+	}
+}
+
+// WithTablePolicy sets the table policy of the Map; see hamt64.New().
+func WithTablePolicy(opts ...hamt64.Option) Option {
+	return func(o *options) {
+		o.table = append(o.table, opts...)
+	}
+}
+
+// Map is an immutable, persistent map from key.Key to V.
+type Map[V any] struct {
+	h hamt64.Hamt
+}
+
+// New returns an empty Map configured by opts.
+func New[V any](opts ...Option) Map[V] {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return Map[V]{hamt64.New(o.table...)}
+}
+
+// Wrap returns the Map of an existing hamt64.Hamt. Every value in h must be
+// of type V.
+func Wrap[V any](h hamt64.Hamt) Map[V] {
+	return Map[V]{h}
+}
+
+// Unwrap returns the underlying hamt64.Hamt.
+func (m Map[V]) Unwrap() hamt64.Hamt {
+	return m.h
+}
+
+// Len returns the number of entries in the Map.
+func (m Map[V]) Len() int {
+	return int(m.h.Nentries())
+}
+
+// Get returns the value of k, or a *KeyError wrapping ErrKeyNotFound.
+func (m Map[V]) Get(k key.Key) (V, error) {
+	var zero V
+	if k == nil {
+		return zero, &KeyError{Op: "Get", Err: ErrNilKey}
+	}
+	var val, found = m.h.Get(k)
+	if !found {
+		return zero, &KeyError{Op: "Get", Key: k, Err: ErrKeyNotFound}
+	}
+	var v, _ = val.(V) // a nil val of an interface V is the zero V
+	return v, nil
+}
+
+// Has returns true if k is in the Map.
+func (m Map[V]) Has(k key.Key) bool {
+	if k == nil {
+		return false
+	}
+	var _, found = m.h.Get(k)
+	return found
+}
+
+// Put returns a new Map with k set to v.
+func (m Map[V]) Put(k key.Key, v V) (Map[V], error) {
+	if k == nil {
+		return m, &KeyError{Op: "Put", Err: ErrNilKey}
+	}
+	var nh, _ = m.h.Put(k, v)
+	return Map[V]{nh}, nil
+}
+
+// Del returns a new Map without k, and the value k had; or a *KeyError
+// wrapping ErrKeyNotFound.
+func (m Map[V]) Del(k key.Key) (Map[V], V, error) {
+	var zero V
+	if k == nil {
+		return m, zero, &KeyError{Op: "Del", Err: ErrNilKey}
+	}
+	var nh, val, deleted = m.h.Del(k)
+	if !deleted {
+		return m, zero, &KeyError{Op: "Del", Key: k, Err: ErrKeyNotFound}
+	}
+	var v, _ = val.(V)
+	return Map[V]{nh}, v, nil
+}
+
+// Range calls fn for every key/val pair of the Map, in hash order, until fn
+// returns false.
+func (m Map[V]) Range(fn func(k key.Key, v V) bool) {
+	m.h.Range(func(k key.Key, val interface{}) bool {
+		var v, _ = val.(V)
+		return fn(k, v)
+	})
+}
+
+// PutAll returns a new Map with every key/val pair of kvs put into it; the
+// last value of a repeated key wins. It is much faster than repeated Puts.
+// A nil Val is the nil V, as for Put, if V is a pointer, interface, map,
+// slice, func or chan type.
+func (m Map[V]) PutAll(kvs []key.KeyVal) (Map[V], error) {
+	var zero V
+	var nilV = isNilable[V]()
+	var stored []key.KeyVal // kvs with each nil Val made the nil V
+	for i, kv := range kvs {
+		if kv.Key == nil {
+			return m, &KeyError{Op: "PutAll", Err: ErrNilKey}
+		}
+		if kv.Val == nil && nilV {
+			if stored == nil {
+				stored = append([]key.KeyVal(nil), kvs...)
+			}
+			stored[i].Val = zero
+			continue
+		}
+		if _, ok := kv.Val.(V); !ok {
+			return m, &KeyError{Op: "PutAll", Key: kv.Key, Err: fmt.Errorf("value %v is a %T", kv.Val, kv.Val)}
+		}
+	}
+	if stored != nil {
+		kvs = stored
+	}
+	return Map[V]{m.h.PutMany(kvs)}, nil
+}
+
+// isNilable returns true if nil is a value of V.
+func isNilable[V any]() bool {
+	switch reflect.TypeOf((*V)(nil)).Elem().Kind() {
+	case reflect.Pointer, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
+
+func (m Map[V]) String() string {
+	return fmt.Sprintf("Map{ %s }", m.h)
+}